@@ -3,9 +3,15 @@ package v1
 import (
 	"encoding/base64"
 	"encoding/json"
+	"log"
 	"net/http"
+	"sg-portal/internal/features"
 	"sg-portal/internal/models"
+	"sg-portal/internal/settings"
+	"sg-portal/internal/tenants"
+	"sg-portal/internal/users"
 	"sg-portal/pkg/util"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -17,6 +23,7 @@ type AuthHandler struct {
 	TokenRepo         *util.Repository[models.Token]
 	TenantRepo        *util.Repository[models.Tenant]
 	TenantMappingRepo *util.Repository[models.UserTenantMapping]
+	LoginAttemptRepo  *util.Repository[models.LoginAttempt]
 }
 
 // NewAuthHandler initializes the auth handler with the repositories.
@@ -27,10 +34,12 @@ func NewAuthHandler(db *gorm.DB) *AuthHandler {
 		TokenRepo:         util.NewRepository[models.Token](db),
 		TenantRepo:        util.NewRepository[models.Tenant](db),
 		TenantMappingRepo: util.NewRepository[models.UserTenantMapping](db),
+		LoginAttemptRepo:  util.NewRepository[models.LoginAttempt](db),
 	}
 }
 
-// Register handles user registration.
+// Register handles user registration. Creating a system user requires the request to come
+// from a system user, see RequireSystemUser.
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	// add default demo subscription
 	subscriptionRepo := util.NewRepository[models.Subscription](util.Db)
@@ -59,8 +68,9 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		Email        string `json:"email"`
 		Name         string `json:"name"`
 		MobileNumber string `json:"mobile_number"`
-		Password     string `json:"password"` // Base64 encoded
-		Type         string `json:"type"`     // New field for user type
+		CountryID    *int   `json:"country_id"` // Optional, the default country is assumed when missing
+		Password     string `json:"password"`   // Base64 encoded
+		Type         string `json:"type"`       // New field for user type
 	}{}
 
 	if err := json.NewDecoder(r.Body).Decode(&userData); err != nil {
@@ -74,6 +84,14 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// System users are only created by other system users through /users/system
+	if userData.Type == models.UserTypeSystem {
+		if userType, _ := util.UserTypeFromContext(r.Context()); userType != models.UserTypeSystem {
+			util.HandleError(w, http.StatusForbidden, "Only system users can create system users")
+			return
+		}
+	}
+
 	// Decode the base64-encoded password
 	passwordBytes, err := base64.StdEncoding.DecodeString(userData.Password)
 	if err != nil {
@@ -82,16 +100,34 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 	password := string(passwordBytes)
 
+	// Store the mobile number in E.164 form for the user's country
+	mobileNumber, err := users.NormalizeMobile(util.Db, userData.CountryID, userData.MobileNumber)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "Invalid mobile number: "+err.Error())
+		return
+	}
+
 	// Create user entity
 	user := &models.User{
 		Email:        userData.Email,
 		Name:         userData.Name,
-		MobileNumber: userData.MobileNumber,
+		MobileNumber: mobileNumber,
+		CountryID:    userData.CountryID,
 		Type:         userData.Type, // Set the user type
 	}
 
+	// Reject duplicates with a clear conflict instead of failing on the unique indexes
+	if conflict := contactConflict(h.UserRepo, user.Email, user.MobileNumber, userData.CountryID, 0); conflict != "" {
+		respondConflict(w, conflict)
+		return
+	}
+
 	// Create user record
 	if err := h.UserRepo.Create(user); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			respondConflict(w, contactConflict(h.UserRepo, user.Email, user.MobileNumber, userData.CountryID, 0))
+			return
+		}
 		util.HandleError(w, http.StatusInternalServerError, "Error creating user")
 		return
 	}
@@ -144,6 +180,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	for _, feature := range allFeatures {
 		featureMapping := models.UserFeatureMapping{
 			UserId:    user.ID,
+			TenantId:  defaultTenant.ID,
 			FeatureId: feature.ID,
 		}
 		featureMappings = append(featureMappings, featureMapping)
@@ -159,16 +196,31 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 // Login handles user login and token generation.
+// Every attempt, successful or not, is recorded in the login history.
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	attempt := &models.LoginAttempt{
+		CredentialType: models.CredentialTypeUnknown,
+		IP:             util.ClientIP(r),
+		UserAgent:      r.UserAgent(),
+		Reason:         models.LoginReasonBadRequest,
+	}
+	defer func() {
+		if err := h.LoginAttemptRepo.Create(attempt); err != nil {
+			log.Printf("[!] Could not record login attempt: %v\n", err)
+		}
+	}()
+
 	loginData := struct {
 		Credential string `json:"credential"` // Can be email or mobile number
 		Password   string `json:"password"`   // Base64 encoded
+		CountryID  *int   `json:"country_id"` // Optional, the country of a mobile number typed without its dialing code
 	}{}
 
 	if err := json.NewDecoder(r.Body).Decode(&loginData); err != nil {
 		util.HandleError(w, http.StatusBadRequest, "Invalid login data")
 		return
 	}
+	attempt.Credential = loginData.Credential
 
 	// Decode the base64-encoded password
 	passwordBytes, err := base64.StdEncoding.DecodeString(loginData.Password)
@@ -182,46 +234,69 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var user *models.User
 	if util.IsValidEmail(loginData.Credential) {
 		// Fetch user by email
+		attempt.CredentialType = models.CredentialTypeEmail
 		user, err = h.UserRepo.GetByField("email", loginData.Credential)
 	} else if util.IsValidMobileNumber(loginData.Credential) {
-		// Fetch user by mobile number
-		user, err = h.UserRepo.GetByField("mobile_number", loginData.Credential)
+		// Fetch user by mobile number, typed with or without the dialing code
+		attempt.CredentialType = models.CredentialTypeMobile
+		user, err = h.findUserByMobile(loginData.Credential, loginData.CountryID)
 	} else {
+		attempt.Reason = models.LoginReasonInvalidCredential
 		util.HandleError(w, http.StatusUnauthorized, "Invalid email or mobile number")
 		return
 	}
 
 	if err != nil {
+		attempt.Reason = models.LoginReasonUnknownUser
 		util.HandleError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
+	attempt.UserId = &user.ID
 
 	// Fetch stored password for user
 	userPassword, err := h.UserPasswordRepo.GetByField("user_id", user.ID)
 	if err != nil {
+		attempt.Reason = models.LoginReasonInvalidPassword
 		util.HandleError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
 
 	// Validate password
 	if err := models.ValidatePassword(password, userPassword.Salt, userPassword.Password); err != nil {
+		attempt.Reason = models.LoginReasonInvalidPassword
 		util.HandleError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
 
+	// Suspended users are only told so once they have proven who they are
+	if !user.IsActive {
+		attempt.Reason = models.LoginReasonUserSuspended
+		respondSuspended(w)
+		return
+	}
+
   // delete any existing tokens
   h.TokenRepo.Delete("user_id = ?", user.ID)
 
 	// Generate token
-	expiry := time.Now().Add(time.Hour * 72) // Token valid for 72 hours
+	now := time.Now()
+	expiry := now.Add(time.Hour * 72) // Token valid for 72 hours
 	token := models.NewToken(user.ID, expiry)
 
 	// Store the token in the database
 	if err := h.TokenRepo.Create(token); err != nil {
+		attempt.Reason = models.LoginReasonInternalError
 		util.HandleError(w, http.StatusInternalServerError, "Error generating token")
 		return
 	}
 
+	if err := h.UserRepo.UpdateOne("id", user.ID, map[string]interface{}{"last_login": now}); err != nil {
+		log.Printf("[!] Could not update last login for user %d: %v\n", user.ID, err)
+	}
+	user.LastLogin = &now
+	attempt.Success = true
+	attempt.Reason = models.LoginReasonSuccess
+
 	// Respond with the
 	response := struct {
 		User  *models.User `json:"user_info"`
@@ -233,52 +308,192 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	util.RespondJSON(w, http.StatusOK, &response)
 }
 
+// findUserByMobile looks a user up by any stored form of the typed mobile number,
+// preferring the normalised E.164 match over legacy rows. Without an explicit country a
+// local number is also read as a number of each user's stored country.
+func (h *AuthHandler) findUserByMobile(mobile string, countryID *int) (*models.User, error) {
+	candidates := users.MobileLookupCandidates(util.Db, countryID, mobile)
+	matches, err := h.UserRepo.GetAllByCondition("mobile_number IN ?", candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) < 1 && countryID == nil {
+		matches, err = users.LocalMobileMatches(util.Db, mobile)
+		if err != nil {
+			return nil, err
+		}
+		// The same local number in two countries needs the country to tell them apart
+		if len(matches) > 1 {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+	if len(matches) < 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	for i := range matches {
+		if strings.HasPrefix(matches[i].MobileNumber, "+") {
+			return &matches[i], nil
+		}
+	}
+	return &matches[0], nil
+}
+
 // validate token and resolve tenant
+// ?settings=all, or ?settings=a,b, adds the company's effective settings to the response
 
 func (h *AuthHandler) ResolveTenant(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("token")
-	companyId := r.Header.Get("companyid")
+	info, status := h.resolveTenant(r.Header.Get("token"), r.Header.Get("companyid"))
+	if requested := r.URL.Query().Get("settings"); requested != "" && status == http.StatusOK {
+		var keys []string
+		if requested != "all" {
+			keys = strings.Split(requested, ",")
+		}
+		values, err := settings.AsMap(util.Db, info.TenantInfo.ID, keys)
+		if err != nil {
+			util.RespondJSON(w, http.StatusInternalServerError, &models.TokenTenantInfo{Message: "Error fetching settings"})
+			return
+		}
+		info.Settings = values
+	}
+	util.RespondJSON(w, status, info)
+}
 
+// resolveTenant checks that the token is valid, its user active and a member of the company,
+// and returns the tenant with the user's role, permissions and healthy endpoints together with
+// the HTTP status to answer with
+func (h *AuthHandler) resolveTenant(token, companyId string) (*models.TokenTenantInfo, int) {
 	tokenRepo := util.NewRepository[models.Token](util.Db)
 	tokenInfo, err := tokenRepo.GetByField("value", token)
 	if err != nil {
-		// Respond with GenericResponseMessage
-		response := models.TokenTenantInfo{
+		return &models.TokenTenantInfo{
 			Message: "Invalid Token Provided",
 			Success: false,
-		}
-		util.RespondJSON(w, http.StatusUnauthorized, &response)
-		return
+			Code:    models.CodeInvalidToken,
+		}, http.StatusUnauthorized
+	}
+	if time.Now().After(tokenInfo.Expiry) {
+		return &models.TokenTenantInfo{
+			Message: "Token Expired",
+			Success: false,
+			Code:    models.CodeTokenExpired,
+		}, http.StatusUnauthorized
+	}
+
+	user, err := h.UserRepo.GetByField("id", tokenInfo.UserID)
+	if err != nil || !user.IsActive {
+		return &models.TokenTenantInfo{
+			Message: "User account is suspended",
+			Success: false,
+			Code:    models.CodeUserSuspended,
+		}, http.StatusForbidden
 	}
 
 	tenantRepo := util.NewRepository[models.Tenant](util.Db)
 	tenantInfo, err := tenantRepo.GetByField("company_guid", companyId)
 	if err != nil {
-		// Respond with GenericResponseMessage
-		response := models.TokenTenantInfo{
+		return &models.TokenTenantInfo{
 			Message: "Non Registered Company Requested",
 			Success: false,
-		}
-		util.RespondJSON(w, http.StatusUnauthorized, &response)
-		return
+		}, http.StatusUnauthorized
 	}
 	tenantMappingRepo := util.NewRepository[models.UserTenantMapping](util.Db)
 	tenantMapping, err := tenantMappingRepo.GetAllByCondition("user_id = ? and tenant_id = ?", tokenInfo.UserID, tenantInfo.ID)
 	if err != nil || len(tenantMapping) < 1 {
-		// Respond with GenericResponseMessage
-		response := models.TokenTenantInfo{
+		return &models.TokenTenantInfo{
 			Message: "No Tenants Configured for the user",
 			Success: false,
-		}
-		util.RespondJSON(w, http.StatusUnauthorized, &response)
-		return
+		}, http.StatusUnauthorized
+	}
+	if tenantMapping[0].DeactivatedAt != nil {
+		return &models.TokenTenantInfo{
+			Message: "Your seat on the company is deactivated",
+			Success: false,
+			Code:    models.CodeSeatInactive,
+		}, http.StatusForbidden
+	}
+	if code, status := tenants.StateRejection(tenantInfo.State); code != "" {
+		return &models.TokenTenantInfo{
+			Message: "Company is " + tenantInfo.State,
+			Success: false,
+			Code:    code,
+		}, status
 	}
 
-	response := models.TokenTenantInfo{
-		TenantInfo: tenantInfo,
-		UserId:     &tokenInfo.UserID,
-		Message:    "Token Valid",
-		Success:    true,
+	permissions, err := features.Permissions(util.Db, tokenInfo.UserID, tenantInfo.ID)
+	if err != nil {
+		return &models.TokenTenantInfo{Message: "Error fetching permissions"}, http.StatusInternalServerError
 	}
-	util.RespondJSON(w, http.StatusOK, &response)
+	endpoints, err := tenants.HealthyEndpoints(util.Db, tenantInfo.ID)
+	if err != nil {
+		return &models.TokenTenantInfo{Message: "Error fetching endpoints"}, http.StatusInternalServerError
+	}
+	maintenance, err := tenants.InMaintenance(util.Db, tenantInfo.ID)
+	if err != nil {
+		return &models.TokenTenantInfo{Message: "Error fetching migration state"}, http.StatusInternalServerError
+	}
+
+	return &models.TokenTenantInfo{
+		TenantInfo:  tenantInfo,
+		UserId:      &tokenInfo.UserID,
+		Role:        tenantMapping[0].Role,
+		Permissions: permissions,
+		Endpoints:   endpoints,
+		Maintenance: maintenance,
+		Message:     "Token Valid",
+		Success:     true,
+	}, http.StatusOK
+}
+
+// respondSuspended rejects a request made by, or on behalf of, a suspended user
+func respondSuspended(w http.ResponseWriter) {
+	util.RespondJSON(w, http.StatusForbidden, &models.GenericResponseMessage{
+		Message: "User account is suspended",
+		Result:  false,
+		Code:    models.CodeUserSuspended,
+	})
+}
+
+// Authenticate resolves the "token" header to an active user and stores the user ID in the request context
+func (h *AuthHandler) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := h.TokenRepo.GetByField("value", r.Header.Get("token"))
+		if err != nil {
+			util.RespondJSON(w, http.StatusUnauthorized, &models.GenericResponseMessage{
+				Message: "Invalid Token Provided",
+				Code:    models.CodeInvalidToken,
+			})
+			return
+		}
+		if time.Now().After(token.Expiry) {
+			util.RespondJSON(w, http.StatusUnauthorized, &models.GenericResponseMessage{
+				Message: "Token Expired",
+				Code:    models.CodeTokenExpired,
+			})
+			return
+		}
+
+		user, err := h.UserRepo.GetByField("id", token.UserID)
+		if err != nil || !user.IsActive {
+			respondSuspended(w)
+			return
+		}
+
+		ctx := util.ContextWithUserID(r.Context(), user.ID)
+		ctx = util.ContextWithUserType(ctx, user.Type)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// RequireSystemUser authenticates the request and only lets system users through
+func (h *AuthHandler) RequireSystemUser(next http.HandlerFunc) http.HandlerFunc {
+	return h.Authenticate(func(w http.ResponseWriter, r *http.Request) {
+		if userType, _ := util.UserTypeFromContext(r.Context()); userType != models.UserTypeSystem {
+			util.RespondJSON(w, http.StatusForbidden, &models.GenericResponseMessage{
+				Message: "Only system users can perform this action",
+				Code:    models.CodeForbidden,
+			})
+			return
+		}
+		next(w, r)
+	})
 }
@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"sg-portal/internal/models"
+	"sg-portal/internal/users"
+	"sg-portal/pkg/util"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -17,6 +19,7 @@ import (
 // TestRegister tests the user registration endpoint with the new type field
 func TestRegister(t *testing.T) {
     db := SetupTestDB(t)  // Set up the in-memory test database
+    seedRegistrationDefaults(t, db)
     authHandler := NewAuthHandler(db)  // Initialize the handler
 
     // Prepare the registration payload
@@ -50,29 +53,114 @@ func TestRegister(t *testing.T) {
     }
 }
 
+// TestRegisterSystemUser checks that system users can only be created by other system users
+func TestRegisterSystemUser(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+	authHandler := NewAuthHandler(db)
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000001", models.UserTypeSystem)
+	_, clientToken := createTestUser(t, db, "client@example.com", "9000000002", models.UserTypeClient)
+
+	register := func(email, mobile string, handler http.HandlerFunc, token *models.Token) int {
+		body, _ := json.Marshal(map[string]string{
+			"email":         email,
+			"name":          email,
+			"mobile_number": mobile,
+			"password":      base64.StdEncoding.EncodeToString([]byte("secret")),
+			"type":          models.UserTypeSystem,
+		})
+		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(body))
+		if token != nil {
+			req.Header.Set("token", token.Value.String())
+		}
+		return executeRequest(req, handler).Code
+	}
+
+	if code := register("anyone@example.com", "9000000003", authHandler.Register, nil); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for public registration, got %d", http.StatusForbidden, code)
+	}
+	if code := register("anyone@example.com", "9000000003", authHandler.RequireSystemUser(authHandler.Register), clientToken); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a client, got %d", http.StatusForbidden, code)
+	}
+	if code := register("ops@example.com", "9000000004", authHandler.RequireSystemUser(authHandler.Register), adminToken); code != http.StatusCreated {
+		t.Errorf("Expected status code %d for a system user, got %d", http.StatusCreated, code)
+	}
+	var created models.User
+	if err := db.Where("email = ?", "ops@example.com").First(&created).Error; err != nil || created.Type != models.UserTypeSystem {
+		t.Errorf("Expected a new system user, got %+v (%v)", created, err)
+	}
+}
+
 // SetupTestDB initializes an in-memory SQLite database for testing.
 func SetupTestDB(t *testing.T) *gorm.DB {
 	t.Helper() // Marks the function as a test helper, hiding it from test reports
 
-	// Open an in-memory SQLite database for testing
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	// Open an in-memory SQLite database for testing; the name keeps every test on its own
+	// database while letting all pooled connections share it
+	dsn := "file:" + t.Name() + "?mode=memory&cache=shared"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
 
 	// Migrate the models (like User, UserPassword, Token, etc.)
 	err = db.AutoMigrate(
-		&models.User{},
-		&models.UserPassword{},
-		&models.Token{},
+		&models.Tenant{}, &models.UserTenantMapping{},
+		&models.User{}, &models.UserPassword{}, &models.Token{},
+		&models.Feature{}, &models.UserFeatureMapping{},
+		&models.Subscription{}, &models.UserSubscriptionMapping{},
+		&models.FeatureSubscriptionMapping{}, &models.UserSubscriptionHistory{},
+		&models.UserSuspension{}, &models.ContactChangeRequest{}, &models.LoginAttempt{},
+		&models.Country{}, &models.UserErasure{}, &models.UserMerge{},
+		&models.UserPreference{},
+		&models.Event{}, &models.TenantServiceStatus{}, &models.TenantHealthCheck{},
+		&models.ProvisioningTemplate{}, &models.TemplateHost{}, &models.TemplateFeature{},
+		&models.TemplateSubscription{}, &models.TemplateRule{},
+		&models.TenantEndpoint{}, &models.TenantStateChange{},
+		&models.OwnershipTransfer{},
+		&models.SettingDefinition{}, &models.TenantSetting{}, &models.TenantSettingRevision{},
+		&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationMemberCompany{},
+		&models.OrganizationSubscription{},
+		&models.Host{}, &models.HostLabel{}, &models.PortAllocation{},
+		&models.TenantSecret{}, &models.TenantSecretVersion{}, &models.ServiceCredential{},
+		&models.TenantInvitation{}, &models.TenantMigration{},
+		&models.UserWildcardGrant{},
 	)
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
 
+	if err := users.SeedCountries(db); err != nil {
+		t.Fatalf("Failed to seed countries: %v", err)
+	}
+
+	// Several handlers still read the package level connection
+	util.Db = db
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
 	return db
 }
 
+// seedRegistrationDefaults creates the demo subscription, default tenant and feature that Register maps new users to.
+func seedRegistrationDefaults(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	seed := []interface{}{
+		&models.Subscription{Name: "Demo", Code: "demo"},
+		&models.Tenant{CompanyGuid: "default", CompanyName: "default", Host: "demo.local", BmrmPort: 8001, SgBizPort: 8002, TallySyncPort: 8003},
+		&models.Feature{Name: "Sales Report", Permission: "sales.report"},
+	}
+	for _, entry := range seed {
+		if err := db.Create(entry).Error; err != nil {
+			t.Fatalf("Failed to seed test database: %v", err)
+		}
+	}
+}
+
 
 // executeRequest helps simulate an HTTP request and records the response.
 func executeRequest(req *http.Request, handlerFunc http.HandlerFunc) *httptest.ResponseRecorder {
@@ -88,3 +176,151 @@ func executeRequest(req *http.Request, handlerFunc http.HandlerFunc) *httptest.R
 	// Return the ResponseRecorder, which contains the response details
 	return rr
 }
+
+// TestLoginHistory checks that failed and successful logins are recorded and LastLogin is set
+func TestLoginHistory(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+	authHandler := NewAuthHandler(db)
+
+	payload, _ := json.Marshal(map[string]string{
+		"email":         "login@example.com",
+		"name":          "Login User",
+		"mobile_number": "9876543210",
+		"password":      base64.StdEncoding.EncodeToString([]byte("secret")),
+		"type":          models.UserTypeClient,
+	})
+	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(payload))
+	if rr := executeRequest(req, authHandler.Register); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+
+	for _, password := range []string{"wrong", "secret"} {
+		body, _ := json.Marshal(map[string]string{
+			"credential": "login@example.com",
+			"password":   base64.StdEncoding.EncodeToString([]byte(password)),
+		})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+		req.Header.Set("User-Agent", "test-agent")
+		executeRequest(req, authHandler.Login)
+	}
+
+	var attempts []models.LoginAttempt
+	db.Order("id").Find(&attempts)
+	if len(attempts) != 2 {
+		t.Fatalf("Expected 2 recorded attempts, got %d", len(attempts))
+	}
+	if attempts[0].Success || attempts[0].Reason != models.LoginReasonInvalidPassword {
+		t.Errorf("Unexpected failed attempt: %+v", attempts[0])
+	}
+	if !attempts[1].Success || attempts[1].UserAgent != "test-agent" || attempts[1].CredentialType != models.CredentialTypeEmail {
+		t.Errorf("Unexpected successful attempt: %+v", attempts[1])
+	}
+
+	var user models.User
+	db.Where("email = ?", "login@example.com").First(&user)
+	if user.LastLogin == nil {
+		t.Errorf("Expected LastLogin to be set after a successful login")
+	}
+}
+
+// TestLoginNormalisedMobile checks that mobile logins find numbers stored in E.164 form,
+// whether typed locally, with the dialing code or for a country other than the default
+func TestLoginNormalisedMobile(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+	authHandler := NewAuthHandler(db)
+
+	password := base64.StdEncoding.EncodeToString([]byte("secret"))
+	uae := 784
+	for _, registration := range []map[string]interface{}{
+		{"email": "india@example.com", "name": "India", "mobile_number": "9876543211", "password": password, "type": models.UserTypeClient},
+		{"email": "uae@example.com", "name": "UAE", "mobile_number": "501234567", "country_id": uae, "password": password, "type": models.UserTypeClient},
+	} {
+		payload, _ := json.Marshal(registration)
+		req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(payload))
+		if rr := executeRequest(req, authHandler.Register); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+		}
+	}
+
+	tests := []struct {
+		credential string
+		countryID  *int
+		email      string
+	}{
+		{"9876543211", nil, "india@example.com"},
+		{"+919876543211", nil, "india@example.com"},
+		{"+971501234567", nil, "uae@example.com"},
+		{"0501234567", &uae, "uae@example.com"},
+		{"0501234567", nil, "uae@example.com"},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(map[string]interface{}{"credential": tt.credential, "password": password, "country_id": tt.countryID})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+		rr := executeRequest(req, authHandler.Login)
+		if rr.Code != http.StatusOK {
+			t.Errorf("Login with %s: expected status code %d, got %d", tt.credential, http.StatusOK, rr.Code)
+			continue
+		}
+		var response struct {
+			User models.User `json:"user_info"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if response.User.Email != tt.email {
+			t.Errorf("Login with %s: expected %s, got %s", tt.credential, tt.email, response.User.Email)
+		}
+	}
+
+	// A local number read as the wrong country does not match
+	india := 356
+	body, _ := json.Marshal(map[string]interface{}{"credential": "0501234567", "password": password, "country_id": india})
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+	if rr := executeRequest(req, authHandler.Login); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// TestNormalizeStoredMobiles checks that numbers saved as typed are rewritten in E.164 form, and that
+// numbers which are invalid or would collide with another user's are left alone
+func TestNormalizeStoredMobiles(t *testing.T) {
+	db := SetupTestDB(t)
+
+	uae := 784
+	legacy := map[string]*models.User{
+		"local":    {Email: "local@example.com", Name: "Local", MobileNumber: "98765 43211", Type: models.UserTypeClient},
+		"dialing":  {Email: "dialing@example.com", Name: "Dialing", MobileNumber: "971501234567", CountryID: &uae, Type: models.UserTypeClient},
+		"clash":    {Email: "clash@example.com", Name: "Clash", MobileNumber: "+919000000001", Type: models.UserTypeClient},
+		"clashing": {Email: "clashing@example.com", Name: "Clashing", MobileNumber: "9000000001", Type: models.UserTypeClient},
+		"invalid":  {Email: "invalid@example.com", Name: "Invalid", MobileNumber: "12345", Type: models.UserTypeClient},
+		"erased":   {Email: "erased@example.com", Name: "Erased", MobileNumber: "erased-99", Type: models.UserTypeClient},
+	}
+	for _, user := range legacy {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("Failed to seed user: %v", err)
+		}
+	}
+
+	if err := users.NormalizeStoredMobiles(db); err != nil {
+		t.Fatalf("Normalisation failed: %v", err)
+	}
+	expected := map[string]string{
+		"local":    "+919876543211",
+		"dialing":  "+971501234567",
+		"clash":    "+919000000001",
+		"clashing": "9000000001",
+		"invalid":  "12345",
+		"erased":   "erased-99",
+	}
+	for name, user := range legacy {
+		var reloaded models.User
+		db.First(&reloaded, user.ID)
+		if reloaded.MobileNumber != expected[name] {
+			t.Errorf("Expected the %s number to be %s, got %s", name, expected[name], reloaded.MobileNumber)
+		}
+	}
+
+	if err := users.NormalizeStoredMobiles(db); err != nil {
+		t.Errorf("Expected a second run to succeed, got %v", err)
+	}
+}
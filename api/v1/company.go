@@ -2,7 +2,14 @@ package v1
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sg-portal/internal/features"
 	"sg-portal/internal/models"
+	"sg-portal/internal/subscriptions"
+	"sg-portal/internal/tenants"
 	"sg-portal/pkg/util"
 
 	"gorm.io/gorm"
@@ -12,6 +19,8 @@ type CompanyHandler struct {
 	UserRepo          *util.Repository[models.User]
 	TenantRepo        *util.Repository[models.Tenant]
 	TenantMappingRepo *util.Repository[models.UserTenantMapping]
+	OrganizationRepo  *util.Repository[models.Organization]
+	db                *gorm.DB
 }
 
 func NewCompanyHandler(db *gorm.DB) *CompanyHandler {
@@ -19,9 +28,26 @@ func NewCompanyHandler(db *gorm.DB) *CompanyHandler {
 		UserRepo:          util.NewRepository[models.User](db),
 		TenantRepo:        util.NewRepository[models.Tenant](db),
 		TenantMappingRepo: util.NewRepository[models.UserTenantMapping](db),
+		OrganizationRepo:  util.NewRepository[models.Organization](db),
+		db:                db,
 	}
 }
 
+// CompanyWithRole is a company together with the requesting user's role in it
+type CompanyWithRole struct {
+	models.Tenant
+	Role string `json:"role"`
+}
+
+// CompanyGroup is the user's companies in one organization; Organization is nil for companies
+// that do not belong to one
+type CompanyGroup struct {
+	Organization *models.Organization `json:"organization"`
+	Companies    []CompanyWithRole    `json:"companies"`
+}
+
+// GetCompanies returns the user's companies (?id=). With ?groupBy=organization they are grouped
+// by organization, ordered by name, followed by the companies outside any organization.
 func (h *CompanyHandler) GetCompanies(w http.ResponseWriter, r *http.Request) {
 
 	userId, paramErr := util.ParseUintParam(r, "id")
@@ -37,28 +63,101 @@ func (h *CompanyHandler) GetCompanies(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var tenantIds []uint64
+	roles := make(map[uint64]string, len(mappings))
 	for _, mapping := range mappings {
 		tenantIds = append(tenantIds, mapping.TenantId)
+		roles[mapping.TenantId] = mapping.Role
 	}
 
-	tenants, err := h.TenantRepo.GetAllByCondition("id IN ?", tenantIds)
+	// Deleted companies are no longer listed; other states are shown so clients can explain them
+	tenants, err := h.TenantRepo.GetAllByCondition("id IN ? AND state <> ?", tenantIds, models.TenantDeleted)
 	if err != nil || len(tenants) < 1 {
 		util.HandleError(w, http.StatusNoContent, "No companies found")
 		return
 	}
-	util.RespondJSON(w, http.StatusOK, &tenants)
+
+	companies := make([]CompanyWithRole, 0, len(tenants))
+	for _, tenant := range tenants {
+		companies = append(companies, CompanyWithRole{Tenant: tenant, Role: roles[tenant.ID]})
+	}
+	if r.URL.Query().Get("groupBy") != "organization" {
+		util.RespondJSON(w, http.StatusOK, &companies)
+		return
+	}
+
+	groups, err := h.groupByOrganization(companies)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching organizations")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &groups)
+}
+
+// groupByOrganization sorts the companies into one group per organization
+func (h *CompanyHandler) groupByOrganization(companies []CompanyWithRole) ([]CompanyGroup, error) {
+	var orgIds []uint64
+	for _, company := range companies {
+		if company.OrganizationId != nil {
+			orgIds = append(orgIds, *company.OrganizationId)
+		}
+	}
+	orgs, err := h.OrganizationRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN ?", orgIds).Order("name")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]CompanyGroup, 0, len(orgs)+1)
+	index := make(map[uint64]int, len(orgs))
+	for i := range orgs {
+		index[orgs[i].ID] = len(groups)
+		groups = append(groups, CompanyGroup{Organization: &orgs[i], Companies: []CompanyWithRole{}})
+	}
+	ungrouped := CompanyGroup{Companies: []CompanyWithRole{}}
+	for _, company := range companies {
+		if company.OrganizationId != nil {
+			if i, ok := index[*company.OrganizationId]; ok {
+				groups[i].Companies = append(groups[i].Companies, company)
+				continue
+			}
+		}
+		ungrouped.Companies = append(ungrouped.Companies, company)
+	}
+	if len(ungrouped.Companies) > 0 {
+		groups = append(groups, ungrouped)
+	}
+	return groups, nil
 }
 
+// GetUserByCompany returns the users mapped to the company in the companyid header, an empty list
+// when it has none. Only members of the company and system users may list them.
 func (h *CompanyHandler) GetUserByCompany(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
 	companyId := r.Header.Get("companyid")
 	tenant, err := h.TenantRepo.GetByField("company_guid", companyId)
 	if err != nil || tenant == nil {
-		util.HandleError(w, http.StatusNoContent, "No Tenant found")
+		util.HandleError(w, http.StatusNotFound, "No Tenant found")
 		return
 	}
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType != models.UserTypeSystem {
+		if _, err := tenants.ActiveMembership(h.db, actorID, tenant.ID); err != nil {
+			util.HandleError(w, http.StatusForbidden, "Only members of the company can see its members")
+			return
+		}
+	}
 	mappings, mappingErr := h.TenantMappingRepo.GetAllByCondition("tenant_id = ?", tenant.ID)
-	if mappingErr != nil || len(mappings) < 1 {
-		util.HandleError(w, http.StatusNoContent, "No users found for the company")
+	if mappingErr != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching users for the company")
+		return
+	}
+	users := []models.User{}
+	if len(mappings) < 1 {
+		util.RespondJSON(w, http.StatusOK, &users)
 		return
 	}
 
@@ -68,10 +167,203 @@ func (h *CompanyHandler) GetUserByCompany(w http.ResponseWriter, r *http.Request
 	}
 
 	users, userErr := h.UserRepo.GetAllByCondition("id IN ?", userIds)
-	if userErr != nil || len(users) < 1 {
-		util.HandleError(w, http.StatusNoContent, "No users found for the company")
+	if userErr != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching users for the company")
 		return
 	}
 
 	util.RespondJSON(w, http.StatusOK, &users)
 }
+
+// CompanyMember is one member of a company as shown to its members
+type CompanyMember struct {
+	UserId        uint64     `json:"user_id"`
+	Name          string     `json:"name"`
+	Email         string     `json:"email"`
+	MobileNumber  string     `json:"mobile_number"`
+	IsActive      bool       `json:"is_active"` // The user's account, across companies
+	Role          string     `json:"role"`
+	InheritedFrom uint64     `json:"inherited_from,omitempty"` // Organization that grants the membership
+	SeatActive    bool       `json:"seat_active"`
+	Features      []string   `json:"features"`      // Permission codes granted in the company
+	Subscriptions []string   `json:"subscriptions"` // Codes of the plans the user holds, directly or through an organization
+	Subscribed    bool       `json:"subscribed"`
+	LastLogin     *time.Time `json:"last_login_time"`
+}
+
+// companyMembersPage is a page of a company's members, ordered by name, with the invitations still
+// waiting to be accepted
+type companyMembersPage struct {
+	Members            []CompanyMember           `json:"members"`
+	Total              int64                     `json:"total"`
+	Limit              int                       `json:"limit"`
+	Offset             int                       `json:"offset"`
+	PendingInvitations []models.TenantInvitation `json:"pending_invitations,omitempty"` // Only shown to owners and admins
+}
+
+// GetMembers lists the members of the company in the companyid header. Only its members and system
+// users can see them; pending invitations are included for owners and admins.
+// Optional filters: search (name, email or mobile number), role, seat (active or deactivated),
+// feature (permission code, held directly or through a wildcard), subscribed (true or false), limit and offset.
+func (h *CompanyHandler) GetMembers(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	query := r.URL.Query()
+	limit, offset, err := util.ParsePagination(r, 25, 100)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var subscribed *bool
+	if query.Has("subscribed") {
+		value, err := strconv.ParseBool(query.Get("subscribed"))
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, "invalid parameter: subscribed")
+			return
+		}
+		subscribed = &value
+	}
+	if role := query.Get("role"); role != "" && !models.IsValidRole(role) {
+		util.HandleError(w, http.StatusBadRequest, "invalid parameter: role")
+		return
+	}
+	seat := query.Get("seat")
+	if seat != "" && seat != "active" && seat != "deactivated" {
+		util.HandleError(w, http.StatusBadRequest, "invalid parameter: seat")
+		return
+	}
+
+	tenant, err := h.TenantRepo.GetByField("company_guid", r.Header.Get("companyid"))
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Company not found")
+		return
+	}
+	manager := false
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType == models.UserTypeSystem {
+		manager = true
+	} else {
+		actor, err := tenants.ActiveMembership(h.db, actorID, tenant.ID)
+		if err != nil {
+			util.HandleError(w, http.StatusForbidden, "Only members of the company can see its members")
+			return
+		}
+		manager = tenants.CanManageMembers(actor.Role)
+	}
+
+	members := h.db.Table("user_tenant_mappings AS m").Joins("JOIN users u ON u.id = m.user_id").Where("m.tenant_id = ?", tenant.ID)
+	if search := strings.ToLower(strings.TrimSpace(query.Get("search"))); search != "" {
+		pattern := "%" + search + "%"
+		members = members.Where("(LOWER(u.name) LIKE ? OR LOWER(u.email) LIKE ? OR u.mobile_number LIKE ?)", pattern, pattern, pattern)
+	}
+	if role := query.Get("role"); role != "" {
+		members = members.Where("m.role = ?", role)
+	}
+	switch seat {
+	case "active":
+		members = members.Where("m.deactivated_at IS NULL")
+	case "deactivated":
+		members = members.Where("m.deactivated_at IS NOT NULL")
+	}
+	if feature := query.Get("feature"); feature != "" {
+		holders, err := features.Holders(h.db, tenant.ID, feature)
+		if err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching features")
+			return
+		}
+		members = members.Where("m.user_id IN ?", holders)
+	}
+	if subscribed != nil {
+		direct := h.db.Model(&models.UserSubscriptionMapping{}).Select("user_id")
+		inherited := h.db.Model(&models.OrganizationMember{}).Select("user_id").
+			Where("organization_id IN (?)", h.db.Model(&models.OrganizationSubscription{}).Select("organization_id"))
+		if *subscribed {
+			members = members.Where("(m.user_id IN (?) OR m.user_id IN (?))", direct, inherited)
+		} else {
+			members = members.Where("m.user_id NOT IN (?) AND m.user_id NOT IN (?)", direct, inherited)
+		}
+	}
+
+	page := &companyMembersPage{Members: []CompanyMember{}, Limit: limit, Offset: offset}
+	if err := members.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error counting members")
+		return
+	}
+	var rows []struct {
+		models.UserTenantMapping
+		Name         string
+		Email        string
+		MobileNumber string
+		IsActive     bool
+		LastLogin    *time.Time
+	}
+	if err := members.Select("m.user_id, m.role, m.inherited_from, m.deactivated_at, u.name, u.email, u.mobile_number, u.is_active, u.last_login").
+		Order("u.name, u.id").Limit(limit).Offset(offset).Scan(&rows).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching members")
+		return
+	}
+
+	held := make(map[uint64][]uint32, len(rows))
+	var subscriptionIds []uint32
+	for _, row := range rows {
+		ids, err := subscriptions.ForUser(h.db, row.UserId)
+		if err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching subscriptions")
+			return
+		}
+		held[row.UserId] = ids
+		subscriptionIds = append(subscriptionIds, ids...)
+	}
+	codes := make(map[uint32]string)
+	if len(subscriptionIds) > 0 {
+		var plans []models.Subscription
+		if err := h.db.Where("id IN ?", subscriptionIds).Find(&plans).Error; err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching subscriptions")
+			return
+		}
+		for _, plan := range plans {
+			codes[plan.ID] = plan.Code
+		}
+	}
+
+	for _, row := range rows {
+		permissions, err := features.Permissions(h.db, row.UserId, tenant.ID)
+		if err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching features")
+			return
+		}
+		member := CompanyMember{
+			UserId:        row.UserId,
+			Name:          row.Name,
+			Email:         row.Email,
+			MobileNumber:  row.MobileNumber,
+			IsActive:      row.IsActive,
+			Role:          row.Role,
+			InheritedFrom: row.InheritedFrom,
+			SeatActive:    row.DeactivatedAt == nil,
+			Features:      permissions,
+			Subscriptions: []string{},
+			LastLogin:     row.LastLogin,
+		}
+		for _, id := range held[row.UserId] {
+			member.Subscriptions = append(member.Subscriptions, codes[id])
+		}
+		member.Subscribed = len(member.Subscriptions) > 0
+		page.Members = append(page.Members, member)
+	}
+
+	if manager {
+		if err := tenants.ExpireInvitations(h.db, tenant.ID, time.Now()); err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching invitations")
+			return
+		}
+		if err := h.db.Where("tenant_id = ? AND status = ?", tenant.ID, models.InvitationPending).
+			Order("created_at DESC, id DESC").Find(&page.PendingInvitations).Error; err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching invitations")
+			return
+		}
+	}
+	util.RespondJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,98 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"sg-portal/internal/models"
+)
+
+// TestCompanyMembers checks that members of a company see its members with their roles, features and
+// plans, filtered and paginated, and that only owners and admins see the pending invitations
+func TestCompanyMembers(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	companyHandler := NewCompanyHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", State: models.TenantActive}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	seller, sellerToken := createTestUser(t, db, "seller@example.com", "9000000002", models.UserTypeClient)
+	viewer, _ := createTestUser(t, db, "viewer@example.com", "9000000003", models.UserTypeClient)
+	_, outsiderToken := createTestUser(t, db, "outsider@example.com", "9000000004", models.UserTypeClient)
+	now := time.Now()
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	db.Create(&models.UserTenantMapping{UserId: seller.ID, TenantId: tenant.ID, Role: models.RoleMember})
+	db.Create(&models.UserTenantMapping{UserId: viewer.ID, TenantId: tenant.ID, Role: models.RoleViewer, DeactivatedAt: &now})
+	db.Model(owner).Update("last_login", now)
+	pro := &models.Subscription{Name: "Pro", Code: "pro"}
+	db.Create(pro)
+	db.Create(&models.UserSubscriptionMapping{UserId: owner.ID, SubscriptionId: pro.ID})
+	feature := &models.Feature{Name: "Sales report", Permission: "sales.report"}
+	db.Create(feature)
+	db.Create(&models.UserFeatureMapping{UserId: seller.ID, TenantId: tenant.ID, FeatureId: feature.ID})
+	db.Create(&models.TenantInvitation{TenantId: tenant.ID, Email: "new@example.com", Role: models.RoleMember,
+		InvitedBy: owner.ID, Status: models.InvitationPending, ExpiresAt: now.Add(time.Hour)})
+
+	list := func(token *models.Token, query string) (int, companyMembersPage) {
+		req, _ := http.NewRequest(http.MethodGet, "/companies/members"+query, nil)
+		req.Header.Set("token", token.Value.String())
+		req.Header.Set("companyid", tenant.CompanyGuid)
+		rr := executeRequest(req, authHandler.Authenticate(companyHandler.GetMembers))
+		var page companyMembersPage
+		json.Unmarshal(rr.Body.Bytes(), &page)
+		return rr.Code, page
+	}
+
+	code, page := list(ownerToken, "")
+	if code != http.StatusOK || page.Total != 3 || len(page.Members) != 3 || len(page.PendingInvitations) != 1 {
+		t.Fatalf("Expected three members and one invitation, got %d %+v", code, page)
+	}
+	first := page.Members[0]
+	if first.UserId != owner.ID || first.Role != models.RoleOwner || !first.Subscribed || first.Subscriptions[0] != "pro" || first.LastLogin == nil {
+		t.Errorf("Unexpected owner entry %+v", first)
+	}
+	if page.Members[1].UserId != seller.ID || len(page.Members[1].Features) != 1 || page.Members[1].Features[0] != "sales.report" {
+		t.Errorf("Expected the seller's features, got %+v", page.Members[1])
+	}
+	if page.Members[2].SeatActive {
+		t.Errorf("Expected the viewer's seat to show as deactivated, got %+v", page.Members[2])
+	}
+
+	// Filters combine and the total counts every match, not only the page
+	if _, page := list(ownerToken, "?search=SELL&feature=sales.report"); page.Total != 1 || page.Members[0].UserId != seller.ID {
+		t.Errorf("Expected the seller alone, got %+v", page)
+	}
+	if _, page := list(ownerToken, "?subscribed=false&seat=active"); page.Total != 1 || page.Members[0].UserId != seller.ID {
+		t.Errorf("Expected the active member without a plan, got %+v", page)
+	}
+	if _, page := list(ownerToken, "?limit=1&offset=1"); page.Total != 3 || len(page.Members) != 1 || page.Members[0].UserId != seller.ID {
+		t.Errorf("Expected the second member on a page of one, got %+v", page)
+	}
+	if code, _ := list(ownerToken, "?role=boss"); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an unknown role, got %d", http.StatusBadRequest, code)
+	}
+
+	if _, page := list(sellerToken, ""); page.Total != 3 || page.PendingInvitations != nil {
+		t.Errorf("Expected members without invitations for a plain member, got %+v", page)
+	}
+	if code, _ := list(outsiderToken, ""); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for someone outside the company, got %d", http.StatusForbidden, code)
+	}
+
+	// The legacy user list is limited to members as well
+	legacy := func(token *models.Token) int {
+		req, _ := http.NewRequest(http.MethodGet, "/companies/get/users", nil)
+		req.Header.Set("token", token.Value.String())
+		req.Header.Set("companyid", tenant.CompanyGuid)
+		return executeRequest(req, authHandler.Authenticate(companyHandler.GetUserByCompany)).Code
+	}
+	if code := legacy(sellerToken); code != http.StatusOK {
+		t.Errorf("Expected status code %d for a member, got %d", http.StatusOK, code)
+	}
+	if code := legacy(outsiderToken); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for someone outside the company, got %d", http.StatusForbidden, code)
+	}
+}
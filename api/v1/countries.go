@@ -0,0 +1,33 @@
+package v1
+
+import (
+	"net/http"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+type CountryHandler struct {
+	CountryRepo *util.Repository[models.Country]
+}
+
+// NewCountryHandler initializes the CountryHandler with the repository
+func NewCountryHandler(db *gorm.DB) *CountryHandler {
+	return &CountryHandler{
+		CountryRepo: util.NewRepository[models.Country](db),
+	}
+}
+
+// GetAllCountries returns the country reference data ordered by name
+func (h *CountryHandler) GetAllCountries(w http.ResponseWriter, r *http.Request) {
+	countries, err := h.CountryRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Order("name")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching countries")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &countries)
+}
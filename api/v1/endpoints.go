@@ -0,0 +1,198 @@
+package v1
+
+import (
+	"net/http"
+	"strings"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+type EndpointHandler struct {
+	TenantRepo   *util.Repository[models.Tenant]
+	EndpointRepo *util.Repository[models.TenantEndpoint]
+	db           *gorm.DB
+}
+
+// NewEndpointHandler initializes the EndpointHandler with the repositories
+func NewEndpointHandler(db *gorm.DB) *EndpointHandler {
+	return &EndpointHandler{
+		TenantRepo:   util.NewRepository[models.Tenant](db),
+		EndpointRepo: util.NewRepository[models.TenantEndpoint](db),
+		db:           db,
+	}
+}
+
+// EndpointRequest is the writable part of a tenant endpoint
+type EndpointRequest struct {
+	Service  string `json:"service"`
+	Host     string `json:"host"`
+	Port     uint32 `json:"port"`
+	Role     string `json:"role"`
+	Priority int    `json:"priority"`
+	Weight   *int   `json:"weight"`
+}
+
+// validate normalises the request and returns a message describing the first invalid field
+func (req *EndpointRequest) validate() string {
+	req.Host = strings.TrimSpace(req.Host)
+	if req.Role == "" {
+		req.Role = models.EndpointPrimary
+	}
+	if req.Weight == nil {
+		weight := 1
+		req.Weight = &weight
+	}
+	switch {
+	case !models.IsValidService(req.Service):
+		return "Invalid service"
+	case req.Host == "" || req.Port == 0:
+		return "Host and port are required"
+	case req.Role != models.EndpointPrimary && req.Role != models.EndpointStandby:
+		return "Role must be primary or standby"
+	case *req.Weight < 0:
+		return "Weight cannot be negative"
+	}
+	return ""
+}
+
+// GetEndpoints returns every endpoint of a tenant with its health (?tenantId=)
+func (h *EndpointHandler) GetEndpoints(w http.ResponseWriter, r *http.Request) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	endpoints, err := tenants.Endpoints(h.db, tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching endpoints")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &endpoints)
+}
+
+// CreateEndpoint adds an endpoint to a tenant (?tenantId=)
+func (h *EndpointHandler) CreateEndpoint(w http.ResponseWriter, r *http.Request) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := h.TenantRepo.GetByField("id", tenantId); err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	req, err := util.ParseJSONBody[EndpointRequest](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	if message := req.validate(); message != "" {
+		util.HandleError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	endpoint := &models.TenantEndpoint{
+		TenantId: tenantId,
+		Service:  req.Service,
+		Host:     req.Host,
+		Port:     req.Port,
+		Role:     req.Role,
+		Priority: req.Priority,
+		Weight:   *req.Weight,
+		Health:   models.HealthUnknown,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(endpoint).Error; err != nil {
+			return err
+		}
+		return tenants.SyncLegacyFields(tx, tenantId)
+	})
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "The tenant already has this endpoint")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error creating endpoint")
+		return
+	}
+	util.RespondJSON(w, http.StatusCreated, endpoint)
+}
+
+// UpdateEndpoint replaces the settings of an endpoint (?id=); a changed address resets its health
+func (h *EndpointHandler) UpdateEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	endpoint, err := h.EndpointRepo.GetByField("id", id)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Endpoint not found")
+		return
+	}
+	req, err := util.ParseJSONBody[EndpointRequest](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	if message := req.validate(); message != "" {
+		util.HandleError(w, http.StatusBadRequest, message)
+		return
+	}
+
+	updates := map[string]interface{}{
+		"service":  req.Service,
+		"host":     req.Host,
+		"port":     req.Port,
+		"role":     req.Role,
+		"priority": req.Priority,
+		"weight":   *req.Weight,
+	}
+	if req.Host != endpoint.Host || req.Port != endpoint.Port || req.Service != endpoint.Service {
+		updates["health"] = models.HealthUnknown
+		updates["checked_at"] = nil
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(endpoint).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tenants.SyncLegacyFields(tx, endpoint.TenantId)
+	})
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "The tenant already has this endpoint")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating endpoint")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, endpoint)
+}
+
+// DeleteEndpoint removes an endpoint (?id=)
+func (h *EndpointHandler) DeleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	endpoint, err := h.EndpointRepo.GetByField("id", id)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Endpoint not found")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(endpoint).Error; err != nil {
+			return err
+		}
+		return tenants.SyncLegacyFields(tx, endpoint.TenantId)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error deleting endpoint")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package v1
+
+import (
+	"net/http"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+type EventHandler struct {
+	EventRepo *util.Repository[models.Event]
+}
+
+// NewEventHandler initializes the EventHandler with the repository
+func NewEventHandler(db *gorm.DB) *EventHandler {
+	return &EventHandler{
+		EventRepo: util.NewRepository[models.Event](db),
+	}
+}
+
+// GetEvents returns events after the ?since= event ID in order, optionally filtered by type and tenantId,
+// so services can poll for changes
+func (h *EventHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var since, tenantId uint64
+	var err error
+	if query.Has("since") {
+		if since, err = util.ParseUintParam(r, "since"); err != nil {
+			util.HandleError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if query.Has("tenantId") {
+		if tenantId, err = util.ParseUintParam(r, "tenantId"); err != nil {
+			util.HandleError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	limit, _, err := util.ParsePagination(r, 100, 1000)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, err := h.EventRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		db = db.Where("id > ?", since)
+		if eventType := query.Get("type"); eventType != "" {
+			db = db.Where("type = ?", eventType)
+		}
+		if tenantId != 0 {
+			db = db.Where("tenant_id = ?", tenantId)
+		}
+		return db.Order("id").Limit(limit)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching events")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &events)
+}
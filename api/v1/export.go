@@ -0,0 +1,456 @@
+package v1
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// exportBatchSize bounds how many rows are held in memory while streaming an export
+const exportBatchSize = 500
+
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatNDJSON = "ndjson"
+)
+
+type ExportHandler struct {
+	UserRepo                *util.Repository[models.User]
+	TenantRepo              *util.Repository[models.Tenant]
+	TenantMappingRepo       *util.Repository[models.UserTenantMapping]
+	SubscriptionRepo        *util.Repository[models.Subscription]
+	UserSubscriptionRepo    *util.Repository[models.UserSubscriptionMapping]
+	SubscriptionHistoryRepo *util.Repository[models.UserSubscriptionHistory]
+	FeatureRepo             *util.Repository[models.Feature]
+	UserFeatureRepo         *util.Repository[models.UserFeatureMapping]
+	WildcardGrantRepo       *util.Repository[models.UserWildcardGrant]
+}
+
+// NewExportHandler initializes the ExportHandler with the repositories
+func NewExportHandler(db *gorm.DB) *ExportHandler {
+	return &ExportHandler{
+		UserRepo:                util.NewRepository[models.User](db),
+		TenantRepo:              util.NewRepository[models.Tenant](db),
+		TenantMappingRepo:       util.NewRepository[models.UserTenantMapping](db),
+		SubscriptionRepo:        util.NewRepository[models.Subscription](db),
+		UserSubscriptionRepo:    util.NewRepository[models.UserSubscriptionMapping](db),
+		SubscriptionHistoryRepo: util.NewRepository[models.UserSubscriptionHistory](db),
+		FeatureRepo:             util.NewRepository[models.Feature](db),
+		UserFeatureRepo:         util.NewRepository[models.UserFeatureMapping](db),
+		WildcardGrantRepo:       util.NewRepository[models.UserWildcardGrant](db),
+	}
+}
+
+// ExportCompany is a tenant the exported user is mapped to
+type ExportCompany struct {
+	CompanyGuid string   `json:"company_guid"`
+	CompanyName string   `json:"company_name"`
+	Role        string   `json:"role"`
+	Features    []string `json:"features,omitempty"`
+}
+
+// ExportSubscription is a subscription the exported user holds, with its history when present
+type ExportSubscription struct {
+	Code             string     `json:"code"`
+	Name             string     `json:"name"`
+	StartDate        *time.Time `json:"start_date,omitempty"`
+	ExpiryDate       *time.Time `json:"expiry_date,omitempty"`
+	NumberOfRenewals uint16     `json:"number_of_renewals"`
+}
+
+// UserExportRecord is one user joined with their companies, subscriptions and features
+type UserExportRecord struct {
+	ID            uint64               `json:"id"`
+	Email         string               `json:"email"`
+	Name          string               `json:"name"`
+	MobileNumber  string               `json:"mobile_number"`
+	Type          string               `json:"type"`
+	IsActive      bool                 `json:"is_active"`
+	LastLogin     *time.Time           `json:"last_login_time"`
+	CreatedAt     time.Time            `json:"created_at"`
+	Companies     []ExportCompany      `json:"companies"`
+	Subscriptions []ExportSubscription `json:"subscriptions"`
+	Features      []string             `json:"features"`
+}
+
+var userExportColumns = []string{
+	"id", "email", "name", "mobile_number", "type", "is_active", "last_login_time", "created_at",
+	"company_guids", "company_names", "subscriptions", "subscription_expiry", "features",
+}
+
+// csvRow flattens the record into userExportColumns, joining lists with "|"
+func (rec *UserExportRecord) csvRow() []string {
+	var guids, names, codes, expiries []string
+	for _, company := range rec.Companies {
+		guids = append(guids, company.CompanyGuid)
+		names = append(names, company.CompanyName)
+	}
+	for _, subscription := range rec.Subscriptions {
+		codes = append(codes, subscription.Code)
+		expiries = append(expiries, formatExportTime(subscription.ExpiryDate))
+	}
+	return []string{
+		strconv.FormatUint(rec.ID, 10), rec.Email, rec.Name, rec.MobileNumber, rec.Type,
+		strconv.FormatBool(rec.IsActive), formatExportTime(rec.LastLogin), formatExportTime(&rec.CreatedAt),
+		strings.Join(guids, "|"), strings.Join(names, "|"),
+		strings.Join(codes, "|"), strings.Join(expiries, "|"), strings.Join(rec.Features, "|"),
+	}
+}
+
+// TenantMappingExportRecord is one user-tenant mapping joined with both sides
+type TenantMappingExportRecord struct {
+	TenantId    uint64 `json:"tenant_id"`
+	CompanyGuid string `json:"company_guid"`
+	CompanyName string `json:"company_name"`
+	Host        string `json:"host"`
+	UserId      uint64 `json:"user_id"`
+	Email       string `json:"email"`
+	Name        string `json:"name"`
+}
+
+var tenantMappingExportColumns = []string{
+	"tenant_id", "company_guid", "company_name", "host", "user_id", "email", "name",
+}
+
+func (rec *TenantMappingExportRecord) csvRow() []string {
+	return []string{
+		strconv.FormatUint(rec.TenantId, 10), rec.CompanyGuid, rec.CompanyName, rec.Host,
+		strconv.FormatUint(rec.UserId, 10), rec.Email, rec.Name,
+	}
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// exportWriter streams records in the requested format, flushing to the client after every batch
+type exportWriter struct {
+	format  string
+	csv     *csv.Writer
+	json    *json.Encoder
+	flusher http.Flusher
+}
+
+// newExportWriter reads the "format" query parameter, sets the response headers and writes the CSV header row
+func newExportWriter(w http.ResponseWriter, r *http.Request, name string, columns []string) (*exportWriter, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+
+	ew := &exportWriter{format: format}
+	ew.flusher, _ = w.(http.Flusher)
+
+	filename := name + "-" + time.Now().Format("20060102-150405")
+	switch format {
+	case ExportFormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".csv\"")
+		ew.csv = csv.NewWriter(w)
+		ew.csv.Write(columns)
+	case ExportFormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".ndjson\"")
+		ew.json = json.NewEncoder(w)
+	default:
+		util.HandleError(w, http.StatusBadRequest, "invalid parameter: format")
+		return nil, false
+	}
+	w.WriteHeader(http.StatusOK)
+	return ew, true
+}
+
+func (ew *exportWriter) write(row []string, record any) error {
+	if ew.csv != nil {
+		return ew.csv.Write(row)
+	}
+	return ew.json.Encode(record)
+}
+
+func (ew *exportWriter) flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		if err := ew.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if ew.flusher != nil {
+		ew.flusher.Flush()
+	}
+	return nil
+}
+
+// ExportUsers streams every user matching the list filters joined with their companies,
+// subscriptions and features as CSV or NDJSON (?format=csv|ndjson).
+func (h *ExportHandler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ew, ok := newExportWriter(w, r, "users", userExportColumns)
+	if !ok {
+		return
+	}
+
+	err = h.UserRepo.FindInBatches(exportBatchSize, func(users []models.User) error {
+		userIds := make([]uint64, 0, len(users))
+		for _, user := range users {
+			userIds = append(userIds, user.ID)
+		}
+
+		tenantMappings, err := h.TenantMappingRepo.GetAllByCondition("user_id IN ?", userIds)
+		if err != nil {
+			return err
+		}
+		subscriptionMappings, err := h.UserSubscriptionRepo.GetAllByCondition("user_id IN ?", userIds)
+		if err != nil {
+			return err
+		}
+		histories, err := h.SubscriptionHistoryRepo.GetAllByCondition("user_id IN ?", userIds)
+		if err != nil {
+			return err
+		}
+		featureMappings, err := h.UserFeatureRepo.GetAllByCondition("user_id IN ?", userIds)
+		if err != nil {
+			return err
+		}
+		wildcardGrants, err := h.WildcardGrantRepo.GetAllByCondition("user_id IN ?", userIds)
+		if err != nil {
+			return err
+		}
+
+		// Only the tenants, subscriptions and features this batch refers to are loaded
+		tenantIds := make([]uint64, 0, len(tenantMappings))
+		for _, mapping := range tenantMappings {
+			tenantIds = append(tenantIds, mapping.TenantId)
+		}
+		tenants, err := h.TenantRepo.GetAllByCondition("id IN ?", tenantIds)
+		if err != nil {
+			return err
+		}
+		tenantsById := make(map[uint64]models.Tenant, len(tenants))
+		for _, tenant := range tenants {
+			tenantsById[tenant.ID] = tenant
+		}
+
+		subscriptionIds := make([]uint32, 0, len(subscriptionMappings))
+		for _, mapping := range subscriptionMappings {
+			subscriptionIds = append(subscriptionIds, mapping.SubscriptionId)
+		}
+		subscriptions, err := h.SubscriptionRepo.GetAllByCondition("id IN ?", subscriptionIds)
+		if err != nil {
+			return err
+		}
+		subscriptionsById := make(map[uint32]models.Subscription, len(subscriptions))
+		for _, subscription := range subscriptions {
+			subscriptionsById[subscription.ID] = subscription
+		}
+
+		featureIds := make([]uint32, 0, len(featureMappings))
+		for _, mapping := range featureMappings {
+			featureIds = append(featureIds, mapping.FeatureId)
+		}
+		features, err := h.FeatureRepo.GetAllByCondition("id IN ?", featureIds)
+		if err != nil {
+			return err
+		}
+		featuresById := make(map[uint32]models.Feature, len(features))
+		for _, feature := range features {
+			featuresById[feature.ID] = feature
+		}
+
+		// Features are listed once per user however many companies grant them
+		featuresByUser := make(map[uint64][]string)
+		featuresByCompany := make(map[uint64]map[uint64][]string)
+		seen := make(map[uint64]map[uint32]bool)
+		for _, mapping := range featureMappings {
+			feature, ok := featuresById[mapping.FeatureId]
+			if !ok {
+				continue
+			}
+			if featuresByCompany[mapping.UserId] == nil {
+				featuresByCompany[mapping.UserId] = make(map[uint64][]string)
+				seen[mapping.UserId] = make(map[uint32]bool)
+			}
+			featuresByCompany[mapping.UserId][mapping.TenantId] = append(featuresByCompany[mapping.UserId][mapping.TenantId], feature.Permission)
+			if !seen[mapping.UserId][feature.ID] {
+				seen[mapping.UserId][feature.ID] = true
+				featuresByUser[mapping.UserId] = append(featuresByUser[mapping.UserId], feature.Permission)
+			}
+		}
+		// Wildcard grants are listed as granted, e.g. sales.*
+		seenPatterns := make(map[uint64]map[string]bool)
+		for _, grant := range wildcardGrants {
+			if featuresByCompany[grant.UserId] == nil {
+				featuresByCompany[grant.UserId] = make(map[uint64][]string)
+			}
+			if seenPatterns[grant.UserId] == nil {
+				seenPatterns[grant.UserId] = make(map[string]bool)
+			}
+			featuresByCompany[grant.UserId][grant.TenantId] = append(featuresByCompany[grant.UserId][grant.TenantId], grant.Pattern)
+			if !seenPatterns[grant.UserId][grant.Pattern] {
+				seenPatterns[grant.UserId][grant.Pattern] = true
+				featuresByUser[grant.UserId] = append(featuresByUser[grant.UserId], grant.Pattern)
+			}
+		}
+
+		companiesByUser := make(map[uint64][]ExportCompany)
+		for _, mapping := range tenantMappings {
+			if tenant, ok := tenantsById[mapping.TenantId]; ok {
+				companiesByUser[mapping.UserId] = append(companiesByUser[mapping.UserId], ExportCompany{
+					CompanyGuid: tenant.CompanyGuid,
+					CompanyName: tenant.CompanyName,
+					Role:        mapping.Role,
+					Features:    featuresByCompany[mapping.UserId][mapping.TenantId],
+				})
+			}
+		}
+
+		historyByUser := make(map[uint64]map[uint32]models.UserSubscriptionHistory)
+		for _, history := range histories {
+			if historyByUser[history.UserId] == nil {
+				historyByUser[history.UserId] = make(map[uint32]models.UserSubscriptionHistory)
+			}
+			historyByUser[history.UserId][history.SubscriptionId] = history
+		}
+
+		subscriptionsByUser := make(map[uint64][]ExportSubscription)
+		for _, mapping := range subscriptionMappings {
+			subscription, ok := subscriptionsById[mapping.SubscriptionId]
+			if !ok {
+				continue
+			}
+			entry := ExportSubscription{Code: subscription.Code, Name: subscription.Name}
+			if history, ok := historyByUser[mapping.UserId][mapping.SubscriptionId]; ok {
+				entry.StartDate = &history.StartDate
+				entry.ExpiryDate = &history.ExpiryDate
+				entry.NumberOfRenewals = history.NumberOfRenewals
+			}
+			subscriptionsByUser[mapping.UserId] = append(subscriptionsByUser[mapping.UserId], entry)
+		}
+
+		for _, user := range users {
+			record := UserExportRecord{
+				ID:            user.ID,
+				Email:         user.Email,
+				Name:          user.Name,
+				MobileNumber:  user.MobileNumber,
+				Type:          user.Type,
+				IsActive:      user.IsActive,
+				LastLogin:     user.LastLogin,
+				CreatedAt:     user.CreatedAt,
+				Companies:     companiesByUser[user.ID],
+				Subscriptions: subscriptionsByUser[user.ID],
+				Features:      featuresByUser[user.ID],
+			}
+			if err := ew.write(record.csvRow(), &record); err != nil {
+				return err
+			}
+		}
+		return ew.flush()
+	}, filter.Scope)
+
+	// The status line has already been sent, so a failure can only cut the stream short
+	if err != nil {
+		log.Printf("[!] users export aborted: %v\n", err)
+	}
+}
+
+// ExportTenantMappings streams one row per user-tenant mapping joined with the tenant and user.
+// It accepts the same userId and tenantId filters as the tenant list endpoints.
+func (h *ExportHandler) ExportTenantMappings(w http.ResponseWriter, r *http.Request) {
+	var conditions []string
+	var args []interface{}
+	query := r.URL.Query()
+	if query.Has("userId") {
+		userId, err := util.ParseUintParam(r, "userId")
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, userId)
+	}
+	if query.Has("tenantId") {
+		tenantId, err := util.ParseUintParam(r, "tenantId")
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		conditions = append(conditions, "tenant_id = ?")
+		args = append(args, tenantId)
+	}
+	scope := func(db *gorm.DB) *gorm.DB {
+		if len(conditions) == 0 {
+			return db
+		}
+		return db.Where(strings.Join(conditions, " AND "), args...)
+	}
+
+	ew, ok := newExportWriter(w, r, "tenant-mappings", tenantMappingExportColumns)
+	if !ok {
+		return
+	}
+
+	err := h.TenantMappingRepo.FindInBatches(exportBatchSize, func(mappings []models.UserTenantMapping) error {
+		var userIds, tenantIds []uint64
+		for _, mapping := range mappings {
+			userIds = append(userIds, mapping.UserId)
+			tenantIds = append(tenantIds, mapping.TenantId)
+		}
+
+		users, err := h.UserRepo.GetAllByCondition("id IN ?", userIds)
+		if err != nil {
+			return err
+		}
+		usersById := make(map[uint64]models.User, len(users))
+		for _, user := range users {
+			usersById[user.ID] = user
+		}
+
+		tenants, err := h.TenantRepo.GetAllByCondition("id IN ?", tenantIds)
+		if err != nil {
+			return err
+		}
+		tenantsById := make(map[uint64]models.Tenant, len(tenants))
+		for _, tenant := range tenants {
+			tenantsById[tenant.ID] = tenant
+		}
+
+		for _, mapping := range mappings {
+			tenant := tenantsById[mapping.TenantId]
+			user := usersById[mapping.UserId]
+			record := TenantMappingExportRecord{
+				TenantId:    mapping.TenantId,
+				CompanyGuid: tenant.CompanyGuid,
+				CompanyName: tenant.CompanyName,
+				Host:        tenant.Host,
+				UserId:      mapping.UserId,
+				Email:       user.Email,
+				Name:        user.Name,
+			}
+			if err := ew.write(record.csvRow(), &record); err != nil {
+				return err
+			}
+		}
+		return ew.flush()
+	}, scope)
+
+	if err != nil {
+		log.Printf("[!] tenant mappings export aborted: %v\n", err)
+	}
+}
@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/models"
+)
+
+// TestExportUsers checks that users are streamed joined with their companies, subscriptions and features
+func TestExportUsers(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+
+	users := []models.User{
+		{Email: "a@example.com", Name: "Alpha", MobileNumber: "1111111111", Type: models.UserTypeClient},
+		{Email: "b@example.com", Name: "Beta", MobileNumber: "2222222222", Type: models.UserTypeSystem},
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatalf("Failed to create users: %v", err)
+	}
+	db.Create(&models.UserTenantMapping{UserId: users[0].ID, TenantId: 1})
+	db.Create(&models.UserSubscriptionMapping{UserId: users[0].ID, SubscriptionId: 1})
+	db.Create(&models.UserFeatureMapping{UserId: users[0].ID, TenantId: 1, FeatureId: 1})
+
+	exportHandler := NewExportHandler(db)
+
+	req, _ := http.NewRequest(http.MethodGet, "/users/export?format=csv&type=client", nil)
+	rr := executeRequest(req, exportHandler.ExportUsers)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV output: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected header and 1 row, got %d rows", len(rows))
+	}
+	row := rows[1]
+	if row[1] != "a@example.com" || row[8] != "default" || row[10] != "demo" || row[12] != "sales.report" {
+		t.Errorf("Unexpected export row: %v", row)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/users/export?format=ndjson", nil)
+	rr = executeRequest(req, exportHandler.ExportUsers)
+
+	var records []UserExportRecord
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var record UserExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if len(records[0].Companies) != 1 || len(records[1].Companies) != 0 {
+		t.Errorf("Unexpected companies in export: %+v", records)
+	}
+}
@@ -2,15 +2,20 @@ package v1
 
 import (
 	"net/http"
+	"strings"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sg-portal/internal/features"
 	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
 	"sg-portal/pkg/util"
 )
 
 type FeatureHandler struct {
 	FeatureRepo     *util.Repository[models.Feature]
 	UserFeatureRepo *util.Repository[models.UserFeatureMapping]
+	db              *gorm.DB
 }
 
 // NewFeatureHandler initializes the FeatureHandler with the repositories
@@ -18,15 +23,57 @@ func NewFeatureHandler(db *gorm.DB) *FeatureHandler {
 	return &FeatureHandler{
 		FeatureRepo:     util.NewRepository[models.Feature](db),
 		UserFeatureRepo: util.NewRepository[models.UserFeatureMapping](db),
+		db:              db,
 	}
 }
 
+// resolveGrantTenant returns the tenant a permission request is scoped to, taken from the
+// tenantId query parameter or the companyid header. The user must be a member of it, and the caller
+// an owner or admin of it or a system user; with allowSelf, users may also act on themselves.
+func (h *FeatureHandler) resolveGrantTenant(w http.ResponseWriter, r *http.Request, userId uint64, allowSelf bool) (uint64, bool) {
+	var tenantId uint64
+	if r.URL.Query().Has("tenantId") {
+		id, err := util.ParseUintParam(r, "tenantId")
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, err.Error())
+			return 0, false
+		}
+		tenantId = id
+	} else if companyId := r.Header.Get("companyid"); companyId != "" {
+		tenant, err := tenants.ByCompanyGuid(h.db, companyId)
+		if err != nil {
+			util.HandleError(w, http.StatusNotFound, "Company not found")
+			return 0, false
+		}
+		tenantId = tenant.ID
+	} else {
+		util.HandleError(w, http.StatusBadRequest, "tenantId or companyid is required")
+		return 0, false
+	}
+
+	if _, err := tenants.Membership(h.db, userId, tenantId); err != nil {
+		util.HandleError(w, http.StatusBadRequest, "User is not a member of the tenant")
+		return 0, false
+	}
+	if actorID, _ := util.UserIDFromContext(r.Context()); allowSelf && actorID == userId {
+		return tenantId, true
+	}
+	if _, ok := authorizeManager(h.db, w, r, tenantId, "manage permissions"); !ok {
+		return 0, false
+	}
+	return tenantId, true
+}
+
 // CreateFeature creates a new feature
 func (h *FeatureHandler) CreateFeature(w http.ResponseWriter, r *http.Request) {
 	feature, err := util.ParseJSONBody[models.Feature](w, r)
 	if err != nil {
 		return // Error already handled by ParseJSONBody
 	}
+	if err := features.ValidateCode(feature.Permission); err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err := h.FeatureRepo.Create(feature); err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error creating feature")
 		return
@@ -34,19 +81,26 @@ func (h *FeatureHandler) CreateFeature(w http.ResponseWriter, r *http.Request) {
 	util.RespondJSON(w, http.StatusCreated, feature)
 }
 
+// CreateMultipleFeatures creates several features; none is created unless every code is valid
 func (h *FeatureHandler) CreateMultipleFeatures(w http.ResponseWriter, r *http.Request) {
-	features, err := util.ParseJSONBody[[]models.Feature](w, r)
+	created, err := util.ParseJSONBody[[]models.Feature](w, r)
 	if err != nil {
 		return // Error already handled by ParseJSONBody
 	}
-	if err := h.FeatureRepo.CreateMultiple(features); err != nil {
+	for _, feature := range *created {
+		if err := features.ValidateCode(feature.Permission); err != nil {
+			util.HandleError(w, http.StatusBadRequest, feature.Permission+": "+err.Error())
+			return
+		}
+	}
+	if err := h.FeatureRepo.CreateMultiple(created); err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error creating feature")
 		return
 	}
-	util.RespondJSON(w, http.StatusCreated, features)
+	util.RespondJSON(w, http.StatusCreated, created)
 }
 
-// Delete all mappings for user
+// Delete all mappings for user, or only those in one tenant when tenantId or companyid is given
 func (h *FeatureHandler) DeleteAlMappingsForUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := util.ParseUintParam(r, "userId")
 	if err != nil {
@@ -54,39 +108,63 @@ func (h *FeatureHandler) DeleteAlMappingsForUser(w http.ResponseWriter, r *http.
 		return
 	}
 
-  err = h.UserFeatureRepo.Delete("user_id = ?", userId)
+	if r.URL.Query().Has("tenantId") || r.Header.Get("companyid") != "" {
+		tenantId, ok := h.resolveGrantTenant(w, r, userId, false)
+		if !ok {
+			return
+		}
+		err = features.RevokeInTenant(h.db, userId, tenantId)
+	} else {
+		// Revoking across every tenant is beyond any one company's managers
+		if userType, _ := util.UserTypeFromContext(r.Context()); userType != models.UserTypeSystem {
+			util.HandleError(w, http.StatusForbidden, "tenantId or companyid is required")
+			return
+		}
+		err = h.UserFeatureRepo.Delete("user_id = ?", userId)
+	}
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Could not delete all permissions")
 		return
 	}
 }
 
-// Delete a single permission for a userId
+// Delete a single permission, or a wildcard grant such as sales.*, for a userId in a tenant
 func (h *FeatureHandler) DeleteFeatureForUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := util.ParseUintParam(r, "userId")
 	if err != nil {
 		util.HandleError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	tenantId, ok := h.resolveGrantTenant(w, r, userId, false)
+	if !ok {
+		return
+	}
 
-  permissionCode := r.URL.Query().Get("code")
+	permissionCode := r.URL.Query().Get("code")
+	if features.IsWildcard(permissionCode) {
+		if err := h.db.Where("user_id = ? AND tenant_id = ? AND pattern = ?", userId, tenantId, permissionCode).
+			Delete(&models.UserWildcardGrant{}).Error; err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Unable to delete feature permission")
+		}
+		return
+	}
 
-  feature, err := h.FeatureRepo.GetByField("permission", permissionCode)
+	feature, err := h.FeatureRepo.GetByField("permission", permissionCode)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "invalid permission code")
 		return
 	}
 
-
-  err = h.UserFeatureRepo.Delete("user_id = ? and feature_id = ?", userId, feature.ID)
+	err = h.UserFeatureRepo.Delete("user_id = ? and tenant_id = ? and feature_id = ?", userId, tenantId, feature.ID)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Unable to delete feature permission")
 		return
 	}
 }
 
-
-// MapUserToFeature maps a single user to a feature
+// MapFeaturesToUser grants permission codes to a user in a tenant. Wildcards such as sales.* are
+// kept as grants of the whole branch, so they also cover features added to it later; the response
+// lists the mappings of the codes granted one by one.
 func (h *FeatureHandler) MapFeaturesToUser(w http.ResponseWriter, r *http.Request) {
 
 	permissionCodes, err := util.ParseJSONBody[[]string](w, r)
@@ -99,33 +177,76 @@ func (h *FeatureHandler) MapFeaturesToUser(w http.ResponseWriter, r *http.Reques
 		util.HandleError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	tenantId, ok := h.resolveGrantTenant(w, r, userId, false)
+	if !ok {
+		return
+	}
 
-	features, err := h.FeatureRepo.GetAllByCondition("permission IN ?", *permissionCodes)
+	var codes, patterns []string
+	for _, code := range *permissionCodes {
+		// A * anywhere marks a wildcard, so misplaced ones are rejected rather than matching nothing
+		if !strings.Contains(code, features.Wildcard) {
+			codes = append(codes, code)
+			continue
+		}
+		if err := features.ValidatePattern(code); err != nil {
+			util.HandleError(w, http.StatusBadRequest, code+": "+err.Error())
+			return
+		}
+		patterns = append(patterns, code)
+	}
+	if len(codes) == 0 && len(patterns) == 0 {
+		util.HandleError(w, http.StatusBadRequest, "No permission codes provided")
+		return
+	}
+
+	granted, err := h.FeatureRepo.GetAllByCondition("permission IN ?", codes)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "invalid permission codes")
 		return
 	}
 
-	var featureMappings []models.UserFeatureMapping
-	for _, feature := range features {
+	featureMappings := []models.UserFeatureMapping{}
+	for _, feature := range granted {
 		var mapping = models.UserFeatureMapping{
 			UserId:    userId,
+			TenantId:  tenantId,
 			FeatureId: feature.ID,
 		}
 		featureMappings = append(featureMappings, mapping)
 	}
 
-	if err := h.UserFeatureRepo.CreateMultiple(&featureMappings); err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if len(featureMappings) > 0 {
+			if err := util.NewRepository[models.UserFeatureMapping](tx).CreateMultiple(&featureMappings); err != nil {
+				return err
+			}
+		}
+		for _, pattern := range patterns {
+			grant := &models.UserWildcardGrant{UserId: userId, TenantId: tenantId, Pattern: pattern}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(grant).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error mapping user to feature")
 		return
 	}
 	util.RespondJSON(w, http.StatusCreated, &featureMappings)
 }
+
+// MapUserToFeature maps a single user to a feature in the tenant given in the body
 func (h *FeatureHandler) MapUserToFeature(w http.ResponseWriter, r *http.Request) {
 	mapping, err := util.ParseJSONBody[models.UserFeatureMapping](w, r)
 	if err != nil {
 		return // Error already handled by ParseJSONBody
 	}
+	if _, err := tenants.Membership(h.db, mapping.UserId, mapping.TenantId); err != nil {
+		util.HandleError(w, http.StatusBadRequest, "User is not a member of the tenant")
+		return
+	}
 	if err := h.UserFeatureRepo.Create(mapping); err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error mapping user to feature")
 		return
@@ -139,6 +260,12 @@ func (h *FeatureHandler) MapUsersToFeature(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return // Error already handled by ParseJSONBody
 	}
+	for _, mapping := range *mappings {
+		if _, err := tenants.Membership(h.db, mapping.UserId, mapping.TenantId); err != nil {
+			util.HandleError(w, http.StatusBadRequest, "User is not a member of the tenant")
+			return
+		}
+	}
 	if err := h.UserFeatureRepo.CreateMultiple(mappings); err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error mapping users to feature")
 		return
@@ -156,28 +283,71 @@ func (h *FeatureHandler) GetAllFeatures(w http.ResponseWriter, r *http.Request)
 	util.RespondJSON(w, http.StatusOK, &features)
 }
 
-// GetFeaturesByUser returns all features mapped to a specific user
+// GetFeaturesByUser returns all features granted to a specific user in a tenant, with wildcard
+// grants resolved against the current catalog
 func (h *FeatureHandler) GetFeaturesByUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := util.ParseUintParam(r, "userId")
 	if err != nil {
 		util.HandleError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	mappings, err := h.UserFeatureRepo.GetAllByCondition("user_id = ?", userId)
+	tenantId, ok := h.resolveGrantTenant(w, r, userId, true)
+	if !ok {
+		return
+	}
+	granted, err := features.ForUser(h.db, userId, tenantId)
 	if err != nil {
-		util.HandleError(w, http.StatusInternalServerError, "Error fetching user-feature mappings")
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching features")
 		return
 	}
-	var featureIds []uint32
-	for _, mapping := range mappings {
-		featureIds = append(featureIds, mapping.FeatureId)
+	util.RespondJSON(w, http.StatusOK, &granted)
+}
+
+// featureGrants is what a user was granted in a tenant, before wildcards are resolved
+type featureGrants struct {
+	Features  []string `json:"features"`  // Codes granted one by one
+	Wildcards []string `json:"wildcards"` // Patterns such as sales.*
+}
+
+// GetFeatureGrants returns the codes and wildcard patterns granted to a user in a tenant as they
+// were granted, so they can be reviewed and revoked
+func (h *FeatureHandler) GetFeatureGrants(w http.ResponseWriter, r *http.Request) {
+	userId, err := util.ParseUintParam(r, "userId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	features, err := h.FeatureRepo.GetAllByCondition("id IN ?", featureIds)
+	tenantId, ok := h.resolveGrantTenant(w, r, userId, true)
+	if !ok {
+		return
+	}
+	grants := &featureGrants{Features: []string{}}
+	if err := h.db.Model(&models.Feature{}).Where("id IN (?)", h.db.Model(&models.UserFeatureMapping{}).Select("feature_id").
+		Where("user_id = ? AND tenant_id = ?", userId, tenantId)).Order("permission").Pluck("permission", &grants.Features).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching features")
+		return
+	}
+	if grants.Wildcards, err = features.Wildcards(h.db, userId, tenantId); err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching features")
+		return
+	}
+	if grants.Wildcards == nil {
+		grants.Wildcards = []string{}
+	}
+	util.RespondJSON(w, http.StatusOK, grants)
+}
+
+// GetFeatureTree returns the feature catalog arranged by the segments of the permission codes
+func (h *FeatureHandler) GetFeatureTree(w http.ResponseWriter, r *http.Request) {
+	catalog, err := h.FeatureRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Order("permission")
+	})
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error fetching features")
 		return
 	}
-	util.RespondJSON(w, http.StatusOK, &features)
+	tree := features.Tree(catalog)
+	util.RespondJSON(w, http.StatusOK, &tree)
 }
 
 // UpdateFeature updates an existing feature
@@ -200,6 +370,15 @@ func (h *FeatureHandler) UpdateFeature(w http.ResponseWriter, r *http.Request) {
 		util.HandleError(w, http.StatusBadRequest, "No updates provided")
 		return
 	}
+	for key, value := range *featureUpdates {
+		if strings.EqualFold(key, "permission") {
+			code, _ := value.(string)
+			if err := features.ValidateCode(code); err != nil {
+				util.HandleError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+	}
 
 	// Apply the updates to the feature by ID
 	if err := h.FeatureRepo.UpdateOne("id", featureId, *featureUpdates); err != nil {
@@ -230,7 +409,7 @@ func (h *FeatureHandler) DeleteFeature(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// DeleteUserFeatureMapping deletes a user-feature mapping (hard delete)
+// DeleteUserFeatureMapping deletes a user-feature mapping in a tenant (hard delete)
 func (h *FeatureHandler) DeleteUserFeatureMapping(w http.ResponseWriter, r *http.Request) {
 	// Extract userId and featureId from query parameters
 	userId, err := util.ParseUintParam(r, "userId")
@@ -238,6 +417,10 @@ func (h *FeatureHandler) DeleteUserFeatureMapping(w http.ResponseWriter, r *http
 		util.HandleError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	tenantId, ok := h.resolveGrantTenant(w, r, userId, false)
+	if !ok {
+		return
+	}
 
 	featureId, err := util.ParseUintParam(r, "featureId")
 	if err != nil {
@@ -245,9 +428,9 @@ func (h *FeatureHandler) DeleteUserFeatureMapping(w http.ResponseWriter, r *http
 		return
 	}
 
-	// Use the repository's Delete method to delete the UserFeatureMapping where user_id and feature_id match
-	condition := "user_id = ? AND feature_id = ?"
-	if err := h.UserFeatureRepo.Delete(condition, userId, featureId); err != nil {
+	// Use the repository's Delete method to delete the UserFeatureMapping where user_id, tenant_id and feature_id match
+	condition := "user_id = ? AND tenant_id = ? AND feature_id = ?"
+	if err := h.UserFeatureRepo.Delete(condition, userId, tenantId, featureId); err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error deleting user-feature mapping")
 		return
 	}
@@ -0,0 +1,187 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/models"
+)
+
+// TestTenantScopedFeatures checks that a grant in one company does not leak into another
+func TestTenantScopedFeatures(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+	authHandler := NewAuthHandler(db)
+	featureHandler := NewFeatureHandler(db)
+
+	acme := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	db.Create(acme)
+	user, token := createTestUser(t, db, "user@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: 1})
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: acme.ID})
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000002", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: acme.ID, Role: models.RoleOwner})
+
+	body, _ := json.Marshal([]string{"sales.report"})
+	grant := func(token *models.Token, url string) int {
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+		req.Header.Set("companyid", "acme")
+		if token != nil {
+			req.Header.Set("token", token.Value.String())
+		}
+		return executeRequest(req, authHandler.Authenticate(featureHandler.MapFeaturesToUser)).Code
+	}
+	// Only the company's owners and admins may grant, not anonymous callers or the user themselves
+	if code := grant(nil, fmt.Sprintf("/features/map/bulk?userId=%d", user.ID)); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without a token, got %d", http.StatusUnauthorized, code)
+	}
+	if code := grant(token, fmt.Sprintf("/features/map/bulk?userId=%d", user.ID)); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a plain member, got %d", http.StatusForbidden, code)
+	}
+	if code := grant(ownerToken, fmt.Sprintf("/features/map/bulk?userId=%d", user.ID)); code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+
+	// Granting in a company the user does not belong to is rejected
+	other := &models.Tenant{CompanyGuid: "other", CompanyName: "Other"}
+	db.Create(other)
+	if code := grant(ownerToken, fmt.Sprintf("/features/map/bulk?userId=%d&tenantId=%d", user.ID, other.ID)); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, code)
+	}
+
+	resolve := func(companyId string) []string {
+		req, _ := http.NewRequest(http.MethodGet, "/token/validate", nil)
+		req.Header.Set("token", token.Value.String())
+		req.Header.Set("companyid", companyId)
+		rr := executeRequest(req, authHandler.ResolveTenant)
+		var info models.TokenTenantInfo
+		json.Unmarshal(rr.Body.Bytes(), &info)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+		}
+		return info.Permissions
+	}
+	if permissions := resolve("acme"); len(permissions) != 1 || permissions[0] != "sales.report" {
+		t.Errorf("Expected sales.report in acme, got %v", permissions)
+	}
+	if permissions := resolve("default"); len(permissions) != 0 {
+		t.Errorf("Expected no permissions in the default company, got %v", permissions)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/features/un-map?userId=%d&tenantId=%d&code=sales.report", user.ID, acme.ID), nil)
+	req.Header.Set("token", ownerToken.Value.String())
+	executeRequest(req, authHandler.Authenticate(featureHandler.DeleteFeatureForUser))
+	if permissions := resolve("acme"); len(permissions) != 0 {
+		t.Errorf("Expected the grant to be removed, got %v", permissions)
+	}
+}
+
+// TestWildcardFeatureGrants checks that a wildcard grant covers its branch, including features
+// added afterwards, in /features/user and the resolved permissions, and can be revoked as granted
+func TestWildcardFeatureGrants(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	featureHandler := NewFeatureHandler(db)
+
+	acme := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", State: models.TenantActive}
+	db.Create(acme)
+	user, token := createTestUser(t, db, "user@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: acme.ID})
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000002", models.UserTypeSystem)
+	db.Create(&[]models.Feature{
+		{Name: "Daily sales", Permission: "sales.reports.daily"},
+		{Name: "Sales overview", Permission: "sales"},
+		{Name: "Stock", Permission: "inventory.stock"},
+	})
+
+	grantURL := fmt.Sprintf("/features/map/bulk?userId=%d&tenantId=%d", user.ID, acme.ID)
+	for codes, want := range map[string]int{`["sales.*"]`: http.StatusCreated, `["sales.*.daily"]`: http.StatusBadRequest, `["Sales.*"]`: http.StatusBadRequest} {
+		req, _ := http.NewRequest(http.MethodPost, grantURL, bytes.NewBufferString(codes))
+		req.Header.Set("token", adminToken.Value.String())
+		if rr := executeRequest(req, authHandler.Authenticate(featureHandler.MapFeaturesToUser)); rr.Code != want {
+			t.Errorf("Expected status code %d granting %s, got %d", want, codes, rr.Code)
+		}
+	}
+
+	// Added after the grant, and still covered by it
+	create := func(code string) int {
+		body, _ := json.Marshal(models.Feature{Name: code, Permission: code})
+		req, _ := http.NewRequest(http.MethodPost, "/features", bytes.NewBuffer(body))
+		return executeRequest(req, featureHandler.CreateFeature).Code
+	}
+	if code := create("sales.reports.monthly"); code != http.StatusCreated {
+		t.Fatalf("Expected status code %d creating a feature, got %d", http.StatusCreated, code)
+	}
+	if code := create("sales..monthly"); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an empty segment, got %d", http.StatusBadRequest, code)
+	}
+
+	userFeatures := func() []string {
+		// Users may read their own features
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/features/user?userId=%d&tenantId=%d", user.ID, acme.ID), nil)
+		req.Header.Set("token", token.Value.String())
+		var granted []models.Feature
+		json.Unmarshal(executeRequest(req, authHandler.Authenticate(featureHandler.GetFeaturesByUser)).Body.Bytes(), &granted)
+		codes := []string{}
+		for _, feature := range granted {
+			codes = append(codes, feature.Permission)
+		}
+		return codes
+	}
+	if codes := userFeatures(); fmt.Sprint(codes) != "[sales.reports.daily sales.reports.monthly]" {
+		t.Errorf("Expected the sales branch without its root, got %v", codes)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/token/validate", nil)
+	req.Header.Set("token", token.Value.String())
+	req.Header.Set("companyid", "acme")
+	var info models.TokenTenantInfo
+	json.Unmarshal(executeRequest(req, authHandler.ResolveTenant).Body.Bytes(), &info)
+	if len(info.Permissions) != 2 {
+		t.Errorf("Expected the wildcard resolved in the token permissions, got %v", info.Permissions)
+	}
+
+	// Grants are shown as given to the user and managers, but not to other members
+	other, otherToken := createTestUser(t, db, "other@example.com", "9000000003", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: other.ID, TenantId: acme.ID})
+	grants := func(token *models.Token) (int, featureGrants) {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/features/user/grants?userId=%d&tenantId=%d", user.ID, acme.ID), nil)
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(featureHandler.GetFeatureGrants))
+		var body featureGrants
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr.Code, body
+	}
+	if code, body := grants(token); code != http.StatusOK || len(body.Wildcards) != 1 || body.Wildcards[0] != "sales.*" {
+		t.Errorf("Expected the user to see the sales.* grant, got %d %+v", code, body)
+	}
+	if code, _ := grants(otherToken); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for another member, got %d", http.StatusForbidden, code)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/features/tree", nil)
+	if rr := executeRequest(req, authHandler.Authenticate(featureHandler.GetFeatureTree)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for the tree without a token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	req.Header.Set("token", token.Value.String())
+	var tree []struct {
+		Segment  string `json:"segment"`
+		Children []struct {
+			Segment  string            `json:"segment"`
+			Children []json.RawMessage `json:"children"`
+		} `json:"children"`
+	}
+	json.Unmarshal(executeRequest(req, authHandler.Authenticate(featureHandler.GetFeatureTree)).Body.Bytes(), &tree)
+	if len(tree) != 2 || tree[0].Segment != "inventory" || tree[1].Segment != "sales" || len(tree[1].Children) != 1 || len(tree[1].Children[0].Children) != 2 {
+		t.Errorf("Unexpected feature tree %+v", tree)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/features/un-map?userId=%d&tenantId=%d&code=sales.*", user.ID, acme.ID), nil)
+	req.Header.Set("token", adminToken.Value.String())
+	executeRequest(req, authHandler.Authenticate(featureHandler.DeleteFeatureForUser))
+	if codes := userFeatures(); len(codes) != 0 {
+		t.Errorf("Expected no features once the wildcard is revoked, got %v", codes)
+	}
+}
@@ -0,0 +1,135 @@
+package v1
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+)
+
+// GatewayPrefix is the path under which tenant services are proxied: /gw/{service}/...
+const GatewayPrefix = "/gw/"
+
+// Identity headers set by the gateway. Values sent by the client under these names are dropped,
+// so tenant services can trust them on requests arriving from the gateway.
+const (
+	HeaderGatewayUserId      = "X-Portal-User-Id"
+	HeaderGatewayTenantId    = "X-Portal-Tenant-Id"
+	HeaderGatewayCompanyId   = "X-Portal-Company-Id"
+	HeaderGatewayRole        = "X-Portal-Role"
+	HeaderGatewayPermissions = "X-Portal-Permissions"
+	HeaderGatewaySecret      = "X-Portal-Gateway-Secret"
+)
+
+// gatewayTargetKey carries the resolved upstream of a request from ServeHTTP to the proxy
+type gatewayTargetKey struct{}
+
+type gatewayTarget struct {
+	url  *url.URL
+	path string
+	info *models.TokenTenantInfo
+}
+
+// GatewayHandler authenticates requests the same way as ResolveTenant and reverse-proxies them
+// to the requested service of the tenant, including streamed responses and websocket upgrades
+type GatewayHandler struct {
+	auth   *AuthHandler
+	proxy  *httputil.ReverseProxy
+	Secret string // Sent to tenant services in HeaderGatewaySecret when set
+}
+
+// NewGatewayHandler initializes the GatewayHandler on top of the auth handler's tenant resolution
+func NewGatewayHandler(auth *AuthHandler) *GatewayHandler {
+	h := &GatewayHandler{auth: auth}
+	h.proxy = &httputil.ReverseProxy{
+		Rewrite:       h.rewrite,
+		FlushInterval: -1, // Flush every write so streamed responses are not buffered
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("[!] Gateway could not reach %s: %v\n", r.URL.Host, err)
+			util.HandleError(w, http.StatusBadGateway, "Tenant service unreachable")
+		},
+	}
+	return h
+}
+
+// ServeHTTP handles /gw/{service}/... requests. The token and companyid are read from the headers,
+// or from the query string for clients such as browser websockets that cannot set headers.
+func (h *GatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service, path, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, GatewayPrefix), "/")
+	if !models.IsValidService(service) {
+		util.HandleError(w, http.StatusNotFound, "Unknown service")
+		return
+	}
+
+	query := r.URL.Query()
+	token := r.Header.Get("token")
+	if token == "" {
+		token = query.Get("token")
+	}
+	companyId := r.Header.Get("companyid")
+	if companyId == "" {
+		companyId = query.Get("companyid")
+	}
+
+	info, status := h.auth.resolveTenant(token, companyId)
+	if status != http.StatusOK {
+		util.RespondJSON(w, status, info)
+		return
+	}
+
+	endpoints := info.Endpoints[service]
+	if len(endpoints) == 0 {
+		util.RespondJSON(w, http.StatusServiceUnavailable, &models.GenericResponseMessage{
+			Message: "No healthy endpoint for " + service,
+			Code:    models.CodeNoEndpoint,
+		})
+		return
+	}
+	endpoint := endpoints[0]
+
+	target := &gatewayTarget{
+		url:  &url.URL{Scheme: "http", Host: net.JoinHostPort(endpoint.Host, strconv.FormatUint(uint64(endpoint.Port), 10))},
+		path: "/" + path,
+		info: info,
+	}
+	h.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gatewayTargetKey{}, target)))
+}
+
+// rewrite points the outgoing request at the tenant service and replaces the portal credentials
+// with the trusted identity headers
+func (h *GatewayHandler) rewrite(pr *httputil.ProxyRequest) {
+	target := pr.In.Context().Value(gatewayTargetKey{}).(*gatewayTarget)
+
+	pr.SetURL(target.url)
+	pr.Out.URL.Path = target.path
+	pr.Out.URL.RawPath = ""
+	query := pr.Out.URL.Query()
+	query.Del("token")
+	query.Del("companyid")
+	pr.Out.URL.RawQuery = query.Encode()
+	pr.SetXForwarded()
+
+	header := pr.Out.Header
+	header.Del("token")
+	for _, name := range []string{HeaderGatewayUserId, HeaderGatewayTenantId, HeaderGatewayCompanyId,
+		HeaderGatewayRole, HeaderGatewayPermissions, HeaderGatewaySecret} {
+		header.Del(name)
+	}
+
+	info := target.info
+	header.Set(HeaderGatewayUserId, strconv.FormatUint(*info.UserId, 10))
+	header.Set(HeaderGatewayTenantId, strconv.FormatUint(info.TenantInfo.ID, 10))
+	header.Set(HeaderGatewayCompanyId, info.TenantInfo.CompanyGuid)
+	header.Set(HeaderGatewayRole, info.Role)
+	header.Set(HeaderGatewayPermissions, strings.Join(info.Permissions, ","))
+	if h.Secret != "" {
+		header.Set(HeaderGatewaySecret, h.Secret)
+	}
+}
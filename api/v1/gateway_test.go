@@ -0,0 +1,98 @@
+package v1
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+)
+
+// TestGateway checks that requests are authenticated, stripped of portal credentials, given the
+// identity headers and proxied to the tenant's service, including websocket upgrades
+func TestGateway(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "echo" {
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			buf.Flush()
+			io.Copy(conn, buf)
+			return
+		}
+		fmt.Fprintf(w, "%s?%s user=%s company=%s role=%s token=%q",
+			r.URL.Path, r.URL.RawQuery, r.Header.Get(HeaderGatewayUserId), r.Header.Get(HeaderGatewayCompanyId),
+			r.Header.Get(HeaderGatewayRole), r.Header.Get("token"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(backendURL.Port())
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", Host: backendURL.Hostname(), SgBizPort: uint32(port)}
+	db.Create(tenant)
+	tenants.CreateLegacyEndpoints(db, tenant)
+	user, token := createTestUser(t, db, "user@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: tenant.ID, Role: models.RoleAdmin})
+
+	gateway := httptest.NewServer(NewGatewayHandler(NewAuthHandler(db)))
+	defer gateway.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/gw/sgbiz/api/orders?page=2", nil)
+	req.Header.Set("token", token.Value.String())
+	req.Header.Set("companyid", "acme")
+	req.Header.Set(HeaderGatewayRole, "owner") // Spoofed identity is replaced
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Gateway request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	expected := fmt.Sprintf(`/api/orders?page=2 user=%d company=acme role=admin token=""`, user.ID)
+	if resp.StatusCode != http.StatusOK || string(body) != expected {
+		t.Errorf("Expected %q, got %d %q", expected, resp.StatusCode, body)
+	}
+
+	// Without a token nothing is proxied
+	resp, _ = http.Get(gateway.URL + "/gw/sgbiz/api/orders?companyid=acme")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// A service without endpoints is unavailable
+	req, _ = http.NewRequest(http.MethodGet, gateway.URL+"/gw/bmrm/", nil)
+	req.Header.Set("token", token.Value.String())
+	req.Header.Set("companyid", "acme")
+	resp, _ = http.DefaultClient.Do(req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	// Upgraded connections are tunnelled both ways, credentials taken from the query string
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /gw/sgbiz/ws?token=%s&companyid=acme HTTP/1.1\r\nHost: portal\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n", token.Value.String())
+	reader := bufio.NewReader(conn)
+	upgrade, err := http.ReadResponse(reader, nil)
+	if err != nil || upgrade.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected a protocol switch, got %v (%v)", upgrade, err)
+	}
+	fmt.Fprint(conn, "ping\n")
+	if line, _ := reader.ReadString('\n'); line != "ping\n" {
+		t.Errorf("Expected the echo through the tunnel, got %q", line)
+	}
+}
@@ -0,0 +1,147 @@
+package v1
+
+import (
+	"net/http"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+type HealthHandler struct {
+	TenantRepo *util.Repository[models.Tenant]
+	StatusRepo *util.Repository[models.TenantServiceStatus]
+	CheckRepo  *util.Repository[models.TenantHealthCheck]
+}
+
+// NewHealthHandler initializes the HealthHandler with the repositories
+func NewHealthHandler(db *gorm.DB) *HealthHandler {
+	return &HealthHandler{
+		TenantRepo: util.NewRepository[models.Tenant](db),
+		StatusRepo: util.NewRepository[models.TenantServiceStatus](db),
+		CheckRepo:  util.NewRepository[models.TenantHealthCheck](db),
+	}
+}
+
+// GetTenantHealth returns the current status of each service of a tenant and its recent
+// check history (?tenantId=&limit=)
+func (h *HealthHandler) GetTenantHealth(w http.ResponseWriter, r *http.Request) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, _, err := util.ParsePagination(r, 50, 1000)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+
+	statuses, err := h.StatusRepo.GetAllByCondition("tenant_id = ?", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenant health")
+		return
+	}
+
+	history, err := h.CheckRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantId).Order("checked_at DESC").Limit(limit)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenant health history")
+		return
+	}
+
+	response := struct {
+		Tenant   *models.Tenant               `json:"tenant"`
+		Status   string                       `json:"status"`
+		Services []models.TenantServiceStatus `json:"services"`
+		History  []models.TenantHealthCheck   `json:"history"`
+	}{
+		Tenant:   tenant,
+		Status:   overallHealth(statuses),
+		Services: statuses,
+		History:  history,
+	}
+	util.RespondJSON(w, http.StatusOK, &response)
+}
+
+// overallHealth is down when any service is down, up when all are up and unknown otherwise
+func overallHealth(statuses []models.TenantServiceStatus) string {
+	if len(statuses) == 0 {
+		return models.HealthUnknown
+	}
+	overall := models.HealthUp
+	for _, status := range statuses {
+		if status.Status == models.HealthDown {
+			return models.HealthDown
+		}
+		if status.Status != models.HealthUp {
+			overall = models.HealthUnknown
+		}
+	}
+	return overall
+}
+
+// TenantHealthSummary is one row of the fleet overview
+type TenantHealthSummary struct {
+	TenantId    uint64                       `json:"tenant_id"`
+	CompanyGuid string                       `json:"company_guid"`
+	CompanyName string                       `json:"company_name"`
+	Host        string                       `json:"host"`
+	Status      string                       `json:"status"`
+	Services    []models.TenantServiceStatus `json:"services"`
+}
+
+// GetFleetHealth returns every tenant's health with totals per state (?status=down to filter)
+func (h *HealthHandler) GetFleetHealth(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("status")
+
+	tenants, err := h.TenantRepo.GetAllByCondition("1 = 1")
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenants")
+		return
+	}
+	statuses, err := h.StatusRepo.GetAllByCondition("1 = 1")
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenant health")
+		return
+	}
+
+	byTenant := make(map[uint64][]models.TenantServiceStatus)
+	for _, status := range statuses {
+		byTenant[status.TenantId] = append(byTenant[status.TenantId], status)
+	}
+
+	totals := map[string]int{models.HealthUp: 0, models.HealthDown: 0, models.HealthUnknown: 0}
+	summaries := []TenantHealthSummary{}
+	for _, tenant := range tenants {
+		summary := TenantHealthSummary{
+			TenantId:    tenant.ID,
+			CompanyGuid: tenant.CompanyGuid,
+			CompanyName: tenant.CompanyName,
+			Host:        tenant.Host,
+			Status:      overallHealth(byTenant[tenant.ID]),
+			Services:    byTenant[tenant.ID],
+		}
+		totals[summary.Status]++
+		if filter == "" || filter == summary.Status {
+			summaries = append(summaries, summary)
+		}
+	}
+
+	response := struct {
+		Totals  map[string]int        `json:"totals"`
+		Tenants []TenantHealthSummary `json:"tenants"`
+	}{
+		Totals:  totals,
+		Tenants: summaries,
+	}
+	util.RespondJSON(w, http.StatusOK, &response)
+}
@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+type HostHandler struct {
+	HostRepo *util.Repository[models.Host]
+	db       *gorm.DB
+}
+
+// NewHostHandler initializes the HostHandler with the repository
+func NewHostHandler(db *gorm.DB) *HostHandler {
+	return &HostHandler{
+		HostRepo: util.NewRepository[models.Host](db),
+		db:       db,
+	}
+}
+
+// GetHosts returns every host of the inventory with its labels, or those carrying ?label=
+func (h *HostHandler) GetHosts(w http.ResponseWriter, r *http.Request) {
+	label := r.URL.Query().Get("label")
+	hosts, err := h.HostRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		if label != "" {
+			db = db.Where("id IN (?)", h.db.Model(&models.HostLabel{}).Select("host_id").Where("label = ?", label))
+		}
+		return db.Order("name")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching hosts")
+		return
+	}
+
+	specs := make([]*tenants.HostSpec, 0, len(hosts))
+	for _, host := range hosts {
+		spec, err := tenants.LoadHost(h.db, host)
+		if err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching hosts")
+			return
+		}
+		specs = append(specs, spec)
+	}
+	util.RespondJSON(w, http.StatusOK, &specs)
+}
+
+// GetUtilization reports the tenants and ports used on every host, or on those carrying ?label=
+func (h *HostHandler) GetUtilization(w http.ResponseWriter, r *http.Request) {
+	report, err := tenants.Utilization(h.db, r.URL.Query().Get("label"))
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error building the utilization report")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &report)
+}
+
+// CreateHost adds a host to the inventory
+func (h *HostHandler) CreateHost(w http.ResponseWriter, r *http.Request) {
+	spec, err := util.ParseJSONBody[tenants.HostSpec](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	spec.ID = 0
+	h.saveHost(w, spec, http.StatusCreated)
+}
+
+// UpdateHost replaces a host's range, capacity, labels and drain flag (?id=)
+func (h *HostHandler) UpdateHost(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	existing, err := h.HostRepo.GetByField("id", id)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Host not found")
+		return
+	}
+
+	spec, err := util.ParseJSONBody[tenants.HostSpec](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	spec.ID = existing.ID
+	spec.CreatedAt = existing.CreatedAt
+	h.saveHost(w, spec, http.StatusOK)
+}
+
+// DeleteHost removes a host from the inventory (?id=) once it carries no tenants
+func (h *HostHandler) DeleteHost(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	host, err := h.HostRepo.GetByField("id", id)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Host not found")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return tenants.DeleteHost(tx, host)
+	})
+	if errors.Is(err, tenants.ErrHostInUse) {
+		util.HandleError(w, http.StatusConflict, "The host still carries tenants; drain and move them first")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error deleting host")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// saveHost validates and stores the host, responding with it on success
+func (h *HostHandler) saveHost(w http.ResponseWriter, spec *tenants.HostSpec, status int) {
+	spec.Name = strings.TrimSpace(spec.Name)
+	switch {
+	case spec.Name == "":
+		util.HandleError(w, http.StatusBadRequest, "Host name is required")
+		return
+	case spec.PortFrom == 0 || spec.PortTo < spec.PortFrom || spec.PortTo > 65535:
+		util.HandleError(w, http.StatusBadRequest, "Port range must lie within 1-65535 with port_from not above port_to")
+		return
+	case spec.Capacity < 1:
+		util.HandleError(w, http.StatusBadRequest, "Capacity must be at least 1")
+		return
+	}
+	for i, label := range spec.Labels {
+		spec.Labels[i] = strings.TrimSpace(label)
+		if spec.Labels[i] == "" {
+			util.HandleError(w, http.StatusBadRequest, "Host labels cannot be blank")
+			return
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return tenants.SaveHost(tx, spec)
+	})
+	if errors.Is(err, tenants.ErrHostInUse) {
+		util.HandleError(w, http.StatusConflict, "A host carrying tenants cannot be renamed")
+		return
+	}
+	if errors.Is(err, tenants.ErrPortOutOfRange) {
+		util.HandleError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "A host with that name already exists, or a label is repeated")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error saving host")
+		return
+	}
+	util.RespondJSON(w, status, spec)
+}
@@ -0,0 +1,134 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+
+	"gorm.io/gorm"
+)
+
+// TestHostInventory checks that new tenants land on the least utilized host with capacity left,
+// get distinct free ports from its range, and that draining hosts and taken ports are respected
+func TestHostInventory(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	hostHandler := NewHostHandler(db)
+	tenantHandler := NewTenantHandler(db)
+
+	template := &tenants.TemplateSpec{
+		ProvisioningTemplate: models.ProvisioningTemplate{Name: "standard", IsDefault: true, BmrmPort: 1, SgBizPort: 1},
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return tenants.SaveTemplate(tx, template) }); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+	_, token := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	_, systemToken := createTestUser(t, db, "admin@example.com", "9000000002", models.UserTypeSystem)
+
+	saveHost := func(method, url string, spec map[string]interface{}) (int, tenants.HostSpec) {
+		body, _ := json.Marshal(spec)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		handler := hostHandler.CreateHost
+		if method == http.MethodPut {
+			handler = hostHandler.UpdateHost
+		}
+		rr := executeRequest(req, handler)
+		var saved tenants.HostSpec
+		json.Unmarshal(rr.Body.Bytes(), &saved)
+		return rr.Code, saved
+	}
+	checkAndMake := func(guid string) (int, ProvisionedTenant) {
+		body, _ := json.Marshal(map[string]string{"CompanyGuid": guid, "CompanyName": guid})
+		req, _ := http.NewRequest(http.MethodPost, "/tenants/check-make", bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(tenantHandler.CheckAndMake))
+		var provisioned ProvisionedTenant
+		json.Unmarshal(rr.Body.Bytes(), &provisioned)
+		return rr.Code, provisioned
+	}
+	createTenant := func(url string, tenant map[string]interface{}) (int, models.Tenant) {
+		body, _ := json.Marshal(tenant)
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+		req.Header.Set("token", systemToken.Value.String())
+		rr := executeRequest(req, authHandler.RequireSystemUser(tenantHandler.CreateTenant))
+		var created models.Tenant
+		json.Unmarshal(rr.Body.Bytes(), &created)
+		return rr.Code, created
+	}
+
+	west := map[string]interface{}{"name": "west-1.local", "port_from": 7000, "port_to": 7010, "capacity": 2, "labels": []string{"region=west"}}
+	code, westHost := saveHost(http.MethodPost, "/hosts", west)
+	if code != http.StatusCreated || len(westHost.Labels) != 1 {
+		t.Fatalf("Expected the host to be created with its label, got %d %+v", code, westHost)
+	}
+	if code, _ := saveHost(http.MethodPost, "/hosts", map[string]interface{}{"name": "bad.local", "port_from": 9000, "port_to": 8000, "capacity": 1}); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an inverted range, got %d", http.StatusBadRequest, code)
+	}
+	saveHost(http.MethodPost, "/hosts", map[string]interface{}{"name": "east-1.local", "port_from": 8000, "port_to": 8002, "capacity": 1, "labels": []string{"region=east"}})
+
+	_, first := checkAndMake("guid-1")
+	_, second := checkAndMake("guid-2")
+	_, third := checkAndMake("guid-3")
+	if first.Tenant == nil || first.Host != "east-1.local" || first.BmrmPort != 8000 || first.SgBizPort != 8001 || first.TallySyncPort != 0 {
+		t.Fatalf("Expected the first company on east-1.local with the template's services, got %+v", first)
+	}
+	if second.Tenant == nil || second.Host != "west-1.local" || second.BmrmPort != 7000 || second.SgBizPort != 7001 {
+		t.Errorf("Expected the second company on the host with capacity left, got %+v", second)
+	}
+	if third.Tenant == nil || third.Host != "west-1.local" || third.BmrmPort != 7002 || third.SgBizPort != 7003 {
+		t.Errorf("Expected the third company on the next free ports, got %+v", third)
+	}
+	if code, _ := checkAndMake("guid-4"); code != http.StatusConflict {
+		t.Errorf("Expected status code %d once every host is full, got %d", http.StatusConflict, code)
+	}
+
+	// Draining hosts take no new tenants even with capacity left
+	west["capacity"], west["draining"] = 3, true
+	if code, _ := saveHost(http.MethodPut, fmt.Sprintf("/hosts?id=%d", westHost.ID), west); code != http.StatusOK {
+		t.Fatalf("Expected status code %d draining the host, got %d", http.StatusOK, code)
+	}
+	if code, _ := createTenant("/tenants?labels=region=west", map[string]interface{}{"CompanyGuid": "guid-5", "CompanyName": "guid-5"}); code != http.StatusConflict {
+		t.Errorf("Expected status code %d while the host drains, got %d", http.StatusConflict, code)
+	}
+	west["draining"] = false
+	saveHost(http.MethodPut, fmt.Sprintf("/hosts?id=%d", westHost.ID), west)
+	code, fifth := createTenant("/tenants?labels=region=west", map[string]interface{}{"CompanyGuid": "guid-5", "CompanyName": "guid-5",
+		"State": models.TenantDeleted, "SeatLimit": 0, "OrganizationId": 1})
+	if code != http.StatusCreated || fifth.Host != "west-1.local" || fifth.BmrmPort != 7004 || fifth.SgBizPort != 7005 || fifth.TallySyncPort != 7006 {
+		t.Errorf("Expected a port for every service on west-1.local, got %d %+v", code, fifth)
+	}
+	if fifth.State != models.TenantActive || fifth.SeatLimit != nil || fifth.OrganizationId != nil {
+		t.Errorf("Expected the state, seat limit and organization in the body to be ignored, got %+v", fifth)
+	}
+
+	// Ports picked by hand are checked against the inventory
+	if code, _ := createTenant("/tenants", map[string]interface{}{"CompanyGuid": "guid-6", "Host": "east-1.local", "BmrmPort": 8000}); code != http.StatusConflict {
+		t.Errorf("Expected status code %d for a port already allocated, got %d", http.StatusConflict, code)
+	}
+	if code, _ := createTenant("/tenants", map[string]interface{}{"CompanyGuid": "guid-6", "Host": "east-1.local", "BmrmPort": 9000}); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a port outside the range, got %d", http.StatusBadRequest, code)
+	}
+	west["port_to"] = 7003
+	if code, _ := saveHost(http.MethodPut, fmt.Sprintf("/hosts?id=%d", westHost.ID), west); code != http.StatusConflict {
+		t.Errorf("Expected status code %d shrinking the range below allocated ports, got %d", http.StatusConflict, code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/hosts/utilization", nil)
+	rr := executeRequest(req, hostHandler.GetUtilization)
+	var report []tenants.HostUsage
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if len(report) != 2 || report[0].Name != "east-1.local" || report[0].Tenants != 1 || report[0].PortsUsed != 2 || report[0].Utilization != 100 ||
+		report[1].Tenants != 3 || report[1].PortsUsed != 7 || report[1].PortsTotal != 11 || report[1].Utilization != 100 {
+		t.Errorf("Unexpected utilization report %+v", report)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("/hosts?id=%d", westHost.ID), nil)
+	if rr := executeRequest(req, hostHandler.DeleteHost); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d deleting a host carrying tenants, got %d", http.StatusConflict, rr.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package v1
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// importMaxBytes caps the size of an uploaded company list
+const importMaxBytes = 5 << 20
+
+type ImportHandler struct {
+	db *gorm.DB
+}
+
+// NewImportHandler initializes the ImportHandler
+func NewImportHandler(db *gorm.DB) *ImportHandler {
+	return &ImportHandler{db: db}
+}
+
+// ImportReport lists what an import did to each company of the list, and the user's companies the
+// list did not mention
+type ImportReport struct {
+	DryRun    bool                   `json:"dry_run"`
+	Created   int                    `json:"created"`
+	Renamed   int                    `json:"renamed"`
+	Unchanged int                    `json:"unchanged"`
+	Skipped   int                    `json:"skipped"`
+	Failed    int                    `json:"failed"`
+	Changes   []tenants.ImportChange `json:"changes"`
+	NotInList []tenants.ImportChange `json:"not_in_list"`
+}
+
+// importFailure describes why creating or renaming a company failed
+func importFailure(err error) string {
+	switch {
+	case errors.Is(err, tenants.ErrTemplateNotFound):
+		return "Provisioning template not found"
+	case errors.Is(err, tenants.ErrNoHosts), errors.Is(err, tenants.ErrNoCapacity), errors.Is(err, tenants.ErrPortsExhausted):
+		return "No host is available for the company"
+	case util.IsDuplicateKeyError(err):
+		return "The company was registered meanwhile"
+	}
+	return "Error saving the company"
+}
+
+// ImportCompanies reads a company list exported by Tally, as XML or JSON, and brings the user's
+// companies in line with it: missing companies are provisioned with the user as owner, using
+// ?template= or the template chosen from their subscriptions, and renamed ones get the new name.
+// Companies are handled one by one, so one failure does not undo the rest. With ?dryRun=true
+// nothing is changed and the report shows what would be.
+func (h *ImportHandler) ImportCompanies(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, importMaxBytes))
+	if err != nil {
+		util.HandleError(w, http.StatusRequestEntityTooLarge, "The company list is larger than 5 MiB")
+		return
+	}
+	companies, err := tenants.ParseCompanyList(data)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(companies) == 0 {
+		util.HandleError(w, http.StatusBadRequest, "The company list is empty")
+		return
+	}
+
+	changes, absent, err := tenants.PlanImport(h.db, userID, companies)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error comparing the company list")
+		return
+	}
+	report := &ImportReport{DryRun: r.URL.Query().Get("dryRun") == "true", Changes: changes, NotInList: absent}
+	if report.NotInList == nil {
+		report.NotInList = []tenants.ImportChange{}
+	}
+
+	var template *models.ProvisioningTemplate
+	var templateErr error
+	for i := range report.Changes {
+		change := &report.Changes[i]
+		if !report.DryRun && (change.Action == tenants.ImportCreate || change.Action == tenants.ImportRename) {
+			if change.Action == tenants.ImportCreate && template == nil && templateErr == nil {
+				template, templateErr = tenants.SelectTemplate(h.db, userID, r.URL.Query().Get("template"))
+			}
+			err := templateErr
+			if err == nil || change.Action == tenants.ImportRename {
+				err = h.db.Transaction(func(tx *gorm.DB) error {
+					return tenants.ApplyImportChange(tx, template, userID, change)
+				})
+			}
+			if err != nil {
+				change.Action, change.Reason = tenants.ImportFailed, importFailure(err)
+			}
+		}
+
+		switch change.Action {
+		case tenants.ImportCreate:
+			report.Created++
+		case tenants.ImportRename:
+			report.Renamed++
+		case tenants.ImportUnchanged:
+			report.Unchanged++
+		case tenants.ImportSkip:
+			report.Skipped++
+		case tenants.ImportFailed:
+			report.Failed++
+		}
+	}
+	util.RespondJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,98 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+
+	"gorm.io/gorm"
+)
+
+// TestImportCompanies checks that a Tally company list creates the missing companies, renames the
+// user's renamed ones, leaves other accounts' companies alone and reports every outcome
+func TestImportCompanies(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	importHandler := NewImportHandler(db)
+
+	template := &tenants.TemplateSpec{
+		ProvisioningTemplate: models.ProvisioningTemplate{Name: "standard", IsDefault: true, BmrmPort: 9001},
+		Hosts:                []string{"tally-1.local"},
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return tenants.SaveTemplate(tx, template) }); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+	user, token := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	stranger, _ := createTestUser(t, db, "other@example.com", "9000000002", models.UserTypeClient)
+	owned := &models.Tenant{CompanyGuid: "guid-owned", CompanyName: "Old Name", State: models.TenantActive}
+	viewed := &models.Tenant{CompanyGuid: "guid-viewed", CompanyName: "Viewed", State: models.TenantActive}
+	foreign := &models.Tenant{CompanyGuid: "guid-foreign", CompanyName: "Foreign", State: models.TenantActive}
+	dropped := &models.Tenant{CompanyGuid: "guid-dropped", CompanyName: "Dropped", State: models.TenantActive}
+	for _, tenant := range []*models.Tenant{owned, viewed, foreign, dropped} {
+		db.Create(tenant)
+	}
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: owned.ID, Role: models.RoleOwner})
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: viewed.ID, Role: models.RoleViewer})
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: dropped.ID, Role: models.RoleOwner})
+	db.Create(&models.UserTenantMapping{UserId: stranger.ID, TenantId: foreign.ID, Role: models.RoleOwner})
+
+	export := `<ENVELOPE><BODY><DATA><COLLECTION>
+		<COMPANY NAME="New Traders"><GUID>guid-new</GUID></COMPANY>
+		<COMPANY><GUID>guid-owned</GUID><NAME>New Name</NAME></COMPANY>
+		<COMPANY><GUID>guid-viewed</GUID><NAME>Viewed Renamed</NAME></COMPANY>
+		<COMPANY><GUID>guid-foreign</GUID><NAME>Foreign</NAME></COMPANY>
+	</COLLECTION></DATA></BODY></ENVELOPE>`
+	upload := func(query, body string) (int, ImportReport) {
+		req, _ := http.NewRequest(http.MethodPost, "/tenants/import"+query, bytes.NewBufferString(body))
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(importHandler.ImportCompanies))
+		var report ImportReport
+		json.Unmarshal(rr.Body.Bytes(), &report)
+		return rr.Code, report
+	}
+
+	code, report := upload("?dryRun=true", export)
+	if code != http.StatusOK || report.Created != 1 || report.Renamed != 1 || report.Skipped != 2 {
+		t.Fatalf("Expected a plan creating one company, renaming one and skipping two, got %d %+v", code, report)
+	}
+	if _, err := tenants.ByCompanyGuid(db, "guid-new"); err == nil {
+		t.Fatal("Expected a dry run to change nothing")
+	}
+
+	_, report = upload("", export)
+	if report.Created != 1 || report.Renamed != 1 || report.Skipped != 2 || report.Failed != 0 {
+		t.Fatalf("Unexpected import report %+v", report)
+	}
+	created, err := tenants.ByCompanyGuid(db, "guid-new")
+	if err != nil || created.CompanyName != "New Traders" || created.Host != "tally-1.local" {
+		t.Fatalf("Expected the missing company to be provisioned, got %+v (%v)", created, err)
+	}
+	if member, err := tenants.Membership(db, user.ID, created.ID); err != nil || member.Role != models.RoleOwner {
+		t.Errorf("Expected the importing user to own the new company, got %+v (%v)", member, err)
+	}
+	if renamed, _ := tenants.ByCompanyGuid(db, "guid-owned"); renamed.CompanyName != "New Name" {
+		t.Errorf("Expected the owned company to be renamed, got %q", renamed.CompanyName)
+	}
+	if kept, _ := tenants.ByCompanyGuid(db, "guid-viewed"); kept.CompanyName != "Viewed" {
+		t.Errorf("Expected a viewer not to rename the company, got %q", kept.CompanyName)
+	}
+	if _, err := tenants.Membership(db, user.ID, foreign.ID); err == nil {
+		t.Error("Expected no access to a company registered to another account")
+	}
+	if len(report.NotInList) != 1 || report.NotInList[0].CompanyGuid != "guid-dropped" {
+		t.Errorf("Expected the company missing from the list to be reported, got %+v", report.NotInList)
+	}
+
+	// The JSON form of the same list changes nothing further
+	_, report = upload("", `{"companies": [{"guid": "guid-new", "name": "New Traders"}, {"guid": "guid-owned", "name": "New Name"}]}`)
+	if report.Unchanged != 2 || report.Created != 0 || report.Renamed != 0 {
+		t.Errorf("Expected a repeated import to be unchanged, got %+v", report)
+	}
+	if code, _ := upload("", "GUID,NAME"); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an unknown format, got %d", http.StatusBadRequest, code)
+	}
+}
@@ -0,0 +1,293 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/notify"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// invitationTTL is how long an invitation, and the seat it holds, stays open
+	invitationTTL = 7 * 24 * time.Hour
+	// The code sent with an invitation proves the invitee controls the email, which registration does not check
+	invitationCodeLength      = 8
+	invitationCodeMaxAttempts = 5
+)
+
+// errInvitationPending aborts inviting an email that already has a pending invitation to the tenant
+var errInvitationPending = errors.New("the email already has a pending invitation")
+
+// CreateInvitation invites someone by email to join a tenant (?tenantId=, body {"email": "...",
+// "role": "member"}). The invitation holds a seat until it is accepted, revoked or expires, so it is
+// refused when the company has none left. Owners and admins invite; only owners invite owners.
+func (h *TenantHandler) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	email := strings.TrimSpace(body.Email)
+	if !util.IsValidEmail(email) {
+		util.HandleError(w, http.StatusBadRequest, "Invalid email")
+		return
+	}
+	if body.Role == "" {
+		body.Role = models.RoleMember
+	}
+	if !models.IsValidRole(body.Role) {
+		util.HandleError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	if !h.authorizeMemberChange(w, r, tenantId, body.Role) {
+		return
+	}
+	if code, status := tenants.StateRejection(tenant.State); code != "" {
+		util.RespondJSON(w, status, &models.GenericResponseMessage{Message: "The company is " + tenant.State, Code: code})
+		return
+	}
+
+	var members int64
+	if err := h.db.Model(&models.UserTenantMapping{}).
+		Where("tenant_id = ? AND user_id IN (?)", tenantId, h.db.Model(&models.User{}).Select("id").Where("LOWER(email) = LOWER(?)", email)).
+		Count(&members).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error checking membership")
+		return
+	}
+	if members > 0 {
+		util.HandleError(w, http.StatusConflict, "The user is already a member of the company")
+		return
+	}
+
+	code, err := util.GenerateNumericCode(invitationCodeLength)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error generating invitation code")
+		return
+	}
+	salt, err := models.GenerateSalt()
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error generating salt")
+		return
+	}
+	codeHash, err := models.HashPassword(code, salt)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error hashing invitation code")
+		return
+	}
+
+	now := time.Now()
+	invitation := &models.TenantInvitation{
+		TenantId:  tenantId,
+		Email:     email,
+		Role:      body.Role,
+		InvitedBy: actorID,
+		Status:    models.InvitationPending,
+		ExpiresAt: now.Add(invitationTTL),
+		CodeHash:  codeHash,
+		Salt:      salt,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tenants.ExpireInvitations(tx, tenantId, now); err != nil {
+			return err
+		}
+		var pending int64
+		if err := tx.Model(&models.TenantInvitation{}).Where("tenant_id = ? AND status = ? AND LOWER(email) = LOWER(?)",
+			tenantId, models.InvitationPending, email).Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return errInvitationPending
+		}
+		if err := tenants.ClaimSeats(tx, tenantId, 1); err != nil {
+			return err
+		}
+		return tx.Create(invitation).Error
+	})
+	if errors.Is(err, errInvitationPending) {
+		util.HandleError(w, http.StatusConflict, "The email already has a pending invitation")
+		return
+	}
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+
+	if err := notify.Send(notify.ChannelEmail, email, "Invitation to join "+tenant.CompanyName,
+		"You have been invited to join "+tenant.CompanyName+" as "+body.Role+". Sign in or register with this email"+
+			" and accept invitation "+strconv.FormatUint(invitation.ID, 10)+" with the code "+code+". It expires in 7 days."); err != nil {
+		// An undelivered invitation would hold a seat nobody knows about, so it is revoked
+		if err := h.db.Model(invitation).Updates(map[string]interface{}{"status": models.InvitationRevoked, "revoked_at": time.Now()}).Error; err != nil {
+			log.Printf("[!] Could not revoke undelivered invitation %d: %v\n", invitation.ID, err)
+		}
+		util.HandleError(w, http.StatusBadGateway, "The invitation could not be delivered and was revoked")
+		return
+	}
+	util.RespondJSON(w, http.StatusCreated, invitation)
+}
+
+// GetInvitations returns the invitations of a tenant, newest first, optionally only those with one
+// status (?tenantId=&status=). Owners and admins of the company and system users can read them.
+func (h *TenantHandler) GetInvitations(w http.ResponseWriter, r *http.Request) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.authorizeMemberChange(w, r, tenantId) {
+		return
+	}
+	if err := tenants.ExpireInvitations(h.db, tenantId, time.Now()); err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching invitations")
+		return
+	}
+
+	var invitations []models.TenantInvitation
+	query := h.db.Where("tenant_id = ?", tenantId)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Order("created_at DESC, id DESC").Find(&invitations).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching invitations")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &invitations)
+}
+
+// RevokeInvitation withdraws a pending invitation and frees its seat (?id=)
+func (h *TenantHandler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var invitation models.TenantInvitation
+	if err := h.db.First(&invitation, id).Error; err != nil {
+		util.HandleError(w, http.StatusNotFound, "Invitation not found")
+		return
+	}
+	if !h.authorizeMemberChange(w, r, invitation.TenantId, invitation.Role) {
+		return
+	}
+
+	now := time.Now()
+	revoked := h.db.Model(&models.TenantInvitation{}).
+		Where("id = ? AND status = ?", id, models.InvitationPending).
+		Updates(map[string]interface{}{"status": models.InvitationRevoked, "revoked_at": now})
+	if revoked.Error != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error revoking invitation")
+		return
+	}
+	if revoked.RowsAffected != 1 {
+		util.HandleError(w, http.StatusConflict, "The invitation is no longer pending")
+		return
+	}
+
+	invitation.Status = models.InvitationRevoked
+	invitation.RevokedAt = &now
+	util.RespondJSON(w, http.StatusOK, &invitation)
+}
+
+// AcceptInvitation makes the signed-in user, whose email must be the invited one, a member of the
+// company (?id=, body {"code": "..."} with the code sent with the invitation)
+func (h *TenantHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	confirmation, err := util.ParseJSONBody[struct {
+		Code string `json:"code"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	var invitation models.TenantInvitation
+	if err := h.db.First(&invitation, id).Error; err != nil {
+		util.HandleError(w, http.StatusNotFound, "Invitation not found")
+		return
+	}
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		util.HandleError(w, http.StatusForbidden, "The invitation was sent to someone else")
+		return
+	}
+	now := time.Now()
+	if invitation.Status == models.InvitationPending {
+		// Each guess claims one of the attempts before the code is checked, so parallel guesses share the limit
+		claimed := h.db.Model(&models.TenantInvitation{}).
+			Where("id = ? AND attempts < ? AND expires_at > ?", invitation.ID, invitationCodeMaxAttempts, now).
+			Update("attempts", gorm.Expr("attempts + 1"))
+		if claimed.Error != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error accepting invitation")
+			return
+		}
+		if claimed.RowsAffected != 1 {
+			h.db.Model(&invitation).Update("status", models.InvitationExpired)
+			util.HandleError(w, http.StatusGone, "The invitation has expired")
+			return
+		}
+		if err := models.ValidatePassword(confirmation.Code, invitation.Salt, invitation.CodeHash); err != nil {
+			util.HandleError(w, http.StatusUnauthorized, "Invalid invitation code")
+			return
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return tenants.AcceptInvitation(tx, &invitation, userID, now)
+	})
+	if errors.Is(err, tenants.ErrInvitationNotPending) {
+		util.HandleError(w, http.StatusConflict, "The invitation is no longer pending")
+		return
+	}
+	if errors.Is(err, tenants.ErrAlreadyMember) {
+		util.HandleError(w, http.StatusConflict, "You are already a member of the company")
+		return
+	}
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error accepting invitation")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &invitation)
+}
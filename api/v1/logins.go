@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// loginHistoryPage is a page of login attempts, newest first
+type loginHistoryPage struct {
+	Attempts []models.LoginAttempt `json:"attempts"`
+	Limit    int                   `json:"limit"`
+	Offset   int                   `json:"offset"`
+}
+
+// parseTimeParam reads an optional RFC 3339 timestamp from the query string
+func parseTimeParam(r *http.Request, param string) (*time.Time, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// GetOwnLoginHistory returns the authenticated user's login attempts (?limit=&offset=)
+func (h *AuthHandler) GetOwnLoginHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit, offset, err := util.ParsePagination(r, 20, 100)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	attempts, err := h.LoginAttemptRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Offset(offset)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching login history")
+		return
+	}
+
+	util.RespondJSON(w, http.StatusOK, &loginHistoryPage{Attempts: attempts, Limit: limit, Offset: offset})
+}
+
+// GetLoginAttempts lets admins query login attempts across users.
+// Optional filters: userId, success, reason, ip, from, to (RFC 3339), limit and offset.
+func (h *AuthHandler) GetLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit, offset, err := util.ParsePagination(r, 50, 500)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var userID uint64
+	if query.Has("userId") {
+		if userID, err = util.ParseUintParam(r, "userId"); err != nil {
+			util.HandleError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	var success *bool
+	if query.Has("success") {
+		value, err := strconv.ParseBool(query.Get("success"))
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, "invalid parameter: success")
+			return
+		}
+		success = &value
+	}
+
+	from, err := parseTimeParam(r, "from")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "invalid parameter: from")
+		return
+	}
+	to, err := parseTimeParam(r, "to")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "invalid parameter: to")
+		return
+	}
+
+	attempts, err := h.LoginAttemptRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		if userID != 0 {
+			db = db.Where("user_id = ?", userID)
+		}
+		if success != nil {
+			db = db.Where("success = ?", *success)
+		}
+		if reason := query.Get("reason"); reason != "" {
+			db = db.Where("reason = ?", reason)
+		}
+		if ip := query.Get("ip"); ip != "" {
+			db = db.Where("ip = ?", ip)
+		}
+		if from != nil {
+			db = db.Where("created_at >= ?", *from)
+		}
+		if to != nil {
+			db = db.Where("created_at < ?", *to)
+		}
+		return db.Order("created_at DESC").Limit(limit).Offset(offset)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching login attempts")
+		return
+	}
+
+	util.RespondJSON(w, http.StatusOK, &loginHistoryPage{Attempts: attempts, Limit: limit, Offset: offset})
+}
@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/users"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// errMergePreview rolls the merge transaction back after the summary has been computed
+var errMergePreview = errors.New("merge preview")
+
+// MergeUsers moves every mapping of absorbed_id onto survivor_id in one transaction. Ownership
+// transfers and invitations still waiting on absorbed_id are cancelled.
+// With "preview": true the merge is computed and rolled back so the summary can be reviewed first.
+func (h *UserHandler) MergeUsers(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	request, err := util.ParseJSONBody[struct {
+		SurvivorId uint64 `json:"survivor_id"`
+		AbsorbedId uint64 `json:"absorbed_id"`
+		Preview    bool   `json:"preview"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+
+	if request.SurvivorId == 0 || request.AbsorbedId == 0 || request.SurvivorId == request.AbsorbedId {
+		util.HandleError(w, http.StatusBadRequest, "survivor_id and absorbed_id must be two different users")
+		return
+	}
+	if _, err := h.UserRepo.GetByField("id", request.SurvivorId); err != nil {
+		util.HandleError(w, http.StatusNotFound, "Surviving user not found")
+		return
+	}
+	if _, err := h.UserRepo.GetByField("id", request.AbsorbedId); err != nil {
+		util.HandleError(w, http.StatusNotFound, "Absorbed user not found")
+		return
+	}
+	if merged, err := h.MergeRepo.GetAllByCondition("absorbed_id IN ?", []uint64{request.SurvivorId, request.AbsorbedId}); err == nil && len(merged) > 0 {
+		util.HandleError(w, http.StatusConflict, "One of the users has already been merged into another account")
+		return
+	}
+
+	var summary *users.MergeSummary
+	var record *models.UserMerge
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		summary, err = users.Merge(tx, request.SurvivorId, request.AbsorbedId)
+		if err != nil {
+			return err
+		}
+		if request.Preview {
+			return errMergePreview
+		}
+
+		encoded, err := json.Marshal(summary)
+		if err != nil {
+			return err
+		}
+		record = &models.UserMerge{
+			SurvivorId: request.SurvivorId,
+			AbsorbedId: request.AbsorbedId,
+			MergedBy:   actorID,
+			Summary:    string(encoded),
+		}
+		return util.NewRepository[models.UserMerge](tx).Create(record)
+	})
+	if err != nil && !errors.Is(err, errMergePreview) {
+		util.HandleError(w, http.StatusInternalServerError, "Error merging users")
+		return
+	}
+
+	response := struct {
+		Preview bool                `json:"preview"`
+		Summary *users.MergeSummary `json:"summary"`
+		Merge   *models.UserMerge   `json:"merge,omitempty"`
+	}{
+		Preview: request.Preview,
+		Summary: summary,
+		Merge:   record,
+	}
+	util.RespondJSON(w, http.StatusOK, &response)
+}
@@ -0,0 +1,122 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"sg-portal/internal/models"
+)
+
+// TestMergeUsers checks preview, collision handling and token revocation of a merge
+func TestMergeUsers(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	userHandler := NewUserHandler(db)
+
+	admin, adminToken := createTestUser(t, db, "admin@example.com", "9000000001", models.UserTypeSystem)
+	survivor, _ := createTestUser(t, db, "person@example.com", "9000000002", models.UserTypeClient)
+	absorbed, _ := createTestUser(t, db, "person@work.example.com", "9000000003", models.UserTypeClient)
+
+	now := time.Now()
+	db.Create(&[]models.UserTenantMapping{
+		{UserId: survivor.ID, TenantId: 1},
+		{UserId: absorbed.ID, TenantId: 1}, // collides with the survivor's mapping
+		{UserId: absorbed.ID, TenantId: 2},
+	})
+	db.Create(&[]models.UserSubscriptionHistory{
+		{UserId: survivor.ID, SubscriptionId: 1, ExpiryDate: now},
+		{UserId: absorbed.ID, SubscriptionId: 1, ExpiryDate: now.AddDate(1, 0, 0)},
+	})
+	pending := &models.OwnershipTransfer{TenantId: 2, FromUserId: absorbed.ID, Channel: "email", Recipient: "friend@example.com",
+		CodeHash: "hash", Salt: "salt", ExpiresAt: now.Add(time.Hour)}
+	offered := &models.OwnershipTransfer{TenantId: 3, FromUserId: survivor.ID, Channel: "mobile", Recipient: absorbed.MobileNumber,
+		CodeHash: "hash", Salt: "salt", ExpiresAt: now.Add(time.Hour)}
+	accepted := &models.OwnershipTransfer{TenantId: 4, FromUserId: admin.ID, ToUserId: &absorbed.ID, Channel: "email", Recipient: absorbed.Email,
+		Status: models.TransferAccepted, CodeHash: "hash", Salt: "salt", ExpiresAt: now}
+	invitation := &models.TenantInvitation{TenantId: 5, Email: absorbed.Email, InvitedBy: admin.ID, ExpiresAt: now.Add(time.Hour)}
+	joined := &models.TenantInvitation{TenantId: 2, Email: absorbed.Email, InvitedBy: admin.ID, Status: models.InvitationAccepted,
+		AcceptedBy: &absorbed.ID, ExpiresAt: now}
+	for _, row := range []interface{}{pending, offered, accepted, invitation, joined,
+		&models.UserPreference{UserId: absorbed.ID, Namespace: "ui", Key: "theme", Value: "dark"},
+		&models.LoginAttempt{UserId: &absorbed.ID, Credential: absorbed.Email, CredentialType: models.CredentialTypeEmail,
+			Success: true, Reason: models.LoginReasonSuccess}} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("Failed to seed merge data: %v", err)
+		}
+	}
+
+	merge := func(preview bool) map[string]interface{} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"survivor_id": survivor.ID, "absorbed_id": absorbed.ID, "preview": preview,
+		})
+		req, _ := http.NewRequest(http.MethodPost, "/users/merge", bytes.NewBuffer(body))
+		req.Header.Set("token", adminToken.Value.String())
+		rr := executeRequest(req, authHandler.RequireSystemUser(userHandler.MergeUsers))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response
+	}
+
+	merge(true)
+	var count int64
+	db.Model(&models.UserTenantMapping{}).Where("user_id = ?", absorbed.ID).Count(&count)
+	if count != 2 {
+		t.Fatalf("Expected preview to leave the absorbed user's mappings untouched, found %d", count)
+	}
+
+	response := merge(false)
+	tenants := response["summary"].(map[string]interface{})["tables"].(map[string]interface{})["user_tenant_mappings"].(map[string]interface{})
+	if tenants["moved"].(float64) != 1 || tenants["dropped"].(float64) != 1 {
+		t.Errorf("Unexpected tenant mapping summary: %v", tenants)
+	}
+
+	db.Model(&models.UserTenantMapping{}).Where("user_id = ?", survivor.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected the survivor to hold 2 tenant mappings, found %d", count)
+	}
+
+	var history []models.UserSubscriptionHistory
+	db.Where("user_id = ?", survivor.ID).Find(&history)
+	if len(history) != 1 || !history[0].ExpiryDate.After(now) {
+		t.Errorf("Expected the longer subscription history to survive, got %+v", history)
+	}
+
+	db.Model(&models.Token{}).Where("user_id = ?", absorbed.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected the absorbed user's tokens to be revoked, found %d", count)
+	}
+
+	// Offers waiting on the absorbed account are withdrawn; the settled records follow the survivor
+	summary := response["summary"].(map[string]interface{})
+	if summary["transfers_cancelled"].(float64) != 2 || summary["invitations_revoked"].(float64) != 1 {
+		t.Errorf("Expected 2 transfers cancelled and 1 invitation revoked, got %v", summary)
+	}
+	for _, transfer := range []*models.OwnershipTransfer{pending, offered} {
+		db.First(transfer, transfer.ID)
+		if transfer.Status != models.TransferCancelled {
+			t.Errorf("Expected the pending transfer %d to be cancelled, got %s", transfer.ID, transfer.Status)
+		}
+	}
+	db.First(pending, pending.ID)
+	db.First(accepted, accepted.ID)
+	if pending.FromUserId != survivor.ID || accepted.ToUserId == nil || *accepted.ToUserId != survivor.ID {
+		t.Errorf("Expected the transfer records to move to the survivor, got %+v and %+v", pending, accepted)
+	}
+	db.First(invitation, invitation.ID)
+	db.First(joined, joined.ID)
+	if invitation.Status != models.InvitationRevoked || joined.AcceptedBy == nil || *joined.AcceptedBy != survivor.ID {
+		t.Errorf("Expected the pending invitation revoked and the accepted one moved, got %+v and %+v", invitation, joined)
+	}
+	var preferences, attempts int64
+	db.Model(&models.UserPreference{}).Where("user_id = ?", survivor.ID).Count(&preferences)
+	db.Model(&models.LoginAttempt{}).Where("user_id = ?", survivor.ID).Count(&attempts)
+	if preferences != 1 || attempts != 1 {
+		t.Errorf("Expected the preference and login history to move, got %d and %d", preferences, attempts)
+	}
+}
@@ -0,0 +1,138 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+type MigrationHandler struct {
+	TenantRepo    *util.Repository[models.Tenant]
+	MigrationRepo *util.Repository[models.TenantMigration]
+	db            *gorm.DB
+}
+
+// NewMigrationHandler initializes the MigrationHandler with the repositories
+func NewMigrationHandler(db *gorm.DB) *MigrationHandler {
+	return &MigrationHandler{
+		TenantRepo:    util.NewRepository[models.Tenant](db),
+		MigrationRepo: util.NewRepository[models.TenantMigration](db),
+		db:            db,
+	}
+}
+
+// GetMigrations returns the migrations of a tenant, newest first (?tenantId=)
+func (h *MigrationHandler) GetMigrations(w http.ResponseWriter, r *http.Request) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	migrations, err := h.MigrationRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantId).Order("created_at DESC, id DESC")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching migrations")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &migrations)
+}
+
+// StartMigration plans moving a tenant to another host (?tenantId=, body {"host": "tally-2.local",
+// "bmrm_port": 9001, "reason": "..."}). Ports left out are allocated on hosts in the inventory. The
+// tenant keeps serving from its current host until the migration is cut over.
+func (h *MigrationHandler) StartMigration(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	target, err := util.ParseJSONBody[tenants.MigrationTarget](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	target.Host = strings.TrimSpace(target.Host)
+	if target.Host == "" {
+		util.HandleError(w, http.StatusBadRequest, "The target host is required")
+		return
+	}
+
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	if tenant.State == models.TenantDeleted {
+		util.HandleError(w, http.StatusConflict, "A deleted tenant cannot be migrated")
+		return
+	}
+
+	var migration *models.TenantMigration
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		migration, err = tenants.PlanMigration(tx, tenant, *target, actorID)
+		return err
+	})
+	switch {
+	case errors.Is(err, tenants.ErrMigrationActive):
+		util.HandleError(w, http.StatusConflict, "The tenant is already being migrated")
+		return
+	case errors.Is(err, tenants.ErrSameHost), errors.Is(err, tenants.ErrPortsRequired):
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if respondPlacementError(w, err) {
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error planning migration")
+		return
+	}
+	util.RespondJSON(w, http.StatusCreated, migration)
+}
+
+// ChangeMigrationState moves a migration on (?id=, body {"state": "copying"}): copying puts the
+// tenant in maintenance, cutover switches its endpoints to the target host and complete releases
+// the source host. Until it is complete, "rolled_back" returns the tenant to where it was.
+func (h *MigrationHandler) ChangeMigrationState(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		State string `json:"state"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	migration, err := h.MigrationRepo.GetByField("id", id)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Migration not found")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return tenants.AdvanceMigration(tx, migration, body.State, time.Now())
+	})
+	if errors.Is(err, tenants.ErrInvalidMigrationTransition) {
+		util.HandleError(w, http.StatusConflict, "A "+migration.State+" migration cannot become "+body.State)
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error changing migration state")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, migration)
+}
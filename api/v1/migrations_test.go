@@ -0,0 +1,160 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+
+	"gorm.io/gorm"
+)
+
+// TestTenantMigration checks that a migration reserves ports on the target host, reports maintenance
+// while copying, switches the endpoints at cutover, and can be rolled back until it is complete
+func TestTenantMigration(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	migrationHandler := NewMigrationHandler(db)
+
+	for _, host := range []*tenants.HostSpec{
+		{Host: models.Host{Name: "tally-1.local", PortFrom: 9000, PortTo: 9010, Capacity: 5}},
+		{Host: models.Host{Name: "tally-2.local", PortFrom: 9100, PortTo: 9110, Capacity: 5}},
+	} {
+		if err := db.Transaction(func(tx *gorm.DB) error { return tenants.SaveHost(tx, host) }); err != nil {
+			t.Fatalf("Failed to save host: %v", err)
+		}
+	}
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", State: models.TenantActive,
+		Host: "tally-1.local", BmrmPort: 9000, SgBizPort: 9001}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(tenant).Error; err != nil {
+			return err
+		}
+		if err := tenants.ReservePorts(tx, tenant); err != nil {
+			return err
+		}
+		return tenants.CreateLegacyEndpoints(tx, tenant)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create tenant: %v", err)
+	}
+	user, token := createTestUser(t, db, "user@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000002", models.UserTypeSystem)
+
+	call := func(url string, body interface{}, handler http.HandlerFunc) (int, models.TenantMigration) {
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(payload))
+		req.Header.Set("token", adminToken.Value.String())
+		rr := executeRequest(req, authHandler.RequireSystemUser(handler))
+		var migration models.TenantMigration
+		json.Unmarshal(rr.Body.Bytes(), &migration)
+		return rr.Code, migration
+	}
+	start := func() (int, models.TenantMigration) {
+		return call(fmt.Sprintf("/tenants/migrations?tenantId=%d", tenant.ID), map[string]string{"host": "tally-2.local"}, migrationHandler.StartMigration)
+	}
+	advance := func(id uint64, state string) int {
+		code, _ := call(fmt.Sprintf("/tenants/migrations/state?id=%d", id), map[string]string{"state": state}, migrationHandler.ChangeMigrationState)
+		return code
+	}
+	resolve := func() models.TokenTenantInfo {
+		req, _ := http.NewRequest(http.MethodGet, "/token/validate", nil)
+		req.Header.Set("token", token.Value.String())
+		req.Header.Set("companyid", tenant.CompanyGuid)
+		var info models.TokenTenantInfo
+		json.Unmarshal(executeRequest(req, authHandler.ResolveTenant).Body.Bytes(), &info)
+		return info
+	}
+	allocations := func(host string) int64 {
+		var count int64
+		db.Model(&models.PortAllocation{}).Joins("JOIN hosts ON hosts.id = port_allocations.host_id").
+			Where("port_allocations.tenant_id = ? AND hosts.name = ?", tenant.ID, host).Count(&count)
+		return count
+	}
+
+	// The host and ports follow the endpoints, so they cannot be edited on the tenant directly
+	updateTenant := func(updates map[string]interface{}) int {
+		payload, _ := json.Marshal(updates)
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("/tenants/update?tenantId=%d", tenant.ID), bytes.NewBuffer(payload))
+		return executeRequest(req, NewTenantHandler(db).UpdateTenant).Code
+	}
+	if code := updateTenant(map[string]interface{}{"host": "tally-2.local"}); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d changing the host directly, got %d", http.StatusBadRequest, code)
+	}
+	if endpoints := resolve().Endpoints; endpoints[models.ServiceBmrm][0].Host != "tally-1.local" {
+		t.Errorf("Expected the endpoints to stay on the source host, got %+v", endpoints)
+	}
+	if code := updateTenant(map[string]interface{}{"bmrm_port": 9001}); code != http.StatusBadRequest || allocations("tally-1.local") != 2 {
+		t.Errorf("Expected a port change to be refused with the allocations untouched, got %d and %d", code, allocations("tally-1.local"))
+	}
+
+	code, migration := start()
+	if code != http.StatusCreated || migration.ToBmrmPort != 9100 || migration.ToSgBizPort != 9101 || migration.ToTallySyncPort != 0 {
+		t.Fatalf("Expected ports allocated on the target host, got %d %+v", code, migration)
+	}
+	if allocations("tally-2.local") != 2 {
+		t.Errorf("Expected the target ports to be reserved, got %d", allocations("tally-2.local"))
+	}
+	if code, _ := start(); code != http.StatusConflict {
+		t.Errorf("Expected status code %d for a second migration, got %d", http.StatusConflict, code)
+	}
+	if resolve().Maintenance {
+		t.Error("Expected no maintenance while the migration is only planned")
+	}
+
+	advance(migration.ID, models.MigrationCopying)
+	if info := resolve(); !info.Maintenance || !info.Success {
+		t.Errorf("Expected the tenant to resolve in maintenance while copying, got %+v", info)
+	}
+	if code := advance(migration.ID, models.MigrationComplete); code != http.StatusConflict {
+		t.Errorf("Expected status code %d completing before cutover, got %d", http.StatusConflict, code)
+	}
+
+	if code := advance(migration.ID, models.MigrationCutover); code != http.StatusOK {
+		t.Fatalf("Expected status code %d at cutover, got %d", http.StatusOK, code)
+	}
+	endpoints := resolve().Endpoints
+	if len(endpoints[models.ServiceBmrm]) != 1 || endpoints[models.ServiceBmrm][0].Host != "tally-2.local" || endpoints[models.ServiceBmrm][0].Port != 9100 {
+		t.Errorf("Expected the endpoints to point at the target host, got %+v", endpoints)
+	}
+	var cutover models.Event
+	if err := db.Where("type = ? AND tenant_id = ? AND payload LIKE ?", events.TenantMigrationChanged, tenant.ID, `%"to":"cutover"%`).
+		First(&cutover).Error; err != nil {
+		t.Errorf("Expected the cutover to be published, got %v", err)
+	}
+
+	// Rolling back after cutover puts the tenant back where it was and frees the target ports
+	if code := advance(migration.ID, models.MigrationRolledBack); code != http.StatusOK {
+		t.Fatalf("Expected status code %d rolling back, got %d", http.StatusOK, code)
+	}
+	restored, _ := tenants.ByCompanyGuid(db, tenant.CompanyGuid)
+	if info := resolve(); restored.Host != "tally-1.local" || info.Maintenance || info.Endpoints[models.ServiceBmrm][0].Port != 9000 {
+		t.Errorf("Expected the tenant back on its source host, got %+v and %+v", restored, info.Endpoints)
+	}
+	if allocations("tally-2.local") != 0 {
+		t.Errorf("Expected the target ports to be released, got %d", allocations("tally-2.local"))
+	}
+
+	// A completed migration releases the source and cannot be rolled back
+	_, migration = start()
+	for _, state := range []string{models.MigrationCopying, models.MigrationCutover, models.MigrationComplete} {
+		if code := advance(migration.ID, state); code != http.StatusOK {
+			t.Fatalf("Expected status code %d moving to %s, got %d", http.StatusOK, state, code)
+		}
+	}
+	if allocations("tally-1.local") != 0 || allocations("tally-2.local") != 2 {
+		t.Errorf("Expected only the target ports to stay reserved, got %d and %d", allocations("tally-1.local"), allocations("tally-2.local"))
+	}
+	if code := advance(migration.ID, models.MigrationRolledBack); code != http.StatusConflict {
+		t.Errorf("Expected status code %d rolling back a complete migration, got %d", http.StatusConflict, code)
+	}
+	if moved, _ := tenants.ByCompanyGuid(db, tenant.CompanyGuid); moved.Host != "tally-2.local" || moved.BmrmPort != 9100 {
+		t.Errorf("Expected the tenant on the target host, got %+v", moved)
+	}
+}
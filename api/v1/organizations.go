@@ -0,0 +1,569 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/organizations"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errLastOrgAdmin aborts a change that would leave an organization without an admin
+var errLastOrgAdmin = errors.New("an organization must keep at least one admin")
+
+// errCompanyInOrg aborts adding a company that another request placed in an organization meanwhile
+var errCompanyInOrg = errors.New("company already belongs to an organization")
+
+type OrganizationHandler struct {
+	OrganizationRepo *util.Repository[models.Organization]
+	MemberRepo       *util.Repository[models.OrganizationMember]
+	TenantRepo       *util.Repository[models.Tenant]
+	db               *gorm.DB
+}
+
+// NewOrganizationHandler initializes the OrganizationHandler with the repositories
+func NewOrganizationHandler(db *gorm.DB) *OrganizationHandler {
+	return &OrganizationHandler{
+		OrganizationRepo: util.NewRepository[models.Organization](db),
+		MemberRepo:       util.NewRepository[models.OrganizationMember](db),
+		TenantRepo:       util.NewRepository[models.Tenant](db),
+		db:               db,
+	}
+}
+
+// OrganizationWithRole is an organization together with the requesting user's role in it
+type OrganizationWithRole struct {
+	models.Organization
+	Role string `json:"role"`
+}
+
+// OrganizationMemberRequest sets a user's membership of an organization. Members without
+// all_companies inherit the companies listed in company_ids.
+type OrganizationMemberRequest struct {
+	UserId       uint64   `json:"user_id"`
+	Role         string   `json:"role"`
+	AllCompanies bool     `json:"all_companies"`
+	TenantRole   string   `json:"tenant_role"`
+	CompanyIds   []uint64 `json:"company_ids"`
+}
+
+// OrganizationMemberEntry is a member with the companies chosen for them
+type OrganizationMemberEntry struct {
+	models.OrganizationMember
+	CompanyIds []uint64 `json:"company_ids"`
+}
+
+// authorizeOrg loads the organization named by ?id= and checks that the authenticated user
+// belongs to it, or administers it when admin is set. System users may manage any organization.
+func (h *OrganizationHandler) authorizeOrg(w http.ResponseWriter, r *http.Request, admin bool) (*models.Organization, uint64, bool) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, 0, false
+	}
+	orgId, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return nil, 0, false
+	}
+	org, err := h.OrganizationRepo.GetByField("id", orgId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Organization not found")
+		return nil, 0, false
+	}
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType == models.UserTypeSystem {
+		return org, actorID, true
+	}
+	member, err := organizations.Member(h.db, actorID, orgId)
+	if err != nil {
+		util.HandleError(w, http.StatusForbidden, "User is not a member of the organization")
+		return nil, 0, false
+	}
+	if admin && member.Role != models.OrgRoleAdmin {
+		util.HandleError(w, http.StatusForbidden, "Only organization admins can manage the organization")
+		return nil, 0, false
+	}
+	return org, actorID, true
+}
+
+// GetOrganizations returns the organizations of the authenticated user; system users see all of them
+func (h *OrganizationHandler) GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	system := false
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType == models.UserTypeSystem {
+		system = true
+	}
+
+	members, err := h.MemberRepo.GetAllByCondition("user_id = ?", actorID)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching organizations")
+		return
+	}
+	roles := make(map[uint64]string, len(members))
+	var orgIds []uint64
+	for _, member := range members {
+		roles[member.OrganizationId] = member.Role
+		orgIds = append(orgIds, member.OrganizationId)
+	}
+	orgs, err := h.OrganizationRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		if !system {
+			db = db.Where("id IN ?", orgIds)
+		}
+		return db.Order("name")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching organizations")
+		return
+	}
+
+	result := make([]OrganizationWithRole, 0, len(orgs))
+	for _, org := range orgs {
+		result = append(result, OrganizationWithRole{Organization: org, Role: roles[org.ID]})
+	}
+	util.RespondJSON(w, http.StatusOK, &result)
+}
+
+// CreateOrganization creates an organization administered by the authenticated user ({"name": "..."})
+func (h *OrganizationHandler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		Name string `json:"name"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	org := &models.Organization{Name: strings.TrimSpace(body.Name)}
+	if org.Name == "" {
+		util.HandleError(w, http.StatusBadRequest, "A name is required")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrganizationMember{
+			OrganizationId: org.ID, UserId: actorID, Role: models.OrgRoleAdmin, AllCompanies: true, TenantRole: models.RoleAdmin,
+		}).Error
+	})
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "An organization with this name already exists")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error creating organization")
+		return
+	}
+	util.RespondJSON(w, http.StatusCreated, &OrganizationWithRole{Organization: *org, Role: models.OrgRoleAdmin})
+}
+
+// UpdateOrganization renames an organization (?id=, body {"name": "..."})
+func (h *OrganizationHandler) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		Name string `json:"name"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		util.HandleError(w, http.StatusBadRequest, "A name is required")
+		return
+	}
+	err = h.OrganizationRepo.UpdateOne("id", org.ID, map[string]interface{}{"name": name})
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "An organization with this name already exists")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating organization")
+		return
+	}
+	org.Name = name
+	util.RespondJSON(w, http.StatusOK, org)
+}
+
+// DeleteOrganization removes an organization (?id=). Its companies stay with their direct members.
+func (h *OrganizationHandler) DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error { return organizations.Delete(tx, org.ID) }); err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error deleting organization")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetCompanies returns the companies of an organization (?id=)
+func (h *OrganizationHandler) GetCompanies(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, false)
+	if !ok {
+		return
+	}
+	companies, err := h.TenantRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("organization_id = ? AND state <> ?", org.ID, models.TenantDeleted).Order("company_name")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching companies")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &companies)
+}
+
+// AddCompany brings a company into an organization (?id=&tenantId=). The caller must administer
+// the organization and own the company. A company belongs to at most one organization.
+func (h *OrganizationHandler) AddCompany(w http.ResponseWriter, r *http.Request) {
+	org, actorID, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	tenant, ok := h.companyParam(w, r)
+	if !ok {
+		return
+	}
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType != models.UserTypeSystem {
+		if member, err := tenants.Membership(h.db, actorID, tenant.ID); err != nil || member.Role != models.RoleOwner {
+			util.HandleError(w, http.StatusForbidden, "Only company owners can add the company to an organization")
+			return
+		}
+	}
+	if tenant.OrganizationId != nil {
+		util.HandleError(w, http.StatusConflict, "The company already belongs to an organization")
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Tenant{}).Where("id = ? AND organization_id IS NULL", tenant.ID).Update("organization_id", org.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errCompanyInOrg
+		}
+		return organizations.Sync(tx, org.ID)
+	})
+	if errors.Is(err, errCompanyInOrg) {
+		util.HandleError(w, http.StatusConflict, "The company already belongs to an organization")
+		return
+	}
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error adding company to organization")
+		return
+	}
+	tenant.OrganizationId = &org.ID
+	util.RespondJSON(w, http.StatusOK, tenant)
+}
+
+// RemoveCompany takes a company out of its organization (?id=&tenantId=). Organization admins and
+// the company's owners may do so; members who only inherited access lose it.
+func (h *OrganizationHandler) RemoveCompany(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	orgId, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tenant, ok := h.companyParam(w, r)
+	if !ok {
+		return
+	}
+	if tenant.OrganizationId == nil || *tenant.OrganizationId != orgId {
+		util.HandleError(w, http.StatusNotFound, "The company does not belong to the organization")
+		return
+	}
+	userType, _ := util.UserTypeFromContext(r.Context())
+	orgMember, orgErr := organizations.Member(h.db, actorID, orgId)
+	tenantMember, tenantErr := tenants.Membership(h.db, actorID, tenant.ID)
+	if userType != models.UserTypeSystem && (orgErr != nil || orgMember.Role != models.OrgRoleAdmin) &&
+		(tenantErr != nil || tenantMember.Role != models.RoleOwner) {
+		util.HandleError(w, http.StatusForbidden, "Only organization admins and company owners can remove the company")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Tenant{}).Where("id = ?", tenant.ID).Update("organization_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_id = ?", tenant.ID).Delete(&models.OrganizationMemberCompany{}).Error; err != nil {
+			return err
+		}
+		return organizations.Sync(tx, orgId)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error removing company from organization")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// companyParam loads the tenant named by ?tenantId=
+func (h *OrganizationHandler) companyParam(w http.ResponseWriter, r *http.Request) (*models.Tenant, bool) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return nil, false
+	}
+	return tenant, true
+}
+
+// GetMembers returns the members of an organization with the companies chosen for them (?id=)
+func (h *OrganizationHandler) GetMembers(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	members, err := h.MemberRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("organization_id = ?", org.ID).Order("id")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching organization members")
+		return
+	}
+	entries := make([]OrganizationMemberEntry, 0, len(members))
+	for _, member := range members {
+		entry := OrganizationMemberEntry{OrganizationMember: member, CompanyIds: []uint64{}}
+		if err := h.db.Model(&models.OrganizationMemberCompany{}).Where("member_id = ?", member.ID).
+			Order("tenant_id").Pluck("tenant_id", &entry.CompanyIds).Error; err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching organization members")
+			return
+		}
+		entries = append(entries, entry)
+	}
+	util.RespondJSON(w, http.StatusOK, &entries)
+}
+
+// SetMember adds a user to an organization or changes their membership (?id=). Admins inherit
+// admin access to every company; members get tenant_role on all companies or on company_ids.
+func (h *OrganizationHandler) SetMember(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	req, err := util.ParseJSONBody[OrganizationMemberRequest](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+	if req.TenantRole == "" {
+		req.TenantRole = models.RoleMember
+	}
+	if req.Role == models.OrgRoleAdmin {
+		req.AllCompanies, req.TenantRole, req.CompanyIds = true, models.RoleAdmin, nil
+	}
+	switch {
+	case req.Role != models.OrgRoleAdmin && req.Role != models.OrgRoleMember:
+		util.HandleError(w, http.StatusBadRequest, "Role must be admin or member")
+		return
+	case !models.IsValidRole(req.TenantRole) || req.TenantRole == models.RoleOwner:
+		util.HandleError(w, http.StatusBadRequest, "Tenant role must be admin, member or viewer")
+		return
+	case req.AllCompanies && len(req.CompanyIds) > 0:
+		util.HandleError(w, http.StatusBadRequest, "Choose either all companies or a list of companies")
+		return
+	}
+	var user models.User
+	if err := h.db.First(&user, req.UserId).Error; err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	var inOrg int64
+	if err := h.db.Model(&models.Tenant{}).Where("id IN ? AND organization_id = ?", req.CompanyIds, org.ID).
+		Count(&inOrg).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error checking companies")
+		return
+	}
+	if len(req.CompanyIds) > 0 && inOrg != int64(len(req.CompanyIds)) {
+		util.HandleError(w, http.StatusBadRequest, "Every company must belong to the organization")
+		return
+	}
+
+	member := &models.OrganizationMember{
+		OrganizationId: org.ID, UserId: req.UserId, Role: req.Role, AllCompanies: req.AllCompanies, TenantRole: req.TenantRole,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "organization_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role", "all_companies", "tenant_role"}),
+		}).Create(member).Error; err != nil {
+			return err
+		}
+		// The conflict update leaves the ID unset on some drivers
+		if err := tx.Where("organization_id = ? AND user_id = ?", org.ID, req.UserId).First(member).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("member_id = ?", member.ID).Delete(&models.OrganizationMemberCompany{}).Error; err != nil {
+			return err
+		}
+		for _, tenantID := range req.CompanyIds {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.OrganizationMemberCompany{MemberId: member.ID, TenantId: tenantID}).Error; err != nil {
+				return err
+			}
+		}
+		if err := guardLastOrgAdmin(tx, org.ID); err != nil {
+			return err
+		}
+		return organizations.Sync(tx, org.ID)
+	})
+	if errors.Is(err, errLastOrgAdmin) {
+		util.HandleError(w, http.StatusConflict, "An organization must keep at least one admin")
+		return
+	}
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error saving organization member")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &OrganizationMemberEntry{OrganizationMember: *member, CompanyIds: req.CompanyIds})
+}
+
+// RemoveMember removes a user from an organization together with the access they inherited (?id=&userId=)
+func (h *OrganizationHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	userId, err := util.ParseUintParam(r, "userId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	member, err := organizations.Member(h.db, userId, org.ID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User is not a member of the organization")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("member_id = ?", member.ID).Delete(&models.OrganizationMemberCompany{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		if err := guardLastOrgAdmin(tx, org.ID); err != nil {
+			return err
+		}
+		return organizations.Sync(tx, org.ID)
+	})
+	if errors.Is(err, errLastOrgAdmin) {
+		util.HandleError(w, http.StatusConflict, "An organization must keep at least one admin")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error removing organization member")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// guardLastOrgAdmin fails with errLastOrgAdmin when the organization would be left without admins
+func guardLastOrgAdmin(tx *gorm.DB, orgID uint64) error {
+	admins, err := organizations.CountAdmins(tx, orgID)
+	if err != nil {
+		return err
+	}
+	if admins < 1 {
+		return errLastOrgAdmin
+	}
+	return nil
+}
+
+// GetSubscriptions returns the subscriptions bought for an organization (?id=)
+func (h *OrganizationHandler) GetSubscriptions(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, false)
+	if !ok {
+		return
+	}
+	var subscriptions []models.Subscription
+	if err := h.db.Where("id IN (?)", h.db.Model(&models.OrganizationSubscription{}).Select("subscription_id").
+		Where("organization_id = ?", org.ID)).Order("id").Find(&subscriptions).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching subscriptions")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &subscriptions)
+}
+
+// AddSubscription gives an organization a subscription that all its members hold (?id=&subscriptionId=)
+func (h *OrganizationHandler) AddSubscription(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	subscriptionId, err := util.ParseUintParam(r, "subscriptionId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var subscription models.Subscription
+	if err := h.db.First(&subscription, subscriptionId).Error; err != nil {
+		util.HandleError(w, http.StatusNotFound, "Subscription not found")
+		return
+	}
+	mapping := &models.OrganizationSubscription{OrganizationId: org.ID, SubscriptionId: subscription.ID}
+	err = h.db.Create(mapping).Error
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "The organization already has this subscription")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error adding subscription")
+		return
+	}
+	util.RespondJSON(w, http.StatusCreated, mapping)
+}
+
+// RemoveSubscription takes a subscription away from an organization (?id=&subscriptionId=)
+func (h *OrganizationHandler) RemoveSubscription(w http.ResponseWriter, r *http.Request) {
+	org, _, ok := h.authorizeOrg(w, r, true)
+	if !ok {
+		return
+	}
+	subscriptionId, err := util.ParseUintParam(r, "subscriptionId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.db.Where("organization_id = ? AND subscription_id = ?", org.ID, subscriptionId).
+		Delete(&models.OrganizationSubscription{}).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error removing subscription")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
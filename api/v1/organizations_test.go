@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/subscriptions"
+	"sg-portal/internal/tenants"
+)
+
+// TestOrganizations checks that organization members inherit access to its companies, all of them
+// or the selected ones, that inherited access is managed through the organization only, and that
+// organization subscriptions apply to every member
+func TestOrganizations(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	orgHandler := NewOrganizationHandler(db)
+	tenantHandler := NewTenantHandler(db)
+	companyHandler := NewCompanyHandler(db)
+
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	member, _ := createTestUser(t, db, "member@example.com", "9000000002", models.UserTypeClient)
+	admin, _ := createTestUser(t, db, "admin@example.com", "9000000003", models.UserTypeClient)
+	north := &models.Tenant{CompanyGuid: "north", CompanyName: "North"}
+	south := &models.Tenant{CompanyGuid: "south", CompanyName: "South"}
+	solo := &models.Tenant{CompanyGuid: "solo", CompanyName: "Solo"}
+	for _, tenant := range []*models.Tenant{north, south, solo} {
+		db.Create(tenant)
+		db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	}
+
+	do := func(method, url string, body interface{}, handler http.HandlerFunc) *http.Response {
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(payload))
+		req.Header.Set("token", ownerToken.Value.String())
+		return executeRequest(req, authHandler.Authenticate(handler)).Result()
+	}
+
+	res := do(http.MethodPost, "/organizations", map[string]string{"name": "Group"}, orgHandler.CreateOrganization)
+	var org OrganizationWithRole
+	json.NewDecoder(res.Body).Decode(&org)
+	if res.StatusCode != http.StatusCreated || org.Role != models.OrgRoleAdmin {
+		t.Fatalf("Expected the creator to administer the new organization, got %d %+v", res.StatusCode, org)
+	}
+	for _, tenant := range []*models.Tenant{north, south} {
+		url := fmt.Sprintf("/organizations/companies?id=%d&tenantId=%d", org.ID, tenant.ID)
+		if res := do(http.MethodPost, url, nil, orgHandler.AddCompany); res.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status code %d adding a company, got %d", http.StatusOK, res.StatusCode)
+		}
+	}
+
+	setMember := func(body map[string]interface{}) int {
+		return do(http.MethodPut, fmt.Sprintf("/organizations/members?id=%d", org.ID), body, orgHandler.SetMember).StatusCode
+	}
+	if code := setMember(map[string]interface{}{"user_id": member.ID, "role": models.OrgRoleMember, "company_ids": []uint64{north.ID}}); code != http.StatusOK {
+		t.Fatalf("Expected status code %d adding a member, got %d", http.StatusOK, code)
+	}
+	if code := setMember(map[string]interface{}{"user_id": admin.ID, "role": models.OrgRoleAdmin}); code != http.StatusOK {
+		t.Fatalf("Expected status code %d adding an admin, got %d", http.StatusOK, code)
+	}
+
+	if m, err := tenants.Membership(db, member.ID, north.ID); err != nil || m.Role != models.RoleMember || m.InheritedFrom != org.ID {
+		t.Errorf("Expected the member to inherit access to the selected company, got %+v %v", m, err)
+	}
+	if _, err := tenants.Membership(db, member.ID, south.ID); err == nil {
+		t.Error("Expected no access to a company that was not selected")
+	}
+	for _, tenant := range []*models.Tenant{north, south} {
+		if m, err := tenants.Membership(db, admin.ID, tenant.ID); err != nil || m.Role != models.RoleAdmin {
+			t.Errorf("Expected the organization admin to administer %s, got %+v %v", tenant.CompanyGuid, m, err)
+		}
+	}
+	if _, err := tenants.Membership(db, admin.ID, solo.ID); err == nil {
+		t.Error("Expected no access to a company outside the organization")
+	}
+
+	// Inherited access takes a seat in the company like any other membership
+	extra, _ := createTestUser(t, db, "extra@example.com", "9000000004", models.UserTypeClient)
+	db.Model(north).Update("seat_limit", 3)
+	if code := setMember(map[string]interface{}{"user_id": extra.ID, "role": models.OrgRoleMember, "company_ids": []uint64{north.ID}}); code != http.StatusConflict {
+		t.Errorf("Expected status code %d adding a member to a full company, got %d", http.StatusConflict, code)
+	}
+	if _, err := tenants.Membership(db, extra.ID, north.ID); err == nil {
+		t.Error("Expected no inherited access past the seat limit")
+	}
+	db.Model(north).Update("seat_limit", nil)
+
+	// Inherited access is changed through the organization, not the company
+	url := fmt.Sprintf("/tenants/users?userId=%d&tenantId=%d", member.ID, north.ID)
+	if res := do(http.MethodDelete, url, nil, tenantHandler.DeleteUserTenantMapping); res.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code %d removing inherited access, got %d", http.StatusConflict, res.StatusCode)
+	}
+
+	// An admin can leave while another remains, keeping their direct memberships
+	url = fmt.Sprintf("/organizations/members?id=%d&userId=%d", org.ID, owner.ID)
+	if res := do(http.MethodDelete, url, nil, orgHandler.RemoveMember); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d removing an admin while another remains, got %d", http.StatusOK, res.StatusCode)
+	}
+	if m, err := tenants.Membership(db, owner.ID, north.ID); err != nil || m.Role != models.RoleOwner {
+		t.Errorf("Expected direct ownership to survive leaving the organization, got %+v %v", m, err)
+	}
+	db.Create(&models.OrganizationMember{OrganizationId: org.ID, UserId: owner.ID, Role: models.OrgRoleAdmin, AllCompanies: true, TenantRole: models.RoleAdmin})
+
+	url = fmt.Sprintf("/organizations/members?id=%d&userId=%d", org.ID, member.ID)
+	if res := do(http.MethodDelete, url, nil, orgHandler.RemoveMember); res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d removing a member, got %d", http.StatusOK, res.StatusCode)
+	}
+	if _, err := tenants.Membership(db, member.ID, north.ID); err == nil {
+		t.Error("Expected the removed member to lose inherited access")
+	}
+
+	pro := &models.Subscription{Name: "Pro", Code: "pro"}
+	db.Create(pro)
+	db.Create(&models.OrganizationSubscription{OrganizationId: org.ID, SubscriptionId: pro.ID})
+	if held, err := subscriptions.ForUser(db, admin.ID); err != nil || len(held) != 1 || held[0] != pro.ID {
+		t.Errorf("Expected organization members to hold its subscription, got %v %v", held, err)
+	}
+	if held, _ := subscriptions.ForUser(db, member.ID); len(held) != 0 {
+		t.Errorf("Expected former members not to hold the subscription, got %v", held)
+	}
+
+	// Companies only join an organization through its admins, never through the tenant update
+	url = fmt.Sprintf("/tenants/update?tenantId=%d", solo.ID)
+	if res := do(http.MethodPut, url, map[string]interface{}{"OrganizationId": org.ID}, tenantHandler.UpdateTenant); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code %d moving a company into an organization directly, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+
+	res = do(http.MethodGet, fmt.Sprintf("/companies?id=%d&groupBy=organization", owner.ID), nil, companyHandler.GetCompanies)
+	var groups []CompanyGroup
+	json.NewDecoder(res.Body).Decode(&groups)
+	if len(groups) != 2 || groups[0].Organization == nil || len(groups[0].Companies) != 2 ||
+		groups[1].Organization != nil || len(groups[1].Companies) != 1 || groups[1].Companies[0].ID != solo.ID {
+		t.Errorf("Expected the organization's companies first and the ungrouped one last, got %+v", groups)
+	}
+}
@@ -0,0 +1,263 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+const (
+	preferenceMaxValueBytes = 16 * 1024  // Largest single document
+	preferenceMaxUserBytes  = 256 * 1024 // Total stored per user across all scopes
+	preferenceMaxBatch      = 100        // Entries per bulk request
+)
+
+var preferenceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+
+// errPreferenceConflict aborts a bulk write when a version no longer matches
+var errPreferenceConflict = errors.New("preference version conflict")
+
+// errPreferenceQuota aborts a bulk write that would take the user over preferenceMaxUserBytes
+var errPreferenceQuota = errors.New("preference quota exceeded")
+
+type PreferenceHandler struct {
+	PreferenceRepo *util.Repository[models.UserPreference]
+	db             *gorm.DB
+}
+
+// NewPreferenceHandler initializes the PreferenceHandler with the repository
+func NewPreferenceHandler(db *gorm.DB) *PreferenceHandler {
+	return &PreferenceHandler{
+		PreferenceRepo: util.NewRepository[models.UserPreference](db),
+		db:             db,
+	}
+}
+
+// PreferenceEntry is a preference as exchanged over the API
+type PreferenceEntry struct {
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Version   uint64          `json:"version"`   // On writes, the version being replaced (0 for new keys)
+	TenantId  uint64          `json:"tenant_id"` // 0 when the value applies across tenants
+}
+
+func toPreferenceEntry(preference *models.UserPreference) PreferenceEntry {
+	return PreferenceEntry{
+		Namespace: preference.Namespace,
+		Key:       preference.Key,
+		Value:     json.RawMessage(preference.Value),
+		Version:   preference.Version,
+		TenantId:  preference.TenantId,
+	}
+}
+
+// resolveScope returns the tenant selected by the optional "companyid" header, checking that the
+// authenticated user belongs to it. 0 means the user-wide scope.
+func (h *PreferenceHandler) resolveScope(w http.ResponseWriter, r *http.Request) (uint64, uint64, bool) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return 0, 0, false
+	}
+
+	companyId := r.Header.Get("companyid")
+	if companyId == "" {
+		return userID, 0, true
+	}
+	tenant, err := tenants.ByCompanyGuid(h.db, companyId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Non Registered Company Requested")
+		return 0, 0, false
+	}
+	if _, err := tenants.ActiveMembership(h.db, userID, tenant.ID); err != nil {
+		util.HandleError(w, http.StatusForbidden, "User is not a member of the company")
+		return 0, 0, false
+	}
+	return userID, tenant.ID, true
+}
+
+// GetPreferences returns the caller's preferences (?namespace=&keys=a,b). With a "companyid"
+// header, values saved for that company override the user-wide ones of the same key.
+func (h *PreferenceHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, ok := h.resolveScope(w, r)
+	if !ok {
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	var keys []string
+	if raw := r.URL.Query().Get("keys"); raw != "" {
+		keys = strings.Split(raw, ",")
+	}
+
+	preferences, err := h.PreferenceRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		db = db.Where("user_id = ? AND tenant_id IN ?", userID, []uint64{0, tenantID})
+		if namespace != "" {
+			db = db.Where("namespace = ?", namespace)
+		}
+		if len(keys) > 0 {
+			db = db.Where("key IN ?", keys)
+		}
+		// User-wide values come first so the tenant values replace them below
+		return db.Order("tenant_id, namespace, key")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching preferences")
+		return
+	}
+
+	effective := map[string]int{}
+	entries := []PreferenceEntry{}
+	for i := range preferences {
+		name := preferences[i].Namespace + "\x00" + preferences[i].Key
+		if index, ok := effective[name]; ok {
+			entries[index] = toPreferenceEntry(&preferences[i])
+			continue
+		}
+		effective[name] = len(entries)
+		entries = append(entries, toPreferenceEntry(&preferences[i]))
+	}
+
+	util.RespondJSON(w, http.StatusOK, &entries)
+}
+
+// SetPreferences writes a batch of preferences in the caller's scope atomically. Every entry
+// carries the version it replaces; if any is stale nothing is written and 409 lists the current values.
+func (h *PreferenceHandler) SetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, ok := h.resolveScope(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := util.ParseJSONBody[[]PreferenceEntry](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	if len(*entries) == 0 || len(*entries) > preferenceMaxBatch {
+		util.HandleError(w, http.StatusBadRequest, "Between 1 and 100 preferences can be set at once")
+		return
+	}
+	for _, entry := range *entries {
+		if !preferenceNamePattern.MatchString(entry.Namespace) || !preferenceNamePattern.MatchString(entry.Key) {
+			util.HandleError(w, http.StatusBadRequest, "Invalid namespace or key: "+entry.Namespace+"/"+entry.Key)
+			return
+		}
+		if len(entry.Value) == 0 || !json.Valid(entry.Value) {
+			util.HandleError(w, http.StatusBadRequest, "Value must be a JSON document: "+entry.Namespace+"/"+entry.Key)
+			return
+		}
+		if len(entry.Value) > preferenceMaxValueBytes {
+			util.HandleError(w, http.StatusRequestEntityTooLarge, "Value exceeds 16KB: "+entry.Namespace+"/"+entry.Key)
+			return
+		}
+	}
+
+	var conflicts []PreferenceEntry
+	saved := make([]PreferenceEntry, 0, len(*entries))
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		repo := util.NewRepository[models.UserPreference](tx)
+		for _, entry := range *entries {
+			existing, err := repo.GetAllByCondition("user_id = ? AND tenant_id = ? AND namespace = ? AND key = ?",
+				userID, tenantID, entry.Namespace, entry.Key)
+			if err != nil {
+				return err
+			}
+
+			if len(existing) == 0 {
+				if entry.Version != 0 {
+					conflicts = append(conflicts, PreferenceEntry{Namespace: entry.Namespace, Key: entry.Key, TenantId: tenantID})
+					continue
+				}
+				preference := &models.UserPreference{
+					UserId: userID, TenantId: tenantID, Namespace: entry.Namespace, Key: entry.Key,
+					Value: string(entry.Value), Version: 1,
+				}
+				if err := repo.Create(preference); err != nil {
+					if util.IsDuplicateKeyError(err) {
+						return errPreferenceConflict
+					}
+					return err
+				}
+				saved = append(saved, toPreferenceEntry(preference))
+				continue
+			}
+
+			// Only the row still at the expected version is updated
+			current := existing[0]
+			result := tx.Model(&models.UserPreference{}).
+				Where("id = ? AND version = ?", current.ID, entry.Version).
+				Updates(map[string]interface{}{"value": string(entry.Value), "version": entry.Version + 1})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				conflicts = append(conflicts, toPreferenceEntry(&current))
+				continue
+			}
+			current.Value = string(entry.Value)
+			current.Version = entry.Version + 1
+			saved = append(saved, toPreferenceEntry(&current))
+		}
+		if len(conflicts) > 0 {
+			return errPreferenceConflict
+		}
+
+		var total int64
+		if err := tx.Model(&models.UserPreference{}).Where("user_id = ?", userID).
+			Select("COALESCE(SUM(LENGTH(value)), 0)").Scan(&total).Error; err != nil {
+			return err
+		}
+		if total > preferenceMaxUserBytes {
+			return errPreferenceQuota
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errPreferenceConflict):
+		util.RespondJSON(w, http.StatusConflict, &conflicts)
+	case errors.Is(err, errPreferenceQuota):
+		util.HandleError(w, http.StatusRequestEntityTooLarge, "Preferences exceed the 256KB limit per user")
+	case err != nil:
+		util.HandleError(w, http.StatusInternalServerError, "Error saving preferences")
+	default:
+		util.RespondJSON(w, http.StatusOK, &saved)
+	}
+}
+
+// DeletePreference removes a preference in the caller's scope (?namespace=&key=&version=)
+func (h *PreferenceHandler) DeletePreference(w http.ResponseWriter, r *http.Request) {
+	userID, tenantID, ok := h.resolveScope(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := util.ParseUintParam(r, "version")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := h.db.Where("user_id = ? AND tenant_id = ? AND namespace = ? AND key = ? AND version = ?",
+		userID, tenantID, r.URL.Query().Get("namespace"), r.URL.Query().Get("key"), version).
+		Delete(&models.UserPreference{})
+	if result.Error != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error deleting preference")
+		return
+	}
+	if result.RowsAffected == 0 {
+		util.HandleError(w, http.StatusConflict, "Preference not found at that version")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
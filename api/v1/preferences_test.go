@@ -0,0 +1,54 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/models"
+)
+
+// TestSetPreferences checks optimistic concurrency and company overrides of preferences
+func TestSetPreferences(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+	authHandler := NewAuthHandler(db)
+	preferenceHandler := NewPreferenceHandler(db)
+
+	user, token := createTestUser(t, db, "prefs@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: 1})
+
+	set := func(companyId string, entries []PreferenceEntry) int {
+		body, _ := json.Marshal(entries)
+		req, _ := http.NewRequest(http.MethodPut, "/preferences", bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		if companyId != "" {
+			req.Header.Set("companyid", companyId)
+		}
+		return executeRequest(req, authHandler.Authenticate(preferenceHandler.SetPreferences)).Code
+	}
+
+	layout := json.RawMessage(`{"columns":["date","amount"]}`)
+	if code := set("", []PreferenceEntry{{Namespace: "reports", Key: "layout", Value: layout}}); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	// Writing again as if the key were new is a stale write
+	if code := set("", []PreferenceEntry{{Namespace: "reports", Key: "layout", Value: layout}}); code != http.StatusConflict {
+		t.Fatalf("Expected status code %d, got %d", http.StatusConflict, code)
+	}
+	if code := set("default", []PreferenceEntry{{Namespace: "reports", Key: "layout", Value: json.RawMessage(`{"columns":["date"]}`)}}); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/preferences?namespace=reports", nil)
+	req.Header.Set("token", token.Value.String())
+	req.Header.Set("companyid", "default")
+	rr := executeRequest(req, authHandler.Authenticate(preferenceHandler.GetPreferences))
+
+	var entries []PreferenceEntry
+	json.Unmarshal(rr.Body.Bytes(), &entries)
+	if len(entries) != 1 || entries[0].TenantId != 1 || string(entries[0].Value) != `{"columns":["date"]}` {
+		t.Errorf("Expected the company value to override the user-wide one, got %+v", entries)
+	}
+}
@@ -0,0 +1,120 @@
+package v1
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/internal/users"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// writeDataExport streams the personal data archive of a user as a zip download
+func (h *UserHandler) writeDataExport(w http.ResponseWriter, userID uint64) {
+	if _, err := h.UserRepo.GetByField("id", userID); err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"user-%d-data.zip\"", userID))
+	w.WriteHeader(http.StatusOK)
+	if err := users.WritePersonalData(h.db, userID, w); err != nil {
+		log.Printf("[!] Data export for user %d aborted: %v\n", userID, err)
+	}
+}
+
+// ExportOwnData returns an archive of everything held about the authenticated user
+func (h *UserHandler) ExportOwnData(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	h.writeDataExport(w, userID)
+}
+
+// ExportUserData returns an archive of everything held about the user in ?id=
+func (h *UserHandler) ExportUserData(w http.ResponseWriter, r *http.Request) {
+	userID, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	h.writeDataExport(w, userID)
+}
+
+// checkRemovable reports whether the user may be erased or deleted, refusing the actor themselves, system users,
+// users already erased and the only owner of a company
+func (h *UserHandler) checkRemovable(w http.ResponseWriter, actorID, userID uint64) bool {
+	if userID == actorID {
+		util.HandleError(w, http.StatusBadRequest, "Users cannot erase themselves")
+		return false
+	}
+	target, err := h.UserRepo.GetByField("id", userID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return false
+	}
+	if target.Type == models.UserTypeSystem {
+		util.HandleError(w, http.StatusForbidden, "System users cannot be erased")
+		return false
+	}
+	if existing, err := h.ErasureRepo.GetAllByCondition("user_id = ?", userID); err == nil && len(existing) > 0 {
+		util.HandleError(w, http.StatusConflict, "User has already been erased")
+		return false
+	}
+	if owned, err := tenants.SoleOwnerOf(h.db, userID); err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error checking company ownership")
+		return false
+	} else if len(owned) > 0 {
+		util.HandleError(w, http.StatusConflict, "User is the only owner of a company; transfer ownership first")
+		return false
+	}
+	return true
+}
+
+// EraseUser anonymises the personal data of the user in ?id= and records the erasure.
+// Subscriptions and their history are kept for billing. System users cannot be erased.
+func (h *UserHandler) EraseUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	body, err := util.ParseJSONBody[struct {
+		Reason string `json:"reason"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+
+	if !h.checkRemovable(w, actorID, userID) {
+		return
+	}
+
+	var erasure *models.UserErasure
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		erasure, err = users.Erase(tx, userID, actorID, strings.TrimSpace(body.Reason), time.Now())
+		return err
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error erasing user")
+		return
+	}
+
+	util.RespondJSON(w, http.StatusOK, erasure)
+}
@@ -0,0 +1,151 @@
+package v1
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/users"
+)
+
+// TestPersonalData checks that the data export archive has a section per kind of record, and that
+// erasure anonymises the user, removes their access, grants, scheduled reactivation and pending offers,
+// keeps billing and refuses system users
+func TestPersonalData(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	userHandler := NewUserHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	db.Create(tenant)
+	user, token := createTestUser(t, db, "user@example.com", "9000000001", models.UserTypeClient)
+	ops, _ := createTestUser(t, db, "ops@example.com", "9000000002", models.UserTypeSystem)
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000003", models.UserTypeSystem)
+	_, clientToken := createTestUser(t, db, "client@example.com", "9000000004", models.UserTypeClient)
+	feature := &models.Feature{Name: "Sales report", Permission: "sales.report"}
+	db.Create(feature)
+	pro := &models.Subscription{Name: "Pro", Code: "pro"}
+	db.Create(pro)
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: tenant.ID, Role: models.RoleMember})
+	db.Create(&models.UserFeatureMapping{UserId: user.ID, TenantId: tenant.ID, FeatureId: feature.ID})
+	db.Create(&models.UserWildcardGrant{UserId: user.ID, TenantId: tenant.ID, Pattern: "sales.*"})
+	db.Create(&models.UserPreference{UserId: user.ID, Namespace: "ui", Key: "theme", Value: `{"mode":"dark"}`})
+	db.Create(&models.UserSubscriptionMapping{UserId: user.ID, SubscriptionId: pro.ID})
+	db.Create(&models.LoginAttempt{UserId: &user.ID, Credential: "user@example.com", CredentialType: "email", IP: "203.0.113.7", Success: true, Reason: "ok"})
+
+	req, _ := http.NewRequest(http.MethodGet, "/profile/data-export", nil)
+	req.Header.Set("token", token.Value.String())
+	rr := executeRequest(req, authHandler.Authenticate(userHandler.ExportOwnData))
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if rr.Code != http.StatusOK || err != nil {
+		t.Fatalf("Expected a zip archive, got %d %v", rr.Code, err)
+	}
+	sections := map[string]string{}
+	for _, file := range archive.File {
+		reader, _ := file.Open()
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		sections[file.Name] = string(content)
+	}
+	for _, name := range []string{"user.json", "tenants.json", "tenant_mappings.json", "features.json", "feature_wildcards.json",
+		"subscriptions.json", "subscription_history.json", "sessions.json", "login_history.json", "preferences.json"} {
+		if _, ok := sections[name]; !ok {
+			t.Errorf("Expected %s in the archive, got %d sections", name, len(sections))
+		}
+	}
+	if !strings.Contains(sections["user.json"], "user@example.com") || !strings.Contains(sections["features.json"], "sales.report") {
+		t.Errorf("Expected the user's record and features, got %s and %s", sections["user.json"], sections["features.json"])
+	}
+	if strings.Contains(sections["sessions.json"], token.Value.String()) {
+		t.Error("Expected sessions without the token values")
+	}
+	var preferences []struct {
+		Key   string            `json:"key"`
+		Value map[string]string `json:"value"`
+	}
+	json.Unmarshal([]byte(sections["preferences.json"]), &preferences)
+	if len(preferences) != 1 || preferences[0].Key != "theme" || preferences[0].Value["mode"] != "dark" {
+		t.Errorf("Expected the preference with its value, got %s", sections["preferences.json"])
+	}
+
+	// A suspension due to lift and offers addressed to the user's contact details
+	now := time.Now()
+	reactivateAt := now.Add(time.Hour)
+	db.Create(&models.UserSuspension{UserId: user.ID, Reason: "Unpaid invoice", SuspendedBy: ops.ID, SuspendedAt: now, ReactivateAt: &reactivateAt})
+	db.Model(user).Update("is_active", false)
+	invitation := &models.TenantInvitation{TenantId: tenant.ID, Email: "User@example.com", Role: models.RoleMember,
+		InvitedBy: ops.ID, Status: models.InvitationPending, ExpiresAt: now.Add(time.Hour)}
+	db.Create(invitation)
+	transfer := &models.OwnershipTransfer{TenantId: tenant.ID, FromUserId: ops.ID, Channel: "mobile", Recipient: user.MobileNumber,
+		Status: models.TransferPending, CodeHash: "hash", Salt: "salt", ExpiresAt: now.Add(time.Hour)}
+	db.Create(transfer)
+
+	erase := func(token *models.Token, userID uint64) int {
+		body, _ := json.Marshal(map[string]string{"reason": "Customer request"})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/user/erase?id=%d", userID), bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.RequireSystemUser(userHandler.EraseUser)).Code
+	}
+	if code := erase(clientToken, user.ID); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a client, got %d", http.StatusForbidden, code)
+	}
+	if code := erase(adminToken, ops.ID); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d erasing a system user, got %d", http.StatusForbidden, code)
+	}
+	if code := erase(adminToken, user.ID); code != http.StatusOK {
+		t.Fatalf("Expected status code %d erasing the user, got %d", http.StatusOK, code)
+	}
+
+	var erased models.User
+	db.First(&erased, user.ID)
+	if erased.Email != fmt.Sprintf("erased-%d@erased.invalid", user.ID) || erased.Name != "Erased User" || erased.IsActive {
+		t.Errorf("Expected the user row to be anonymised, got %+v", erased)
+	}
+	for _, model := range []interface{}{&models.UserTenantMapping{}, &models.Token{}, &models.UserFeatureMapping{},
+		&models.UserWildcardGrant{}, &models.UserPreference{}} {
+		var count int64
+		db.Model(model).Where("user_id = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("Expected no %T rows left, found %d", model, count)
+		}
+	}
+	var attempt models.LoginAttempt
+	db.Where("user_id = ?", user.ID).First(&attempt)
+	if attempt.Credential != "" || attempt.IP != "" {
+		t.Errorf("Expected the login history to be stripped, got %+v", attempt)
+	}
+	var kept, erasures int64
+	db.Model(&models.UserSubscriptionMapping{}).Where("user_id = ?", user.ID).Count(&kept)
+	db.Model(&models.UserErasure{}).Where("user_id = ?", user.ID).Count(&erasures)
+	if kept != 1 || erasures != 1 {
+		t.Errorf("Expected the subscription kept and the erasure recorded, got %d and %d", kept, erasures)
+	}
+	if code := erase(adminToken, user.ID); code != http.StatusConflict {
+		t.Errorf("Expected status code %d erasing twice, got %d", http.StatusConflict, code)
+	}
+
+	// Nothing brings the anonymised account back or keeps its contact details
+	if count, err := users.ReactivateDue(db, now.Add(2*time.Hour)); err != nil || count != 0 {
+		t.Errorf("Expected no scheduled reactivation of an erased user, got %d (%v)", count, err)
+	}
+	req, _ = http.NewRequest(http.MethodPost, fmt.Sprintf("/user/reactivate?id=%d", user.ID), nil)
+	req.Header.Set("token", adminToken.Value.String())
+	if rr := executeRequest(req, authHandler.RequireSystemUser(userHandler.ReactivateUser)); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d reactivating an erased user, got %d", http.StatusConflict, rr.Code)
+	}
+	db.First(invitation, invitation.ID)
+	if invitation.Status != models.InvitationRevoked || invitation.Email != erased.Email {
+		t.Errorf("Expected the invitation revoked without the email, got %+v", invitation)
+	}
+	db.First(transfer, transfer.ID)
+	if transfer.Status != models.TransferCancelled || transfer.Recipient != "" {
+		t.Errorf("Expected the transfer cancelled without the mobile number, got %+v", transfer)
+	}
+}
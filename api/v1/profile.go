@@ -0,0 +1,280 @@
+package v1
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/notify"
+	"sg-portal/internal/users"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+const (
+	contactCodeLength      = 6
+	contactCodeTTL         = 15 * time.Minute
+	contactCodeMaxAttempts = 5
+)
+
+// contactConflict returns the reason code when the email or mobile number already belongs to
+// another user, or "" when both are free. Empty values are not checked; countryID is the
+// country the mobile number was normalised with.
+func contactConflict(userRepo *util.Repository[models.User], email, mobile string, countryID *int, excludeID uint64) string {
+	if email != "" {
+		if existing, err := userRepo.GetAllByCondition("email = ? AND id <> ?", email, excludeID); err == nil && len(existing) > 0 {
+			return models.CodeEmailInUse
+		}
+	}
+	if mobile != "" {
+		candidates := users.MobileLookupCandidates(util.Db, countryID, mobile)
+		if existing, err := userRepo.GetAllByCondition("mobile_number IN ? AND id <> ?", candidates, excludeID); err == nil && len(existing) > 0 {
+			return models.CodeMobileInUse
+		}
+	}
+	return ""
+}
+
+// respondConflict reports a uniqueness clash on a user's contact details
+func respondConflict(w http.ResponseWriter, code string) {
+	message := "Email or mobile number is already registered"
+	switch code {
+	case models.CodeEmailInUse:
+		message = "Email is already registered"
+	case models.CodeMobileInUse:
+		message = "Mobile number is already registered"
+	}
+	util.RespondJSON(w, http.StatusConflict, &models.GenericResponseMessage{
+		Message: message,
+		Result:  false,
+		Code:    code,
+	})
+}
+
+// UpdateUserProfile lets the authenticated user change their own name and country.
+// Email and mobile changes go through RequestContactChange instead.
+func (h *UserHandler) UpdateUserProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	profile, err := util.ParseJSONBody[struct {
+		Name      *string `json:"name"`
+		CountryID *int    `json:"country_id"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+
+	updates := map[string]interface{}{}
+	if profile.Name != nil {
+		name := strings.TrimSpace(*profile.Name)
+		if name == "" {
+			util.HandleError(w, http.StatusBadRequest, "Name cannot be empty")
+			return
+		}
+		updates["name"] = name
+	}
+	if profile.CountryID != nil {
+		if _, err := users.CountryFor(h.db, profile.CountryID); err != nil {
+			util.HandleError(w, http.StatusBadRequest, "Invalid country")
+			return
+		}
+		updates["country_id"] = *profile.CountryID
+	}
+	if len(updates) == 0 {
+		util.HandleError(w, http.StatusBadRequest, "No updates provided")
+		return
+	}
+
+	if err := h.UserRepo.UpdateOne("id", userID, updates); err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating profile")
+		return
+	}
+
+	user, err := h.UserRepo.GetByField("id", userID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, user)
+}
+
+// RequestContactChange starts an email or mobile change for the authenticated user.
+// A confirmation code goes to the new contact and a notice to the current one.
+func (h *UserHandler) RequestContactChange(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	change, err := util.ParseJSONBody[struct {
+		Channel string `json:"channel"`
+		Value   string `json:"value"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	value := strings.TrimSpace(change.Value)
+
+	user, err := h.UserRepo.GetByField("id", userID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	var oldValue, conflict string
+	switch change.Channel {
+	case notify.ChannelEmail:
+		if !util.IsValidEmail(value) {
+			util.HandleError(w, http.StatusBadRequest, "Invalid email")
+			return
+		}
+		oldValue = user.Email
+		conflict = contactConflict(h.UserRepo, value, "", nil, userID)
+	case notify.ChannelMobile:
+		value, err = users.NormalizeMobile(h.db, user.CountryID, value)
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, "Invalid mobile number: "+err.Error())
+			return
+		}
+		oldValue = user.MobileNumber
+		conflict = contactConflict(h.UserRepo, "", value, user.CountryID, userID)
+	default:
+		util.HandleError(w, http.StatusBadRequest, "channel must be email or mobile")
+		return
+	}
+	if value == oldValue {
+		util.HandleError(w, http.StatusBadRequest, "New value matches the current one")
+		return
+	}
+	if conflict != "" {
+		respondConflict(w, conflict)
+		return
+	}
+
+	code, err := util.GenerateNumericCode(contactCodeLength)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error generating confirmation code")
+		return
+	}
+	salt, err := models.GenerateSalt()
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error generating salt")
+		return
+	}
+	codeHash, err := models.HashPassword(code, salt)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error hashing confirmation code")
+		return
+	}
+
+	request := &models.ContactChangeRequest{
+		UserId:    userID,
+		Channel:   change.Channel,
+		NewValue:  value,
+		CodeHash:  codeHash,
+		Salt:      salt,
+		ExpiresAt: time.Now().Add(contactCodeTTL),
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Only the latest request per channel can be confirmed
+		if err := util.NewRepository[models.ContactChangeRequest](tx).
+			Delete("user_id = ? AND channel = ? AND confirmed_at IS NULL", userID, change.Channel); err != nil {
+			return err
+		}
+		return util.NewRepository[models.ContactChangeRequest](tx).Create(request)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error creating contact change request")
+		return
+	}
+
+	if err := notify.Send(change.Channel, value, "Confirm your new contact details",
+		"Your confirmation code is "+code+". It expires in 15 minutes."); err != nil {
+		util.HandleError(w, http.StatusBadGateway, "Could not deliver the confirmation code")
+		return
+	}
+	notify.Send(change.Channel, oldValue, "Contact change requested",
+		"A change of your "+change.Channel+" to "+value+" was requested. Contact support if this was not you.")
+
+	util.RespondJSON(w, http.StatusAccepted, request)
+}
+
+// ConfirmContactChange applies a pending email or mobile change once the code sent to the new contact is provided
+func (h *UserHandler) ConfirmContactChange(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	confirmation, err := util.ParseJSONBody[struct {
+		ID   uint64 `json:"id"`
+		Code string `json:"code"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+
+	pending, err := h.ContactChangeRepo.GetAllByCondition("id = ? AND user_id = ? AND confirmed_at IS NULL", confirmation.ID, userID)
+	if err != nil || len(pending) < 1 {
+		util.HandleError(w, http.StatusNotFound, "No pending contact change found")
+		return
+	}
+	request := pending[0]
+
+	// Each guess claims one of the attempts before the code is checked, so parallel guesses share the limit
+	claimed := h.db.Model(&models.ContactChangeRequest{}).
+		Where("id = ? AND attempts < ? AND expires_at > ?", request.ID, contactCodeMaxAttempts, time.Now()).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if claimed.Error != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error confirming contact change")
+		return
+	}
+	if claimed.RowsAffected != 1 {
+		util.HandleError(w, http.StatusGone, "Confirmation code expired, request a new one")
+		return
+	}
+	if err := models.ValidatePassword(confirmation.Code, request.Salt, request.CodeHash); err != nil {
+		util.HandleError(w, http.StatusUnauthorized, "Invalid confirmation code")
+		return
+	}
+
+	column := "email"
+	if request.Channel == notify.ChannelMobile {
+		column = "mobile_number"
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := util.NewRepository[models.User](tx).UpdateOne("id", userID, map[string]interface{}{column: request.NewValue}); err != nil {
+			return err
+		}
+		return util.NewRepository[models.ContactChangeRequest](tx).UpdateOne("id", request.ID, map[string]interface{}{"confirmed_at": time.Now()})
+	})
+	if util.IsDuplicateKeyError(err) {
+		// Someone else claimed the value between the request and its confirmation
+		if column == "email" {
+			respondConflict(w, models.CodeEmailInUse)
+		} else {
+			respondConflict(w, models.CodeMobileInUse)
+		}
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating contact details")
+		return
+	}
+
+	user, err := h.UserRepo.GetByField("id", userID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, user)
+}
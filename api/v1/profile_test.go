@@ -0,0 +1,142 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/notify"
+)
+
+// TestProfileUpdate checks that users change their own name and country but not to invalid values
+func TestProfileUpdate(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	userHandler := NewUserHandler(db)
+	user, token := createTestUser(t, db, "user@example.com", "9000000001", models.UserTypeClient)
+
+	update := func(body map[string]interface{}) (int, models.User) {
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPut, "/profile", bytes.NewBuffer(payload))
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(userHandler.UpdateUserProfile))
+		var updated models.User
+		json.Unmarshal(rr.Body.Bytes(), &updated)
+		return rr.Code, updated
+	}
+
+	var country models.Country
+	db.Where("iso_code = ?", "IN").First(&country)
+	if code, updated := update(map[string]interface{}{"name": "  Jane Doe ", "country_id": country.ID}); code != http.StatusOK || updated.Name != "Jane Doe" {
+		t.Fatalf("Expected the name to be updated, got %d %+v", code, updated)
+	}
+	for _, body := range []map[string]interface{}{{"name": " "}, {"country_id": 99999}, {}} {
+		if code, _ := update(body); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %v, got %d", http.StatusBadRequest, body, code)
+		}
+	}
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if reloaded.Name != "Jane Doe" || reloaded.Email != "user@example.com" {
+		t.Errorf("Expected only the valid update to be applied, got %+v", reloaded)
+	}
+}
+
+// TestContactChange checks that a new email or mobile number only replaces the current one once the
+// code sent to it is confirmed, and that taken values, wrong codes and expired requests are refused
+func TestContactChange(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	userHandler := NewUserHandler(db)
+	sender := &recordingSender{}
+	notify.Default = sender
+	defer func() { notify.Default = notify.LogSender{} }()
+
+	user, token := createTestUser(t, db, "user@example.com", "9000000001", models.UserTypeClient)
+	createTestUser(t, db, "taken@example.com", "9000000002", models.UserTypeClient)
+
+	request := func(channel, value string) (int, models.ContactChangeRequest, models.GenericResponseMessage) {
+		payload, _ := json.Marshal(map[string]string{"channel": channel, "value": value})
+		req, _ := http.NewRequest(http.MethodPost, "/profile/contact", bytes.NewBuffer(payload))
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(userHandler.RequestContactChange))
+		var change models.ContactChangeRequest
+		var message models.GenericResponseMessage
+		json.Unmarshal(rr.Body.Bytes(), &change)
+		json.Unmarshal(rr.Body.Bytes(), &message)
+		return rr.Code, change, message
+	}
+	confirm := func(id uint64, code string) int {
+		payload, _ := json.Marshal(map[string]interface{}{"id": id, "code": code})
+		req, _ := http.NewRequest(http.MethodPost, "/profile/contact/confirm", bytes.NewBuffer(payload))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.Authenticate(userHandler.ConfirmContactChange)).Code
+	}
+	codeSentTo := func(to string) string {
+		match := regexp.MustCompile(`code is (\d+)`).FindStringSubmatch(sender.sent[to])
+		if match == nil {
+			t.Fatalf("Expected a confirmation code sent to %s, got %q", to, sender.sent[to])
+		}
+		return match[1]
+	}
+
+	// Values held by another user are refused before any code is sent
+	if code, _, message := request(notify.ChannelEmail, "taken@example.com"); code != http.StatusConflict || message.Code != models.CodeEmailInUse {
+		t.Errorf("Expected %s for a taken email, got %d %+v", models.CodeEmailInUse, code, message)
+	}
+	if code, _, message := request(notify.ChannelMobile, "+91 90000 00002"); code != http.StatusConflict || message.Code != models.CodeMobileInUse {
+		t.Errorf("Expected %s for a taken mobile number, got %d %+v", models.CodeMobileInUse, code, message)
+	}
+
+	code, change, _ := request(notify.ChannelEmail, "new@example.com")
+	if code != http.StatusAccepted || change.NewValue != "new@example.com" {
+		t.Fatalf("Expected a pending email change, got %d %+v", code, change)
+	}
+	if _, ok := sender.sent["user@example.com"]; !ok {
+		t.Error("Expected a notice sent to the current email")
+	}
+	if code := confirm(change.ID, "000000x"); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for a wrong code, got %d", http.StatusUnauthorized, code)
+	}
+	if code := confirm(change.ID, codeSentTo("new@example.com")); code != http.StatusOK {
+		t.Fatalf("Expected status code %d confirming the change, got %d", http.StatusOK, code)
+	}
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if reloaded.Email != "new@example.com" {
+		t.Errorf("Expected the email to be changed, got %s", reloaded.Email)
+	}
+	if code := confirm(change.ID, codeSentTo("new@example.com")); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d confirming twice, got %d", http.StatusNotFound, code)
+	}
+
+	// An expired request cannot be confirmed even with the right code
+	code, change, _ = request(notify.ChannelMobile, "9000000003")
+	if code != http.StatusAccepted || change.NewValue != "+919000000003" {
+		t.Fatalf("Expected a pending mobile change in E.164 form, got %d %+v", code, change)
+	}
+	db.Model(&models.ContactChangeRequest{}).Where("id = ?", change.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	if code := confirm(change.ID, codeSentTo("+919000000003")); code != http.StatusGone {
+		t.Errorf("Expected status code %d for an expired code, got %d", http.StatusGone, code)
+	}
+	db.First(&reloaded, user.ID)
+	if reloaded.MobileNumber != "9000000001" {
+		t.Errorf("Expected the mobile number to be unchanged, got %s", reloaded.MobileNumber)
+	}
+
+	// Once the attempts are used up the right code is refused too
+	code, change, _ = request(notify.ChannelEmail, "other@example.com")
+	if code != http.StatusAccepted {
+		t.Fatalf("Expected a pending email change, got %d", code)
+	}
+	for i := 0; i < contactCodeMaxAttempts; i++ {
+		confirm(change.ID, "000000x")
+	}
+	if code := confirm(change.ID, codeSentTo("other@example.com")); code != http.StatusGone {
+		t.Errorf("Expected status code %d after too many attempts, got %d", http.StatusGone, code)
+	}
+}
@@ -0,0 +1,155 @@
+package v1
+
+import (
+	"net/http"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// GetSeats returns how many of a tenant's seats are used, pending and available (?tenantId=).
+// Members of the company and system users can read it.
+func (h *TenantHandler) GetSeats(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType != models.UserTypeSystem {
+		if _, err := tenants.ActiveMembership(h.db, actorID, tenantId); err != nil {
+			util.HandleError(w, http.StatusForbidden, "Only members of the company can see its seats")
+			return
+		}
+	}
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	usage, err := tenants.Seats(h.db, tenant)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error counting seats")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, usage)
+}
+
+// SetSeatLimit overrides the seats a tenant's plans give it (?tenantId=, body {"limit": 10}); 0 makes
+// them unlimited and null goes back to the plans. Lowering the limit below the seats in use removes
+// nobody, it only stops new members until enough seats are freed.
+func (h *TenantHandler) SetSeatLimit(w http.ResponseWriter, r *http.Request) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		Limit *int `json:"limit"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	if body.Limit != nil && *body.Limit < 0 {
+		util.HandleError(w, http.StatusBadRequest, "The seat limit cannot be negative")
+		return
+	}
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+
+	var limit interface{} = gorm.Expr("NULL")
+	if body.Limit != nil {
+		limit = *body.Limit
+	}
+	if err := h.db.Model(&models.Tenant{}).Where("id = ?", tenantId).Update("seat_limit", limit).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating seat limit")
+		return
+	}
+	tenant.SeatLimit = body.Limit
+	usage, err := tenants.Seats(h.db, tenant)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error counting seats")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, usage)
+}
+
+// DeactivateSeat frees the seat of a member without removing them (?tenantId=&userId=). The user
+// keeps their role and feature grants but cannot use the company until the seat is activated again.
+// Owners cannot be deactivated.
+func (h *TenantHandler) DeactivateSeat(w http.ResponseWriter, r *http.Request) {
+	h.setSeatActive(w, r, false)
+}
+
+// ActivateSeat gives a deactivated member their seat back, provided one is free (?tenantId=&userId=).
+// Another manager must do it; nobody can reactivate their own seat.
+func (h *TenantHandler) ActivateSeat(w http.ResponseWriter, r *http.Request) {
+	h.setSeatActive(w, r, true)
+}
+
+func (h *TenantHandler) setSeatActive(w http.ResponseWriter, r *http.Request, active bool) {
+	userId, err := util.ParseUintParam(r, "userId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	member, err := tenants.Membership(h.db, userId, tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User is not a member of the tenant")
+		return
+	}
+	if !h.authorizeMemberChange(w, r, tenantId, member.Role) {
+		return
+	}
+	if actorID, _ := util.UserIDFromContext(r.Context()); active && actorID == userId {
+		util.HandleError(w, http.StatusForbidden, "Members cannot reactivate their own seat")
+		return
+	}
+	if !active && member.Role == models.RoleOwner {
+		util.HandleError(w, http.StatusConflict, "Owners cannot be deactivated")
+		return
+	}
+	if active == (member.DeactivatedAt == nil) {
+		util.RespondJSON(w, http.StatusOK, member)
+		return
+	}
+
+	var deactivatedAt *time.Time
+	if !active {
+		now := time.Now()
+		deactivatedAt = &now
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if active {
+			if err := tenants.ClaimSeats(tx, tenantId, 1); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.UserTenantMapping{}).Where("id = ?", member.ID).Update("deactivated_at", deactivatedAt).Error
+	})
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating seat")
+		return
+	}
+	member.DeactivatedAt = deactivatedAt
+	util.RespondJSON(w, http.StatusOK, member)
+}
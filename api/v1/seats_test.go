@@ -0,0 +1,232 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/notify"
+	"sg-portal/internal/tenants"
+)
+
+// TestSeatLimits checks that the owners' plan caps the members and pending invitations of a company,
+// that deactivated members free their seat while staying mapped, and that the override wins
+func TestSeatLimits(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	tenantHandler := NewTenantHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", State: models.TenantActive}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	member, memberToken := createTestUser(t, db, "member@example.com", "9000000002", models.UserTypeClient)
+	extra, _ := createTestUser(t, db, "extra@example.com", "9000000003", models.UserTypeClient)
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000004", models.UserTypeSystem)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	team := &models.Subscription{Name: "Team", Code: "team", Seats: 3}
+	db.Create(team)
+	db.Create(&models.UserSubscriptionMapping{UserId: owner.ID, SubscriptionId: team.ID})
+
+	call := func(token *models.Token, method, url string, body interface{}, handler http.HandlerFunc) (int, []byte) {
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(payload))
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(handler))
+		return rr.Code, rr.Body.Bytes()
+	}
+	mapUser := func(userID uint64) (int, models.GenericResponseMessage) {
+		code, body := call(ownerToken, http.MethodPost, "/tenants/map", models.UserTenantMapping{UserId: userID, TenantId: tenant.ID}, tenantHandler.MapUserToTenant)
+		var message models.GenericResponseMessage
+		json.Unmarshal(body, &message)
+		return code, message
+	}
+	seats := func() tenants.SeatUsage {
+		_, body := call(ownerToken, http.MethodGet, fmt.Sprintf("/tenants/seats?tenantId=%d", tenant.ID), nil, tenantHandler.GetSeats)
+		var usage tenants.SeatUsage
+		json.Unmarshal(body, &usage)
+		return usage
+	}
+	seatURL := func(action string, userID uint64) string {
+		return fmt.Sprintf("/tenants/seats/%s?tenantId=%d&userId=%d", action, tenant.ID, userID)
+	}
+
+	if code, _ := mapUser(member.ID); code != http.StatusCreated {
+		t.Fatalf("Expected status code %d mapping the second seat, got %d", http.StatusCreated, code)
+	}
+	sender := &recordingSender{}
+	notify.Default = sender
+	defer func() { notify.Default = notify.LogSender{} }()
+	code, body := call(ownerToken, http.MethodPost, fmt.Sprintf("/tenants/invitations?tenantId=%d", tenant.ID),
+		map[string]string{"email": "invitee@example.com"}, tenantHandler.CreateInvitation)
+	var invitation models.TenantInvitation
+	json.Unmarshal(body, &invitation)
+	if code != http.StatusCreated || invitation.Status != models.InvitationPending {
+		t.Fatalf("Expected a pending invitation holding the third seat, got %d %s", code, body)
+	}
+	if code, message := mapUser(extra.ID); code != http.StatusConflict || message.Code != models.CodeNoSeats {
+		t.Errorf("Expected %s with every seat taken, got %d %+v", models.CodeNoSeats, code, message)
+	}
+	if usage := seats(); usage.Limit != 3 || usage.Source != tenants.SeatsPlan || usage.Used != 2 || usage.Pending != 1 || *usage.Available != 0 {
+		t.Errorf("Unexpected seat usage %+v", usage)
+	}
+
+	// A deactivated member frees their seat and is refused at the company, but stays mapped
+	if code, _ := call(ownerToken, http.MethodPost, seatURL("deactivate", owner.ID), nil, tenantHandler.DeactivateSeat); code != http.StatusConflict {
+		t.Errorf("Expected status code %d deactivating an owner, got %d", http.StatusConflict, code)
+	}
+	if code, _ := call(ownerToken, http.MethodPost, seatURL("deactivate", member.ID), nil, tenantHandler.DeactivateSeat); code != http.StatusOK {
+		t.Fatalf("Expected status code %d deactivating a member, got %d", http.StatusOK, code)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "/token/validate", nil)
+	req.Header.Set("token", memberToken.Value.String())
+	req.Header.Set("companyid", tenant.CompanyGuid)
+	rr := executeRequest(req, authHandler.ResolveTenant)
+	var info models.TokenTenantInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if rr.Code != http.StatusForbidden || info.Code != models.CodeSeatInactive {
+		t.Errorf("Expected %s for a deactivated seat, got %d %+v", models.CodeSeatInactive, rr.Code, info)
+	}
+	if _, err := tenants.Membership(db, member.ID, tenant.ID); err != nil {
+		t.Errorf("Expected a deactivated member to stay mapped, got %v", err)
+	}
+
+	// Even as an admin, a deactivated member cannot manage the company or take their seat back
+	db.Model(&models.UserTenantMapping{}).Where("user_id = ? AND tenant_id = ?", member.ID, tenant.ID).Update("role", models.RoleAdmin)
+	if code, _ := call(memberToken, http.MethodGet, fmt.Sprintf("/tenants/seats?tenantId=%d", tenant.ID), nil, tenantHandler.GetSeats); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d reading seats with a deactivated seat, got %d", http.StatusForbidden, code)
+	}
+	if code, _ := call(memberToken, http.MethodPost, seatURL("activate", member.ID), nil, tenantHandler.ActivateSeat); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d reactivating one's own seat, got %d", http.StatusForbidden, code)
+	}
+	if code, _ := call(memberToken, http.MethodPost, fmt.Sprintf("/tenants/invitations?tenantId=%d", tenant.ID),
+		map[string]string{"email": "other@example.com"}, tenantHandler.CreateInvitation); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d inviting with a deactivated seat, got %d", http.StatusForbidden, code)
+	}
+
+	// The invitee takes the seat their invitation held, leaving none for the deactivated member
+	invitee, inviteeToken := createTestUser(t, db, "Invitee@example.com", "9000000005", models.UserTypeClient)
+	acceptURL := fmt.Sprintf("/tenants/invitations/accept?id=%d", invitation.ID)
+	invitationCode := regexp.MustCompile(`code (\d+)`).FindStringSubmatch(sender.sent["invitee@example.com"])[1]
+	if code, _ := call(ownerToken, http.MethodPost, acceptURL, map[string]string{"code": invitationCode}, tenantHandler.AcceptInvitation); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d accepting someone else's invitation, got %d", http.StatusForbidden, code)
+	}
+	// Registering with the invited email is not enough without the code sent to it
+	if code, _ := call(inviteeToken, http.MethodPost, acceptURL, map[string]string{"code": "00000000"}, tenantHandler.AcceptInvitation); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for a wrong code, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := call(inviteeToken, http.MethodPost, acceptURL, map[string]string{"code": invitationCode}, tenantHandler.AcceptInvitation); code != http.StatusOK {
+		t.Fatalf("Expected status code %d accepting the invitation, got %d", http.StatusOK, code)
+	}
+	if _, err := tenants.Membership(db, invitee.ID, tenant.ID); err != nil {
+		t.Errorf("Expected the invitee to become a member, got %v", err)
+	}
+	if usage := seats(); usage.Used != 2 || usage.Pending != 0 || usage.Deactivated != 1 {
+		t.Errorf("Unexpected seat usage after accepting %+v", usage)
+	}
+	if code, _ := call(ownerToken, http.MethodPost, seatURL("activate", member.ID), nil, tenantHandler.ActivateSeat); code != http.StatusOK {
+		t.Errorf("Expected status code %d activating into the last seat, got %d", http.StatusOK, code)
+	}
+
+	// The tenant's own limit overrides the plan
+	payload, _ := json.Marshal(map[string]int{"limit": 4})
+	req, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/tenants/seats?tenantId=%d", tenant.ID), bytes.NewBuffer(payload))
+	req.Header.Set("token", adminToken.Value.String())
+	if rr := executeRequest(req, authHandler.RequireSystemUser(tenantHandler.SetSeatLimit)); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d setting the seat limit, got %d", http.StatusOK, rr.Code)
+	}
+	if code, _ := mapUser(extra.ID); code != http.StatusCreated {
+		t.Errorf("Expected status code %d within the override, got %d", http.StatusCreated, code)
+	}
+	if usage := seats(); usage.Limit != 4 || usage.Source != tenants.SeatsOverride || *usage.Available != 0 {
+		t.Errorf("Unexpected seat usage with the override %+v", usage)
+	}
+}
+
+// failingSender refuses every notification
+type failingSender struct{}
+
+func (failingSender) Send(channel, to, subject, body string) error {
+	return errors.New("mail server unavailable")
+}
+
+// TestUndeliveredInvitation checks that an invitation that cannot be delivered is revoked and frees its seat
+func TestUndeliveredInvitation(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	tenantHandler := NewTenantHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", State: models.TenantActive}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	pair := &models.Subscription{Name: "Pair", Code: "pair", Seats: 2}
+	db.Create(pair)
+	db.Create(&models.UserSubscriptionMapping{UserId: owner.ID, SubscriptionId: pair.ID})
+
+	invite := func() int {
+		payload, _ := json.Marshal(map[string]string{"email": "invitee@example.com"})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/tenants/invitations?tenantId=%d", tenant.ID), bytes.NewBuffer(payload))
+		req.Header.Set("token", ownerToken.Value.String())
+		return executeRequest(req, authHandler.Authenticate(tenantHandler.CreateInvitation)).Code
+	}
+
+	notify.Default = failingSender{}
+	defer func() { notify.Default = notify.LogSender{} }()
+	if code := invite(); code != http.StatusBadGateway {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadGateway, code)
+	}
+	var invitation models.TenantInvitation
+	db.Where("tenant_id = ?", tenant.ID).First(&invitation)
+	if invitation.Status != models.InvitationRevoked || invitation.RevokedAt == nil {
+		t.Errorf("Expected the undelivered invitation to be revoked, got %+v", invitation)
+	}
+
+	// The seat and the email are free for a new invitation
+	notify.Default = notify.LogSender{}
+	if code := invite(); code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+}
+
+// TestUpdateTenantSeatLimit checks that the seat limit and other protected fields cannot be edited
+// through the generic tenant update, which is reserved for system users
+func TestUpdateTenantSeatLimit(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	tenantHandler := NewTenantHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", State: models.TenantActive}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000002", models.UserTypeSystem)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+
+	update := func(token *models.Token, updates map[string]interface{}) int {
+		payload, _ := json.Marshal(updates)
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("/tenants/update?tenantId=%d", tenant.ID), bytes.NewBuffer(payload))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.RequireSystemUser(tenantHandler.UpdateTenant)).Code
+	}
+
+	if code := update(ownerToken, map[string]interface{}{"CompanyName": "Acme Ltd"}); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for an owner, got %d", http.StatusForbidden, code)
+	}
+	for _, updates := range []map[string]interface{}{{"SeatLimit": 0}, {"seat_limit": 0}, {"CompanyGuid": "other"}} {
+		if code := update(adminToken, updates); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %v, got %d", http.StatusBadRequest, updates, code)
+		}
+	}
+	if code := update(adminToken, map[string]interface{}{"CompanyName": "Acme Ltd"}); code != http.StatusOK {
+		t.Errorf("Expected status code %d renaming the company, got %d", http.StatusOK, code)
+	}
+	var reloaded models.Tenant
+	db.First(&reloaded, tenant.ID)
+	if reloaded.CompanyName != "Acme Ltd" || reloaded.SeatLimit != nil {
+		t.Errorf("Unexpected tenant after update %+v", reloaded)
+	}
+}
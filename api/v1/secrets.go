@@ -0,0 +1,312 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/secrets"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// secretMaxBytes caps the size of one secret value
+const secretMaxBytes = 16 * 1024
+
+type SecretHandler struct {
+	TenantRepo     *util.Repository[models.Tenant]
+	SecretRepo     *util.Repository[models.TenantSecret]
+	CredentialRepo *util.Repository[models.ServiceCredential]
+	Vault          *secrets.Vault // nil when no master key is configured
+	db             *gorm.DB
+}
+
+// NewSecretHandler initializes the SecretHandler with the repositories
+func NewSecretHandler(db *gorm.DB) *SecretHandler {
+	return &SecretHandler{
+		TenantRepo:     util.NewRepository[models.Tenant](db),
+		SecretRepo:     util.NewRepository[models.TenantSecret](db),
+		CredentialRepo: util.NewRepository[models.ServiceCredential](db),
+		db:             db,
+	}
+}
+
+// SecretRequest writes a new value of a secret
+type SecretRequest struct {
+	Name    string `json:"name"`
+	Service string `json:"service"`
+	Value   string `json:"value"`
+}
+
+// SecretValue is a decrypted secret as handed to a service
+type SecretValue struct {
+	TenantId uint64 `json:"tenant_id"`
+	Name     string `json:"name"`
+	Version  int    `json:"version"`
+	Value    string `json:"value"`
+}
+
+// ServiceCredentialRequest creates a service credential; without tenant_id it covers every tenant
+type ServiceCredentialRequest struct {
+	Name     string  `json:"name"`
+	Service  string  `json:"service"`
+	TenantId *uint64 `json:"tenant_id"`
+}
+
+// IssuedCredential is a new service credential with its key, which is only ever shown once
+type IssuedCredential struct {
+	models.ServiceCredential
+	Key string `json:"key"`
+}
+
+// requireVault answers 503 when no master key is configured and reports whether one is
+func (h *SecretHandler) requireVault(w http.ResponseWriter) bool {
+	if h.Vault == nil {
+		util.HandleError(w, http.StatusServiceUnavailable, "Secret storage is not configured")
+		return false
+	}
+	return true
+}
+
+// tenantParam loads the tenant named by ?tenantId=
+func (h *SecretHandler) tenantParam(w http.ResponseWriter, r *http.Request) (*models.Tenant, bool) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return nil, false
+	}
+	return tenant, true
+}
+
+// secretParam loads the secret named by ?tenantId=&name=
+func (h *SecretHandler) secretParam(w http.ResponseWriter, r *http.Request) (*models.TenantSecret, bool) {
+	tenant, ok := h.tenantParam(w, r)
+	if !ok {
+		return nil, false
+	}
+	found, err := h.SecretRepo.GetAllByCondition("tenant_id = ? AND name = ?", tenant.ID, r.URL.Query().Get("name"))
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching secret")
+		return nil, false
+	}
+	if len(found) == 0 {
+		util.HandleError(w, http.StatusNotFound, "Secret not found")
+		return nil, false
+	}
+	return &found[0], true
+}
+
+// GetSecrets lists a tenant's secrets without their values (?tenantId=)
+func (h *SecretHandler) GetSecrets(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := h.tenantParam(w, r)
+	if !ok {
+		return
+	}
+	stored, err := h.SecretRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenant.ID).Order("name")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching secrets")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &stored)
+}
+
+// PutSecret stores a new value of a tenant's secret, creating it on first use (?tenantId=). The
+// value is encrypted and never returned by this API.
+func (h *SecretHandler) PutSecret(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVault(w) {
+		return
+	}
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tenant, ok := h.tenantParam(w, r)
+	if !ok {
+		return
+	}
+	req, err := util.ParseJSONBody[SecretRequest](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	switch {
+	case !preferenceNamePattern.MatchString(req.Name):
+		util.HandleError(w, http.StatusBadRequest, "Secret names are 1-100 letters, digits, '.', '_' or '-'")
+		return
+	case !models.IsValidService(req.Service):
+		util.HandleError(w, http.StatusBadRequest, "Invalid service")
+		return
+	case req.Value == "" || len(req.Value) > secretMaxBytes:
+		util.HandleError(w, http.StatusBadRequest, "Secret value must be between 1 byte and 16 KiB")
+		return
+	}
+
+	var secret *models.TenantSecret
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		secret, err = secrets.Put(tx, h.Vault, tenant.ID, req.Name, req.Service, []byte(req.Value), actorID, time.Now())
+		return err
+	})
+	if errors.Is(err, secrets.ErrServiceMismatch) {
+		util.HandleError(w, http.StatusConflict, "The secret belongs to another service")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error storing secret")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, secret)
+}
+
+// GetSecretVersions returns the version history of a secret without values (?tenantId=&name=)
+func (h *SecretHandler) GetSecretVersions(w http.ResponseWriter, r *http.Request) {
+	secret, ok := h.secretParam(w, r)
+	if !ok {
+		return
+	}
+	var versions []models.TenantSecretVersion
+	if err := h.db.Where("secret_id = ?", secret.ID).Order("version DESC").Find(&versions).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching secret versions")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &versions)
+}
+
+// DeleteSecret removes a secret with its whole history (?tenantId=&name=)
+func (h *SecretHandler) DeleteSecret(w http.ResponseWriter, r *http.Request) {
+	secret, ok := h.secretParam(w, r)
+	if !ok {
+		return
+	}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return secrets.Delete(tx, secret)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error deleting secret")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetCredentials lists the service credentials without their keys
+func (h *SecretHandler) GetCredentials(w http.ResponseWriter, r *http.Request) {
+	credentials, err := h.CredentialRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching service credentials")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &credentials)
+}
+
+// CreateCredential issues a service credential and returns its key once
+func (h *SecretHandler) CreateCredential(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	req, err := util.ParseJSONBody[ServiceCredentialRequest](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || !models.IsValidService(req.Service) {
+		util.HandleError(w, http.StatusBadRequest, "A name and a valid service are required")
+		return
+	}
+	if req.TenantId != nil {
+		if _, err := h.TenantRepo.GetByField("id", *req.TenantId); err != nil {
+			util.HandleError(w, http.StatusNotFound, "Tenant not found")
+			return
+		}
+	}
+
+	issued := &IssuedCredential{ServiceCredential: models.ServiceCredential{
+		Name: req.Name, Service: req.Service, TenantId: req.TenantId, CreatedBy: actorID,
+	}}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		issued.Key, err = secrets.NewCredential(tx, &issued.ServiceCredential)
+		return err
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error creating service credential")
+		return
+	}
+	util.RespondJSON(w, http.StatusCreated, issued)
+}
+
+// RevokeCredential revokes a service credential (?id=)
+func (h *SecretHandler) RevokeCredential(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result := h.db.Model(&models.ServiceCredential{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error revoking service credential")
+		return
+	}
+	if result.RowsAffected == 0 {
+		util.HandleError(w, http.StatusNotFound, "Active service credential not found")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RevealSecret hands a secret's value to a tenant service (?tenantId=&name=&version=). The caller
+// authenticates with its service key in the X-Service-Key header and may only read secrets of its
+// service, and of its tenant when the credential is tied to one. Without ?version= the current value
+// is returned.
+func (h *SecretHandler) RevealSecret(w http.ResponseWriter, r *http.Request) {
+	if !h.requireVault(w) {
+		return
+	}
+	credential, err := secrets.Authenticate(h.db, r.Header.Get("X-Service-Key"), time.Now())
+	if errors.Is(err, secrets.ErrInvalidCredential) {
+		util.HandleError(w, http.StatusUnauthorized, "Invalid service credential")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error checking service credential")
+		return
+	}
+	version := 0
+	if raw := r.URL.Query().Get("version"); raw != "" {
+		if version, err = strconv.Atoi(raw); err != nil || version < 1 {
+			util.HandleError(w, http.StatusBadRequest, "Invalid version")
+			return
+		}
+	}
+	secret, ok := h.secretParam(w, r)
+	if !ok {
+		return
+	}
+	if !secrets.Allows(credential, secret) {
+		util.HandleError(w, http.StatusForbidden, "The credential does not cover this secret")
+		return
+	}
+
+	value, version, err := secrets.Reveal(h.db, h.Vault, secret, version)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		util.HandleError(w, http.StatusNotFound, "Secret version not found")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error decrypting secret")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &SecretValue{TenantId: secret.TenantId, Name: secret.Name, Version: version, Value: string(value)})
+}
@@ -0,0 +1,118 @@
+package v1
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/secrets"
+)
+
+// TestTenantSecrets checks that secrets are stored encrypted, never returned to portal users, and
+// handed out only to service credentials covering them, by version and across master key rotation
+func TestTenantSecrets(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	secretHandler := NewSecretHandler(db)
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+	secretHandler.Vault, _ = secrets.NewVault(masterKey)
+
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000001", models.UserTypeSystem)
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	other := &models.Tenant{CompanyGuid: "other", CompanyName: "Other"}
+	db.Create(tenant)
+	db.Create(other)
+
+	asAdmin := func(method, url string, body interface{}, handler http.HandlerFunc) *http.Response {
+		payload, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(payload))
+		req.Header.Set("token", adminToken.Value.String())
+		return executeRequest(req, authHandler.RequireSystemUser(handler)).Result()
+	}
+	put := func(name, service, value string) int {
+		body := map[string]string{"name": name, "service": service, "value": value}
+		return asAdmin(http.MethodPut, fmt.Sprintf("/tenants/secrets?tenantId=%d", tenant.ID), body, secretHandler.PutSecret).StatusCode
+	}
+	issue := func(service string, tenantID *uint64) IssuedCredential {
+		body := map[string]interface{}{"name": service + " agent", "service": service, "tenant_id": tenantID}
+		var issued IssuedCredential
+		json.NewDecoder(asAdmin(http.MethodPost, "/services/credentials", body, secretHandler.CreateCredential).Body).Decode(&issued)
+		return issued
+	}
+	reveal := func(key, query string) (int, SecretValue) {
+		req, _ := http.NewRequest(http.MethodGet, "/services/secrets?"+query, nil)
+		req.Header.Set("X-Service-Key", key)
+		rr := executeRequest(req, secretHandler.RevealSecret)
+		var value SecretValue
+		json.Unmarshal(rr.Body.Bytes(), &value)
+		return rr.Code, value
+	}
+
+	if code := put("bmrm-db-password", models.ServiceBmrm, "first"); code != http.StatusOK {
+		t.Fatalf("Expected status code %d storing a secret, got %d", http.StatusOK, code)
+	}
+	put("bmrm-db-password", models.ServiceBmrm, "second")
+	if code := put("bmrm-db-password", models.ServiceSgBiz, "third"); code != http.StatusConflict {
+		t.Errorf("Expected status code %d moving a secret to another service, got %d", http.StatusConflict, code)
+	}
+
+	var stored []models.TenantSecretVersion
+	db.Find(&stored)
+	for _, version := range stored {
+		if bytes.Contains(version.Ciphertext, []byte("first")) || bytes.Contains(version.Ciphertext, []byte("second")) {
+			t.Errorf("Expected version %d to be stored encrypted", version.Version)
+		}
+	}
+	res := asAdmin(http.MethodGet, fmt.Sprintf("/tenants/secrets/versions?tenantId=%d&name=bmrm-db-password", tenant.ID), nil, secretHandler.GetSecretVersions)
+	var history bytes.Buffer
+	history.ReadFrom(res.Body)
+	var versions []models.TenantSecretVersion
+	json.Unmarshal(history.Bytes(), &versions)
+	if len(versions) != 2 || versions[0].Version != 2 || strings.Contains(history.String(), "second") {
+		t.Errorf("Expected two versions without values, got %s", history.String())
+	}
+
+	bmrm := issue(models.ServiceBmrm, &tenant.ID)
+	sgbiz := issue(models.ServiceSgBiz, nil)
+	if bmrm.Key == "" || bmrm.KeyHash != "" {
+		t.Fatalf("Expected the key to be returned once without its hash, got %+v", bmrm)
+	}
+	query := fmt.Sprintf("tenantId=%d&name=bmrm-db-password", tenant.ID)
+	if code, value := reveal(bmrm.Key, query); code != http.StatusOK || value.Value != "second" || value.Version != 2 {
+		t.Errorf("Expected the current value for a covering credential, got %d %+v", code, value)
+	}
+	if _, value := reveal(bmrm.Key, query+"&version=1"); value.Value != "first" {
+		t.Errorf("Expected the first version on request, got %+v", value)
+	}
+	if code, _ := reveal(sgbiz.Key, query); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for another service's credential, got %d", http.StatusForbidden, code)
+	}
+	if code, _ := reveal(bmrm.Key, fmt.Sprintf("tenantId=%d&name=bmrm-db-password", other.ID)); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a secret the tenant lacks, got %d", http.StatusNotFound, code)
+	}
+	if code, _ := reveal(bmrm.Key+"x", query); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for a wrong key, got %d", http.StatusUnauthorized, code)
+	}
+
+	// Rotating the master key keeps every version readable under the new key
+	rand.Read(masterKey)
+	next, _ := secrets.NewVault(masterKey)
+	if err := secrets.RewrapAll(db, secretHandler.Vault, next); err != nil {
+		t.Fatalf("Failed to rewrap secrets: %v", err)
+	}
+	secretHandler.Vault = next
+	if _, value := reveal(bmrm.Key, query+"&version=1"); value.Value != "first" {
+		t.Errorf("Expected the value to survive master key rotation, got %+v", value)
+	}
+
+	res = asAdmin(http.MethodDelete, fmt.Sprintf("/services/credentials?id=%d", bmrm.ID), nil, secretHandler.RevokeCredential)
+	if code, _ := reveal(bmrm.Key, query); res.StatusCode != http.StatusOK || code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked credential to be refused, got %d then %d", res.StatusCode, code)
+	}
+}
@@ -0,0 +1,334 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/settings"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+// settingMaxBatch is how many settings one request may change
+const settingMaxBatch = 100
+
+type SettingHandler struct {
+	DefinitionRepo *util.Repository[models.SettingDefinition]
+	RevisionRepo   *util.Repository[models.TenantSettingRevision]
+	db             *gorm.DB
+}
+
+// NewSettingHandler initializes the SettingHandler with the repositories
+func NewSettingHandler(db *gorm.DB) *SettingHandler {
+	return &SettingHandler{
+		DefinitionRepo: util.NewRepository[models.SettingDefinition](db),
+		RevisionRepo:   util.NewRepository[models.TenantSettingRevision](db),
+		db:             db,
+	}
+}
+
+// SettingDefinitionEntry is a setting definition as exchanged over the API
+type SettingDefinitionEntry struct {
+	Key         string          `json:"key"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+	Default     json.RawMessage `json:"default"`
+}
+
+func toSettingDefinitionEntry(definition *models.SettingDefinition) SettingDefinitionEntry {
+	return SettingDefinitionEntry{
+		Key:         definition.Key,
+		Description: definition.Description,
+		Schema:      json.RawMessage(definition.Schema),
+		Default:     json.RawMessage(definition.Default),
+	}
+}
+
+// SettingRevisionEntry is one recorded value of a tenant setting; a null value marks a reset
+type SettingRevisionEntry struct {
+	Version   uint64          `json:"version"`
+	Value     json.RawMessage `json:"value"`
+	ActorId   *uint64         `json:"actor_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// resolveTenant returns the tenant named by the "companyid" header and the caller, checking that
+// they are a member, or an owner or admin when manage is set. System users may use any tenant.
+func (h *SettingHandler) resolveTenant(w http.ResponseWriter, r *http.Request, manage bool) (*models.Tenant, uint64, bool) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, 0, false
+	}
+	tenant, err := tenants.ByCompanyGuid(h.db, r.Header.Get("companyid"))
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Non Registered Company Requested")
+		return nil, 0, false
+	}
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType == models.UserTypeSystem {
+		return tenant, userID, true
+	}
+	member, err := tenants.ActiveMembership(h.db, userID, tenant.ID)
+	if err != nil {
+		util.HandleError(w, http.StatusForbidden, "User is not a member of the company")
+		return nil, 0, false
+	}
+	if manage && !tenants.CanManageMembers(member.Role) {
+		util.HandleError(w, http.StatusForbidden, "Only company owners and admins can change settings")
+		return nil, 0, false
+	}
+	return tenant, userID, true
+}
+
+// GetSettings returns the company's value of every setting, or of ?keys=a,b, with the defaults
+// filled in for settings it has not changed
+func (h *SettingHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	tenant, _, ok := h.resolveTenant(w, r, false)
+	if !ok {
+		return
+	}
+	var keys []string
+	if raw := r.URL.Query().Get("keys"); raw != "" {
+		keys = strings.Split(raw, ",")
+	}
+	entries, err := settings.Effective(h.db, tenant.ID, keys)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching settings")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &entries)
+}
+
+// SetSettings validates and writes a batch of settings atomically. Every entry carries the version
+// it replaces (0 while the default is in use); if any is stale nothing is written and 409 lists the
+// current values.
+func (h *SettingHandler) SetSettings(w http.ResponseWriter, r *http.Request) {
+	tenant, actorID, ok := h.resolveTenant(w, r, true)
+	if !ok {
+		return
+	}
+	entries, err := util.ParseJSONBody[[]settings.Entry](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	if len(*entries) == 0 || len(*entries) > settingMaxBatch {
+		util.HandleError(w, http.StatusBadRequest, "Between 1 and 100 settings can be set at once")
+		return
+	}
+
+	var conflicts []string
+	saved := make([]settings.Entry, 0, len(*entries))
+	now := time.Now()
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		for _, entry := range *entries {
+			setting, err := settings.Save(tx, tenant.ID, entry.Key, entry.Value, entry.Version, &actorID, now)
+			if errors.Is(err, settings.ErrVersionConflict) {
+				conflicts = append(conflicts, entry.Key)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry.Key, err)
+			}
+			saved = append(saved, settings.Entry{Key: setting.Key, Value: json.RawMessage(setting.Value), Version: setting.Version})
+		}
+		if len(conflicts) > 0 {
+			return settings.ErrVersionConflict
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, settings.ErrVersionConflict):
+		current, err := settings.Effective(h.db, tenant.ID, conflicts)
+		if err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching settings")
+			return
+		}
+		util.RespondJSON(w, http.StatusConflict, &current)
+	case errors.Is(err, settings.ErrUnknownSetting), errors.Is(err, settings.ErrInvalidValue):
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		util.HandleError(w, http.StatusInternalServerError, "Error saving settings")
+	default:
+		util.RespondJSON(w, http.StatusOK, &saved)
+	}
+}
+
+// ResetSetting drops the company's value of a setting so the default applies again (?key=&version=)
+func (h *SettingHandler) ResetSetting(w http.ResponseWriter, r *http.Request) {
+	tenant, actorID, ok := h.resolveTenant(w, r, true)
+	if !ok {
+		return
+	}
+	version, err := util.ParseUintParam(r, "version")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return settings.Reset(tx, tenant.ID, r.URL.Query().Get("key"), version, &actorID, time.Now())
+	})
+	if errors.Is(err, settings.ErrVersionConflict) {
+		util.HandleError(w, http.StatusConflict, "Setting not found at that version")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error resetting setting")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetSettingHistory returns every value a company setting has held, newest first (?key=)
+func (h *SettingHandler) GetSettingHistory(w http.ResponseWriter, r *http.Request) {
+	tenant, _, ok := h.resolveTenant(w, r, true)
+	if !ok {
+		return
+	}
+	revisions, err := h.RevisionRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ? AND key = ?", tenant.ID, r.URL.Query().Get("key")).Order("version DESC")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching setting history")
+		return
+	}
+	history := make([]SettingRevisionEntry, 0, len(revisions))
+	for _, revision := range revisions {
+		entry := SettingRevisionEntry{Version: revision.Version, ActorId: revision.ActorId, CreatedAt: revision.CreatedAt}
+		if revision.Value != nil {
+			entry.Value = json.RawMessage(*revision.Value)
+		}
+		history = append(history, entry)
+	}
+	util.RespondJSON(w, http.StatusOK, &history)
+}
+
+// GetDefinitions returns every setting definition
+func (h *SettingHandler) GetDefinitions(w http.ResponseWriter, r *http.Request) {
+	definitions, err := h.DefinitionRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Order("key")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching setting definitions")
+		return
+	}
+	entries := make([]SettingDefinitionEntry, 0, len(definitions))
+	for i := range definitions {
+		entries = append(entries, toSettingDefinitionEntry(&definitions[i]))
+	}
+	util.RespondJSON(w, http.StatusOK, &entries)
+}
+
+// parseDefinition reads and checks a definition from the request body
+func parseDefinition(w http.ResponseWriter, r *http.Request) (*models.SettingDefinition, bool) {
+	entry, err := util.ParseJSONBody[SettingDefinitionEntry](w, r)
+	if err != nil {
+		return nil, false // Error already handled by ParseJSONBody
+	}
+	definition := &models.SettingDefinition{
+		Key:         strings.TrimSpace(entry.Key),
+		Description: strings.TrimSpace(entry.Description),
+		Schema:      string(entry.Schema),
+		Default:     string(entry.Default),
+	}
+	if !preferenceNamePattern.MatchString(definition.Key) {
+		util.HandleError(w, http.StatusBadRequest, "Invalid key")
+		return nil, false
+	}
+	if err := settings.CheckDefinition(definition); err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return definition, true
+}
+
+// CreateDefinition adds a setting definition
+func (h *SettingHandler) CreateDefinition(w http.ResponseWriter, r *http.Request) {
+	definition, ok := parseDefinition(w, r)
+	if !ok {
+		return
+	}
+	err := h.DefinitionRepo.Create(definition)
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "A setting with this key already exists")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error creating setting definition")
+		return
+	}
+	entry := toSettingDefinitionEntry(definition)
+	util.RespondJSON(w, http.StatusCreated, &entry)
+}
+
+// UpdateDefinition replaces the description, schema and default of a setting (?key=). Values
+// companies already stored must satisfy the new schema.
+func (h *SettingHandler) UpdateDefinition(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	existing, err := h.DefinitionRepo.GetByField("key", key)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Setting definition not found")
+		return
+	}
+	definition, ok := parseDefinition(w, r)
+	if !ok {
+		return
+	}
+	definition.Key = key
+
+	schema, _ := settings.ParseSchema([]byte(definition.Schema))
+	var stored []models.TenantSetting
+	if err := h.db.Where("key = ?", key).Find(&stored).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error checking stored settings")
+		return
+	}
+	for _, setting := range stored {
+		if err := schema.Validate([]byte(setting.Value)); err != nil {
+			util.HandleError(w, http.StatusConflict, "A company's value does not satisfy the new schema: "+err.Error())
+			return
+		}
+	}
+
+	if err := h.DefinitionRepo.UpdateOne("id", existing.ID, map[string]interface{}{
+		"description": definition.Description,
+		"schema":      definition.Schema,
+		"default":     definition.Default,
+	}); err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating setting definition")
+		return
+	}
+	entry := toSettingDefinitionEntry(definition)
+	util.RespondJSON(w, http.StatusOK, &entry)
+}
+
+// DeleteDefinition removes a setting that no company has a value for (?key=)
+func (h *SettingHandler) DeleteDefinition(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	var inUse int64
+	if err := h.db.Model(&models.TenantSetting{}).Where("key = ?", key).Count(&inUse).Error; err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error checking stored settings")
+		return
+	}
+	if inUse > 0 {
+		util.HandleError(w, http.StatusConflict, "Companies still have a value for this setting")
+		return
+	}
+	result := h.db.Where("key = ?", key).Delete(&models.SettingDefinition{})
+	if result.Error != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error deleting setting definition")
+		return
+	}
+	if result.RowsAffected == 0 {
+		util.HandleError(w, http.StatusNotFound, "Setting definition not found")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
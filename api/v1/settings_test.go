@@ -0,0 +1,93 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/settings"
+)
+
+// TestTenantSettings checks validation, versioned writes, defaults, history and ResolveTenant
+func TestTenantSettings(t *testing.T) {
+	db := SetupTestDB(t)
+	if err := settings.SeedDefinitions(db); err != nil {
+		t.Fatalf("Failed to seed setting definitions: %v", err)
+	}
+	authHandler := NewAuthHandler(db)
+	settingHandler := NewSettingHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	member, memberToken := createTestUser(t, db, "member@example.com", "9000000002", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	db.Create(&models.UserTenantMapping{UserId: member.ID, TenantId: tenant.ID, Role: models.RoleMember})
+
+	request := func(method, url string, token *models.Token, body interface{}, handler http.HandlerFunc) (int, []byte) {
+		encoded, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(encoded))
+		req.Header.Set("token", token.Value.String())
+		req.Header.Set("companyid", "acme")
+		rr := executeRequest(req, authHandler.Authenticate(handler))
+		return rr.Code, rr.Body.Bytes()
+	}
+	set := func(token *models.Token, entries ...settings.Entry) (int, []byte) {
+		return request(http.MethodPut, "/tenants/settings", token, entries, settingHandler.SetSettings)
+	}
+
+	interval := settings.Entry{Key: "sync_interval_minutes", Value: json.RawMessage(`30`)}
+	if code, _ := set(memberToken, interval); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a member, got %d", http.StatusForbidden, code)
+	}
+	// One invalid value rejects the whole batch
+	if code, _ := set(ownerToken, interval, settings.Entry{Key: "gstin", Value: json.RawMessage(`"abc"`)}); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an invalid GSTIN, got %d", http.StatusBadRequest, code)
+	}
+	if code, _ := set(ownerToken, settings.Entry{Key: "unknown", Value: json.RawMessage(`1`)}); code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an unknown setting, got %d", http.StatusBadRequest, code)
+	}
+	code, body := set(ownerToken, interval)
+	var saved []settings.Entry
+	json.Unmarshal(body, &saved)
+	if code != http.StatusOK || len(saved) != 1 || saved[0].Version != 1 {
+		t.Fatalf("Expected version 1 to be saved, got %d %s", code, body)
+	}
+	if code, _ := set(ownerToken, interval); code != http.StatusConflict {
+		t.Errorf("Expected status code %d for a stale version, got %d", http.StatusConflict, code)
+	}
+
+	code, body = request(http.MethodGet, "/tenants/settings?keys=sync_interval_minutes,financial_year_start", memberToken, nil, settingHandler.GetSettings)
+	var effective []settings.Entry
+	json.Unmarshal(body, &effective)
+	if code != http.StatusOK || len(effective) != 2 || string(effective[0].Value) != `"04-01"` || !effective[0].IsDefault ||
+		string(effective[1].Value) != `30` {
+		t.Errorf("Expected the default year start and the saved interval, got %d %s", code, body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/token/validate?settings=sync_interval_minutes", nil)
+	req.Header.Set("token", memberToken.Value.String())
+	req.Header.Set("companyid", "acme")
+	rr := executeRequest(req, authHandler.ResolveTenant)
+	var info models.TokenTenantInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if rr.Code != http.StatusOK || len(info.Settings) != 1 || string(info.Settings["sync_interval_minutes"]) != `30` {
+		t.Errorf("Expected the interval in the resolved tenant, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A reset brings back the default without reusing versions
+	if code, _ := request(http.MethodDelete, "/tenants/settings?key=sync_interval_minutes&version=1", ownerToken, nil, settingHandler.ResetSetting); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if _, body := set(ownerToken, interval); !bytes.Contains(body, []byte(`"version":3`)) {
+		t.Errorf("Expected version 3 after a reset, got %s", body)
+	}
+	code, body = request(http.MethodGet, "/tenants/settings/history?key=sync_interval_minutes", ownerToken, nil, settingHandler.GetSettingHistory)
+	var history []SettingRevisionEntry
+	json.Unmarshal(body, &history)
+	if code != http.StatusOK || len(history) != 3 || string(history[1].Value) != "null" {
+		t.Errorf("Expected three revisions with the reset in the middle, got %d %s", code, body)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"gorm.io/gorm"
 	"sg-portal/internal/models"
+	"sg-portal/internal/subscriptions"
 	"sg-portal/pkg/util"
 )
 
@@ -12,6 +13,7 @@ type SubscriptionHandler struct {
 	SubscriptionRepo        *util.Repository[models.Subscription]
 	UserSubscriptionRepo    *util.Repository[models.UserSubscriptionMapping]
 	FeatureSubscriptionRepo *util.Repository[models.FeatureSubscriptionMapping]
+	db                      *gorm.DB
 }
 
 // NewSubscriptionHandler initializes the SubscriptionHandler with the repositories
@@ -20,6 +22,7 @@ func NewSubscriptionHandler(db *gorm.DB) *SubscriptionHandler {
 		SubscriptionRepo:        util.NewRepository[models.Subscription](db),
 		UserSubscriptionRepo:    util.NewRepository[models.UserSubscriptionMapping](db),
 		FeatureSubscriptionRepo: util.NewRepository[models.FeatureSubscriptionMapping](db),
+		db:                      db,
 	}
 }
 
@@ -109,28 +112,25 @@ func (h *SubscriptionHandler) MapUserToSubscription(w http.ResponseWriter, r *ht
 	util.RespondJSON(w, http.StatusCreated, mapping)
 }
 
-// GetSubscriptionsByUser returns all subscriptions mapped to a specific user
+// GetSubscriptionsByUser returns all subscriptions of a specific user, including those bought
+// for their organizations
 func (h *SubscriptionHandler) GetSubscriptionsByUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := util.ParseUintParam(r, "userId")
 	if err != nil {
 		util.HandleError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	mappings, err := h.UserSubscriptionRepo.GetAllByCondition("user_id = ?", userId)
+	subscriptionIds, err := subscriptions.ForUser(h.db, userId)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error fetching user-subscription mappings")
 		return
 	}
-	var subscriptionIds []uint32
-	for _, mapping := range mappings {
-		subscriptionIds = append(subscriptionIds, mapping.SubscriptionId)
-	}
-	subscriptions, err := h.SubscriptionRepo.GetAllByCondition("id IN ?", subscriptionIds)
+	held, err := h.SubscriptionRepo.GetAllByCondition("id IN ?", subscriptionIds)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error fetching subscriptions")
 		return
 	}
-	util.RespondJSON(w, http.StatusOK, &subscriptions)
+	util.RespondJSON(w, http.StatusOK, &held)
 }
 
 // DeleteUserSubscriptionMapping deletes a user-subscription mapping (hard delete)
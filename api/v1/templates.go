@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+type TemplateHandler struct {
+	TemplateRepo *util.Repository[models.ProvisioningTemplate]
+	db           *gorm.DB
+}
+
+// NewTemplateHandler initializes the TemplateHandler with the repository
+func NewTemplateHandler(db *gorm.DB) *TemplateHandler {
+	return &TemplateHandler{
+		TemplateRepo: util.NewRepository[models.ProvisioningTemplate](db),
+		db:           db,
+	}
+}
+
+// GetTemplates returns every provisioning template with its hosts, default grants and rules
+func (h *TemplateHandler) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.TemplateRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Order("priority, name")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching provisioning templates")
+		return
+	}
+
+	specs := make([]*tenants.TemplateSpec, 0, len(templates))
+	for _, template := range templates {
+		spec, err := tenants.LoadTemplate(h.db, template)
+		if err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error fetching provisioning templates")
+			return
+		}
+		specs = append(specs, spec)
+	}
+	util.RespondJSON(w, http.StatusOK, &specs)
+}
+
+// CreateTemplate creates a provisioning template
+func (h *TemplateHandler) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	spec, err := util.ParseJSONBody[tenants.TemplateSpec](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	spec.ID = 0
+	h.saveTemplate(w, spec, http.StatusCreated)
+}
+
+// UpdateTemplate replaces a provisioning template (?id=)
+func (h *TemplateHandler) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	existing, err := h.TemplateRepo.GetByField("id", id)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Provisioning template not found")
+		return
+	}
+
+	spec, err := util.ParseJSONBody[tenants.TemplateSpec](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	spec.ID = existing.ID
+	spec.CreatedAt = existing.CreatedAt
+	h.saveTemplate(w, spec, http.StatusOK)
+}
+
+// DeleteTemplate deletes a provisioning template (?id=). Companies already provisioned from it are kept.
+func (h *TemplateHandler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return tenants.DeleteTemplate(tx, uint32(id))
+	})
+	if errors.Is(err, tenants.ErrDefaultTemplateRequired) {
+		util.HandleError(w, http.StatusConflict, "Make another template the default before deleting this one")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error deleting provisioning template")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// saveTemplate validates and stores the template, responding with it on success
+func (h *TemplateHandler) saveTemplate(w http.ResponseWriter, spec *tenants.TemplateSpec, status int) {
+	spec.Name = strings.TrimSpace(spec.Name)
+	if spec.Name == "" {
+		util.HandleError(w, http.StatusBadRequest, "Template name is required")
+		return
+	}
+	for i, host := range spec.Hosts {
+		spec.Hosts[i] = strings.TrimSpace(host)
+		if spec.Hosts[i] == "" {
+			util.HandleError(w, http.StatusBadRequest, "Template hosts cannot be blank")
+			return
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return tenants.SaveTemplate(tx, spec)
+	})
+	if errors.Is(err, tenants.ErrUnknownCode) {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, tenants.ErrDefaultTemplateRequired) {
+		util.HandleError(w, http.StatusConflict, "Make another template the default instead of clearing the flag on this one")
+		return
+	}
+	if util.IsDuplicateKeyError(err) {
+		util.HandleError(w, http.StatusConflict, "A template with that name or host already exists")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error saving provisioning template")
+		return
+	}
+	util.RespondJSON(w, status, spec)
+}
@@ -1,38 +1,110 @@
 package v1
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"sg-portal/internal/features"
 	"sg-portal/internal/models"
+	"sg-portal/internal/organizations"
+	"sg-portal/internal/tenants"
 	"sg-portal/pkg/util"
 
 	"gorm.io/gorm"
 )
 
 type TenantHandler struct {
-	TenantRepo     *util.Repository[models.Tenant]
-	UserTenantRepo *util.Repository[models.UserTenantMapping]
+	TenantRepo      *util.Repository[models.Tenant]
+	UserTenantRepo  *util.Repository[models.UserTenantMapping]
+	StateChangeRepo *util.Repository[models.TenantStateChange]
+	Retention       time.Duration // How long a deleted tenant keeps its memberships
+	db              *gorm.DB
 }
 
 // NewTenantHandler initializes the TenantHandler with the repositories
 func NewTenantHandler(db *gorm.DB) *TenantHandler {
 	return &TenantHandler{
-		TenantRepo:     util.NewRepository[models.Tenant](db),
-		UserTenantRepo: util.NewRepository[models.UserTenantMapping](db),
+		TenantRepo:      util.NewRepository[models.Tenant](db),
+		UserTenantRepo:  util.NewRepository[models.UserTenantMapping](db),
+		StateChangeRepo: util.NewRepository[models.TenantStateChange](db),
+		Retention:       30 * 24 * time.Hour,
+		db:              db,
 	}
 }
 
-// Check if exits and if not create with copying default
-func (h *TenantHandler) CheckAndMake(w http.ResponseWriter, r *http.Request) {
-	userID, err := util.ParseUintHeader(r, "userid")
-	util.HandleError(w, http.StatusBadRequest, "Invalid UserId")
+// errLastOwner aborts a membership change that would leave a tenant without an owner
+var errLastOwner = errors.New("a company must keep at least one owner")
+
+// authorizeManager checks that the authenticated user is a system user or an owner or admin of the
+// tenant, and returns their membership (nil for system users). Otherwise it answers 401 or 403 with
+// a message saying what only managers can do.
+func authorizeManager(db *gorm.DB, w http.ResponseWriter, r *http.Request, tenantID uint64, action string) (*models.UserTenantMapping, bool) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType == models.UserTypeSystem {
+		return nil, true
+	}
+
+	actor, err := tenants.ActiveMembership(db, actorID, tenantID)
+	if err != nil || !tenants.CanManageMembers(actor.Role) {
+		util.HandleError(w, http.StatusForbidden, "Only company owners and admins can "+action)
+		return nil, false
+	}
+	return actor, true
+}
+
+// authorizeMemberChange checks that the authenticated user may change memberships of the tenant.
+// Owners and admins manage members; only owners may grant, change or remove the owner role.
+// System users may manage any tenant.
+func (h *TenantHandler) authorizeMemberChange(w http.ResponseWriter, r *http.Request, tenantID uint64, roles ...string) bool {
+	actor, ok := authorizeManager(h.db, w, r, tenantID, "manage members")
+	if !ok || actor == nil {
+		return ok
+	}
+	for _, role := range roles {
+		if role == models.RoleOwner && actor.Role != models.RoleOwner {
+			util.HandleError(w, http.StatusForbidden, "Only company owners can manage owners")
+			return false
+		}
+	}
+	return true
+}
+
+// guardLastOwner fails with errLastOwner when the tenant would be left without owners
+func guardLastOwner(tx *gorm.DB, tenantID uint64) error {
+	owners, err := tenants.CountOwners(tx, tenantID)
 	if err != nil {
-		return
+		return err
 	}
+	if owners < 1 {
+		return errLastOwner
+	}
+	return nil
+}
+
+// respondInherited rejects changing a membership that comes from the company's organization
+func respondInherited(w http.ResponseWriter) {
+	util.HandleError(w, http.StatusConflict, "Access is inherited from the organization; change it there")
+}
+
+// ProvisionedTenant is a company created by CheckAndMake with the template it was provisioned from
+type ProvisionedTenant struct {
+	*models.Tenant
+	Template string `json:"template"`
+}
 
-	defaultTenant, tenantErr := h.TenantRepo.GetByField("company_guid", "default")
-	if tenantErr != nil || defaultTenant == nil {
-		util.HandleError(w, http.StatusInternalServerError, "Could not map to demo server")
+// CheckAndMake creates the company for the authenticated user, who becomes its owner, unless it
+// is already registered. The provisioning template is taken from ?template= or chosen from the
+// user's subscriptions.
+func (h *TenantHandler) CheckAndMake(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
@@ -41,83 +113,303 @@ func (h *TenantHandler) CheckAndMake(w http.ResponseWriter, r *http.Request) {
 		return // Error already handled by ParseJSONBody
 	}
 
-	existing, tenantErr := h.TenantRepo.GetByField("company_name", newTenant.CompanyGuid)
+	existing, tenantErr := h.TenantRepo.GetByField("company_guid", newTenant.CompanyGuid)
 	if tenantErr == nil && existing != nil {
 		util.HandleError(w, http.StatusOK, "Tenant Already Exists")
 		return
 	}
-	var tenant = defaultTenant
-	tenant.ID = 0
-	tenant.CompanyGuid = newTenant.CompanyGuid
-	tenant.CompanyName = newTenant.CompanyName
 
-	if err := h.TenantRepo.Create(tenant); err != nil {
-		util.HandleError(w, http.StatusInternalServerError, "Error creating tenant")
+	template, err := tenants.SelectTemplate(h.db, userID, r.URL.Query().Get("template"))
+	if errors.Is(err, tenants.ErrTemplateNotFound) {
+		util.HandleError(w, http.StatusNotFound, "Provisioning template not found")
 		return
 	}
-
-	tenantInfo, err := h.TenantRepo.GetByField("company_guid", tenant.CompanyGuid)
 	if err != nil {
-		util.HandleError(w, http.StatusInternalServerError, "Error creating tenant")
+		util.HandleError(w, http.StatusInternalServerError, "Error selecting provisioning template")
 		return
 	}
 
-	tenantMappingRepo := util.NewRepository[models.UserTenantMapping](util.Db)
-	var newEntry = models.UserTenantMapping{
-		TenantId: tenantInfo.ID,
-		UserId:   userID,
+	var tenant *models.Tenant
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		tenant, err = tenants.Provision(tx, template, userID, newTenant.CompanyGuid, newTenant.CompanyName)
+		return err
+	})
+	if errors.Is(err, tenants.ErrNoHosts) {
+		util.HandleError(w, http.StatusConflict, "Provisioning template has no hosts")
+		return
 	}
-
-	if err := tenantMappingRepo.Create(&newEntry); err != nil {
+	if respondPlacementError(w, err) {
+		return
+	}
+	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error creating tenant")
 		return
 	}
 
-	util.RespondJSON(w, http.StatusCreated, tenant)
+	util.RespondJSON(w, http.StatusCreated, &ProvisionedTenant{Tenant: tenant, Template: template.Name})
+}
+
+// respondPlacementError answers host and port allocation failures and reports whether it did
+func respondPlacementError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, tenants.ErrNoCapacity):
+		util.HandleError(w, http.StatusConflict, "No host has capacity for another tenant")
+	case errors.Is(err, tenants.ErrPortsExhausted):
+		util.HandleError(w, http.StatusConflict, "The host has no free ports left")
+	case errors.Is(err, tenants.ErrPortTaken):
+		util.HandleError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, tenants.ErrPortOutOfRange):
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+	default:
+		return false
+	}
+	return true
 }
 
-// CreateTenant creates a new tenant
+// newTenant is the body of CreateTenant. The lifecycle state, seat limit and organization are
+// managed by their own endpoints, so new tenants always start active, unlimited and ungrouped.
+type newTenant struct {
+	CompanyGuid   string
+	CompanyName   string
+	Host          string
+	BmrmPort      uint32
+	SgBizPort     uint32
+	TallySyncPort uint32
+}
+
+// CreateTenant creates a new tenant. Without a host it is placed on the least utilized host of the
+// inventory carrying every label in ?labels=, with free ports allocated; ports given for a host in
+// the inventory are checked against its range and reserved.
 func (h *TenantHandler) CreateTenant(w http.ResponseWriter, r *http.Request) {
-	tenant, err := util.ParseJSONBody[models.Tenant](w, r)
+	body, err := util.ParseJSONBody[newTenant](w, r)
 	if err != nil {
 		return // Error already handled by ParseJSONBody
 	}
-	if err := h.TenantRepo.Create(tenant); err != nil {
+	tenant := &models.Tenant{
+		CompanyGuid:   body.CompanyGuid,
+		CompanyName:   body.CompanyName,
+		Host:          body.Host,
+		BmrmPort:      body.BmrmPort,
+		SgBizPort:     body.SgBizPort,
+		TallySyncPort: body.TallySyncPort,
+		State:         models.TenantActive,
+	}
+	var labels []string
+	if raw := r.URL.Query().Get("labels"); raw != "" {
+		for _, label := range strings.Split(raw, ",") {
+			labels = append(labels, strings.TrimSpace(label))
+		}
+	}
+
+	tenant.Host = strings.TrimSpace(tenant.Host)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if tenant.Host == "" {
+			if err := tenants.Place(tx, tenant, labels); err != nil {
+				return err
+			}
+		}
+		if err := util.NewRepository[models.Tenant](tx).Create(tenant); err != nil {
+			return err
+		}
+		if err := tenants.ReservePorts(tx, tenant); err != nil {
+			return err
+		}
+		return tenants.CreateLegacyEndpoints(tx, tenant)
+	})
+	if respondPlacementError(w, err) {
+		return
+	}
+	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error creating tenant")
 		return
 	}
 	util.RespondJSON(w, http.StatusCreated, tenant)
 }
 
-// MapUserToTenant maps a single user to a tenant
+// respondSeatError answers a seat claim that failed because the tenant is full or unknown, and
+// reports whether it did
+func respondSeatError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, tenants.ErrNoSeats):
+		util.RespondJSON(w, http.StatusConflict, &models.GenericResponseMessage{
+			Message: "All of the company's seats are taken",
+			Result:  false,
+			Code:    models.CodeNoSeats,
+		})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+	default:
+		return false
+	}
+	return true
+}
+
+// memberMapping is the body of the mapping endpoints. Organization inheritance and seat
+// deactivation have their own flows, so they cannot be set here.
+type memberMapping struct {
+	UserId   uint64
+	TenantId uint64
+	Role     string
+}
+
+func (m *memberMapping) toModel() *models.UserTenantMapping {
+	return &models.UserTenantMapping{UserId: m.UserId, TenantId: m.TenantId, Role: m.Role}
+}
+
+// MapUserToTenant maps a single user to a tenant with a role (member when omitted), provided the
+// tenant has a seat left
 func (h *TenantHandler) MapUserToTenant(w http.ResponseWriter, r *http.Request) {
-	mapping, err := util.ParseJSONBody[models.UserTenantMapping](w, r)
+	body, err := util.ParseJSONBody[memberMapping](w, r)
 	if err != nil {
 		return // Error already handled by ParseJSONBody
 	}
-	if err := h.UserTenantRepo.Create(mapping); err != nil {
+	mapping := body.toModel()
+	if mapping.Role == "" {
+		mapping.Role = models.RoleMember
+	}
+	if !models.IsValidRole(mapping.Role) {
+		util.HandleError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+	if !h.authorizeMemberChange(w, r, mapping.TenantId, mapping.Role) {
+		return
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tenants.ClaimSeats(tx, mapping.TenantId, 1); err != nil {
+			return err
+		}
+		return util.NewRepository[models.UserTenantMapping](tx).Create(mapping)
+	})
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		if util.IsDuplicateKeyError(err) {
+			util.HandleError(w, http.StatusConflict, "User is already mapped to the tenant")
+			return
+		}
 		util.HandleError(w, http.StatusInternalServerError, "Error mapping user to tenant")
 		return
 	}
 	util.RespondJSON(w, http.StatusCreated, mapping)
 }
 
-// MapUsersToTenant maps multiple users to a tenant
+// MapUsersToTenant maps multiple users to tenants; nothing is mapped unless every tenant has
+// seats left for all of its new members
 func (h *TenantHandler) MapUsersToTenant(w http.ResponseWriter, r *http.Request) {
-	mappings, err := util.ParseJSONBody[[]models.UserTenantMapping](w, r)
+	body, err := util.ParseJSONBody[[]memberMapping](w, r)
 	if err != nil {
 		return // Error already handled by ParseJSONBody
 	}
-	if err := h.UserTenantRepo.CreateMultiple(mappings); err != nil {
+	mappings := make([]models.UserTenantMapping, 0, len(*body))
+	for _, entry := range *body {
+		mappings = append(mappings, *entry.toModel())
+	}
+	for i := range mappings {
+		mapping := &mappings[i]
+		if mapping.Role == "" {
+			mapping.Role = models.RoleMember
+		}
+		if !models.IsValidRole(mapping.Role) {
+			util.HandleError(w, http.StatusBadRequest, "Invalid role")
+			return
+		}
+		if !h.authorizeMemberChange(w, r, mapping.TenantId, mapping.Role) {
+			return
+		}
+	}
+	seats := make(map[uint64]int)
+	for _, mapping := range mappings {
+		seats[mapping.TenantId]++
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		for tenantID, n := range seats {
+			if err := tenants.ClaimSeats(tx, tenantID, n); err != nil {
+				return err
+			}
+		}
+		return util.NewRepository[models.UserTenantMapping](tx).CreateMultiple(&mappings)
+	})
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		if util.IsDuplicateKeyError(err) {
+			util.HandleError(w, http.StatusConflict, "A user is already mapped to the tenant")
+			return
+		}
 		util.HandleError(w, http.StatusInternalServerError, "Error mapping users to tenant")
 		return
 	}
-	util.RespondJSON(w, http.StatusCreated, mappings)
+	util.RespondJSON(w, http.StatusCreated, &mappings)
+}
+
+// UpdateMemberRole changes the role of a tenant member (?userId=&tenantId=, body {"role": "admin"})
+func (h *TenantHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	userId, err := util.ParseUintParam(r, "userId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		Role string `json:"role"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	if !models.IsValidRole(body.Role) {
+		util.HandleError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	member, err := tenants.Membership(h.db, userId, tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User is not a member of the tenant")
+		return
+	}
+	if !h.authorizeMemberChange(w, r, tenantId, member.Role, body.Role) {
+		return
+	}
+	if member.InheritedFrom != 0 {
+		respondInherited(w)
+		return
+	}
+	if body.Role == models.RoleOwner && member.DeactivatedAt != nil {
+		util.HandleError(w, http.StatusConflict, "Reactivate the member's seat before making them an owner")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := util.NewRepository[models.UserTenantMapping](tx).UpdateOne("id", member.ID, map[string]interface{}{"role": body.Role}); err != nil {
+			return err
+		}
+		return guardLastOwner(tx, tenantId)
+	})
+	if errors.Is(err, errLastOwner) {
+		util.HandleError(w, http.StatusConflict, "A company must keep at least one owner")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error updating member role")
+		return
+	}
+
+	member.Role = body.Role
+	util.RespondJSON(w, http.StatusOK, member)
 }
 
-// GetAllTenants returns all tenants
+// GetAllTenants returns all tenants, optionally only those in one lifecycle state (?state=)
 func (h *TenantHandler) GetAllTenants(w http.ResponseWriter, r *http.Request) {
-	tenants, err := h.TenantRepo.GetAllByCondition("1 = 1")
+	condition, args := "1 = 1", []interface{}{}
+	if state := r.URL.Query().Get("state"); state != "" {
+		condition, args = "state = ?", append(args, state)
+	}
+	tenants, err := h.TenantRepo.GetAllByCondition(condition, args...)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenants")
 		return
@@ -141,7 +433,7 @@ func (h *TenantHandler) GetTenantsByUser(w http.ResponseWriter, r *http.Request)
 	for _, mapping := range mappings {
 		tenantIds = append(tenantIds, mapping.TenantId)
 	}
-	tenants, err := h.TenantRepo.GetAllByCondition("id IN ?", tenantIds)
+	tenants, err := h.TenantRepo.GetAllByCondition("id IN ? AND state <> ?", tenantIds, models.TenantDeleted)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenants")
 		return
@@ -164,7 +456,7 @@ func (h *TenantHandler) GetTenantsByHeaderUser(w http.ResponseWriter, r *http.Re
 	for _, mapping := range mappings {
 		tenantIds = append(tenantIds, mapping.TenantId)
 	}
-	tenants, err := h.TenantRepo.GetAllByCondition("id IN ?", tenantIds)
+	tenants, err := h.TenantRepo.GetAllByCondition("id IN ? AND state <> ?", tenantIds, models.TenantDeleted)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenants")
 		return
@@ -172,7 +464,9 @@ func (h *TenantHandler) GetTenantsByHeaderUser(w http.ResponseWriter, r *http.Re
 	util.RespondJSON(w, http.StatusOK, &tenants)
 }
 
-// UpdateTenant updates an existing tenant
+// UpdateTenant updates an existing tenant; only its company name can be edited here. Its host and
+// ports are changed through its endpoints or a migration instead, its state through /tenants/state,
+// its seat limit through /tenants/seats and its organization through /organizations/companies.
 func (h *TenantHandler) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 	// Extract tenantId from query parameters
 	tenantId, err := util.ParseUintParam(r, "tenantId")
@@ -192,6 +486,28 @@ func (h *TenantHandler) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 		util.HandleError(w, http.StatusBadRequest, "No updates provided")
 		return
 	}
+	for key := range *tenantUpdates {
+		switch strings.ToLower(strings.ReplaceAll(key, "_", "")) {
+		case "state", "purgeafter", "purgedat":
+			util.HandleError(w, http.StatusBadRequest, "Use /tenants/state to change the lifecycle state")
+			return
+		case "host", "bmrmport", "sgbizport", "tallysyncport":
+			// These only mirror the primary endpoints, which are what clients are routed to
+			util.HandleError(w, http.StatusBadRequest, "Use /tenants/endpoints to change the tenant's endpoints or /tenants/migrations to move it to another host")
+			return
+		case "seatlimit":
+			util.HandleError(w, http.StatusBadRequest, "Use /tenants/seats to change the seat limit")
+			return
+		case "organizationid":
+			// Joining an organization grants its members access, so it needs the organization's admins
+			util.HandleError(w, http.StatusBadRequest, "Use /organizations/companies to add the company to an organization")
+			return
+		case "companyname":
+		default:
+			util.HandleError(w, http.StatusBadRequest, "Field cannot be updated: "+key)
+			return
+		}
+	}
 
 	// Apply the updates to the tenant by ID
 	if err := h.TenantRepo.UpdateOne("id", tenantId, *tenantUpdates); err != nil {
@@ -203,6 +519,72 @@ func (h *TenantHandler) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// ChangeTenantState moves a tenant through its lifecycle (?tenantId=, body {"state": "suspended", "reason": "..."})
+func (h *TenantHandler) ChangeTenantState(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		State  string `json:"state"`
+		Reason string `json:"reason"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		util.HandleError(w, http.StatusBadRequest, "A reason is required")
+		return
+	}
+
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		return tenants.Transition(tx, tenant, body.State, body.Reason, &actorID, time.Now(), h.Retention)
+	})
+	if errors.Is(err, tenants.ErrInvalidTransition) {
+		util.HandleError(w, http.StatusConflict, "A "+tenant.State+" tenant cannot become "+body.State)
+		return
+	}
+	if errors.Is(err, tenants.ErrPurged) {
+		util.HandleError(w, http.StatusConflict, "The tenant's retention window has passed")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error changing tenant state")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, tenant)
+}
+
+// GetTenantStateHistory returns the lifecycle transitions of a tenant, newest first (?tenantId=)
+func (h *TenantHandler) GetTenantStateHistory(w http.ResponseWriter, r *http.Request) {
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	changes, err := h.StateChangeRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantId).Order("created_at DESC, id DESC")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching tenant state history")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, &changes)
+}
+
 // DeleteUserTenantMapping deletes a user-tenant mapping (hard delete)
 func (h *TenantHandler) DeleteUserTenantMapping(w http.ResponseWriter, r *http.Request) {
 	// Extract userId and tenantId from query parameters
@@ -218,9 +600,42 @@ func (h *TenantHandler) DeleteUserTenantMapping(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	member, err := tenants.Membership(h.db, userId, tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User is not a member of the tenant")
+		return
+	}
+	if !h.authorizeMemberChange(w, r, tenantId, member.Role) {
+		return
+	}
+	if member.InheritedFrom != 0 {
+		respondInherited(w)
+		return
+	}
+
 	// Use the repository's Delete method to delete the UserTenantMapping where user_id and tenant_id match
 	condition := "user_id = ? AND tenant_id = ?"
-	if err := h.UserTenantRepo.Delete(condition, userId, tenantId); err != nil {
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := util.NewRepository[models.UserTenantMapping](tx).Delete(condition, userId, tenantId); err != nil {
+			return err
+		}
+		if err := features.RevokeInTenant(tx, userId, tenantId); err != nil {
+			return err
+		}
+		// Access the user still has through the company's organization is restored
+		if err := organizations.SyncForTenant(tx, tenantId); err != nil {
+			return err
+		}
+		if member.Role != models.RoleOwner {
+			return nil
+		}
+		return guardLastOwner(tx, tenantId)
+	})
+	if errors.Is(err, errLastOwner) {
+		util.HandleError(w, http.StatusConflict, "A company must keep at least one owner")
+		return
+	}
+	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error deleting user-tenant mapping")
 		return
 	}
@@ -0,0 +1,267 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"sg-portal/internal/features"
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+
+	"gorm.io/gorm"
+)
+
+// TestMemberRoles checks that only owners and admins manage members and that a company keeps an owner
+func TestMemberRoles(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	tenantHandler := NewTenantHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	admin, adminToken := createTestUser(t, db, "admin@example.com", "9000000002", models.UserTypeClient)
+	member, memberToken := createTestUser(t, db, "member@example.com", "9000000003", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	db.Create(&models.UserTenantMapping{UserId: admin.ID, TenantId: tenant.ID, Role: models.RoleAdmin})
+
+	mapUser := func(token *models.Token, role string) int {
+		body, _ := json.Marshal(map[string]interface{}{"UserId": member.ID, "TenantId": tenant.ID, "Role": role,
+			"InheritedFrom": 7, "DeactivatedAt": time.Now()})
+		req, _ := http.NewRequest(http.MethodPost, "/tenants/map", bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.Authenticate(tenantHandler.MapUserToTenant)).Code
+	}
+	setRole := func(token *models.Token, userID uint64, role string) int {
+		body, _ := json.Marshal(map[string]string{"role": role})
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("/tenants/members/role?userId=%d&tenantId=%d", userID, tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.Authenticate(tenantHandler.UpdateMemberRole)).Code
+	}
+
+	// Non-members cannot add members, admins cannot grant ownership
+	if code := mapUser(memberToken, ""); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a non-member, got %d", http.StatusForbidden, code)
+	}
+	if code := mapUser(adminToken, models.RoleOwner); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for an admin granting ownership, got %d", http.StatusForbidden, code)
+	}
+	if code := mapUser(adminToken, ""); code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d", http.StatusCreated, code)
+	}
+	if m, err := tenants.Membership(db, member.ID, tenant.ID); err != nil || m.InheritedFrom != 0 || m.DeactivatedAt != nil {
+		t.Errorf("Expected a direct, active membership whatever the body says, got %+v %v", m, err)
+	}
+
+	// The only owner cannot step down until another owner exists
+	if code := setRole(ownerToken, owner.ID, models.RoleAdmin); code != http.StatusConflict {
+		t.Errorf("Expected status code %d when demoting the last owner, got %d", http.StatusConflict, code)
+	}
+	if code := setRole(ownerToken, admin.ID, models.RoleOwner); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := setRole(ownerToken, owner.ID, models.RoleMember); code != http.StatusOK {
+		t.Errorf("Expected status code %d once another owner exists, got %d", http.StatusOK, code)
+	}
+
+	// A deactivated member cannot be made an owner, and an existing deactivated owner does not count
+	db.Model(&models.UserTenantMapping{}).Where("user_id = ?", member.ID).Update("deactivated_at", time.Now())
+	if code := setRole(adminToken, member.ID, models.RoleOwner); code != http.StatusConflict {
+		t.Errorf("Expected status code %d promoting a deactivated member, got %d", http.StatusConflict, code)
+	}
+	db.Model(&models.UserTenantMapping{}).Where("user_id = ?", member.ID).Update("role", models.RoleOwner)
+	if code := setRole(adminToken, admin.ID, models.RoleMember); code != http.StatusConflict {
+		t.Errorf("Expected status code %d when only a deactivated owner would remain, got %d", http.StatusConflict, code)
+	}
+	db.Model(&models.UserTenantMapping{}).Where("user_id = ?", member.ID).Updates(map[string]interface{}{"role": models.RoleMember, "deactivated_at": nil})
+
+	req, _ := http.NewRequest(http.MethodGet, "/token/validate", nil)
+	req.Header.Set("token", memberToken.Value.String())
+	req.Header.Set("companyid", "acme")
+	rr := executeRequest(req, authHandler.ResolveTenant)
+	var info models.TokenTenantInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if rr.Code != http.StatusOK || info.Role != models.RoleMember {
+		t.Errorf("Expected the member role in the resolved tenant, got %d %+v", rr.Code, info)
+	}
+}
+
+// TestCheckAndMake checks template selection by subscription rule and explicit name, and host balancing
+func TestCheckAndMake(t *testing.T) {
+	db := SetupTestDB(t)
+	seedRegistrationDefaults(t, db)
+	authHandler := NewAuthHandler(db)
+	tenantHandler := NewTenantHandler(db)
+
+	if err := tenants.SeedDefaultTemplate(db); err != nil {
+		t.Fatalf("Failed to seed the default template: %v", err)
+	}
+	pro := &models.Subscription{Name: "Pro", Code: "pro"}
+	db.Create(pro)
+	premium := &tenants.TemplateSpec{
+		ProvisioningTemplate: models.ProvisioningTemplate{Name: "premium", BmrmPort: 9001},
+		Hosts:                []string{"pro-1.local", "pro-2.local"},
+		Features:             []string{"sales.report"},
+		Rules:                []string{"pro"},
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return tenants.SaveTemplate(tx, premium) }); err != nil {
+		t.Fatalf("Failed to save template: %v", err)
+	}
+
+	user, token := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserSubscriptionMapping{UserId: user.ID, SubscriptionId: pro.ID})
+
+	checkAndMake := func(guid, template string) (int, ProvisionedTenant) {
+		body, _ := json.Marshal(map[string]string{"CompanyGuid": guid, "CompanyName": guid})
+		req, _ := http.NewRequest(http.MethodPost, "/tenants/check-make?template="+template, bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(tenantHandler.CheckAndMake))
+		var provisioned ProvisionedTenant
+		json.Unmarshal(rr.Body.Bytes(), &provisioned)
+		return rr.Code, provisioned
+	}
+
+	code, first := checkAndMake("guid-1", "")
+	if code != http.StatusCreated || first.Template != "premium" || first.Tenant == nil || first.Host != "pro-1.local" || first.BmrmPort != 9001 {
+		t.Fatalf("Expected guid-1 on premium/pro-1.local, got %d %+v", code, first)
+	}
+	if _, second := checkAndMake("guid-2", ""); second.Tenant == nil || second.Host != "pro-2.local" {
+		t.Errorf("Expected the second company on the less loaded host, got %+v", second)
+	}
+	if _, legacy := checkAndMake("guid-3", models.DefaultTemplateName); legacy.Tenant == nil || legacy.Host != "demo.local" {
+		t.Errorf("Expected the explicit default template on demo.local, got %+v", legacy)
+	}
+	if code, _ := checkAndMake("guid-1", ""); code != http.StatusOK {
+		t.Errorf("Expected status code %d for an existing company, got %d", http.StatusOK, code)
+	}
+	if code, _ := checkAndMake("guid-4", "missing"); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for an unknown template, got %d", http.StatusNotFound, code)
+	}
+
+	member, err := tenants.Membership(db, user.ID, first.ID)
+	if err != nil || member.Role != models.RoleOwner {
+		t.Errorf("Expected the creator to own the company, got %+v (%v)", member, err)
+	}
+	if codes, _ := features.Permissions(db, user.ID, first.ID); len(codes) != 1 || codes[0] != "sales.report" {
+		t.Errorf("Expected the template's features to be granted, got %v", codes)
+	}
+}
+
+// TestTenantLifecycle checks state transitions, their effect on token resolution and the purge of deleted tenants
+func TestTenantLifecycle(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	tenantHandler := NewTenantHandler(db)
+	companyHandler := NewCompanyHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	db.Create(tenant)
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000001", models.UserTypeSystem)
+	user, token := createTestUser(t, db, "user@example.com", "9000000002", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: user.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+
+	transition := func(state string) int {
+		body, _ := json.Marshal(map[string]string{"state": state, "reason": "Invoice overdue"})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/tenants/state?tenantId=%d", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("token", adminToken.Value.String())
+		return executeRequest(req, authHandler.RequireSystemUser(tenantHandler.ChangeTenantState)).Code
+	}
+	resolve := func() (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, "/token/validate", nil)
+		req.Header.Set("token", token.Value.String())
+		req.Header.Set("companyid", "acme")
+		rr := executeRequest(req, authHandler.ResolveTenant)
+		var info models.TokenTenantInfo
+		json.Unmarshal(rr.Body.Bytes(), &info)
+		return rr.Code, info.Code
+	}
+
+	if code := transition(models.TenantSuspended); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if status, code := resolve(); status != http.StatusForbidden || code != models.CodeTenantSuspended {
+		t.Errorf("Expected a %s rejection, got %d %s", models.CodeTenantSuspended, status, code)
+	}
+	if code := transition(models.TenantProvisioning); code != http.StatusConflict {
+		t.Errorf("Expected status code %d for an invalid transition, got %d", http.StatusConflict, code)
+	}
+	if code := transition(models.TenantDeleted); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/companies?id=%d", user.ID), nil)
+	if rr := executeRequest(req, companyHandler.GetCompanies); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected deleted companies to be hidden, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	var changes int64
+	db.Model(&models.TenantStateChange{}).Where("tenant_id = ?", tenant.ID).Count(&changes)
+	if changes != 2 {
+		t.Errorf("Expected 2 recorded transitions, got %d", changes)
+	}
+
+	if count, _ := tenants.PurgeDeleted(db, time.Now()); count != 0 {
+		t.Errorf("Expected nothing to be purged inside the retention window, got %d", count)
+	}
+	if count, err := tenants.PurgeDeleted(db, time.Now().Add(tenantHandler.Retention+time.Minute)); err != nil || count != 1 {
+		t.Fatalf("Expected 1 purged tenant, got %d (%v)", count, err)
+	}
+	var mappings int64
+	db.Model(&models.UserTenantMapping{}).Where("tenant_id = ?", tenant.ID).Count(&mappings)
+	if mappings != 0 {
+		t.Errorf("Expected the memberships to be purged, found %d", mappings)
+	}
+	if code := transition(models.TenantArchived); code != http.StatusConflict {
+		t.Errorf("Expected a purged tenant not to be restorable, got %d", code)
+	}
+}
+
+// TestDefaultTemplateRequired checks that the default template can only lose its flag to another template
+func TestDefaultTemplateRequired(t *testing.T) {
+	db := SetupTestDB(t)
+	templateHandler := NewTemplateHandler(db)
+
+	save := func(method, url string, spec map[string]interface{}) (int, tenants.TemplateSpec) {
+		body, _ := json.Marshal(spec)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		handler := templateHandler.CreateTemplate
+		if method == http.MethodPut {
+			handler = templateHandler.UpdateTemplate
+		}
+		rr := executeRequest(req, handler)
+		var saved tenants.TemplateSpec
+		json.Unmarshal(rr.Body.Bytes(), &saved)
+		return rr.Code, saved
+	}
+	remove := func(id uint32) int {
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("/templates/delete?id=%d", id), nil)
+		return executeRequest(req, templateHandler.DeleteTemplate).Code
+	}
+
+	_, standard := save(http.MethodPost, "/templates/create", map[string]interface{}{"Name": "standard", "IsDefault": true, "Hosts": []string{"std-1.local"}})
+	_, premium := save(http.MethodPost, "/templates/create", map[string]interface{}{"Name": "premium", "Hosts": []string{"pro-1.local"}})
+
+	if code, _ := save(http.MethodPut, fmt.Sprintf("/templates/update?id=%d", standard.ID), map[string]interface{}{"Name": "standard", "IsDefault": false}); code != http.StatusConflict {
+		t.Errorf("Expected clearing the default flag to fail with %d, got %d", http.StatusConflict, code)
+	}
+	if code := remove(standard.ID); code != http.StatusConflict {
+		t.Errorf("Expected deleting the default template to fail with %d, got %d", http.StatusConflict, code)
+	}
+
+	// Moving the flag to another template frees the old default
+	if code, _ := save(http.MethodPut, fmt.Sprintf("/templates/update?id=%d", premium.ID), map[string]interface{}{"Name": "premium", "IsDefault": true}); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := remove(standard.ID); code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	var defaults []models.ProvisioningTemplate
+	db.Where("is_default = ?", true).Find(&defaults)
+	if len(defaults) != 1 || defaults[0].ID != premium.ID {
+		t.Errorf("Expected premium to be the only default template, got %+v", defaults)
+	}
+}
@@ -0,0 +1,365 @@
+package v1
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/notify"
+	"sg-portal/internal/organizations"
+	"sg-portal/internal/tenants"
+	"sg-portal/internal/users"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+)
+
+const (
+	transferCodeLength      = 8
+	transferCodeTTL         = 7 * 24 * time.Hour
+	transferCodeMaxAttempts = 5
+)
+
+type TransferHandler struct {
+	TenantRepo   *util.Repository[models.Tenant]
+	UserRepo     *util.Repository[models.User]
+	TransferRepo *util.Repository[models.OwnershipTransfer]
+	db           *gorm.DB
+}
+
+// NewTransferHandler initializes the TransferHandler with the repositories
+func NewTransferHandler(db *gorm.DB) *TransferHandler {
+	return &TransferHandler{
+		TenantRepo:   util.NewRepository[models.Tenant](db),
+		UserRepo:     util.NewRepository[models.User](db),
+		TransferRepo: util.NewRepository[models.OwnershipTransfer](db),
+		db:           db,
+	}
+}
+
+// isOwner reports whether the user owns the tenant
+func (h *TransferHandler) isOwner(userID, tenantID uint64) bool {
+	member, err := tenants.ActiveMembership(h.db, userID, tenantID)
+	return err == nil && member.Role == models.RoleOwner
+}
+
+// StartTransfer offers ownership of a tenant to another user by email or mobile number
+// (?tenantId=, body {"email": "...", "keep_as_member": true}). Only owners can start a transfer.
+func (h *TransferHandler) StartTransfer(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body, err := util.ParseJSONBody[struct {
+		Email        string `json:"email"`
+		Mobile       string `json:"mobile"`
+		KeepAsMember bool   `json:"keep_as_member"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	email, mobile := strings.TrimSpace(body.Email), strings.TrimSpace(body.Mobile)
+
+	tenant, err := h.TenantRepo.GetByField("id", tenantId)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Tenant not found")
+		return
+	}
+	if !h.isOwner(actorID, tenantId) {
+		util.HandleError(w, http.StatusForbidden, "Only company owners can transfer ownership")
+		return
+	}
+	if code, status := tenants.StateRejection(tenant.State); code != "" {
+		util.RespondJSON(w, status, &models.GenericResponseMessage{Message: "The company is " + tenant.State, Code: code})
+		return
+	}
+	actor, err := h.UserRepo.GetByField("id", actorID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	// The recipient may not have registered yet; a known one is recorded so only they can accept
+	var channel, recipient string
+	var existing []models.User
+	switch {
+	case email != "" && mobile != "":
+		util.HandleError(w, http.StatusBadRequest, "Provide either an email or a mobile number")
+		return
+	case email != "":
+		if !util.IsValidEmail(email) {
+			util.HandleError(w, http.StatusBadRequest, "Invalid email")
+			return
+		}
+		channel, recipient = notify.ChannelEmail, email
+		existing, err = h.UserRepo.GetAllByCondition("email = ?", email)
+	case mobile != "":
+		recipient, err = users.NormalizeMobile(h.db, actor.CountryID, mobile)
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, "Invalid mobile number: "+err.Error())
+			return
+		}
+		channel = notify.ChannelMobile
+		existing, err = h.UserRepo.GetAllByCondition("mobile_number IN ?", users.MobileLookupCandidates(h.db, actor.CountryID, recipient))
+	default:
+		util.HandleError(w, http.StatusBadRequest, "The recipient's email or mobile number is required")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error looking up the recipient")
+		return
+	}
+	var toUserId *uint64
+	if len(existing) > 0 {
+		toUserId = &existing[0].ID
+		if existing[0].ID == actorID {
+			util.HandleError(w, http.StatusBadRequest, "You cannot transfer ownership to yourself")
+			return
+		}
+		if h.isOwner(existing[0].ID, tenantId) {
+			util.HandleError(w, http.StatusBadRequest, "The recipient already owns the company")
+			return
+		}
+	}
+
+	code, err := util.GenerateNumericCode(transferCodeLength)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error generating confirmation code")
+		return
+	}
+	salt, err := models.GenerateSalt()
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error generating salt")
+		return
+	}
+	codeHash, err := models.HashPassword(code, salt)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error hashing confirmation code")
+		return
+	}
+
+	transfer := &models.OwnershipTransfer{
+		TenantId:     tenantId,
+		FromUserId:   actorID,
+		ToUserId:     toUserId,
+		Channel:      channel,
+		Recipient:    recipient,
+		KeepAsMember: body.KeepAsMember,
+		Status:       models.TransferPending,
+		CodeHash:     codeHash,
+		Salt:         salt,
+		ExpiresAt:    time.Now().Add(transferCodeTTL),
+	}
+	errPending := errors.New("transfer already pending")
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// A tenant has at most one open transfer; it must be cancelled before another is started
+		pending, err := util.NewRepository[models.OwnershipTransfer](tx).
+			GetAllByCondition("tenant_id = ? AND status = ? AND expires_at > ?", tenantId, models.TransferPending, time.Now())
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return errPending
+		}
+		return util.NewRepository[models.OwnershipTransfer](tx).Create(transfer)
+	})
+	if errors.Is(err, errPending) {
+		util.HandleError(w, http.StatusConflict, "An ownership transfer is already pending for this company")
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error creating ownership transfer")
+		return
+	}
+
+	if err := notify.Send(channel, recipient, "Ownership of "+tenant.CompanyName+" offered to you",
+		"You have been offered ownership of "+tenant.CompanyName+". Sign in or register with this "+channel+
+			" and accept transfer "+strconv.FormatUint(transfer.ID, 10)+" with the code "+code+
+			". It expires in 7 days."); err != nil {
+		// A transfer nobody was told about would block new ones until it expires, so it is cancelled
+		if err := h.TransferRepo.UpdateOne("id", transfer.ID, map[string]interface{}{"status": models.TransferCancelled, "cancelled_at": time.Now()}); err != nil {
+			log.Printf("[!] Could not cancel undelivered ownership transfer %d: %v\n", transfer.ID, err)
+		}
+		util.HandleError(w, http.StatusBadGateway, "Could not deliver the confirmation code; the transfer was cancelled")
+		return
+	}
+
+	util.RespondJSON(w, http.StatusAccepted, transfer)
+}
+
+// recipientMatches reports whether the user is the one the transfer was offered to
+func (h *TransferHandler) recipientMatches(transfer *models.OwnershipTransfer, user *models.User) bool {
+	if transfer.ToUserId != nil {
+		return *transfer.ToUserId == user.ID
+	}
+	if transfer.Channel == notify.ChannelEmail {
+		return strings.EqualFold(user.Email, transfer.Recipient)
+	}
+	mobile, err := users.NormalizeMobile(h.db, user.CountryID, user.MobileNumber)
+	return err == nil && mobile == transfer.Recipient
+}
+
+// AcceptTransfer completes a pending transfer for the signed-in recipient (body {"id": 1, "code": "..."})
+func (h *TransferHandler) AcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	userID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	confirmation, err := util.ParseJSONBody[struct {
+		ID   uint64 `json:"id"`
+		Code string `json:"code"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+
+	pending, err := h.TransferRepo.GetAllByCondition("id = ? AND status = ?", confirmation.ID, models.TransferPending)
+	if err != nil || len(pending) < 1 {
+		util.HandleError(w, http.StatusNotFound, "No pending ownership transfer found")
+		return
+	}
+	transfer := &pending[0]
+
+	user, err := h.UserRepo.GetByField("id", userID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if !h.recipientMatches(transfer, user) {
+		util.HandleError(w, http.StatusForbidden, "The transfer was offered to someone else")
+		return
+	}
+
+	// Each guess claims one of the attempts before the code is checked, so parallel guesses share the limit
+	claimed := h.db.Model(&models.OwnershipTransfer{}).
+		Where("id = ? AND attempts < ? AND expires_at > ?", transfer.ID, transferCodeMaxAttempts, time.Now()).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if claimed.Error != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error transferring ownership")
+		return
+	}
+	if claimed.RowsAffected != 1 {
+		h.TransferRepo.UpdateOne("id", transfer.ID, map[string]interface{}{"status": models.TransferExpired})
+		util.HandleError(w, http.StatusGone, "The ownership transfer has expired")
+		return
+	}
+	if err := models.ValidatePassword(confirmation.Code, transfer.Salt, transfer.CodeHash); err != nil {
+		util.HandleError(w, http.StatusUnauthorized, "Invalid confirmation code")
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tenants.AcceptTransfer(tx, transfer, userID, time.Now()); err != nil {
+			return err
+		}
+		// A removed owner keeps any access their organization membership gives them
+		return organizations.SyncForTenant(tx, transfer.TenantId)
+	})
+	if errors.Is(err, tenants.ErrTransferNotPending) {
+		util.HandleError(w, http.StatusConflict, "The ownership transfer is no longer pending")
+		return
+	}
+	if errors.Is(err, tenants.ErrTransferStale) {
+		util.HandleError(w, http.StatusConflict, "The user who offered the company no longer owns it")
+		return
+	}
+	if respondSeatError(w, err) {
+		return
+	}
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error transferring ownership")
+		return
+	}
+	util.RespondJSON(w, http.StatusOK, transfer)
+}
+
+// CancelTransfer withdraws a pending transfer (?id=). The initiator, any owner of the company and
+// system users can cancel it until it has been accepted.
+func (h *TransferHandler) CancelTransfer(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	id, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	transfer, err := h.TransferRepo.GetByField("id", id)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "Ownership transfer not found")
+		return
+	}
+	userType, _ := util.UserTypeFromContext(r.Context())
+	if userType != models.UserTypeSystem && transfer.FromUserId != actorID && !h.isOwner(actorID, transfer.TenantId) {
+		util.HandleError(w, http.StatusForbidden, "Only company owners can cancel an ownership transfer")
+		return
+	}
+
+	now := time.Now()
+	cancelled := h.db.Model(&models.OwnershipTransfer{}).
+		Where("id = ? AND status = ?", id, models.TransferPending).
+		Updates(map[string]interface{}{"status": models.TransferCancelled, "cancelled_at": now, "cancelled_by": actorID})
+	if cancelled.Error != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error cancelling ownership transfer")
+		return
+	}
+	if cancelled.RowsAffected != 1 {
+		util.HandleError(w, http.StatusConflict, "The ownership transfer is no longer pending")
+		return
+	}
+
+	transfer.Status = models.TransferCancelled
+	transfer.CancelledAt = &now
+	transfer.CancelledBy = &actorID
+	util.RespondJSON(w, http.StatusOK, transfer)
+}
+
+// GetTransfers returns every ownership transfer of a tenant, newest first (?tenantId=).
+// Owners and admins of the company and system users can read them.
+func (h *TransferHandler) GetTransfers(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	tenantId, err := util.ParseUintParam(r, "tenantId")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if userType, _ := util.UserTypeFromContext(r.Context()); userType != models.UserTypeSystem {
+		member, err := tenants.ActiveMembership(h.db, actorID, tenantId)
+		if err != nil || !tenants.CanManageMembers(member.Role) {
+			util.HandleError(w, http.StatusForbidden, "Only company owners and admins can view ownership transfers")
+			return
+		}
+	}
+
+	transfers, err := h.TransferRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantId).Order("created_at DESC, id DESC")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching ownership transfers")
+		return
+	}
+	now := time.Now()
+	for i := range transfers {
+		if transfers[i].Status == models.TransferPending && now.After(transfers[i].ExpiresAt) {
+			transfers[i].Status = models.TransferExpired
+		}
+	}
+	util.RespondJSON(w, http.StatusOK, &transfers)
+}
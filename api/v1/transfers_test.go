@@ -0,0 +1,239 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+	"sg-portal/internal/notify"
+	"sg-portal/internal/tenants"
+)
+
+// recordingSender keeps the last message, and the last one to each recipient, so tests can read
+// confirmation codes
+type recordingSender struct {
+	to, body string
+	sent     map[string]string
+}
+
+func (s *recordingSender) Send(channel, to, subject, body string) error {
+	s.to, s.body = to, body
+	if s.sent == nil {
+		s.sent = map[string]string{}
+	}
+	s.sent[to] = body
+	return nil
+}
+
+// TestOwnershipTransfer checks that a transfer to a not yet registered user moves ownership, grants
+// and subscriptions once accepted with the code, and that transfers can be cancelled until then
+func TestOwnershipTransfer(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	transferHandler := NewTransferHandler(db)
+	sender := &recordingSender{}
+	notify.Default = sender
+	defer func() { notify.Default = notify.LogSender{} }()
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	admin, adminToken := createTestUser(t, db, "admin@example.com", "9000000002", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	db.Create(&models.UserTenantMapping{UserId: admin.ID, TenantId: tenant.ID, Role: models.RoleAdmin})
+	feature := &models.Feature{Name: "Sales report", Permission: "sales.report"}
+	db.Create(feature)
+	pro := &models.Subscription{Name: "Pro", Code: "pro"}
+	db.Create(pro)
+	db.Create(&models.FeatureSubscriptionMapping{FeatureId: feature.ID, SubscriptionId: pro.ID})
+	db.Create(&models.UserSubscriptionMapping{UserId: owner.ID, SubscriptionId: pro.ID})
+	db.Create(&models.UserFeatureMapping{UserId: owner.ID, TenantId: tenant.ID, FeatureId: feature.ID})
+
+	start := func(token *models.Token, email string) (int, models.OwnershipTransfer) {
+		body, _ := json.Marshal(map[string]interface{}{"email": email, "keep_as_member": true})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/tenants/transfers?tenantId=%d", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(transferHandler.StartTransfer))
+		var transfer models.OwnershipTransfer
+		json.Unmarshal(rr.Body.Bytes(), &transfer)
+		return rr.Code, transfer
+	}
+	accept := func(token *models.Token, id uint64, code string) int {
+		body, _ := json.Marshal(map[string]interface{}{"id": id, "code": code})
+		req, _ := http.NewRequest(http.MethodPost, "/tenants/transfers/accept", bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.Authenticate(transferHandler.AcceptTransfer)).Code
+	}
+	cancel := func(token *models.Token, id uint64) int {
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/tenants/transfers/cancel?id=%d", id), nil)
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.Authenticate(transferHandler.CancelTransfer)).Code
+	}
+
+	// Only owners can offer the company
+	if code, _ := start(adminToken, "new@example.com"); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for an admin, got %d", http.StatusForbidden, code)
+	}
+	code, transfer := start(ownerToken, "new@example.com")
+	if code != http.StatusAccepted || transfer.ToUserId != nil || sender.to != "new@example.com" {
+		t.Fatalf("Expected a pending transfer to an unregistered user, got %d %+v", code, transfer)
+	}
+	confirmation := regexp.MustCompile(`code (\d+)`).FindStringSubmatch(sender.body)[1]
+	if code, _ := start(ownerToken, "other@example.com"); code != http.StatusConflict {
+		t.Errorf("Expected status code %d while a transfer is pending, got %d", http.StatusConflict, code)
+	}
+
+	// The recipient registers and accepts; nobody else can
+	recipient, recipientToken := createTestUser(t, db, "new@example.com", "9000000003", models.UserTypeClient)
+	if code := accept(adminToken, transfer.ID, confirmation); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for someone else, got %d", http.StatusForbidden, code)
+	}
+	if code := accept(recipientToken, transfer.ID, "00000000"); code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for a wrong code, got %d", http.StatusUnauthorized, code)
+	}
+	if code := accept(recipientToken, transfer.ID, confirmation); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+
+	roles := map[uint64]string{}
+	var mappings []models.UserTenantMapping
+	db.Where("tenant_id = ?", tenant.ID).Find(&mappings)
+	for _, mapping := range mappings {
+		roles[mapping.UserId] = mapping.Role
+	}
+	if roles[recipient.ID] != models.RoleOwner || roles[owner.ID] != models.RoleMember {
+		t.Errorf("Expected the recipient to own the company and the old owner to stay a member, got %v", roles)
+	}
+	var grants, linked, transferred int64
+	db.Model(&models.UserFeatureMapping{}).Where("user_id = ? AND tenant_id = ?", recipient.ID, tenant.ID).Count(&grants)
+	db.Model(&models.UserSubscriptionMapping{}).Where("user_id = ? AND subscription_id = ?", recipient.ID, pro.ID).Count(&linked)
+	db.Model(&models.Event{}).Where("type = ?", events.TenantOwnershipTransferred).Count(&transferred)
+	if grants != 1 || linked != 1 || transferred != 1 {
+		t.Errorf("Expected the grant, subscription and event to follow the transfer, got %d %d %d", grants, linked, transferred)
+	}
+	if code := cancel(recipientToken, transfer.ID); code != http.StatusConflict {
+		t.Errorf("Expected status code %d cancelling an accepted transfer, got %d", http.StatusConflict, code)
+	}
+
+	// A cancelled transfer cannot be accepted
+	_, second := start(recipientToken, "admin@example.com")
+	if code := cancel(recipientToken, second.ID); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := accept(adminToken, second.ID, regexp.MustCompile(`code (\d+)`).FindStringSubmatch(sender.body)[1]); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a cancelled transfer, got %d", http.StatusNotFound, code)
+	}
+
+	// Once the attempts are used up the right code is refused and the transfer expires
+	_, third := start(recipientToken, "admin@example.com")
+	thirdCode := regexp.MustCompile(`code (\d+)`).FindStringSubmatch(sender.body)[1]
+	for i := 0; i < transferCodeMaxAttempts; i++ {
+		accept(adminToken, third.ID, "00000000")
+	}
+	if code := accept(adminToken, third.ID, thirdCode); code != http.StatusGone {
+		t.Errorf("Expected status code %d after too many attempts, got %d", http.StatusGone, code)
+	}
+}
+
+// TestTransferSeats checks that a recipient joining while the initiator stays needs a free seat and
+// that a recipient whose seat was deactivated becomes an active owner
+func TestTransferSeats(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	transferHandler := NewTransferHandler(db)
+	sender := &recordingSender{}
+	notify.Default = sender
+	defer func() { notify.Default = notify.LogSender{} }()
+
+	limit := 2
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", SeatLimit: &limit}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	member, memberToken := createTestUser(t, db, "member@example.com", "9000000002", models.UserTypeClient)
+	_, outsiderToken := createTestUser(t, db, "outsider@example.com", "9000000003", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+	db.Create(&models.UserTenantMapping{UserId: member.ID, TenantId: tenant.ID, Role: models.RoleMember})
+
+	transfer := func(email string, keepAsMember bool) (uint64, string) {
+		body, _ := json.Marshal(map[string]interface{}{"email": email, "keep_as_member": keepAsMember})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/tenants/transfers?tenantId=%d", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("token", ownerToken.Value.String())
+		rr := executeRequest(req, authHandler.Authenticate(transferHandler.StartTransfer))
+		var started models.OwnershipTransfer
+		json.Unmarshal(rr.Body.Bytes(), &started)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected status code %d starting the transfer, got %d", http.StatusAccepted, rr.Code)
+		}
+		return started.ID, regexp.MustCompile(`code (\d+)`).FindStringSubmatch(sender.body)[1]
+	}
+	accept := func(token *models.Token, id uint64, code string) int {
+		body, _ := json.Marshal(map[string]interface{}{"id": id, "code": code})
+		req, _ := http.NewRequest(http.MethodPost, "/tenants/transfers/accept", bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.Authenticate(transferHandler.AcceptTransfer)).Code
+	}
+
+	// Both seats are taken, so an outsider cannot join while the owner stays on
+	id, code := transfer("outsider@example.com", true)
+	if status := accept(outsiderToken, id, code); status != http.StatusConflict {
+		t.Errorf("Expected status code %d with every seat taken, got %d", http.StatusConflict, status)
+	}
+	var pending models.OwnershipTransfer
+	db.First(&pending, id)
+	if pending.Status != models.TransferPending {
+		t.Errorf("Expected the refused transfer to stay pending, got %s", pending.Status)
+	}
+	db.Model(&pending).Update("status", models.TransferCancelled)
+
+	// A deactivated member takes over the seat of the owner who leaves
+	db.Model(&models.UserTenantMapping{}).Where("user_id = ?", member.ID).Update("deactivated_at", time.Now())
+	id, code = transfer("member@example.com", false)
+	if status := accept(memberToken, id, code); status != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, status)
+	}
+	if m, err := tenants.Membership(db, member.ID, tenant.ID); err != nil || m.Role != models.RoleOwner || m.DeactivatedAt != nil {
+		t.Errorf("Expected the recipient to be an active owner, got %+v %v", m, err)
+	}
+}
+
+// TestUndeliveredTransfer checks that a transfer whose code cannot be delivered is cancelled and
+// does not block the next one
+func TestUndeliveredTransfer(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	transferHandler := NewTransferHandler(db)
+
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme"}
+	db.Create(tenant)
+	owner, ownerToken := createTestUser(t, db, "owner@example.com", "9000000001", models.UserTypeClient)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+
+	start := func() int {
+		body, _ := json.Marshal(map[string]interface{}{"email": "new@example.com"})
+		req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/tenants/transfers?tenantId=%d", tenant.ID), bytes.NewBuffer(body))
+		req.Header.Set("token", ownerToken.Value.String())
+		return executeRequest(req, authHandler.Authenticate(transferHandler.StartTransfer)).Code
+	}
+
+	notify.Default = failingSender{}
+	defer func() { notify.Default = notify.LogSender{} }()
+	if code := start(); code != http.StatusBadGateway {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadGateway, code)
+	}
+	var transfer models.OwnershipTransfer
+	db.Where("tenant_id = ?", tenant.ID).First(&transfer)
+	if transfer.Status != models.TransferCancelled || transfer.CancelledAt == nil {
+		t.Errorf("Expected the undelivered transfer to be cancelled, got %+v", transfer)
+	}
+
+	notify.Default = notify.LogSender{}
+	if code := start(); code != http.StatusAccepted {
+		t.Errorf("Expected status code %d for a new transfer, got %d", http.StatusAccepted, code)
+	}
+}
@@ -2,24 +2,38 @@ package v1
 
 import (
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"sg-portal/internal/models"
+	"sg-portal/internal/users"
 	"sg-portal/pkg/util"
+	"strconv"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type UserHandler struct {
-	UserRepo         *util.Repository[models.User]
-	UserPasswordRepo *util.Repository[models.UserPassword]
+	UserRepo          *util.Repository[models.User]
+	UserPasswordRepo  *util.Repository[models.UserPassword]
+	SuspensionRepo    *util.Repository[models.UserSuspension]
+	ContactChangeRepo *util.Repository[models.ContactChangeRequest]
+	ErasureRepo       *util.Repository[models.UserErasure]
+	MergeRepo         *util.Repository[models.UserMerge]
+	db                *gorm.DB
 }
 
 // NewUserHandler initializes the UserHandler with the user and user password repositories.
 func NewUserHandler(db *gorm.DB) *UserHandler {
 	return &UserHandler{
-		UserRepo:         util.NewRepository[models.User](db),
-		UserPasswordRepo: util.NewRepository[models.UserPassword](db),
+		UserRepo:          util.NewRepository[models.User](db),
+		UserPasswordRepo:  util.NewRepository[models.UserPassword](db),
+		SuspensionRepo:    util.NewRepository[models.UserSuspension](db),
+		ContactChangeRepo: util.NewRepository[models.ContactChangeRequest](db),
+		ErasureRepo:       util.NewRepository[models.UserErasure](db),
+		MergeRepo:         util.NewRepository[models.UserMerge](db),
+		db:                db,
 	}
 }
 
@@ -43,10 +57,87 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 	util.RespondJSON(w, http.StatusOK, user)
 }
 
-// GetAllUsers retrieves all users from the database.
+// UserFilter holds the optional query filters shared by the user list and export endpoints.
+type UserFilter struct {
+	Type           string
+	Active         *bool
+	TenantId       uint64
+	SubscriptionId uint64
+	Search         string
+}
+
+// parseUserFilter reads the user filters from the query string (e.g. ?type=client&active=true&tenantId=4)
+func parseUserFilter(r *http.Request) (*UserFilter, error) {
+	query := r.URL.Query()
+	filter := &UserFilter{
+		Type:   query.Get("type"),
+		Search: strings.TrimSpace(query.Get("search")),
+	}
+
+	if filter.Type != "" && filter.Type != models.UserTypeClient && filter.Type != models.UserTypeSystem {
+		return nil, errors.New("invalid parameter: type")
+	}
+
+	if query.Has("active") {
+		active, err := strconv.ParseBool(query.Get("active"))
+		if err != nil {
+			return nil, errors.New("invalid parameter: active")
+		}
+		filter.Active = &active
+	}
+
+	if query.Has("tenantId") {
+		tenantId, err := util.ParseUintParam(r, "tenantId")
+		if err != nil {
+			return nil, err
+		}
+		filter.TenantId = tenantId
+	}
+
+	if query.Has("subscriptionId") {
+		subscriptionId, err := util.ParseUintParam(r, "subscriptionId")
+		if err != nil {
+			return nil, err
+		}
+		filter.SubscriptionId = subscriptionId
+	}
+
+	return filter, nil
+}
+
+// Scope applies the filter to a query on the users table
+func (f *UserFilter) Scope(db *gorm.DB) *gorm.DB {
+	if f.Type != "" {
+		db = db.Where("type = ?", f.Type)
+	}
+	if f.Active != nil {
+		db = db.Where("is_active = ?", *f.Active)
+	}
+	if f.TenantId != 0 {
+		db = db.Where("id IN (?)", db.Session(&gorm.Session{NewDB: true}).
+			Model(&models.UserTenantMapping{}).Select("user_id").Where("tenant_id = ?", f.TenantId))
+	}
+	if f.SubscriptionId != 0 {
+		db = db.Where("id IN (?)", db.Session(&gorm.Session{NewDB: true}).
+			Model(&models.UserSubscriptionMapping{}).Select("user_id").Where("subscription_id = ?", f.SubscriptionId))
+	}
+	if f.Search != "" {
+		pattern := "%" + strings.ToLower(f.Search) + "%"
+		db = db.Where("LOWER(name) LIKE ? OR LOWER(email) LIKE ? OR mobile_number LIKE ?", pattern, pattern, pattern)
+	}
+	return db
+}
+
+// GetAllUsers retrieves all users matching the optional filters from the database.
 func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	// Fetch all users
-	users, err := h.UserRepo.GetAllByCondition("1 = 1")
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Fetch the matching users
+	users, err := h.UserRepo.GetAllByScopes(filter.Scope)
 	if err != nil {
 		util.HandleError(w, http.StatusInternalServerError, "Error fetching users")
 		return
@@ -56,6 +147,14 @@ func (h *UserHandler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 	util.RespondJSON(w, http.StatusOK, &users)
 }
 
+// editableUserColumns are the user columns UpdateUser accepts
+var editableUserColumns = map[string]bool{
+	"name":          true,
+	"email":         true,
+	"mobile_number": true,
+	"country_id":    true,
+}
+
 // UpdateUser updates the details of an existing user.
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from URL query parameters (e.g., ?id=1)
@@ -78,8 +177,59 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Activation goes through suspend/reactivate so it is always recorded, and system users
+	// are created through /users/system, so only the contact details can be edited here
+	for key := range *userUpdates {
+		if key == "is_active" {
+			util.HandleError(w, http.StatusBadRequest, "Use /user/suspend or /user/reactivate to change is_active")
+			return
+		}
+		if !editableUserColumns[key] {
+			util.HandleError(w, http.StatusBadRequest, "Field cannot be updated: "+key)
+			return
+		}
+	}
+
+	// Keep mobile numbers in E.164 form for the user's (possibly new) country
+	_, mobileChanged := (*userUpdates)["mobile_number"]
+	_, countryChanged := (*userUpdates)["country_id"]
+	var countryID *int
+	if mobileChanged || countryChanged {
+		user, err := h.UserRepo.GetByField("id", userID)
+		if err != nil {
+			util.HandleError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		countryID = user.CountryID
+		if countryChanged {
+			value, ok := (*userUpdates)["country_id"].(float64)
+			if !ok {
+				util.HandleError(w, http.StatusBadRequest, "Invalid country")
+				return
+			}
+			id := int(value)
+			countryID = &id
+		}
+		mobile := user.MobileNumber
+		if mobileChanged {
+			mobile, _ = (*userUpdates)["mobile_number"].(string)
+		}
+		normalized, err := users.NormalizeMobile(h.db, countryID, mobile)
+		if err != nil {
+			util.HandleError(w, http.StatusBadRequest, "Invalid mobile number: "+err.Error())
+			return
+		}
+		(*userUpdates)["mobile_number"] = normalized
+	}
+
 	// Apply the updates to the user by ID
 	if err := h.UserRepo.UpdateOne("id", userID, *userUpdates); err != nil {
+		if util.IsDuplicateKeyError(err) {
+			email, _ := (*userUpdates)["email"].(string)
+			mobile, _ := (*userUpdates)["mobile_number"].(string)
+			respondConflict(w, contactConflict(h.UserRepo, email, mobile, countryID, userID))
+			return
+		}
 		util.HandleError(w, http.StatusInternalServerError, "Error updating user")
 		return
 	}
@@ -88,14 +238,23 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// DeleteUser deletes a user by their ID.
+// DeleteUser deletes a user by their ID. The same users as for EraseUser are refused.
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
 	// Extract user ID from URL query parameters (e.g., ?id=1)
 	userID, err := util.ParseUintParam(r, "id")
 	if err != nil {
 		util.HandleError(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
+	if !h.checkRemovable(w, actorID, userID) {
+		return
+	}
 
 	// Delete the user by ID
 	if err := h.UserRepo.Delete("id = ?", userID); err != nil {
@@ -159,6 +318,10 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		util.HandleError(w, http.StatusInternalServerError, "Invalid Email")
 		return
 	}
+	if !userInfo.IsActive {
+		respondSuspended(w)
+		return
+	}
 
 	// Fetch the current user password details
 	userPassword, err := h.UserPasswordRepo.GetByField("user_id", userInfo.ID)
@@ -198,3 +361,146 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	// Respond with success
 	w.WriteHeader(http.StatusOK)
 }
+
+// SuspendUser deactivates a user, records the reason and the acting admin and revokes their tokens.
+// An optional reactivate_at schedules the automatic reactivation.
+func (h *UserHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	if userID == actorID {
+		util.HandleError(w, http.StatusBadRequest, "Users cannot suspend themselves")
+		return
+	}
+
+	body, err := util.ParseJSONBody[struct {
+		Reason       string     `json:"reason"`
+		ReactivateAt *time.Time `json:"reactivate_at"`
+	}](w, r)
+	if err != nil {
+		return // Error already handled by ParseJSONBody
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" {
+		util.HandleError(w, http.StatusBadRequest, "A suspension reason is required")
+		return
+	}
+	if body.ReactivateAt != nil && !body.ReactivateAt.After(time.Now()) {
+		util.HandleError(w, http.StatusBadRequest, "reactivate_at must be in the future")
+		return
+	}
+
+	user, err := h.UserRepo.GetByField("id", userID)
+	if err != nil {
+		util.HandleError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if !user.IsActive {
+		util.HandleError(w, http.StatusConflict, "User is already suspended")
+		return
+	}
+
+	suspension := &models.UserSuspension{
+		UserId:       userID,
+		Reason:       body.Reason,
+		SuspendedBy:  actorID,
+		SuspendedAt:  time.Now(),
+		ReactivateAt: body.ReactivateAt,
+	}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := util.NewRepository[models.UserSuspension](tx).Create(suspension); err != nil {
+			return err
+		}
+		if err := util.NewRepository[models.User](tx).UpdateOne("id", userID, map[string]interface{}{"is_active": false}); err != nil {
+			return err
+		}
+		return users.RevokeTokens(tx, userID)
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error suspending user")
+		return
+	}
+
+	util.RespondJSON(w, http.StatusCreated, suspension)
+}
+
+// ReactivateUser lifts the user's open suspension ahead of any scheduled reactivation
+func (h *UserHandler) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := util.UserIDFromContext(r.Context())
+	if !ok {
+		util.HandleError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if erased, err := h.ErasureRepo.GetAllByCondition("user_id = ?", userID); err == nil && len(erased) > 0 {
+		util.HandleError(w, http.StatusConflict, "User has been erased")
+		return
+	}
+
+	open, err := h.SuspensionRepo.GetAllByCondition("user_id = ? AND reactivated_at IS NULL", userID)
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching suspensions")
+		return
+	}
+
+	// Users deactivated before suspensions were recorded have no suspension to lift
+	if len(open) < 1 {
+		user, err := h.UserRepo.GetByField("id", userID)
+		if err != nil {
+			util.HandleError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if user.IsActive {
+			util.HandleError(w, http.StatusNotFound, "User is not suspended")
+			return
+		}
+		if err := h.UserRepo.UpdateOne("id", userID, map[string]interface{}{"is_active": true}); err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error reactivating user")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	for i := range open {
+		if err := users.Reactivate(h.db, &open[i], &actorID, time.Now()); err != nil {
+			util.HandleError(w, http.StatusInternalServerError, "Error reactivating user")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetUserSuspensions returns the suspension history of a user, newest first
+func (h *UserHandler) GetUserSuspensions(w http.ResponseWriter, r *http.Request) {
+	userID, err := util.ParseUintParam(r, "id")
+	if err != nil {
+		util.HandleError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	suspensions, err := h.SuspensionRepo.GetAllByScopes(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID).Order("suspended_at DESC")
+	})
+	if err != nil {
+		util.HandleError(w, http.StatusInternalServerError, "Error fetching suspensions")
+		return
+	}
+
+	util.RespondJSON(w, http.StatusOK, &suspensions)
+}
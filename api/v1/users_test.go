@@ -0,0 +1,160 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/users"
+
+	"gorm.io/gorm"
+)
+
+// createTestUser stores a user together with a valid token and returns both
+func createTestUser(t *testing.T, db *gorm.DB, email, mobile, userType string) (*models.User, *models.Token) {
+	t.Helper()
+
+	user := &models.User{Email: email, Name: email, MobileNumber: mobile, Type: userType, IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	token := models.NewToken(user.ID, time.Now().Add(time.Hour))
+	if err := db.Create(token).Error; err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	return user, token
+}
+
+// TestSuspendUser checks that suspension revokes tokens, is enforced and is lifted by the scheduled job
+func TestSuspendUser(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	userHandler := NewUserHandler(db)
+
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000001", models.UserTypeSystem)
+	client, clientToken := createTestUser(t, db, "client@example.com", "9000000002", models.UserTypeClient)
+
+	// Clients cannot suspend anyone
+	body, _ := json.Marshal(map[string]interface{}{"reason": "Unpaid invoice"})
+	req, _ := http.NewRequest(http.MethodPost, "/user/suspend?id=1", bytes.NewBuffer(body))
+	req.Header.Set("token", clientToken.Value.String())
+	if rr := executeRequest(req, authHandler.RequireSystemUser(userHandler.SuspendUser)); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status code %d, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	reactivateAt := time.Now().Add(time.Hour)
+	body, _ = json.Marshal(map[string]interface{}{"reason": "Unpaid invoice", "reactivate_at": reactivateAt})
+	req, _ = http.NewRequest(http.MethodPost, "/user/suspend?id="+strconv.FormatUint(client.ID, 10), bytes.NewBuffer(body))
+	req.Header.Set("token", adminToken.Value.String())
+	if rr := executeRequest(req, authHandler.RequireSystemUser(userHandler.SuspendUser)); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	var tokens int64
+	db.Model(&models.Token{}).Where("user_id = ?", client.ID).Count(&tokens)
+	if tokens != 0 {
+		t.Errorf("Expected the suspended user's tokens to be revoked, found %d", tokens)
+	}
+
+	// A token issued before the suspension took effect is still rejected with the reason code
+	stale := models.NewToken(client.ID, time.Now().Add(time.Hour))
+	db.Create(stale)
+	req, _ = http.NewRequest(http.MethodGet, "/token/validate", nil)
+	req.Header.Set("token", stale.Value.String())
+	rr := executeRequest(req, authHandler.ResolveTenant)
+	var info models.TokenTenantInfo
+	json.Unmarshal(rr.Body.Bytes(), &info)
+	if rr.Code != http.StatusForbidden || info.Code != models.CodeUserSuspended {
+		t.Errorf("Expected %s rejection, got %d %+v", models.CodeUserSuspended, rr.Code, info)
+	}
+
+	if count, err := users.ReactivateDue(db, reactivateAt.Add(time.Minute)); err != nil || count != 1 {
+		t.Fatalf("Expected 1 scheduled reactivation, got %d (%v)", count, err)
+	}
+	var reloaded models.User
+	db.First(&reloaded, client.ID)
+	if !reloaded.IsActive {
+		t.Errorf("Expected user to be active after the scheduled reactivation")
+	}
+}
+
+// TestReactivateUserWithoutSuspension checks that users deactivated without a suspension row can be reactivated
+func TestReactivateUserWithoutSuspension(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	userHandler := NewUserHandler(db)
+
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000001", models.UserTypeSystem)
+	client, _ := createTestUser(t, db, "client@example.com", "9000000002", models.UserTypeClient)
+	db.Model(client).Update("is_active", false)
+
+	reactivate := func() int {
+		req, _ := http.NewRequest(http.MethodPost, "/user/reactivate?id="+strconv.FormatUint(client.ID, 10), nil)
+		req.Header.Set("token", adminToken.Value.String())
+		return executeRequest(req, authHandler.RequireSystemUser(userHandler.ReactivateUser)).Code
+	}
+
+	if code := reactivate(); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	var reloaded models.User
+	db.First(&reloaded, client.ID)
+	if !reloaded.IsActive {
+		t.Errorf("Expected user to be active after reactivation")
+	}
+
+	// An active user has nothing to reactivate
+	if code := reactivate(); code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, code)
+	}
+}
+
+// TestUpdateAndDeleteUser checks that only system users edit or delete users, that only contact
+// details can be edited and that deletion is refused for the same users as erasure
+func TestUpdateAndDeleteUser(t *testing.T) {
+	db := SetupTestDB(t)
+	authHandler := NewAuthHandler(db)
+	userHandler := NewUserHandler(db)
+
+	_, adminToken := createTestUser(t, db, "admin@example.com", "9000000001", models.UserTypeSystem)
+	client, clientToken := createTestUser(t, db, "client@example.com", "9000000002", models.UserTypeClient)
+	owner, _ := createTestUser(t, db, "owner@example.com", "9000000003", models.UserTypeClient)
+	tenant := &models.Tenant{CompanyGuid: "acme", CompanyName: "Acme", State: models.TenantActive}
+	db.Create(tenant)
+	db.Create(&models.UserTenantMapping{UserId: owner.ID, TenantId: tenant.ID, Role: models.RoleOwner})
+
+	call := func(token *models.Token, method string, userID uint64, updates map[string]interface{}, handler http.HandlerFunc) int {
+		body, _ := json.Marshal(updates)
+		req, _ := http.NewRequest(method, "/user?id="+strconv.FormatUint(userID, 10), bytes.NewBuffer(body))
+		req.Header.Set("token", token.Value.String())
+		return executeRequest(req, authHandler.RequireSystemUser(handler)).Code
+	}
+
+	if code := call(clientToken, http.MethodPut, client.ID, map[string]interface{}{"name": "Renamed"}, userHandler.UpdateUser); code != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a client, got %d", http.StatusForbidden, code)
+	}
+	for _, updates := range []map[string]interface{}{{"type": models.UserTypeSystem}, {"IsActive": true}, {"is_active": true}} {
+		if code := call(adminToken, http.MethodPut, client.ID, updates, userHandler.UpdateUser); code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %v, got %d", http.StatusBadRequest, updates, code)
+		}
+	}
+	if code := call(adminToken, http.MethodPut, client.ID, map[string]interface{}{"name": "Renamed"}, userHandler.UpdateUser); code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	var reloaded models.User
+	db.First(&reloaded, client.ID)
+	if reloaded.Name != "Renamed" || reloaded.Type != models.UserTypeClient {
+		t.Errorf("Unexpected user after update %+v", reloaded)
+	}
+
+	if code := call(adminToken, http.MethodDelete, owner.ID, nil, userHandler.DeleteUser); code != http.StatusConflict {
+		t.Errorf("Expected status code %d deleting a company's only owner, got %d", http.StatusConflict, code)
+	}
+	if code := call(adminToken, http.MethodDelete, client.ID, nil, userHandler.DeleteUser); code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+}
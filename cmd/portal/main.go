@@ -3,12 +3,19 @@ package main
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
 	v1 "sg-portal/api/v1"
+	"sg-portal/internal/events"
+	"sg-portal/internal/features"
 	"sg-portal/internal/models"
+	"sg-portal/internal/secrets"
+	"sg-portal/internal/settings"
+	"sg-portal/internal/tenants"
+	"sg-portal/internal/users"
 	"sg-portal/pkg/util"
 )
 
@@ -18,7 +25,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Priority, companyid, token")
 
 		// Handle preflight requests
@@ -48,20 +55,120 @@ func main() {
 		&models.Feature{}, &models.UserFeatureMapping{},
 		&models.Subscription{}, &models.UserSubscriptionMapping{},
 		&models.FeatureSubscriptionMapping{}, &models.UserSubscriptionHistory{},
+		&models.UserSuspension{}, &models.ContactChangeRequest{}, &models.LoginAttempt{},
+		&models.Country{}, &models.UserErasure{}, &models.UserMerge{},
+		&models.UserPreference{},
+		&models.Event{}, &models.TenantServiceStatus{}, &models.TenantHealthCheck{},
+		&models.ProvisioningTemplate{}, &models.TemplateHost{}, &models.TemplateFeature{},
+		&models.TemplateSubscription{}, &models.TemplateRule{},
+		&models.TenantEndpoint{}, &models.TenantStateChange{},
+		&models.OwnershipTransfer{},
+		&models.SettingDefinition{}, &models.TenantSetting{}, &models.TenantSettingRevision{},
+		&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationMemberCompany{},
+		&models.OrganizationSubscription{},
+		&models.Host{}, &models.HostLabel{}, &models.PortAllocation{},
+		&models.TenantSecret{}, &models.TenantSecretVersion{}, &models.ServiceCredential{},
+		&models.TenantInvitation{}, &models.TenantMigration{},
+		&models.UserWildcardGrant{},
 	)
 
 	if err != nil {
 		log.Fatalf("Failed to migrate database schema: %v", err)
 	}
 
+	if err := users.SeedCountries(db); err != nil {
+		log.Fatalf("Failed to seed countries: %v", err)
+	}
+	if err := users.NormalizeStoredMobiles(db); err != nil {
+		log.Fatalf("Failed to normalise stored mobile numbers: %v", err)
+	}
+	if err := tenants.EnsureOwners(db); err != nil {
+		log.Fatalf("Failed to assign tenant owners: %v", err)
+	}
+	if err := features.MigrateTenantScope(db); err != nil {
+		log.Fatalf("Failed to scope feature grants to tenants: %v", err)
+	}
+	if err := tenants.SeedDefaultTemplate(db); err != nil {
+		log.Fatalf("Failed to seed the default provisioning template: %v", err)
+	}
+	if err := tenants.EnsureEndpoints(db); err != nil {
+		log.Fatalf("Failed to register tenant endpoints: %v", err)
+	}
+	if err := settings.SeedDefinitions(db); err != nil {
+		log.Fatalf("Failed to seed tenant setting definitions: %v", err)
+	}
+	if err := users.BootstrapSystemUser(db, util.GetEnv("SGPortal_SystemUserEmail", "")); err != nil {
+		log.Fatalf("Failed to promote the first system user: %v", err)
+	}
+
+	// Tenant secrets stay unavailable until a master key is configured. Setting the previous key
+	// alongside a new one moves every stored data key under the new key at startup.
+	var vault *secrets.Vault
+	if masterKey := util.GetEnv("SGPortal_SecretsMasterKey", ""); masterKey != "" {
+		if vault, err = secrets.ParseVault(masterKey); err != nil {
+			log.Fatalf("Invalid SGPortal_SecretsMasterKey: %v", err)
+		}
+		if previousKey := util.GetEnv("SGPortal_SecretsPreviousMasterKey", ""); previousKey != "" {
+			previous, err := secrets.ParseVault(previousKey)
+			if err != nil {
+				log.Fatalf("Invalid SGPortal_SecretsPreviousMasterKey: %v", err)
+			}
+			if err := secrets.RewrapAll(db, previous, vault); err != nil {
+				log.Fatalf("Failed to rewrap tenant secrets: %v", err)
+			}
+		}
+	} else {
+		log.Printf("[!] SGPortal_SecretsMasterKey is not set; tenant secrets are unavailable\n")
+	}
+
+	// Forwarded client addresses are only believed from these proxies
+	if err := util.SetTrustedProxies(util.GetEnv("SGPortal_TrustedProxies", "")); err != nil {
+		log.Fatalf("Invalid SGPortal_TrustedProxies: %v", err)
+	}
+
+	// Background jobs
+	go users.RunReactivationJob(db, time.Minute)
+	loginRetention := time.Duration(util.GetEnvInt("SGPortal_LoginRetentionDays", 90)) * 24 * time.Hour
+	go users.RunLoginRetentionJob(db, loginRetention, time.Hour)
+	tenantRetention := time.Duration(util.GetEnvInt("SGPortal_TenantRetentionDays", 30)) * 24 * time.Hour
+	go tenants.RunPurgeJob(db, time.Hour)
+
+	prober := tenants.NewProber(db)
+	prober.Mode = util.GetEnv("SGPortal_HealthMode", tenants.ProbeTCP)
+	prober.HTTPPath = util.GetEnv("SGPortal_HealthPath", prober.HTTPPath)
+	prober.Retention = time.Duration(util.GetEnvInt("SGPortal_HealthRetentionDays", 7)) * 24 * time.Hour
+	go prober.Run(time.Duration(util.GetEnvInt("SGPortal_HealthIntervalSeconds", 60)) * time.Second)
+
+	if webhookURL := util.GetEnv("SGPortal_EventWebhookURL", ""); webhookURL != "" {
+		webhook := events.NewWebhook(db, webhookURL)
+		webhook.Secret = util.GetEnv("SGPortal_EventWebhookSecret", "")
+		go webhook.Run(time.Duration(util.GetEnvInt("SGPortal_EventWebhookIntervalSeconds", 5)) * time.Second)
+	}
+
 	// Initialize handlers
 	authHandler := v1.NewAuthHandler(db)
 	userHandler := v1.NewUserHandler(db)
 	tenantHandler := v1.NewTenantHandler(db)
+	tenantHandler.Retention = tenantRetention
 	featureHandler := v1.NewFeatureHandler(db)
 	subscriptionHandler := v1.NewSubscriptionHandler(db)
 	userSubscriptionHistoryHandler := v1.NewUserSubscriptionHistoryHandler(db)
 	companyHandler := v1.NewCompanyHandler(db)
+	exportHandler := v1.NewExportHandler(db)
+	countryHandler := v1.NewCountryHandler(db)
+	preferenceHandler := v1.NewPreferenceHandler(db)
+	healthHandler := v1.NewHealthHandler(db)
+	eventHandler := v1.NewEventHandler(db)
+	templateHandler := v1.NewTemplateHandler(db)
+	hostHandler := v1.NewHostHandler(db)
+	secretHandler := v1.NewSecretHandler(db)
+	secretHandler.Vault = vault
+	importHandler := v1.NewImportHandler(db)
+	migrationHandler := v1.NewMigrationHandler(db)
+	endpointHandler := v1.NewEndpointHandler(db)
+	transferHandler := v1.NewTransferHandler(db)
+	settingHandler := v1.NewSettingHandler(db)
+	organizationHandler := v1.NewOrganizationHandler(db)
 
 	// Define a new ServeMux to register routes
 	mux := http.NewServeMux()
@@ -76,13 +183,263 @@ func main() {
 	mux.HandleFunc("/companies/get/users", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			companyHandler.GetUserByCompany(w, r)
+			authHandler.Authenticate(companyHandler.GetUserByCompany)(w, r)
+		}
+	})
+	mux.HandleFunc("/companies/members", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.Authenticate(companyHandler.GetMembers)(w, r)
+		}
+	})
+	mux.HandleFunc("/organizations", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(organizationHandler.GetOrganizations)(w, r)
+		case http.MethodPost:
+			authHandler.Authenticate(organizationHandler.CreateOrganization)(w, r)
+		case http.MethodPut:
+			authHandler.Authenticate(organizationHandler.UpdateOrganization)(w, r)
+		case http.MethodDelete:
+			authHandler.Authenticate(organizationHandler.DeleteOrganization)(w, r)
+		}
+	})
+	mux.HandleFunc("/organizations/companies", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(organizationHandler.GetCompanies)(w, r)
+		case http.MethodPost:
+			authHandler.Authenticate(organizationHandler.AddCompany)(w, r)
+		case http.MethodDelete:
+			authHandler.Authenticate(organizationHandler.RemoveCompany)(w, r)
+		}
+	})
+	mux.HandleFunc("/organizations/members", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(organizationHandler.GetMembers)(w, r)
+		case http.MethodPut:
+			authHandler.Authenticate(organizationHandler.SetMember)(w, r)
+		case http.MethodDelete:
+			authHandler.Authenticate(organizationHandler.RemoveMember)(w, r)
+		}
+	})
+	mux.HandleFunc("/organizations/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(organizationHandler.GetSubscriptions)(w, r)
+		case http.MethodPost:
+			authHandler.RequireSystemUser(organizationHandler.AddSubscription)(w, r)
+		case http.MethodDelete:
+			authHandler.RequireSystemUser(organizationHandler.RemoveSubscription)(w, r)
 		}
 	})
 	mux.HandleFunc("/tenants/check-make", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
-			tenantHandler.CheckAndMake(w, r)
+			authHandler.Authenticate(tenantHandler.CheckAndMake)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/templates", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(templateHandler.GetTemplates)(w, r)
+		case http.MethodPost:
+			authHandler.RequireSystemUser(templateHandler.CreateTemplate)(w, r)
+		case http.MethodPut:
+			authHandler.RequireSystemUser(templateHandler.UpdateTemplate)(w, r)
+		case http.MethodDelete:
+			authHandler.RequireSystemUser(templateHandler.DeleteTemplate)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/hosts", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(hostHandler.GetHosts)(w, r)
+		case http.MethodPost:
+			authHandler.RequireSystemUser(hostHandler.CreateHost)(w, r)
+		case http.MethodPut:
+			authHandler.RequireSystemUser(hostHandler.UpdateHost)(w, r)
+		case http.MethodDelete:
+			authHandler.RequireSystemUser(hostHandler.DeleteHost)(w, r)
+		}
+	})
+	mux.HandleFunc("/hosts/utilization", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(hostHandler.GetUtilization)(w, r)
+		}
+	})
+
+	// Secrets are write-only for portal users; only service credentials read values back
+	mux.HandleFunc("/tenants/secrets", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(secretHandler.GetSecrets)(w, r)
+		case http.MethodPut:
+			authHandler.RequireSystemUser(secretHandler.PutSecret)(w, r)
+		case http.MethodDelete:
+			authHandler.RequireSystemUser(secretHandler.DeleteSecret)(w, r)
+		}
+	})
+	mux.HandleFunc("/tenants/secrets/versions", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(secretHandler.GetSecretVersions)(w, r)
+		}
+	})
+	mux.HandleFunc("/services/credentials", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(secretHandler.GetCredentials)(w, r)
+		case http.MethodPost:
+			authHandler.RequireSystemUser(secretHandler.CreateCredential)(w, r)
+		case http.MethodDelete:
+			authHandler.RequireSystemUser(secretHandler.RevokeCredential)(w, r)
+		}
+	})
+	mux.HandleFunc("/services/secrets", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			secretHandler.RevealSecret(w, r)
+		}
+	})
+
+	// Company lists exported from Tally, as XML or JSON
+	mux.HandleFunc("/tenants/import", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.Authenticate(importHandler.ImportCompanies)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/migrations", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(migrationHandler.GetMigrations)(w, r)
+		case http.MethodPost:
+			authHandler.RequireSystemUser(migrationHandler.StartMigration)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/migrations/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.RequireSystemUser(migrationHandler.ChangeMigrationState)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/endpoints", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RequireSystemUser(endpointHandler.GetEndpoints)(w, r)
+		case http.MethodPost:
+			authHandler.RequireSystemUser(endpointHandler.CreateEndpoint)(w, r)
+		case http.MethodPut:
+			authHandler.RequireSystemUser(endpointHandler.UpdateEndpoint)(w, r)
+		case http.MethodDelete:
+			authHandler.RequireSystemUser(endpointHandler.DeleteEndpoint)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.RequireSystemUser(tenantHandler.ChangeTenantState)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/state/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(tenantHandler.GetTenantStateHistory)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/transfers", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(transferHandler.GetTransfers)(w, r)
+		case http.MethodPost:
+			authHandler.Authenticate(transferHandler.StartTransfer)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/transfers/accept", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.Authenticate(transferHandler.AcceptTransfer)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/transfers/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.Authenticate(transferHandler.CancelTransfer)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/invitations", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(tenantHandler.GetInvitations)(w, r)
+		case http.MethodPost:
+			authHandler.Authenticate(tenantHandler.CreateInvitation)(w, r)
+		case http.MethodDelete:
+			authHandler.Authenticate(tenantHandler.RevokeInvitation)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/invitations/accept", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.Authenticate(tenantHandler.AcceptInvitation)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/seats", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(tenantHandler.GetSeats)(w, r)
+		case http.MethodPut:
+			authHandler.RequireSystemUser(tenantHandler.SetSeatLimit)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/seats/deactivate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.Authenticate(tenantHandler.DeactivateSeat)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/seats/activate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.Authenticate(tenantHandler.ActivateSeat)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/settings", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(settingHandler.GetSettings)(w, r)
+		case http.MethodPut:
+			authHandler.Authenticate(settingHandler.SetSettings)(w, r)
+		case http.MethodDelete:
+			authHandler.Authenticate(settingHandler.ResetSetting)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/settings/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.Authenticate(settingHandler.GetSettingHistory)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/settings/definitions", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(settingHandler.GetDefinitions)(w, r)
+		case http.MethodPost:
+			authHandler.RequireSystemUser(settingHandler.CreateDefinition)(w, r)
+		case http.MethodPut:
+			authHandler.RequireSystemUser(settingHandler.UpdateDefinition)(w, r)
+		case http.MethodDelete:
+			authHandler.RequireSystemUser(settingHandler.DeleteDefinition)(w, r)
 		}
 	})
 
@@ -92,19 +449,34 @@ func main() {
 		case http.MethodGet:
 			tenantHandler.GetAllTenants(w, r)
 		case http.MethodPost:
-			tenantHandler.CreateTenant(w, r)
+			authHandler.RequireSystemUser(tenantHandler.CreateTenant)(w, r)
 		}
 	})
 
 	mux.HandleFunc("/tenants/map", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.Authenticate(tenantHandler.MapUserToTenant)(w, r)
+		case http.MethodDelete:
+			authHandler.Authenticate(tenantHandler.DeleteUserTenantMapping)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/map/bulk", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
-			tenantHandler.MapUserToTenant(w, r)
+			authHandler.Authenticate(tenantHandler.MapUsersToTenant)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/members/role", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			authHandler.Authenticate(tenantHandler.UpdateMemberRole)(w, r)
 		}
 	})
 
 	mux.HandleFunc("/tenants/update", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPut {
-			tenantHandler.UpdateTenant(w, r)
+			authHandler.RequireSystemUser(tenantHandler.UpdateTenant)(w, r)
 		}
 	})
 
@@ -118,6 +490,36 @@ func main() {
 			tenantHandler.GetTenantsByHeaderUser(w, r)
 		}
 	})
+	mux.HandleFunc("/tenants/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(exportHandler.ExportTenantMappings)(w, r)
+		}
+	})
+	// Reference data routes
+	mux.HandleFunc("/countries", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			countryHandler.GetAllCountries(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(healthHandler.GetTenantHealth)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/tenants/health/overview", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(healthHandler.GetFleetHealth)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(eventHandler.GetEvents)(w, r)
+		}
+	})
+
 	// Auth-related routes
 	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
@@ -125,6 +527,12 @@ func main() {
 		}
 	})
 
+	mux.HandleFunc("/users/system", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.RequireSystemUser(authHandler.Register)(w, r)
+		}
+	})
+
 	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
 			authHandler.Login(w, r)
@@ -144,21 +552,96 @@ func main() {
 		}
 	})
 
+	mux.HandleFunc("/users/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(exportHandler.ExportUsers)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/users/merge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.RequireSystemUser(userHandler.MergeUsers)(w, r)
+		}
+	})
+
 	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			userHandler.GetUserByID(w, r)
 		case http.MethodPut:
-			userHandler.UpdateUser(w, r)
+			authHandler.RequireSystemUser(userHandler.UpdateUser)(w, r)
 		case http.MethodDelete:
-			userHandler.DeleteUser(w, r)
+			authHandler.RequireSystemUser(userHandler.DeleteUser)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/user/suspend", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.RequireSystemUser(userHandler.SuspendUser)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/user/reactivate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.RequireSystemUser(userHandler.ReactivateUser)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/user/data-export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(userHandler.ExportUserData)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/user/erase", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.RequireSystemUser(userHandler.EraseUser)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/user/suspensions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.RequireSystemUser(userHandler.GetUserSuspensions)(w, r)
 		}
 	})
 
 	// Profile route (for getting the authenticated user's profile)
 	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(userHandler.GetUserProfile)(w, r)
+		case http.MethodPut:
+			authHandler.Authenticate(userHandler.UpdateUserProfile)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/profile/data-export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.Authenticate(userHandler.ExportOwnData)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/profile/logins", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.Authenticate(authHandler.GetOwnLoginHistory)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/logins", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
-			userHandler.GetUserProfile(w, r)
+			authHandler.RequireSystemUser(authHandler.GetLoginAttempts)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/profile/contact", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.Authenticate(userHandler.RequestContactChange)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/profile/contact/confirm", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			authHandler.Authenticate(userHandler.ConfirmContactChange)(w, r)
 		}
 	})
 
@@ -169,6 +652,19 @@ func main() {
 		}
 	})
 
+	// Preferences are read and written with the same token /token/validate accepts,
+	// scoped to a company when the companyid header is present
+	mux.HandleFunc("/preferences", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.Authenticate(preferenceHandler.GetPreferences)(w, r)
+		case http.MethodPut:
+			authHandler.Authenticate(preferenceHandler.SetPreferences)(w, r)
+		case http.MethodDelete:
+			authHandler.Authenticate(preferenceHandler.DeletePreference)(w, r)
+		}
+	})
+
 	// Set up routes for the Feature API
 	mux.HandleFunc("/features", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -181,21 +677,21 @@ func main() {
 	mux.HandleFunc("/features/map/bulk", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
-			featureHandler.MapFeaturesToUser(w, r)
+			authHandler.Authenticate(featureHandler.MapFeaturesToUser)(w, r)
 		}
 	})
 
 	mux.HandleFunc("/features/un-map", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			featureHandler.DeleteFeatureForUser(w, r)
+			authHandler.Authenticate(featureHandler.DeleteFeatureForUser)(w, r)
 		}
 	})
 
 	mux.HandleFunc("/features/un-map/all", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			featureHandler.DeleteAlMappingsForUser(w, r)
+			authHandler.Authenticate(featureHandler.DeleteAlMappingsForUser)(w, r)
 		}
 	})
 
@@ -214,23 +710,36 @@ func main() {
 
 	mux.HandleFunc("/features/user", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
-			featureHandler.GetFeaturesByUser(w, r)
+			authHandler.Authenticate(featureHandler.GetFeaturesByUser)(w, r)
 		}
 	})
 
-	// Set up routes for the Subscription API
+	mux.HandleFunc("/features/user/grants", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.Authenticate(featureHandler.GetFeatureGrants)(w, r)
+		}
+	})
+
+	mux.HandleFunc("/features/tree", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			authHandler.Authenticate(featureHandler.GetFeatureTree)(w, r)
+		}
+	})
+
+	// Set up routes for the Subscription API. Plans set seat limits and select provisioning
+	// templates, so only system users change them or map users onto them.
 	mux.HandleFunc("/subscriptions", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			subscriptionHandler.GetAllSubscriptions(w, r)
 		case http.MethodPost:
-			subscriptionHandler.CreateSubscription(w, r)
+			authHandler.RequireSystemUser(subscriptionHandler.CreateSubscription)(w, r)
 		}
 	})
 
 	mux.HandleFunc("/subscriptions/update", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPut {
-			subscriptionHandler.UpdateSubscription(w, r)
+			authHandler.RequireSystemUser(subscriptionHandler.UpdateSubscription)(w, r)
 		}
 	})
 
@@ -242,7 +751,7 @@ func main() {
 
 	mux.HandleFunc("/subscriptions/map/user", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
-			subscriptionHandler.MapUserToSubscription(w, r)
+			authHandler.RequireSystemUser(subscriptionHandler.MapUserToSubscription)(w, r)
 		}
 	})
 
@@ -256,10 +765,17 @@ func main() {
 				userSubscriptionHistoryHandler.GetAllUserSubscriptionHistories(w, r)
 			}
 		case http.MethodPost:
-			userSubscriptionHistoryHandler.CreateUserSubscriptionHistory(w, r)
+			authHandler.RequireSystemUser(userSubscriptionHistoryHandler.CreateUserSubscriptionHistory)(w, r)
 		}
 	})
 
+	// Gateway mode proxies /gw/{service}/... to the tenant services so their ports need not be public
+	if util.GetEnv("SGPortal_GatewayEnabled", "false") == "true" {
+		gatewayHandler := v1.NewGatewayHandler(authHandler)
+		gatewayHandler.Secret = util.GetEnv("SGPortal_GatewaySecret", "")
+		mux.Handle(v1.GatewayPrefix, gatewayHandler)
+	}
+
 	// Start the server on port 8080 with CORS-enabled middleware
 	corsMux := corsMiddleware(mux)
 	log.Println("Server is running on http://localhost:8080")
@@ -272,7 +788,7 @@ func main() {
 func initDB() (*gorm.DB, error) {
 	log.Printf("[+] Connecting to Postgres...\n")
 	dsn := "host=localhost user=postgres password=314#sg dbname=sg_portal port=5432 sslmode=disable TimeZone=Asia/Kolkata"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
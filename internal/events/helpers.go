@@ -0,0 +1,30 @@
+package events
+
+import (
+	"encoding/json"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Event types
+const (
+	TenantHealthChanged         = "tenant.health.changed"
+	TenantEndpointHealthChanged = "tenant.endpoint.health.changed"
+	TenantProvisioned           = "tenant.provisioned"
+	TenantStateChanged          = "tenant.state.changed"
+	TenantOwnershipTransferred  = "tenant.ownership.transferred"
+	TenantSecretRotated         = "tenant.secret.rotated"
+	TenantMigrationChanged      = "tenant.migration.changed"
+)
+
+// Publish stores the event in db so services can read it from /events. Called with a transaction,
+// the event only becomes visible, and is only delivered to the webhook, once that transaction commits.
+func Publish(db *gorm.DB, eventType string, tenantID *uint64, payload interface{}) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return db.Create(&models.Event{Type: eventType, TenantId: tenantID, Payload: string(encoded)}).Error
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Webhook posts committed events, oldest first, to a subscribed service
+type Webhook struct {
+	db        *gorm.DB
+	URL       string
+	Secret    string        // Sent in the X-Portal-Webhook-Secret header when set
+	Timeout   time.Duration // Per delivery
+	BatchSize int           // Events delivered per pass
+	client    *http.Client
+}
+
+// NewWebhook initializes a Webhook for url with the defaults used by the server
+func NewWebhook(db *gorm.DB, url string) *Webhook {
+	return &Webhook{
+		db:        db,
+		URL:       url,
+		Timeout:   10 * time.Second,
+		BatchSize: 100,
+		client:    &http.Client{},
+	}
+}
+
+// Deliver posts undelivered events in order and marks each one delivered. It stops at the first
+// failure so the receiver never sees events out of order; the rest are retried on the next pass.
+func (wh *Webhook) Deliver(ctx context.Context) (int, error) {
+	var pending []models.Event
+	if err := wh.db.Where("delivered_at IS NULL").Order("id").Limit(wh.BatchSize).Find(&pending).Error; err != nil {
+		return 0, err
+	}
+	for i := range pending {
+		if err := wh.post(ctx, pending[i]); err != nil {
+			return i, fmt.Errorf("event %d: %w", pending[i].ID, err)
+		}
+		if err := wh.db.Model(&pending[i]).Update("delivered_at", time.Now()).Error; err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+func (wh *Webhook) post(ctx context.Context, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, wh.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.Secret != "" {
+		req.Header.Set("X-Portal-Webhook-Secret", wh.Secret)
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Run delivers events every interval; it is meant to run in its own goroutine
+func (wh *Webhook) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		if _, err := wh.Deliver(context.Background()); err != nil {
+			log.Printf("[!] Event webhook delivery failed: %v\n", err)
+		}
+	}
+}
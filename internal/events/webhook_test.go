@@ -0,0 +1,74 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestWebhookDelivery checks that only committed events are posted, in order, and that a failed
+// delivery is retried on the next pass
+func TestWebhookDelivery(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Event{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	var received []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Portal-Webhook-Secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event models.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received = append(received, event.Type)
+	}))
+	defer server.Close()
+
+	tenantID := uint64(1)
+	if err := Publish(db, TenantStateChanged, &tenantID, map[string]string{"state": "suspended"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	db.Transaction(func(tx *gorm.DB) error {
+		Publish(tx, TenantMigrationChanged, &tenantID, map[string]string{"status": "cutover"})
+		return gorm.ErrInvalidTransaction // Rolled back, so never delivered
+	})
+	if err := Publish(db, TenantSecretRotated, &tenantID, map[string]string{"name": "api"}); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	webhook := NewWebhook(db, server.URL)
+	webhook.Secret = "secret"
+	if count, err := webhook.Deliver(context.Background()); err == nil || count != 0 {
+		t.Fatalf("Expected the first delivery to fail, got %d delivered and error %v", count, err)
+	}
+
+	failing = false
+	count, err := webhook.Deliver(context.Background())
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 events delivered, got %d and error %v", count, err)
+	}
+	if len(received) != 2 || received[0] != TenantStateChanged || received[1] != TenantSecretRotated {
+		t.Errorf("Expected the committed events in order, got %v", received)
+	}
+
+	if count, err := webhook.Deliver(context.Background()); err != nil || count != 0 {
+		t.Errorf("Expected nothing left to deliver, got %d and error %v", count, err)
+	}
+}
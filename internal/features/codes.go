@@ -0,0 +1,112 @@
+package features
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sg-portal/internal/models"
+)
+
+// Wildcard is the last segment of a pattern granting a whole branch of the catalog
+const Wildcard = "*"
+
+// ErrInvalidCode is returned for a permission code or pattern that is not dotted lower-case segments
+var ErrInvalidCode = errors.New("permission codes are dot-separated segments of a-z, 0-9, _ and -")
+
+// segment is one level of a permission code
+var segment = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ValidateCode checks that code names a single feature, e.g. sales.reports.daily
+func ValidateCode(code string) error {
+	if len(code) == 0 || len(code) > 100 {
+		return ErrInvalidCode
+	}
+	for _, part := range strings.Split(code, ".") {
+		if !segment.MatchString(part) {
+			return ErrInvalidCode
+		}
+	}
+	return nil
+}
+
+// IsWildcard reports whether the grant covers a branch of the catalog rather than one feature
+func IsWildcard(pattern string) bool {
+	return pattern == Wildcard || strings.HasSuffix(pattern, "."+Wildcard)
+}
+
+// ValidatePattern checks a wildcard grant: a code prefix followed by .*, or * alone
+func ValidatePattern(pattern string) error {
+	if !IsWildcard(pattern) {
+		return ErrInvalidCode
+	}
+	if pattern == Wildcard {
+		return nil
+	}
+	return ValidateCode(strings.TrimSuffix(pattern, "."+Wildcard))
+}
+
+// Matches reports whether the grant, a code or a wildcard pattern, covers the permission code.
+// sales.* covers sales.report and sales.reports.daily but not sales itself.
+func Matches(grant, code string) bool {
+	if !IsWildcard(grant) {
+		return grant == code
+	}
+	return grant == Wildcard || strings.HasPrefix(code, strings.TrimSuffix(grant, Wildcard))
+}
+
+// likePrefix turns a wildcard pattern into a LIKE pattern for the codes it covers, escaping the
+// characters LIKE treats specially
+func likePrefix(pattern string) string {
+	prefix := strings.TrimSuffix(pattern, Wildcard)
+	prefix = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	return prefix + "%"
+}
+
+// Node is one level of the feature catalog tree. Feature is set when a feature has exactly this
+// code; branches without one only group their children.
+type Node struct {
+	Segment  string          `json:"segment"`
+	Code     string          `json:"code"`
+	Feature  *models.Feature `json:"feature,omitempty"`
+	Children []*Node         `json:"children,omitempty"`
+}
+
+// Tree arranges the features by the segments of their permission codes, sorted at every level.
+// Codes that are not dotted, like those created before codes were checked, become top-level nodes.
+func Tree(catalog []models.Feature) []*Node {
+	root := &Node{}
+	index := map[string]*Node{"": root}
+	for i := range catalog {
+		parent, code := root, ""
+		for _, part := range strings.Split(catalog[i].Permission, ".") {
+			if code == "" {
+				code = part
+			} else {
+				code += "." + part
+			}
+			node, ok := index[code]
+			if !ok {
+				node = &Node{Segment: part, Code: code}
+				index[code] = node
+				parent.Children = append(parent.Children, node)
+			}
+			parent = node
+		}
+		parent.Feature = &catalog[i]
+	}
+
+	var sortChildren func(node *Node)
+	sortChildren = func(node *Node) {
+		sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Segment < node.Children[j].Segment })
+		for _, child := range node.Children {
+			sortChildren(child)
+		}
+	}
+	sortChildren(root)
+	if root.Children == nil {
+		return []*Node{}
+	}
+	return root.Children
+}
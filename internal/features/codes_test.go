@@ -0,0 +1,29 @@
+package features
+
+import "testing"
+
+// TestMatches checks which codes a grant covers and which patterns can be granted
+func TestMatches(t *testing.T) {
+	for _, c := range []struct {
+		grant, code string
+		want        bool
+	}{
+		{"sales.report", "sales.report", true},
+		{"sales.report", "sales.reports", false},
+		{"sales.*", "sales.report", true},
+		{"sales.*", "sales.reports.daily", true},
+		{"sales.*", "sales", false},
+		{"sales.*", "salesforce.sync", false},
+		{"*", "inventory.stock", true},
+	} {
+		if got := Matches(c.grant, c.code); got != c.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", c.grant, c.code, got, c.want)
+		}
+	}
+
+	for pattern, valid := range map[string]bool{"sales.*": true, "*": true, "sales": false, "sales.*.daily": false, ".*": false, "Sales.*": false} {
+		if err := ValidatePattern(pattern); (err == nil) != valid {
+			t.Errorf("ValidatePattern(%q) = %v, want valid %v", pattern, err, valid)
+		}
+	}
+}
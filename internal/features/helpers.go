@@ -0,0 +1,125 @@
+package features
+
+import (
+	"log"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// legacyIndex is the (user, feature) unique index used before grants were tenant scoped
+const legacyIndex = "idx_uf_mapping"
+
+// ForUser returns the features granted to the user in the tenant, one by one or through wildcard grants
+func ForUser(db *gorm.DB, userID, tenantID uint64) ([]models.Feature, error) {
+	patterns, err := Wildcards(db, userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	granted := db.Where("id IN (?)", db.Model(&models.UserFeatureMapping{}).Select("feature_id").
+		Where("user_id = ? AND tenant_id = ?", userID, tenantID))
+	for _, pattern := range patterns {
+		granted = granted.Or(`permission LIKE ? ESCAPE '\'`, likePrefix(pattern))
+	}
+
+	var features []models.Feature
+	err = db.Where(granted).Order("permission").Find(&features).Error
+	return features, err
+}
+
+// Wildcards returns the patterns of the user's wildcard grants in the tenant
+func Wildcards(db *gorm.DB, userID, tenantID uint64) ([]string, error) {
+	var patterns []string
+	err := db.Model(&models.UserWildcardGrant{}).Where("user_id = ? AND tenant_id = ?", userID, tenantID).
+		Order("pattern").Pluck("pattern", &patterns).Error
+	return patterns, err
+}
+
+// Holders returns the users of the tenant granted the permission code, one by one or through a
+// wildcard grant
+func Holders(db *gorm.DB, tenantID uint64, code string) ([]uint64, error) {
+	var holders []uint64
+	if err := db.Model(&models.UserFeatureMapping{}).Where("tenant_id = ? AND feature_id IN (?)", tenantID,
+		db.Model(&models.Feature{}).Select("id").Where("permission = ?", code)).
+		Pluck("user_id", &holders).Error; err != nil {
+		return nil, err
+	}
+	var wildcards []models.UserWildcardGrant
+	if err := db.Where("tenant_id = ?", tenantID).Find(&wildcards).Error; err != nil {
+		return nil, err
+	}
+	for _, grant := range wildcards {
+		if Matches(grant.Pattern, code) {
+			holders = append(holders, grant.UserId)
+		}
+	}
+	return holders, nil
+}
+
+// Permissions returns the permission codes granted to the user in the tenant
+func Permissions(db *gorm.DB, userID, tenantID uint64) ([]string, error) {
+	features, err := ForUser(db, userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, len(features))
+	for _, feature := range features {
+		codes = append(codes, feature.Permission)
+	}
+	return codes, nil
+}
+
+// RevokeInTenant deletes every grant the user holds in the tenant, wildcard grants included
+func RevokeInTenant(db *gorm.DB, userID, tenantID uint64) error {
+	if err := db.Where("user_id = ? AND tenant_id = ?", userID, tenantID).Delete(&models.UserFeatureMapping{}).Error; err != nil {
+		return err
+	}
+	return db.Where("user_id = ? AND tenant_id = ?", userID, tenantID).Delete(&models.UserWildcardGrant{}).Error
+}
+
+// MigrateTenantScope copies every grant made before permissions were tenant scoped into each
+// tenant the user belongs to and removes the global grant. Grants of users without a tenant have
+// nowhere to go; they are logged with their permission code before they are removed. It is safe to
+// run on every start.
+func MigrateTenantScope(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&models.UserFeatureMapping{}, legacyIndex) {
+		if err := db.Migrator().DropIndex(&models.UserFeatureMapping{}, legacyIndex); err != nil {
+			return err
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		copied := tx.Exec(`INSERT INTO user_feature_mappings (user_id, tenant_id, feature_id)
+			SELECT f.user_id, t.tenant_id, f.feature_id
+			FROM user_feature_mappings f
+			JOIN user_tenant_mappings t ON t.user_id = f.user_id
+			WHERE f.tenant_id = 0 AND NOT EXISTS (
+				SELECT 1 FROM user_feature_mappings e
+				WHERE e.user_id = f.user_id AND e.tenant_id = t.tenant_id AND e.feature_id = f.feature_id)`)
+		if copied.Error != nil {
+			return copied.Error
+		}
+		var dropped []struct {
+			UserId     uint64
+			Permission string
+		}
+		if err := tx.Table("user_feature_mappings f").Select("f.user_id, COALESCE(features.permission, CAST(f.feature_id AS TEXT)) AS permission").
+			Joins("LEFT JOIN features ON features.id = f.feature_id").
+			Where("f.tenant_id = 0 AND NOT EXISTS (SELECT 1 FROM user_tenant_mappings t WHERE t.user_id = f.user_id)").
+			Order("f.user_id, features.permission").Scan(&dropped).Error; err != nil {
+			return err
+		}
+		for _, grant := range dropped {
+			log.Printf("[!] Dropping global grant of %q to user %d, who belongs to no tenant\n", grant.Permission, grant.UserId)
+		}
+		removed := tx.Where("tenant_id = 0").Delete(&models.UserFeatureMapping{})
+		if removed.Error != nil {
+			return removed.Error
+		}
+		if removed.RowsAffected > 0 {
+			log.Printf("[+] Scoped %d global feature grants into %d tenant grants\n", removed.RowsAffected, copied.RowsAffected)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package features
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestMigrateTenantScope checks that global grants are copied into every tenant of the user
+func TestMigrateTenantScope(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Feature{}, &models.UserFeatureMapping{}, &models.UserTenantMapping{}, &models.UserWildcardGrant{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	db.Create(&models.Feature{Name: "Sales Report", Permission: "sales.report"})
+	db.Create(&[]models.UserTenantMapping{{UserId: 1, TenantId: 10}, {UserId: 1, TenantId: 20}})
+	db.Create(&[]models.UserFeatureMapping{
+		{UserId: 1, FeatureId: 1},
+		// Already granted in tenant 20, so only tenant 10 needs a copy
+		{UserId: 1, TenantId: 20, FeatureId: 1},
+		// User 2 belongs to no tenant, so the grant is dropped with a trace in the log
+		{UserId: 2, FeatureId: 1},
+	})
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for i := 0; i < 2; i++ {
+		if err := MigrateTenantScope(db); err != nil {
+			t.Fatalf("Migration failed: %v", err)
+		}
+	}
+
+	var grants []models.UserFeatureMapping
+	db.Order("tenant_id").Find(&grants)
+	if len(grants) != 2 || grants[0].TenantId != 10 || grants[1].TenantId != 20 {
+		t.Errorf("Expected one grant in each tenant, got %+v", grants)
+	}
+	if !strings.Contains(logged.String(), `"sales.report" to user 2`) {
+		t.Errorf("Expected the dropped grant to be logged, got %q", logged.String())
+	}
+	if codes, _ := Permissions(db, 1, 10); len(codes) != 1 || codes[0] != "sales.report" {
+		t.Errorf("Expected sales.report in tenant 10, got %v", codes)
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// ContactChangeRequest is a pending change of a user's email or mobile number.
+// The new value is only applied once the code sent to it has been confirmed.
+type ContactChangeRequest struct {
+	ID          uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserId      uint64     `gorm:"not null;index" json:"user_id"`
+	Channel     string     `gorm:"size:10;not null" json:"channel"` // notify.ChannelEmail or notify.ChannelMobile
+	NewValue    string     `gorm:"not null" json:"new_value"`
+	CodeHash    string     `gorm:"not null" json:"-"`
+	Salt        string     `gorm:"not null" json:"-"`
+	Attempts    uint8      `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// Country is reference data for User.CountryID. IDs are the ISO 3166-1 numeric codes.
+type Country struct {
+	ID              int    `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	IsoCode         string `gorm:"size:2;not null;uniqueIndex" json:"iso_code"` // ISO 3166-1 alpha-2
+	IsoCode3        string `gorm:"size:3;not null" json:"iso_code3"`            // ISO 3166-1 alpha-3
+	Name            string `gorm:"size:100;not null" json:"name"`
+	DialCode        string `gorm:"size:5;not null" json:"dial_code"`  // Digits only, without the leading +
+	MinMobileLength int    `gorm:"not null" json:"min_mobile_length"` // National number length bounds
+	MaxMobileLength int    `gorm:"not null" json:"max_mobile_length"`
+}
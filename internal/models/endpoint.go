@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// Endpoint roles
+const (
+	EndpointPrimary = "primary"
+	EndpointStandby = "standby"
+)
+
+// IsValidService reports whether service is one of the services hosted for tenants
+func IsValidService(service string) bool {
+	return service == ServiceBmrm || service == ServiceSgBiz || service == ServiceTallySync
+}
+
+// TenantEndpoint is one instance serving a service of a tenant. Clients try primaries before
+// standbys, lower priority first and, among equals, spread load by weight.
+type TenantEndpoint struct {
+	ID        uint64     `gorm:"primaryKey" json:"id"`
+	TenantId  uint64     `gorm:"not null;uniqueIndex:idx_tnt_endpoint" json:"tenant_id"`
+	Service   string     `gorm:"size:20;not null;uniqueIndex:idx_tnt_endpoint" json:"service"`
+	Host      string     `gorm:"size:250;not null;uniqueIndex:idx_tnt_endpoint" json:"host"`
+	Port      uint32     `gorm:"not null;uniqueIndex:idx_tnt_endpoint" json:"port"`
+	Role      string     `gorm:"size:10;not null;default:primary" json:"role"`
+	Priority  int        `gorm:"not null;default:0" json:"priority"`
+	Weight    int        `gorm:"not null;default:1" json:"weight"`
+	Health    string     `gorm:"size:10;not null;default:unknown" json:"health"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
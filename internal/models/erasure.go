@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// UserErasure records that a user's personal data was anonymised.
+// Billing records (subscriptions and their history) are kept against the anonymised user.
+type UserErasure struct {
+	ID       uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserId   uint64    `gorm:"not null;uniqueIndex" json:"user_id"`
+	ErasedBy uint64    `gorm:"not null" json:"erased_by"`
+	Reason   string    `gorm:"size:500" json:"reason"`
+	ErasedAt time.Time `gorm:"not null" json:"erased_at"`
+}
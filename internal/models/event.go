@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// Event is a persisted notification that something changed, readable by downstream services
+type Event struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Type      string    `gorm:"size:100;not null;index" json:"type"`
+	TenantId  *uint64   `gorm:"index" json:"tenant_id"`
+	Payload   string    `gorm:"type:text" json:"payload"` // JSON document describing the change
+	CreatedAt time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+	// Set once the event has been posted to the configured webhook
+	DeliveredAt *time.Time `gorm:"index" json:"-"`
+}
@@ -7,13 +7,27 @@ import (
 type Feature struct {
 	ID         uint32 `gorm:"primaryKey"`
 	Name       string `gorm:"size:200;not null"`
-	Permission string `gorm:"size:50;not null"`
+	Permission string `gorm:"size:100;not null"` // Dotted code from general to specific, e.g. sales.reports.daily
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 }
 
+// UserFeatureMapping grants a feature to a user within one tenant.
+// TenantId 0 only appears on grants made before permissions were tenant scoped.
 type UserFeatureMapping struct {
 	ID        uint64 `gorm:"primaryKey"`
-	UserId    uint64 `gorm:"uniqueIndex:idx_uf_mapping;not null"`
-	FeatureId uint32 `gorm:"uniqueIndex:idx_uf_mapping;not null"`
+	UserId    uint64 `gorm:"uniqueIndex:idx_utf_mapping;not null"`
+	TenantId  uint64 `gorm:"uniqueIndex:idx_utf_mapping;not null;default:0"`
+	FeatureId uint32 `gorm:"uniqueIndex:idx_utf_mapping;not null"`
+}
+
+// UserWildcardGrant grants a user, within one tenant, every feature under a branch of the
+// permission codes, e.g. sales.* covers sales.report and sales.reports.daily, including features
+// added to the catalog later. The pattern * grants every feature.
+type UserWildcardGrant struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	UserId    uint64    `gorm:"uniqueIndex:idx_utw_grant;not null" json:"user_id"`
+	TenantId  uint64    `gorm:"uniqueIndex:idx_utw_grant;not null" json:"tenant_id"`
+	Pattern   string    `gorm:"size:100;uniqueIndex:idx_utw_grant;not null" json:"pattern"`
+	CreatedAt time.Time `json:"created_at"`
 }
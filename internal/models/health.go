@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+)
+
+// Services hosted for every tenant
+const (
+	ServiceBmrm      = "bmrm"
+	ServiceSgBiz     = "sgbiz"
+	ServiceTallySync = "tallysync"
+)
+
+// Health states of a tenant service
+const (
+	HealthUnknown = "unknown"
+	HealthUp      = "up"
+	HealthDown    = "down"
+)
+
+// TenantServiceStatus is the latest known health of one service of a tenant
+type TenantServiceStatus struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"-"`
+	TenantId  uint64    `gorm:"not null;uniqueIndex:idx_tnt_service" json:"tenant_id"`
+	Service   string    `gorm:"size:20;not null;uniqueIndex:idx_tnt_service" json:"service"`
+	Host      string    `gorm:"size:250" json:"host"`
+	Port      uint32    `json:"port"`
+	Status    string    `gorm:"size:10;not null" json:"status"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `gorm:"size:500" json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	ChangedAt time.Time `json:"changed_at"` // When Status last changed
+}
+
+// TenantHealthCheck is one probe result, kept as latency and availability history
+type TenantHealthCheck struct {
+	ID         uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	TenantId   uint64    `gorm:"not null;index:idx_tnt_health_check" json:"tenant_id"`
+	EndpointId uint64    `json:"endpoint_id,omitempty"`
+	Service    string    `gorm:"size:20;not null" json:"service"`
+	Host       string    `gorm:"size:250" json:"host"`
+	Port       uint32    `json:"port"`
+	Status     string    `gorm:"size:10;not null" json:"status"`
+	LatencyMs  int64     `json:"latency_ms"`
+	Error      string    `gorm:"size:500" json:"error,omitempty"`
+	CheckedAt  time.Time `gorm:"not null;index:idx_tnt_health_check" json:"checked_at"`
+}
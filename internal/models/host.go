@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// Host is a server tenants are provisioned on. New tenants are given free ports from its range
+// while it has capacity left and is not being drained.
+type Host struct {
+	ID        uint32    `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:250;uniqueIndex;not null" json:"name"` // As stored in Tenant.Host
+	PortFrom  uint32    `gorm:"not null" json:"port_from"`
+	PortTo    uint32    `gorm:"not null" json:"port_to"`
+	Capacity  int       `gorm:"not null" json:"capacity"` // Most tenants the host carries
+	Draining  bool      `gorm:"not null;default:false" json:"draining"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// HostLabel tags a host, e.g. with its region, so tenants can be placed on matching hosts
+type HostLabel struct {
+	ID     uint64 `gorm:"primaryKey"`
+	HostId uint32 `gorm:"uniqueIndex:idx_host_label;not null"`
+	Label  string `gorm:"size:100;uniqueIndex:idx_host_label;not null"`
+}
+
+// PortAllocation reserves one port of a host for a service of a tenant
+type PortAllocation struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	HostId    uint32    `gorm:"uniqueIndex:idx_host_port;not null" json:"host_id"`
+	Port      uint32    `gorm:"uniqueIndex:idx_host_port;not null" json:"port"`
+	TenantId  uint64    `gorm:"not null;index" json:"tenant_id"`
+	Service   string    `gorm:"size:20;not null" json:"service"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// Invitation statuses; a pending invitation holds one of the tenant's seats until it is settled
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// TenantInvitation asks someone, by email, to join a tenant with a role. It is accepted by the
+// user signed in with that email using the code sent with it, and the row is kept afterwards as the
+// record of the invitation.
+type TenantInvitation struct {
+	ID         uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	TenantId   uint64     `gorm:"not null;index" json:"tenant_id"`
+	Email      string     `gorm:"size:250;not null;index" json:"email"`
+	Role       string     `gorm:"size:20;not null;default:member" json:"role"`
+	InvitedBy  uint64     `gorm:"not null" json:"invited_by"`
+	Status     string     `gorm:"size:20;not null;default:pending;index" json:"status"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	CodeHash   string     `gorm:"not null;default:''" json:"-"`
+	Salt       string     `gorm:"not null;default:''" json:"-"`
+	Attempts   uint8      `gorm:"not null;default:0" json:"attempts"`
+	AcceptedBy *uint64    `json:"accepted_by,omitempty"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
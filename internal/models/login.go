@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Outcome reasons recorded for every login attempt
+const (
+	LoginReasonSuccess           = "SUCCESS"
+	LoginReasonBadRequest        = "BAD_REQUEST"
+	LoginReasonInvalidCredential = "INVALID_CREDENTIAL"
+	LoginReasonUnknownUser       = "UNKNOWN_USER"
+	LoginReasonInvalidPassword   = "INVALID_PASSWORD"
+	LoginReasonUserSuspended     = CodeUserSuspended
+	LoginReasonInternalError     = "INTERNAL_ERROR"
+)
+
+// Credential types a user can sign in with
+const (
+	CredentialTypeEmail   = "email"
+	CredentialTypeMobile  = "mobile"
+	CredentialTypeUnknown = "unknown"
+)
+
+// LoginAttempt records a single successful or failed call to /login
+type LoginAttempt struct {
+	ID             uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserId         *uint64   `gorm:"index" json:"user_id"` // Nil when the credential matched no user
+	Credential     string    `gorm:"size:250" json:"credential"`
+	CredentialType string    `gorm:"size:10;not null" json:"credential_type"`
+	IP             string    `gorm:"size:64" json:"ip"`
+	UserAgent      string    `gorm:"size:500" json:"user_agent"`
+	Success        bool      `gorm:"not null" json:"success"`
+	Reason         string    `gorm:"size:50;not null" json:"reason"`
+	CreatedAt      time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
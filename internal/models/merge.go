@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// UserMerge records that the absorbed user's mappings were moved onto the surviving user
+type UserMerge struct {
+	ID         uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	SurvivorId uint64    `gorm:"not null;index" json:"survivor_id"`
+	AbsorbedId uint64    `gorm:"not null;uniqueIndex" json:"absorbed_id"`
+	MergedBy   uint64    `gorm:"not null" json:"merged_by"`
+	Summary    string    `gorm:"type:text" json:"summary"` // JSON encoded users.MergeSummary
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
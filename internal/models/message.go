@@ -1,6 +1,25 @@
 package models
 
+// Reason codes returned alongside rejections so clients can tell failures apart
+const (
+	CodeInvalidToken  = "INVALID_TOKEN"
+	CodeTokenExpired  = "TOKEN_EXPIRED"
+	CodeUserSuspended = "USER_SUSPENDED"
+	CodeForbidden     = "FORBIDDEN"
+	CodeEmailInUse    = "EMAIL_IN_USE"
+	CodeMobileInUse   = "MOBILE_IN_USE"
+	CodeNoEndpoint    = "NO_HEALTHY_ENDPOINT"
+	CodeNoSeats       = "NO_SEATS_AVAILABLE"
+	CodeSeatInactive  = "SEAT_DEACTIVATED"
+
+	CodeTenantProvisioning = "TENANT_PROVISIONING"
+	CodeTenantSuspended    = "TENANT_SUSPENDED"
+	CodeTenantArchived     = "TENANT_ARCHIVED"
+	CodeTenantDeleted      = "TENANT_DELETED"
+)
+
 type GenericResponseMessage struct {
 	Message string `json:"message"`
 	Result  bool   `json:"result"`
+	Code    string `json:"code,omitempty"`
 }
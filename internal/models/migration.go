@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// Tenant migration states. A migration is active until it is complete or rolled back, and a tenant
+// has at most one active migration.
+const (
+	MigrationPlanned    = "planned"
+	MigrationCopying    = "copying"
+	MigrationCutover    = "cutover"
+	MigrationComplete   = "complete"
+	MigrationRolledBack = "rolled_back"
+)
+
+// MigrationTransitions lists the states each migration state may move to. Every state short of
+// complete can still be rolled back.
+var MigrationTransitions = map[string][]string{
+	MigrationPlanned: {MigrationCopying, MigrationRolledBack},
+	MigrationCopying: {MigrationCutover, MigrationRolledBack},
+	MigrationCutover: {MigrationComplete, MigrationRolledBack},
+}
+
+// TenantMigration moves a tenant's services from one host to another. The target ports are reserved
+// when it is planned, the endpoints switch at cutover, and the source ports are released once it is
+// complete. The row is kept afterwards as the record of the move.
+type TenantMigration struct {
+	ID                uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	TenantId          uint64     `gorm:"not null;index" json:"tenant_id"`
+	State             string     `gorm:"size:20;not null;default:planned;index" json:"state"`
+	FromHost          string     `gorm:"size:250;not null" json:"from_host"`
+	FromBmrmPort      uint32     `json:"from_bmrm_port"`
+	FromSgBizPort     uint32     `json:"from_sg_biz_port"`
+	FromTallySyncPort uint32     `json:"from_tally_sync_port"`
+	ToHost            string     `gorm:"size:250;not null" json:"to_host"`
+	ToBmrmPort        uint32     `json:"to_bmrm_port"`
+	ToSgBizPort       uint32     `json:"to_sg_biz_port"`
+	ToTallySyncPort   uint32     `json:"to_tally_sync_port"`
+	PreviousEndpoints string     `gorm:"type:text" json:"-"` // JSON of the endpoints replaced at cutover, restored on rollback
+	Reason            string     `gorm:"size:500" json:"reason,omitempty"`
+	CreatedBy         uint64     `gorm:"not null" json:"created_by"`
+	CutoverAt         *time.Time `json:"cutover_at,omitempty"`
+	CompletedAt       *time.Time `json:"completed_at,omitempty"`
+	RolledBackAt      *time.Time `json:"rolled_back_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// Organization roles. Admins manage the organization and have admin access to all of its
+// companies; members get the companies and role chosen for them.
+const (
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+// Organization groups the companies of one business
+type Organization struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:250;not null;uniqueIndex" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrganizationMember gives a user access to the organization's companies, either all of them,
+// including ones added later, or those listed in OrganizationMemberCompany
+type OrganizationMember struct {
+	ID             uint64    `gorm:"primaryKey" json:"id"`
+	OrganizationId uint64    `gorm:"not null;uniqueIndex:idx_org_member" json:"organization_id"`
+	UserId         uint64    `gorm:"not null;uniqueIndex:idx_org_member;index" json:"user_id"`
+	Role           string    `gorm:"size:20;not null;default:member" json:"role"`
+	AllCompanies   bool      `gorm:"not null;default:false" json:"all_companies"`
+	TenantRole     string    `gorm:"size:20;not null;default:member" json:"tenant_role"` // Role given on the inherited companies
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OrganizationMemberCompany is one company a member without AllCompanies inherits
+type OrganizationMemberCompany struct {
+	ID       uint64 `gorm:"primaryKey" json:"-"`
+	MemberId uint64 `gorm:"not null;uniqueIndex:idx_org_member_company" json:"member_id"`
+	TenantId uint64 `gorm:"not null;uniqueIndex:idx_org_member_company;index" json:"tenant_id"`
+}
+
+// OrganizationSubscription is a subscription bought for the whole organization; every member
+// is treated as holding it
+type OrganizationSubscription struct {
+	ID             uint64    `gorm:"primaryKey" json:"id"`
+	OrganizationId uint64    `gorm:"not null;uniqueIndex:idx_org_subscription" json:"organization_id"`
+	SubscriptionId uint32    `gorm:"not null;uniqueIndex:idx_org_subscription" json:"subscription_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
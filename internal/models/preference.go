@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// UserPreference is a JSON document stored under namespace/key for a user.
+// TenantId 0 means the preference applies to the user across all tenants.
+type UserPreference struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserId    uint64    `gorm:"not null;uniqueIndex:idx_user_pref" json:"-"`
+	TenantId  uint64    `gorm:"not null;default:0;uniqueIndex:idx_user_pref" json:"tenant_id"`
+	Namespace string    `gorm:"size:100;not null;uniqueIndex:idx_user_pref" json:"namespace"`
+	Key       string    `gorm:"size:100;not null;uniqueIndex:idx_user_pref" json:"key"`
+	Value     string    `gorm:"type:text;not null" json:"-"` // Raw JSON document
+	Version   uint64    `gorm:"not null;default:1" json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
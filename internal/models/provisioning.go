@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// DefaultTemplateName names the template seeded from the legacy "default" tenant
+const DefaultTemplateName = "default"
+
+// ProvisioningTemplate describes where and how a new company is provisioned
+type ProvisioningTemplate struct {
+	ID            uint32 `gorm:"primaryKey"`
+	Name          string `gorm:"size:100;uniqueIndex;not null"`
+	Description   string `gorm:"size:500"`
+	Priority      int    `gorm:"not null;default:0"` // Lower wins when several templates match the user's subscriptions
+	IsDefault     bool   `gorm:"not null;default:false"`
+	BmrmPort      uint32
+	SgBizPort     uint32
+	TallySyncPort uint32
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// TemplateHost is one host of a template's pool; new companies go to the least loaded host
+type TemplateHost struct {
+	ID         uint64 `gorm:"primaryKey"`
+	TemplateId uint32 `gorm:"uniqueIndex:idx_template_host;not null"`
+	Host       string `gorm:"size:250;uniqueIndex:idx_template_host;not null"`
+}
+
+// TemplateFeature is granted to the owner of every company provisioned from the template
+type TemplateFeature struct {
+	ID         uint64 `gorm:"primaryKey"`
+	TemplateId uint32 `gorm:"uniqueIndex:idx_template_feature;not null"`
+	FeatureId  uint32 `gorm:"uniqueIndex:idx_template_feature;not null"`
+}
+
+// TemplateSubscription is given to the owner of a company provisioned from the template when they lack it
+type TemplateSubscription struct {
+	ID             uint64 `gorm:"primaryKey"`
+	TemplateId     uint32 `gorm:"uniqueIndex:idx_template_subscription;not null"`
+	SubscriptionId uint32 `gorm:"uniqueIndex:idx_template_subscription;not null"`
+}
+
+// TemplateRule selects the template for users holding the subscription
+type TemplateRule struct {
+	ID             uint64 `gorm:"primaryKey"`
+	TemplateId     uint32 `gorm:"uniqueIndex:idx_template_rule;not null"`
+	SubscriptionId uint32 `gorm:"uniqueIndex:idx_template_rule;not null"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// TenantSecret is a named credential a tenant service needs, e.g. the BMRM database password.
+// Its values are kept as encrypted versions; the latest one is current.
+type TenantSecret struct {
+	ID             uint64    `gorm:"primaryKey" json:"id"`
+	TenantId       uint64    `gorm:"not null;uniqueIndex:idx_tenant_secret" json:"tenant_id"`
+	Name           string    `gorm:"size:100;not null;uniqueIndex:idx_tenant_secret" json:"name"`
+	Service        string    `gorm:"size:20;not null" json:"service"` // Service whose credentials may read it
+	CurrentVersion int       `gorm:"not null" json:"current_version"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TenantSecretVersion is one value of a secret, encrypted with its own data key. The data key is
+// stored wrapped by the master key identified by KeyId.
+type TenantSecretVersion struct {
+	ID         uint64    `gorm:"primaryKey" json:"id"`
+	SecretId   uint64    `gorm:"not null;uniqueIndex:idx_secret_version" json:"secret_id"`
+	TenantId   uint64    `gorm:"not null;index" json:"tenant_id"`
+	Version    int       `gorm:"not null;uniqueIndex:idx_secret_version" json:"version"`
+	Ciphertext []byte    `gorm:"not null" json:"-"`
+	WrappedKey []byte    `gorm:"not null" json:"-"`
+	KeyId      string    `gorm:"size:16;not null;index" json:"key_id"`
+	CreatedBy  *uint64   `json:"created_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ServiceCredential lets a tenant service read the secrets of its service, for one tenant or,
+// without TenantId, for all of them. Only a hash of the key is kept.
+type ServiceCredential struct {
+	ID         uint64     `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	Service    string     `gorm:"size:20;not null" json:"service"`
+	TenantId   *uint64    `gorm:"index" json:"tenant_id,omitempty"`
+	KeyHash    string     `gorm:"size:64;not null" json:"-"`
+	CreatedBy  uint64     `gorm:"not null" json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// SettingDefinition declares a tenant setting: the JSON schema its values must satisfy and the
+// default used by tenants that have not set it
+type SettingDefinition struct {
+	ID          uint32    `gorm:"primaryKey" json:"id"`
+	Key         string    `gorm:"size:100;not null;uniqueIndex" json:"key"`
+	Description string    `gorm:"size:500" json:"description"`
+	Schema      string    `gorm:"type:text;not null" json:"-"` // Raw JSON schema
+	Default     string    `gorm:"type:text;not null" json:"-"` // Raw JSON document
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TenantSetting is the value a tenant chose for a setting. Version starts at 1 and grows with
+// every change; writes must name the version they replace.
+type TenantSetting struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"-"`
+	TenantId  uint64    `gorm:"not null;uniqueIndex:idx_tenant_setting" json:"tenant_id"`
+	Key       string    `gorm:"size:100;not null;uniqueIndex:idx_tenant_setting" json:"key"`
+	Value     string    `gorm:"type:text;not null" json:"-"` // Raw JSON document
+	Version   uint64    `gorm:"not null;default:1" json:"version"`
+	UpdatedBy *uint64   `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TenantSettingRevision records every value a tenant setting has held; a reset to the default is
+// recorded with a null value
+type TenantSettingRevision struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement" json:"id"`
+	TenantId  uint64    `gorm:"not null;index:idx_tenant_setting_revision" json:"tenant_id"`
+	Key       string    `gorm:"size:100;not null;index:idx_tenant_setting_revision" json:"key"`
+	Version   uint64    `gorm:"not null" json:"version"`
+	Value     *string   `gorm:"type:text" json:"-"`
+	ActorId   *uint64   `json:"actor_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
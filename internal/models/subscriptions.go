@@ -9,6 +9,7 @@ type Subscription struct {
 	ID        uint32 `gorm:"primaryKey"`
 	Name      string `gorm:"size:200;not null"`
 	Code      string `gorm:"size:50;not null"`
+	Seats     int    `gorm:"not null;default:0"` // Users each company of a holder may have, 0 for unlimited
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
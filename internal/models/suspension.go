@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// UserSuspension records why and by whom a user was suspended, and when the suspension ends.
+// A suspension is open while ReactivatedAt is nil.
+type UserSuspension struct {
+	ID            uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserId        uint64     `gorm:"not null;index" json:"user_id"`
+	Reason        string     `gorm:"size:500;not null" json:"reason"`
+	SuspendedBy   uint64     `gorm:"not null" json:"suspended_by"`
+	SuspendedAt   time.Time  `gorm:"not null" json:"suspended_at"`
+	ReactivateAt  *time.Time `gorm:"index" json:"reactivate_at"` // Optional scheduled reactivation
+	ReactivatedAt *time.Time `json:"reactivated_at"`
+	ReactivatedBy *uint64    `json:"reactivated_by"` // Nil when the scheduled job lifted the suspension
+}
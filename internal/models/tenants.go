@@ -1,30 +1,97 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+// Membership roles on a tenant, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// RoleRank orders the membership roles; a higher rank grants more
+var RoleRank = map[string]int{
+	RoleOwner:  4,
+	RoleAdmin:  3,
+	RoleMember: 2,
+	RoleViewer: 1,
+}
+
+// IsValidRole reports whether role is one of the membership roles
+func IsValidRole(role string) bool {
+	_, ok := RoleRank[role]
+	return ok
+}
+
+// Tenant lifecycle states
+const (
+	TenantProvisioning = "provisioning"
+	TenantActive       = "active"
+	TenantSuspended    = "suspended"
+	TenantArchived     = "archived"
+	TenantDeleted      = "deleted"
+)
+
+// TenantTransitions lists the states each state may move to. A deleted tenant can be restored
+// to archived until its retention window ends.
+var TenantTransitions = map[string][]string{
+	TenantProvisioning: {TenantActive, TenantDeleted},
+	TenantActive:       {TenantSuspended, TenantArchived, TenantDeleted},
+	TenantSuspended:    {TenantActive, TenantArchived, TenantDeleted},
+	TenantArchived:     {TenantActive, TenantDeleted},
+	TenantDeleted:      {TenantArchived},
+}
+
 type UserTenantMapping struct {
-	ID       uint64 `gorm:"primaryKey"`
-	UserId   uint64 `gorm:"uniqueIndex:idx_tnt_mapping;not null"`
-	TenantId uint64 `gorm:"uniqueIndex:idx_tnt_mapping;not null"`
+	ID            uint64     `gorm:"primaryKey"`
+	UserId        uint64     `gorm:"uniqueIndex:idx_tnt_mapping;not null"`
+	TenantId      uint64     `gorm:"uniqueIndex:idx_tnt_mapping;not null"`
+	Role          string     `gorm:"size:20;not null;default:member"`
+	InheritedFrom uint64     `gorm:"not null;default:0"` // Organization whose membership granted the access, 0 for direct members
+	DeactivatedAt *time.Time // Set while the seat is deactivated; the user stays mapped but cannot use the company
 }
 
 type Tenant struct {
-	ID            uint64 `gorm:"primaryKey"`
-	CompanyGuid   string `gorm:"size:50;uniqueIndex:idx_tnt:;not null"`
-	CompanyName   string `gorm:"size:250;uniqueIndex:idx_tnt"`
-	Host          string `gorm:"size:250;uniqueIndex:idx_tnt"`
-	BmrmPort      uint32
-	SgBizPort     uint32
-	TallySyncPort uint32
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	ID             uint64 `gorm:"primaryKey"`
+	CompanyGuid    string `gorm:"size:50;uniqueIndex:idx_tnt:;not null"`
+	CompanyName    string `gorm:"size:250;uniqueIndex:idx_tnt"`
+	Host           string `gorm:"size:250;uniqueIndex:idx_tnt"`
+	BmrmPort       uint32
+	SgBizPort      uint32
+	TallySyncPort  uint32
+	State          string     `gorm:"size:20;not null;default:active;index"`
+	PurgeAfter     *time.Time // Set while deleted; memberships are removed once it passes
+	PurgedAt       *time.Time
+	OrganizationId *uint64 `gorm:"index"`
+	SeatLimit      *int    // Overrides the seats of the owners' plans; 0 means unlimited
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// TenantStateChange records one lifecycle transition of a tenant
+type TenantStateChange struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	TenantId  uint64    `gorm:"not null;index" json:"tenant_id"`
+	FromState string    `gorm:"size:20;not null" json:"from_state"`
+	ToState   string    `gorm:"size:20;not null" json:"to_state"`
+	Reason    string    `gorm:"size:500;not null" json:"reason"`
+	ActorId   *uint64   `json:"actor_id,omitempty"` // nil for system-driven transitions
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type TokenTenantInfo struct {
-	TenantInfo *Tenant
-	UserId     *uint64
-	Success    bool
-	Message    string
+	TenantInfo  *Tenant
+	UserId      *uint64
+	Role        string                      `json:",omitempty"`
+	Permissions []string                    `json:",omitempty"`
+	Endpoints   map[string][]TenantEndpoint `json:",omitempty"` // Healthy endpoints by service, in the order to try them
+	Settings    map[string]json.RawMessage  `json:",omitempty"` // Effective settings, when requested
+	Maintenance bool                        `json:",omitempty"` // The company is being moved to another host
+	Success     bool
+	Message     string
+	Code        string `json:",omitempty"`
 }
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Ownership transfer statuses; only pending transfers can be accepted or cancelled
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferCancelled = "cancelled"
+	TransferExpired   = "expired"
+)
+
+// OwnershipTransfer hands a tenant from one of its owners to another user, who may not be
+// registered yet. It takes effect once the recipient confirms it with the code sent to them,
+// and the row is kept afterwards as the record of the transfer.
+type OwnershipTransfer struct {
+	ID           uint64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	TenantId     uint64     `gorm:"not null;index" json:"tenant_id"`
+	FromUserId   uint64     `gorm:"not null;index" json:"from_user_id"`
+	ToUserId     *uint64    `gorm:"index" json:"to_user_id,omitempty"` // Known recipient, or the one who accepted
+	Channel      string     `gorm:"size:10;not null" json:"channel"`   // notify.ChannelEmail or notify.ChannelMobile
+	Recipient    string     `gorm:"not null" json:"recipient"`         // Email or mobile number the code was sent to
+	KeepAsMember bool       `gorm:"not null;default:false" json:"keep_as_member"`
+	Status       string     `gorm:"size:20;not null;default:pending;index" json:"status"`
+	CodeHash     string     `gorm:"not null" json:"-"`
+	Salt         string     `gorm:"not null" json:"-"`
+	Attempts     uint8      `gorm:"not null;default:0" json:"attempts"`
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt   *time.Time `json:"accepted_at,omitempty"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+	CancelledBy  *uint64    `json:"cancelled_by,omitempty"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"log"
+)
+
+// Delivery channels for notifications
+const (
+	ChannelEmail  = "email"
+	ChannelMobile = "mobile"
+)
+
+// Sender delivers a message to an email address or mobile number
+type Sender interface {
+	Send(channel, to, subject, body string) error
+}
+
+// LogSender records that a message was sent in the server log; it stands in until an email/SMS
+// gateway is configured. Bodies carry confirmation codes, so only their length is logged.
+type LogSender struct{}
+
+func (LogSender) Send(channel, to, subject, body string) error {
+	log.Printf("[>] %s to %s: %s (%d character body withheld)\n", channel, to, subject, len(body))
+	return nil
+}
+
+// Default is the sender used by the handlers
+var Default Sender = LogSender{}
+
+// Send delivers a message through the Default sender
+func Send(channel, to, subject, body string) error {
+	return Default.Send(channel, to, subject, body)
+}
@@ -0,0 +1,154 @@
+package organizations
+
+import (
+	"errors"
+	"sort"
+
+	"sg-portal/internal/features"
+	"sg-portal/internal/models"
+	"sg-portal/internal/tenants"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Member returns the user's membership of the organization, or gorm.ErrRecordNotFound
+func Member(db *gorm.DB, userID, orgID uint64) (*models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	err := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error
+	return &member, err
+}
+
+// CountAdmins returns how many admins the organization has
+func CountAdmins(db *gorm.DB, orgID uint64) (int64, error) {
+	var admins int64
+	err := db.Model(&models.OrganizationMember{}).Where("organization_id = ? AND role = ?", orgID, models.OrgRoleAdmin).Count(&admins).Error
+	return admins, err
+}
+
+// inheritedKey identifies one user's access to one company
+type inheritedKey struct {
+	userID, tenantID uint64
+}
+
+// Sync brings the company memberships inherited from the organization in line with its members
+// and companies. Direct memberships, and ones inherited from another organization, take precedence
+// and are left alone, as are inherited memberships that were promoted to owner. New memberships take
+// seats like any other, and the sync fails with tenants.ErrNoSeats when a company has too few left.
+// It must run inside a transaction.
+func Sync(tx *gorm.DB, orgID uint64) error {
+	var companies []uint64
+	// Deleted companies keep their members until they are purged, in case they are restored
+	if err := tx.Model(&models.Tenant{}).Where("organization_id = ? AND purged_at IS NULL", orgID).
+		Pluck("id", &companies).Error; err != nil {
+		return err
+	}
+	var members []models.OrganizationMember
+	if err := tx.Where("organization_id = ?", orgID).Find(&members).Error; err != nil {
+		return err
+	}
+
+	desired := map[inheritedKey]string{}
+	for _, member := range members {
+		role, tenantIDs := member.TenantRole, companies
+		if member.Role == models.OrgRoleAdmin {
+			role = models.RoleAdmin
+		} else if !member.AllCompanies {
+			tenantIDs = nil
+			if err := tx.Model(&models.OrganizationMemberCompany{}).
+				Where("member_id = ? AND tenant_id IN ?", member.ID, companies).
+				Pluck("tenant_id", &tenantIDs).Error; err != nil {
+				return err
+			}
+		}
+		for _, tenantID := range tenantIDs {
+			desired[inheritedKey{member.UserId, tenantID}] = role
+		}
+	}
+
+	var inherited []models.UserTenantMapping
+	if err := tx.Where("inherited_from = ?", orgID).Find(&inherited).Error; err != nil {
+		return err
+	}
+	for _, mapping := range inherited {
+		key := inheritedKey{mapping.UserId, mapping.TenantId}
+		role, keep := desired[key]
+		delete(desired, key)
+		if mapping.Role == models.RoleOwner {
+			continue
+		}
+		if !keep {
+			if err := tx.Delete(&mapping).Error; err != nil {
+				return err
+			}
+			if err := features.RevokeInTenant(tx, mapping.UserId, mapping.TenantId); err != nil {
+				return err
+			}
+			continue
+		}
+		if mapping.Role != role {
+			if err := tx.Model(&mapping).Update("role", role).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	added := map[uint64][]*models.UserTenantMapping{}
+	for key, role := range desired {
+		if _, err := tenants.Membership(tx, key.userID, key.tenantID); err == nil {
+			continue
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		added[key.tenantID] = append(added[key.tenantID],
+			&models.UserTenantMapping{UserId: key.userID, TenantId: key.tenantID, Role: role, InheritedFrom: orgID})
+	}
+	// Claiming locks the tenant, so companies are visited in the same order by every sync
+	tenantIDs := make([]uint64, 0, len(added))
+	for tenantID := range added {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	sort.Slice(tenantIDs, func(i, j int) bool { return tenantIDs[i] < tenantIDs[j] })
+	for _, tenantID := range tenantIDs {
+		if err := tenants.ClaimSeats(tx, tenantID, len(added[tenantID])); err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(added[tenantID]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncForTenant re-syncs the organization the tenant belongs to, if any. It must run inside a transaction.
+func SyncForTenant(tx *gorm.DB, tenantID uint64) error {
+	var tenant models.Tenant
+	if err := tx.Select("organization_id").First(&tenant, tenantID).Error; err != nil {
+		return err
+	}
+	if tenant.OrganizationId == nil {
+		return nil
+	}
+	return Sync(tx, *tenant.OrganizationId)
+}
+
+// Delete removes the organization. Its companies stay, without the memberships inherited from it.
+// It must run inside a transaction.
+func Delete(tx *gorm.DB, orgID uint64) error {
+	if err := tx.Model(&models.Tenant{}).Where("organization_id = ?", orgID).Update("organization_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("member_id IN (?)", tx.Model(&models.OrganizationMember{}).Select("id").Where("organization_id = ?", orgID)).
+		Delete(&models.OrganizationMemberCompany{}).Error; err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&models.OrganizationMember{}, &models.OrganizationSubscription{}} {
+		if err := tx.Where("organization_id = ?", orgID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := Sync(tx, orgID); err != nil {
+		return err
+	}
+	return tx.Delete(&models.Organization{}, orgID).Error
+}
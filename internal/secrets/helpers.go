@@ -0,0 +1,175 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrServiceMismatch is returned when a secret is rotated under a different service
+	ErrServiceMismatch = errors.New("secret belongs to another service")
+	// ErrInvalidCredential is returned for a service key that is malformed, unknown or revoked
+	ErrInvalidCredential = errors.New("invalid service credential")
+)
+
+// additionalData binds a ciphertext to its tenant, secret name and version, so values cannot be
+// swapped between rows
+func additionalData(tenantID uint64, name string, version int) []byte {
+	return []byte(fmt.Sprintf("%d/%s/%d", tenantID, name, version))
+}
+
+// Put stores value as the new current version of the tenant's secret, creating the secret on
+// first use. It must run inside a transaction.
+func Put(tx *gorm.DB, vault *Vault, tenantID uint64, name, service string, value []byte, actorID uint64, now time.Time) (*models.TenantSecret, error) {
+	var secret models.TenantSecret
+	err := tx.Where("tenant_id = ? AND name = ?", tenantID, name).First(&secret).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		secret = models.TenantSecret{TenantId: tenantID, Name: name, Service: service}
+		err = tx.Create(&secret).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret.Service != service {
+		return nil, ErrServiceMismatch
+	}
+
+	// Versions are never reused, so a value can always be traced to when it was written
+	var latest int
+	if err := tx.Model(&models.TenantSecretVersion{}).Where("secret_id = ?", secret.ID).
+		Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+		return nil, err
+	}
+	version := latest + 1
+	sealed, err := vault.Seal(value, additionalData(tenantID, name, version))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&models.TenantSecretVersion{
+		SecretId: secret.ID, TenantId: tenantID, Version: version,
+		Ciphertext: sealed.Ciphertext, WrappedKey: sealed.WrappedKey, KeyId: sealed.KeyId,
+		CreatedBy: &actorID, CreatedAt: now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&secret).Updates(map[string]interface{}{"current_version": version, "updated_at": now}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := events.Publish(tx, events.TenantSecretRotated, &tenantID, map[string]interface{}{
+		"name":    name,
+		"service": service,
+		"version": version,
+	}); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// Reveal decrypts a version of the secret; version 0 means the current one
+func Reveal(db *gorm.DB, vault *Vault, secret *models.TenantSecret, version int) ([]byte, int, error) {
+	if version == 0 {
+		version = secret.CurrentVersion
+	}
+	var stored models.TenantSecretVersion
+	if err := db.Where("secret_id = ? AND version = ?", secret.ID, version).First(&stored).Error; err != nil {
+		return nil, 0, err
+	}
+	value, err := vault.Open(&Sealed{Ciphertext: stored.Ciphertext, WrappedKey: stored.WrappedKey, KeyId: stored.KeyId},
+		additionalData(secret.TenantId, secret.Name, version))
+	return value, version, err
+}
+
+// Delete removes the secret with every version. It must run inside a transaction.
+func Delete(tx *gorm.DB, secret *models.TenantSecret) error {
+	if err := tx.Where("secret_id = ?", secret.ID).Delete(&models.TenantSecretVersion{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(secret).Error
+}
+
+// RewrapAll moves every data key wrapped by the previous master key under the current one, so the
+// previous key can be retired. Values are not re-encrypted.
+func RewrapAll(db *gorm.DB, previous, current *Vault) error {
+	var versions []models.TenantSecretVersion
+	if err := db.Where("key_id = ?", previous.KeyID()).Find(&versions).Error; err != nil {
+		return err
+	}
+	for _, version := range versions {
+		sealed := &Sealed{Ciphertext: version.Ciphertext, WrappedKey: version.WrappedKey, KeyId: version.KeyId}
+		if err := previous.Rewrap(sealed, current); err != nil {
+			return err
+		}
+		if err := db.Model(&version).Updates(map[string]interface{}{
+			"wrapped_key": sealed.WrappedKey, "key_id": sealed.KeyId,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	if len(versions) > 0 {
+		log.Printf("[+] Rewrapped %d secret versions under master key %s\n", len(versions), current.KeyID())
+	}
+	return nil
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewCredential stores the credential with a fresh key and returns the key, which is not kept
+// and can only be shown now. Keys have the form "<id>.<secret>". It must run inside a transaction.
+func NewCredential(tx *gorm.DB, credential *models.ServiceCredential) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+	credential.KeyHash = hashKey(secret)
+	if err := tx.Create(credential).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%s", credential.ID, secret), nil
+}
+
+// Authenticate returns the active credential the key belongs to and records its use
+func Authenticate(db *gorm.DB, key string, now time.Time) (*models.ServiceCredential, error) {
+	idPart, secret, found := strings.Cut(key, ".")
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if !found || err != nil {
+		return nil, ErrInvalidCredential
+	}
+	var credential models.ServiceCredential
+	if err := db.Where("id = ? AND revoked_at IS NULL", id).First(&credential).Error; err != nil {
+		return nil, ErrInvalidCredential
+	}
+	if subtle.ConstantTimeCompare([]byte(hashKey(secret)), []byte(credential.KeyHash)) != 1 {
+		return nil, ErrInvalidCredential
+	}
+	if err := db.Model(&credential).Update("last_used_at", now).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// Allows reports whether the credential may read the secret
+func Allows(credential *models.ServiceCredential, secret *models.TenantSecret) bool {
+	if credential.Service != secret.Service {
+		return false
+	}
+	return credential.TenantId == nil || *credential.TenantId == secret.TenantId
+}
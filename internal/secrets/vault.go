@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// keySize is the length of master and data keys: AES-256
+const keySize = 32
+
+var (
+	// ErrInvalidKey is returned for a master key that is not 32 bytes of base64
+	ErrInvalidKey = errors.New("master key must be 32 bytes, base64 encoded")
+	// ErrUnknownKey is returned when a value was sealed with a master key the vault does not hold
+	ErrUnknownKey = errors.New("value was sealed with another master key")
+	// ErrDecrypt is returned when a value or its data key fails authentication
+	ErrDecrypt = errors.New("value could not be decrypted")
+)
+
+// Sealed is a value encrypted with a fresh data key, together with that key wrapped by the
+// master key. Both ciphertexts carry their nonce in front.
+type Sealed struct {
+	Ciphertext []byte
+	WrappedKey []byte
+	KeyId      string
+}
+
+// Vault encrypts values with envelope encryption under a locally configured master key
+type Vault struct {
+	master cipher.AEAD
+	keyID  string
+}
+
+// NewVault returns a vault for the 32 byte master key
+func NewVault(key []byte) (*Vault, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	master, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &Vault{master: master, keyID: hex.EncodeToString(sum[:8])}, nil
+}
+
+// ParseVault returns a vault for a base64 encoded master key
+func ParseVault(encoded string) (*Vault, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return NewVault(key)
+}
+
+// KeyID identifies the master key without revealing it
+func (v *Vault) KeyID() string {
+	return v.keyID
+}
+
+// Seal encrypts the value with a new data key and wraps that key with the master key. The
+// additional data binds the ciphertext to where it is stored; Open needs the same.
+func (v *Vault) Seal(value, additional []byte) (*Sealed, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(data, value, additional)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(v.master, dataKey, []byte(v.keyID))
+	if err != nil {
+		return nil, err
+	}
+	return &Sealed{Ciphertext: ciphertext, WrappedKey: wrapped, KeyId: v.keyID}, nil
+}
+
+// Open unwraps the data key and decrypts the value
+func (v *Vault) Open(sealed *Sealed, additional []byte) ([]byte, error) {
+	dataKey, err := v.unwrap(sealed)
+	if err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(data, sealed.Ciphertext, additional)
+}
+
+// Rewrap re-encrypts the data key of a value sealed by this vault under the master key of to.
+// The value itself is left untouched.
+func (v *Vault) Rewrap(sealed *Sealed, to *Vault) error {
+	dataKey, err := v.unwrap(sealed)
+	if err != nil {
+		return err
+	}
+	wrapped, err := seal(to.master, dataKey, []byte(to.keyID))
+	if err != nil {
+		return err
+	}
+	sealed.WrappedKey, sealed.KeyId = wrapped, to.keyID
+	return nil
+}
+
+func (v *Vault) unwrap(sealed *Sealed) ([]byte, error) {
+	if sealed.KeyId != v.keyID {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, sealed.KeyId)
+	}
+	return open(v.master, sealed.WrappedKey, []byte(v.keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additional []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, body := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, body, additional)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newTestVault(t *testing.T) *Vault {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	vault, err := ParseVault(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	return vault
+}
+
+// TestVault checks that sealed values only open with the same master key and additional data,
+// and still open after their data key is rewrapped under a new master key
+func TestVault(t *testing.T) {
+	vault := newTestVault(t)
+	value := []byte("s3cret-password")
+
+	sealed, err := vault.Seal(value, []byte("1/db/1"))
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	if bytes.Contains(sealed.Ciphertext, value) || sealed.KeyId != vault.KeyID() {
+		t.Fatalf("Expected an encrypted value tagged with the master key, got %+v", sealed)
+	}
+	if opened, err := vault.Open(sealed, []byte("1/db/1")); err != nil || !bytes.Equal(opened, value) {
+		t.Errorf("Expected the value back, got %q (%v)", opened, err)
+	}
+	if _, err := vault.Open(sealed, []byte("2/db/1")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected %v for other additional data, got %v", ErrDecrypt, err)
+	}
+
+	next := newTestVault(t)
+	if _, err := next.Open(sealed, []byte("1/db/1")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected %v for another master key, got %v", ErrUnknownKey, err)
+	}
+	ciphertext := sealed.Ciphertext
+	if err := vault.Rewrap(sealed, next); err != nil {
+		t.Fatalf("Failed to rewrap: %v", err)
+	}
+	if !bytes.Equal(sealed.Ciphertext, ciphertext) || sealed.KeyId != next.KeyID() {
+		t.Errorf("Expected only the data key to change, got %+v", sealed)
+	}
+	if opened, err := next.Open(sealed, []byte("1/db/1")); err != nil || !bytes.Equal(opened, value) {
+		t.Errorf("Expected the rewrapped value to open under the new key, got %q (%v)", opened, err)
+	}
+
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParseVault(encoded); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Expected %v for %q, got %v", ErrInvalidKey, encoded, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrUnknownSetting is returned for keys without a definition
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// ErrInvalidValue wraps the reason a value does not satisfy the setting's schema
+var ErrInvalidValue = errors.New("invalid setting value")
+
+// ErrVersionConflict is returned when a write names a version the setting no longer has
+var ErrVersionConflict = errors.New("setting version conflict")
+
+// builtinDefinitions are the settings every installation starts with
+var builtinDefinitions = []models.SettingDefinition{
+	{Key: "financial_year_start", Description: "First day of the financial year as MM-DD",
+		Schema: `{"type": "string", "pattern": "^(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01])$"}`, Default: `"04-01"`},
+	{Key: "tally_version", Description: "Tally release the company's data comes from",
+		Schema: `{"type": "string", "maxLength": 50}`, Default: `""`},
+	{Key: "gstin", Description: "GST identification number of the company",
+		Schema: `{"type": "string", "pattern": "^([0-9]{2}[0-9A-Z]{13})?$"}`, Default: `""`},
+	{Key: "sync_interval_minutes", Description: "How often Tally data is synchronised",
+		Schema: `{"type": "integer", "minimum": 1, "maximum": 1440}`, Default: `15`},
+	{Key: "branch_code", Description: "Branch code used on documents",
+		Schema: `{"type": "string", "maxLength": 20}`, Default: `""`},
+}
+
+// SeedDefinitions adds the built-in setting definitions that do not exist yet
+func SeedDefinitions(db *gorm.DB) error {
+	definitions := make([]models.SettingDefinition, len(builtinDefinitions))
+	copy(definitions, builtinDefinitions)
+	return db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+		Create(&definitions).Error
+}
+
+// CheckDefinition verifies that the definition's schema is usable and its default satisfies it
+func CheckDefinition(definition *models.SettingDefinition) error {
+	schema, err := ParseSchema([]byte(definition.Schema))
+	if err != nil {
+		return err
+	}
+	if err := schema.Validate([]byte(definition.Default)); err != nil {
+		return errors.New("default: " + err.Error())
+	}
+	return nil
+}
+
+// Validate checks a value against the definition of the key
+func Validate(db *gorm.DB, key string, value []byte) error {
+	var definition models.SettingDefinition
+	if err := db.Where("key = ?", key).First(&definition).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUnknownSetting
+	} else if err != nil {
+		return err
+	}
+	schema, err := ParseSchema([]byte(definition.Schema))
+	if err != nil {
+		return err
+	}
+	if err := schema.Validate(value); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidValue, err)
+	}
+	return nil
+}
+
+// Entry is a tenant's effective value of one setting. Version 0 means the default is in use.
+type Entry struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   uint64          `json:"version"`
+	IsDefault bool            `json:"is_default"`
+}
+
+// Effective returns the tenant's value of every defined setting, or of the given keys only,
+// falling back to the defaults, ordered by key
+func Effective(db *gorm.DB, tenantID uint64, keys []string) ([]Entry, error) {
+	query := db.Order("key")
+	if len(keys) > 0 {
+		query = query.Where("key IN ?", keys)
+	}
+	var definitions []models.SettingDefinition
+	if err := query.Find(&definitions).Error; err != nil {
+		return nil, err
+	}
+
+	var stored []models.TenantSetting
+	if err := db.Where("tenant_id = ?", tenantID).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]models.TenantSetting, len(stored))
+	for _, setting := range stored {
+		byKey[setting.Key] = setting
+	}
+
+	entries := make([]Entry, 0, len(definitions))
+	for _, definition := range definitions {
+		if setting, ok := byKey[definition.Key]; ok {
+			entries = append(entries, Entry{Key: setting.Key, Value: json.RawMessage(setting.Value), Version: setting.Version})
+			continue
+		}
+		entries = append(entries, Entry{Key: definition.Key, Value: json.RawMessage(definition.Default), IsDefault: true})
+	}
+	return entries, nil
+}
+
+// AsMap returns the effective settings keyed by name, as included in a resolved tenant
+func AsMap(db *gorm.DB, tenantID uint64, keys []string) (map[string]json.RawMessage, error) {
+	entries, err := Effective(db, tenantID, keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage, len(entries))
+	for _, entry := range entries {
+		values[entry.Key] = entry.Value
+	}
+	return values, nil
+}
+
+// Save validates and stores the tenant's value of a setting, replacing version (0 when the
+// default is in use), and records the revision. It must run inside a transaction.
+func Save(tx *gorm.DB, tenantID uint64, key string, value []byte, version uint64, actorID *uint64, now time.Time) (*models.TenantSetting, error) {
+	if err := Validate(tx, key, value); err != nil {
+		return nil, err
+	}
+
+	var setting models.TenantSetting
+	err := tx.Where("tenant_id = ? AND key = ?", tenantID, key).First(&setting).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if version != 0 {
+			return nil, ErrVersionConflict
+		}
+		// A reset keeps counting from the last recorded revision so versions are never reused
+		var last uint64
+		if err := tx.Model(&models.TenantSettingRevision{}).Where("tenant_id = ? AND key = ?", tenantID, key).
+			Select("COALESCE(MAX(version), 0)").Scan(&last).Error; err != nil {
+			return nil, err
+		}
+		setting = models.TenantSetting{TenantId: tenantID, Key: key, Value: string(value), Version: last + 1, UpdatedBy: actorID, UpdatedAt: now}
+		if err := tx.Create(&setting).Error; err != nil {
+			if util.IsDuplicateKeyError(err) {
+				return nil, ErrVersionConflict
+			}
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		result := tx.Model(&models.TenantSetting{}).Where("id = ? AND version = ?", setting.ID, version).
+			Updates(map[string]interface{}{"value": string(value), "version": version + 1, "updated_by": actorID, "updated_at": now})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, ErrVersionConflict
+		}
+		setting.Value, setting.Version, setting.UpdatedBy, setting.UpdatedAt = string(value), version+1, actorID, now
+	}
+
+	stored := setting.Value
+	return &setting, tx.Create(&models.TenantSettingRevision{
+		TenantId: tenantID, Key: key, Version: setting.Version, Value: &stored, ActorId: actorID, CreatedAt: now,
+	}).Error
+}
+
+// Reset removes the tenant's value of a setting at the given version so the default applies
+// again, and records the reset as a revision. It must run inside a transaction.
+func Reset(tx *gorm.DB, tenantID uint64, key string, version uint64, actorID *uint64, now time.Time) error {
+	result := tx.Where("tenant_id = ? AND key = ? AND version = ?", tenantID, key, version).Delete(&models.TenantSetting{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return tx.Create(&models.TenantSettingRevision{
+		TenantId: tenantID, Key: key, Version: version + 1, ActorId: actorID, CreatedAt: now,
+	}).Error
+}
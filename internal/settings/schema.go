@@ -0,0 +1,193 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"sort"
+	"unicode/utf8"
+)
+
+// Schema is the subset of JSON Schema that setting definitions may use: type, enum, numeric and
+// length bounds, pattern, object properties with required keys, and array items
+type Schema struct {
+	Type                 string             `json:"type"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var schemaTypes = []string{"string", "integer", "number", "boolean", "object", "array"}
+
+// ParseSchema reads a schema document and checks that it only uses the supported keywords
+func ParseSchema(raw []byte) (*Schema, error) {
+	var schema Schema
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := schema.compile("$"); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func (s *Schema) compile(path string) error {
+	if !slices.Contains(schemaTypes, s.Type) {
+		return fmt.Errorf("%s: type must be one of %v", path, schemaTypes)
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+		s.pattern = pattern
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s.%s: missing schema", path, name)
+		}
+		if err := property.compile(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+// Validate decodes the JSON document and checks it against the schema
+func (s *Schema) Validate(raw []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return errors.New("value is not a JSON document")
+	}
+	if decoder.More() {
+		return errors.New("value must be a single JSON document")
+	}
+	return s.validate("$", value)
+}
+
+func (s *Schema) validate(path string, value interface{}) error {
+	switch s.Type {
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+		length := utf8.RuneCountInString(text)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s: must be at least %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s: must be at most %d characters", path, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(text) {
+			return fmt.Errorf("%s: must match %s", path, s.Pattern)
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected a %s", path, s.Type)
+		}
+		parsed, _, err := big.ParseFloat(number.String(), 10, 64, big.ToNearestEven)
+		if err != nil {
+			return fmt.Errorf("%s: expected a %s", path, s.Type)
+		}
+		if s.Type == "integer" && !parsed.IsInt() {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+		float, _ := parsed.Float64()
+		if s.Minimum != nil && float < *s.Minimum {
+			return fmt.Errorf("%s: must be at least %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && float > *s.Maximum {
+			return fmt.Errorf("%s: must be at most %v", path, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", path)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s: is required", path, name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s: is not allowed", path, name)
+				}
+				continue
+			}
+			if err := property.validate(path+"."+name, object[name]); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			return fmt.Errorf("%s: must have at least %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(items) > *s.MaxItems {
+			return fmt.Errorf("%s: must have at most %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(s.Enum) > 0 && !s.inEnum(value) {
+		return fmt.Errorf("%s: must be one of %v", path, s.Enum)
+	}
+	return nil
+}
+
+// inEnum compares the value with the allowed ones by their JSON encoding
+func (s *Schema) inEnum(value interface{}) bool {
+	encoded, _ := json.Marshal(value)
+	for _, allowed := range s.Enum {
+		if candidate, _ := json.Marshal(allowed); bytes.Equal(candidate, encoded) {
+			return true
+		}
+	}
+	return false
+}
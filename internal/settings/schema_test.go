@@ -0,0 +1,47 @@
+package settings
+
+import (
+	"testing"
+)
+
+// TestSchemaValidate checks the supported keywords against valid and invalid documents
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+		"type": "object",
+		"required": ["interval"],
+		"additionalProperties": false,
+		"properties": {
+			"interval": {"type": "integer", "minimum": 1, "maximum": 60},
+			"mode": {"type": "string", "enum": ["push", "pull"]},
+			"branches": {"type": "array", "maxItems": 2, "items": {"type": "string", "pattern": "^[A-Z]{3}$"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	cases := map[string]bool{
+		`{"interval": 5}`: true,
+		`{"interval": 5, "mode": "push", "branches": ["BLR"]}`: true,
+		`{"interval": 5.5}`:                                  false,
+		`{"interval": 0}`:                                    false,
+		`{"mode": "push"}`:                                   false,
+		`{"interval": 5, "mode": "sync"}`:                    false,
+		`{"interval": 5, "branches": ["blr"]}`:               false,
+		`{"interval": 5, "branches": ["BLR", "DEL", "BOM"]}`: false,
+		`{"interval": 5, "extra": true}`:                     false,
+		`{"interval": 5} {}`:                                 false,
+		`[]`:                                                 false,
+	}
+	for document, valid := range cases {
+		if err := schema.Validate([]byte(document)); (err == nil) != valid {
+			t.Errorf("Validate(%s) = %v, expected valid=%v", document, err, valid)
+		}
+	}
+
+	for _, invalid := range []string{`{"type": "date"}`, `{"type": "string", "format": "email"}`, `{"type": "string", "pattern": "("}`} {
+		if _, err := ParseSchema([]byte(invalid)); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
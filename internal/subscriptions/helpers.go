@@ -1 +1,27 @@
 package subscriptions
+
+import (
+	"slices"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ForUser returns the IDs of the subscriptions the user holds, directly or through the
+// organizations they belong to
+func ForUser(db *gorm.DB, userID uint64) ([]uint32, error) {
+	var direct, inherited []uint32
+	if err := db.Model(&models.UserSubscriptionMapping{}).Where("user_id = ?", userID).
+		Pluck("subscription_id", &direct).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.OrganizationSubscription{}).
+		Where("organization_id IN (?)", db.Model(&models.OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID)).
+		Pluck("subscription_id", &inherited).Error; err != nil {
+		return nil, err
+	}
+	ids := append(direct, inherited...)
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
@@ -0,0 +1,111 @@
+package tenants
+
+import (
+	"log"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// endpointOrder sorts primaries before standbys, then by priority and weight
+const endpointOrder = "CASE WHEN role = 'primary' THEN 0 ELSE 1 END, priority, weight DESC, id"
+
+// legacyEndpoints derives one primary endpoint per service from the tenant's single-host fields
+func legacyEndpoints(tenant *models.Tenant) []models.TenantEndpoint {
+	var endpoints []models.TenantEndpoint
+	for _, target := range ProbeTargets(tenant) {
+		endpoints = append(endpoints, models.TenantEndpoint{
+			TenantId: tenant.ID,
+			Service:  target.Service,
+			Host:     target.Host,
+			Port:     target.Port,
+			Role:     models.EndpointPrimary,
+			Weight:   1,
+			Health:   models.HealthUnknown,
+		})
+	}
+	return endpoints
+}
+
+// CreateLegacyEndpoints registers the tenant's single-host fields as its primary endpoints
+func CreateLegacyEndpoints(db *gorm.DB, tenant *models.Tenant) error {
+	endpoints := legacyEndpoints(tenant)
+	if len(endpoints) == 0 {
+		return nil
+	}
+	return db.Create(&endpoints).Error
+}
+
+// EnsureEndpoints gives every tenant without endpoints the ones described by its single-host fields
+func EnsureEndpoints(db *gorm.DB) error {
+	var tenants []models.Tenant
+	if err := db.Where("id NOT IN (?)", db.Model(&models.TenantEndpoint{}).Select("tenant_id")).
+		Find(&tenants).Error; err != nil {
+		return err
+	}
+	created := 0
+	for i := range tenants {
+		if err := CreateLegacyEndpoints(db, &tenants[i]); err != nil {
+			return err
+		}
+		created++
+	}
+	if created > 0 {
+		log.Printf("[+] Registered endpoints for %d tenants from their host fields\n", created)
+	}
+	return nil
+}
+
+// Endpoints returns every endpoint of the tenant in the order clients should try them
+func Endpoints(db *gorm.DB, tenantID uint64) ([]models.TenantEndpoint, error) {
+	var endpoints []models.TenantEndpoint
+	err := db.Where("tenant_id = ?", tenantID).Order("service, " + endpointOrder).Find(&endpoints).Error
+	return endpoints, err
+}
+
+// HealthyEndpoints groups the tenant's endpoints that are not known to be down by service, in the
+// order clients should try them. Endpoints that have not been probed yet count as healthy.
+func HealthyEndpoints(db *gorm.DB, tenantID uint64) (map[string][]models.TenantEndpoint, error) {
+	var endpoints []models.TenantEndpoint
+	if err := db.Where("tenant_id = ? AND health <> ?", tenantID, models.HealthDown).
+		Order(endpointOrder).Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	byService := make(map[string][]models.TenantEndpoint)
+	for _, endpoint := range endpoints {
+		byService[endpoint.Service] = append(byService[endpoint.Service], endpoint)
+	}
+	return byService, nil
+}
+
+// SyncLegacyFields points the tenant's single-host fields at its first primary endpoints, so that
+// older clients keep reading a usable host and ports. The host follows the BMRM endpoint.
+func SyncLegacyFields(db *gorm.DB, tenantID uint64) error {
+	var endpoints []models.TenantEndpoint
+	if err := db.Where("tenant_id = ? AND role = ?", tenantID, models.EndpointPrimary).
+		Order(endpointOrder).Find(&endpoints).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{}
+	columns := map[string]string{
+		models.ServiceBmrm:      "bmrm_port",
+		models.ServiceSgBiz:     "sg_biz_port",
+		models.ServiceTallySync: "tally_sync_port",
+	}
+	for _, endpoint := range endpoints {
+		column := columns[endpoint.Service]
+		if _, done := updates[column]; done {
+			continue
+		}
+		updates[column] = endpoint.Port
+		if _, done := updates["host"]; !done || endpoint.Service == models.ServiceBmrm {
+			updates["host"] = endpoint.Host
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return db.Model(&models.Tenant{}).Where("id = ?", tenantID).Updates(updates).Error
+}
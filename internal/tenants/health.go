@@ -0,0 +1,307 @@
+package tenants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Health check modes
+const (
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+)
+
+// ProbeTarget is one service endpoint of a tenant to check
+type ProbeTarget struct {
+	TenantId   uint64
+	EndpointId uint64 // 0 when taken from the tenant's single-host fields
+	Service    string
+	Host       string
+	Port       uint32
+}
+
+// ProbeTargets lists the services a tenant exposes; services without a port are skipped
+func ProbeTargets(tenant *models.Tenant) []ProbeTarget {
+	var targets []ProbeTarget
+	for _, target := range []ProbeTarget{
+		{Service: models.ServiceBmrm, Port: tenant.BmrmPort},
+		{Service: models.ServiceSgBiz, Port: tenant.SgBizPort},
+		{Service: models.ServiceTallySync, Port: tenant.TallySyncPort},
+	} {
+		if tenant.Host != "" && target.Port != 0 {
+			target.TenantId = tenant.ID
+			target.Host = tenant.Host
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// Prober checks every tenant's services with a TCP connect or an HTTP GET on a schedule
+type Prober struct {
+	db          *gorm.DB
+	Mode        string        // ProbeTCP or ProbeHTTP
+	HTTPPath    string        // Path requested in HTTP mode, e.g. "/health"
+	Timeout     time.Duration // Per check
+	Concurrency int           // Checks run in parallel
+	Retention   time.Duration // How long check history is kept
+	client      *http.Client  // Shared by the concurrent checks, so it is built once up front
+}
+
+// NewProber initializes a Prober with the defaults used by the server
+func NewProber(db *gorm.DB) *Prober {
+	return &Prober{
+		db:          db,
+		Mode:        ProbeTCP,
+		HTTPPath:    "/health",
+		Timeout:     5 * time.Second,
+		Concurrency: 16,
+		Retention:   7 * 24 * time.Hour,
+		client:      &http.Client{},
+	}
+}
+
+// Check probes a single target and returns the resulting history entry
+func (p *Prober) Check(ctx context.Context, target ProbeTarget) models.TenantHealthCheck {
+	check := models.TenantHealthCheck{
+		TenantId:   target.TenantId,
+		EndpointId: target.EndpointId,
+		Service:    target.Service,
+		Host:       target.Host,
+		Port:       target.Port,
+		Status:     models.HealthUp,
+		CheckedAt:  time.Now(),
+	}
+	address := net.JoinHostPort(target.Host, strconv.FormatUint(uint64(target.Port), 10))
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var err error
+	start := time.Now()
+	if p.Mode == ProbeHTTP {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+p.HTTPPath, nil)
+		if err == nil {
+			var resp *http.Response
+			resp, err = p.client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode >= 500 {
+					err = fmt.Errorf("health endpoint returned %d", resp.StatusCode)
+				}
+			}
+		}
+	} else {
+		var conn net.Conn
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err == nil {
+			conn.Close()
+		}
+	}
+	check.LatencyMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		check.Status = models.HealthDown
+		check.Error = err.Error()
+		if len(check.Error) > 500 {
+			check.Error = check.Error[:500]
+		}
+	}
+	return check
+}
+
+// recordEndpoint updates the health of the checked endpoint and raises an event when it changed.
+// It returns the check as seen at service level: while another endpoint of the service is up,
+// the service is reported up through that endpoint.
+func (p *Prober) recordEndpoint(check models.TenantHealthCheck) (models.TenantHealthCheck, error) {
+	var endpoint models.TenantEndpoint
+	if err := p.db.First(&endpoint, check.EndpointId).Error; err != nil {
+		return check, err
+	}
+	if err := p.db.Model(&endpoint).Updates(map[string]interface{}{
+		"health":     check.Status,
+		"checked_at": check.CheckedAt,
+	}).Error; err != nil {
+		return check, err
+	}
+	if endpoint.Health != check.Status {
+		if err := events.Publish(p.db, events.TenantEndpointHealthChanged, &check.TenantId, map[string]interface{}{
+			"endpoint_id": endpoint.ID,
+			"service":     endpoint.Service,
+			"host":        endpoint.Host,
+			"port":        endpoint.Port,
+			"role":        endpoint.Role,
+			"from":        endpoint.Health,
+			"to":          check.Status,
+		}); err != nil {
+			return check, err
+		}
+	}
+
+	if check.Status == models.HealthUp {
+		return check, nil
+	}
+	var fallback models.TenantEndpoint
+	err := p.db.Where("tenant_id = ? AND service = ? AND health = ?", check.TenantId, check.Service, models.HealthUp).
+		Order(endpointOrder).First(&fallback).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return check, nil
+	}
+	if err != nil {
+		return check, err
+	}
+	check.Host = fallback.Host
+	check.Port = fallback.Port
+	check.Status = models.HealthUp
+	check.Error = ""
+	return check, nil
+}
+
+// record stores the check, updates the current status and raises an event when the status changed
+func (p *Prober) record(check models.TenantHealthCheck) error {
+	if err := p.db.Create(&check).Error; err != nil {
+		return err
+	}
+	if check.EndpointId != 0 {
+		var err error
+		if check, err = p.recordEndpoint(check); err != nil {
+			return err
+		}
+	}
+
+	var status models.TenantServiceStatus
+	err := p.db.Where("tenant_id = ? AND service = ?", check.TenantId, check.Service).First(&status).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	previous := status.Status
+	if previous == "" {
+		previous = models.HealthUnknown
+	}
+
+	status.TenantId = check.TenantId
+	status.Service = check.Service
+	status.Host = check.Host
+	status.Port = check.Port
+	status.LatencyMs = check.LatencyMs
+	status.Error = check.Error
+	status.CheckedAt = check.CheckedAt
+	if previous != check.Status {
+		status.ChangedAt = check.CheckedAt
+	}
+	status.Status = check.Status
+	if err := p.db.Save(&status).Error; err != nil {
+		return err
+	}
+
+	if previous != check.Status {
+		return events.Publish(p.db, events.TenantHealthChanged, &check.TenantId, map[string]interface{}{
+			"service": check.Service,
+			"host":    check.Host,
+			"port":    check.Port,
+			"from":    previous,
+			"to":      check.Status,
+			"error":   check.Error,
+		})
+	}
+	return nil
+}
+
+// ProbeAll checks every tenant's services once
+func (p *Prober) ProbeAll(ctx context.Context) error {
+	targets, err := p.targets()
+	if err != nil {
+		return err
+	}
+
+	checks := make(chan models.TenantHealthCheck)
+	queue := make(chan ProbeTarget)
+	var wg sync.WaitGroup
+	for i := 0; i < max(p.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range queue {
+				checks <- p.Check(ctx, target)
+			}
+		}()
+	}
+	go func() {
+		for _, target := range targets {
+			queue <- target
+		}
+		close(queue)
+		wg.Wait()
+		close(checks)
+	}()
+
+	// Results are written from this goroutine only
+	for check := range checks {
+		if err := p.record(check); err != nil {
+			log.Printf("[!] Could not record health of tenant %d %s: %v\n", check.TenantId, check.Service, err)
+		}
+	}
+	return nil
+}
+
+// targets lists the service endpoints of every tenant, falling back to the single-host
+// fields of tenants that have no registered endpoints
+func (p *Prober) targets() ([]ProbeTarget, error) {
+	// Archived and deleted tenants are no longer served, so they are not probed
+	var tenants []models.Tenant
+	if err := p.db.Where("state NOT IN ?", []string{models.TenantArchived, models.TenantDeleted}).
+		Find(&tenants).Error; err != nil {
+		return nil, err
+	}
+	var endpoints []models.TenantEndpoint
+	if err := p.db.Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	byTenant := make(map[uint64][]ProbeTarget)
+	for _, endpoint := range endpoints {
+		byTenant[endpoint.TenantId] = append(byTenant[endpoint.TenantId], ProbeTarget{
+			TenantId:   endpoint.TenantId,
+			EndpointId: endpoint.ID,
+			Service:    endpoint.Service,
+			Host:       endpoint.Host,
+			Port:       endpoint.Port,
+		})
+	}
+
+	var targets []ProbeTarget
+	for i := range tenants {
+		if registered, ok := byTenant[tenants[i].ID]; ok {
+			targets = append(targets, registered...)
+		} else {
+			targets = append(targets, ProbeTargets(&tenants[i])...)
+		}
+	}
+	return targets, nil
+}
+
+// Run probes all tenants every interval and prunes old history; it is meant to run in its own goroutine
+func (p *Prober) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		if err := p.ProbeAll(context.Background()); err != nil {
+			log.Printf("[!] Tenant health probe failed: %v\n", err)
+		}
+		if err := p.db.Where("checked_at < ?", time.Now().Add(-p.Retention)).Delete(&models.TenantHealthCheck{}).Error; err != nil {
+			log.Printf("[!] Tenant health history purge failed: %v\n", err)
+		}
+	}
+}
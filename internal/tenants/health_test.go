@@ -0,0 +1,109 @@
+package tenants
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestProbeAll checks that service states are recorded and that state changes raise events
+func TestProbeAll(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Tenant{}, &models.TenantEndpoint{}, &models.TenantServiceStatus{}, &models.TenantHealthCheck{}, &models.Event{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := uint32(listener.Addr().(*net.TCPAddr).Port)
+	db.Create(&models.Tenant{CompanyGuid: "guid-1", CompanyName: "Acme", Host: "127.0.0.1", BmrmPort: port})
+
+	prober := NewProber(db)
+	if err := prober.ProbeAll(context.Background()); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	listener.Close()
+	if err := prober.ProbeAll(context.Background()); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+
+	var status models.TenantServiceStatus
+	db.Where("service = ?", models.ServiceBmrm).First(&status)
+	if status.Status != models.HealthDown {
+		t.Errorf("Expected bmrm to be down after the listener closed, got %s", status.Status)
+	}
+
+	var checks, changes int64
+	db.Model(&models.TenantHealthCheck{}).Count(&checks)
+	db.Model(&models.Event{}).Where("type = ?", events.TenantHealthChanged).Count(&changes)
+	if checks != 2 || changes != 2 {
+		t.Errorf("Expected 2 checks and 2 status changes, got %d and %d", checks, changes)
+	}
+}
+
+// TestEndpointFailover checks that a down primary is dropped from the healthy endpoints while the
+// service stays up through its standby
+func TestEndpointFailover(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Tenant{}, &models.TenantEndpoint{}, &models.TenantServiceStatus{}, &models.TenantHealthCheck{}, &models.Event{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	standby, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer standby.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closed.Close()
+
+	tenant := &models.Tenant{CompanyGuid: "guid-1", CompanyName: "Acme", Host: "127.0.0.1", BmrmPort: uint32(closed.Addr().(*net.TCPAddr).Port)}
+	db.Create(tenant)
+	if err := EnsureEndpoints(db); err != nil {
+		t.Fatalf("Failed to register endpoints: %v", err)
+	}
+	db.Create(&models.TenantEndpoint{
+		TenantId: tenant.ID, Service: models.ServiceBmrm, Host: "127.0.0.1",
+		Port: uint32(standby.Addr().(*net.TCPAddr).Port), Role: models.EndpointStandby, Weight: 1, Health: models.HealthUnknown,
+	})
+
+	// Before any probe both endpoints are offered, primary first
+	healthy, _ := HealthyEndpoints(db, tenant.ID)
+	if len(healthy[models.ServiceBmrm]) != 2 || healthy[models.ServiceBmrm][0].Role != models.EndpointPrimary {
+		t.Fatalf("Expected the primary then the standby, got %+v", healthy)
+	}
+
+	prober := NewProber(db)
+	for i := 0; i < 2; i++ {
+		if err := prober.ProbeAll(context.Background()); err != nil {
+			t.Fatalf("Probe failed: %v", err)
+		}
+	}
+
+	healthy, _ = HealthyEndpoints(db, tenant.ID)
+	if len(healthy[models.ServiceBmrm]) != 1 || healthy[models.ServiceBmrm][0].Role != models.EndpointStandby {
+		t.Errorf("Expected only the standby to remain, got %+v", healthy)
+	}
+	var status models.TenantServiceStatus
+	db.Where("tenant_id = ? AND service = ?", tenant.ID, models.ServiceBmrm).First(&status)
+	if status.Status != models.HealthUp || status.Port != healthy[models.ServiceBmrm][0].Port {
+		t.Errorf("Expected bmrm to stay up through the standby, got %+v", status)
+	}
+}
@@ -1 +1,87 @@
 package tenants
+
+import (
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ByCompanyGuid returns the tenant registered for the Tally company GUID
+func ByCompanyGuid(db *gorm.DB, companyGuid string) (*models.Tenant, error) {
+	var tenant models.Tenant
+	err := db.Where("company_guid = ?", companyGuid).First(&tenant).Error
+	return &tenant, err
+}
+
+// Membership returns the user's mapping to the tenant, or gorm.ErrRecordNotFound when they are not a member
+func Membership(db *gorm.DB, userID, tenantID uint64) (*models.UserTenantMapping, error) {
+	var mapping models.UserTenantMapping
+	err := db.Where("user_id = ? AND tenant_id = ?", userID, tenantID).First(&mapping).Error
+	return &mapping, err
+}
+
+// ActiveMembership returns the user's mapping to the tenant like Membership, or ErrSeatInactive
+// when their seat is deactivated and they may not act in the company
+func ActiveMembership(db *gorm.DB, userID, tenantID uint64) (*models.UserTenantMapping, error) {
+	mapping, err := Membership(db, userID, tenantID)
+	if err == nil && mapping.DeactivatedAt != nil {
+		return mapping, ErrSeatInactive
+	}
+	return mapping, err
+}
+
+// CanManageMembers reports whether the role may add, remove or change members
+func CanManageMembers(role string) bool {
+	return role == models.RoleOwner || role == models.RoleAdmin
+}
+
+// CountOwners returns how many active owners the tenant has; a deactivated owner cannot manage it
+func CountOwners(db *gorm.DB, tenantID uint64) (int64, error) {
+	var owners int64
+	err := db.Model(&models.UserTenantMapping{}).
+		Where("tenant_id = ? AND role = ? AND deactivated_at IS NULL", tenantID, models.RoleOwner).Count(&owners).Error
+	return owners, err
+}
+
+// SoleOwnerOf returns the tenants where the user is the only owner
+func SoleOwnerOf(db *gorm.DB, userID uint64) ([]uint64, error) {
+	var owned []models.UserTenantMapping
+	if err := db.Where("user_id = ? AND role = ? AND deactivated_at IS NULL", userID, models.RoleOwner).Find(&owned).Error; err != nil {
+		return nil, err
+	}
+	var sole []uint64
+	for _, mapping := range owned {
+		owners, err := CountOwners(db, mapping.TenantId)
+		if err != nil {
+			return nil, err
+		}
+		if owners == 1 {
+			sole = append(sole, mapping.TenantId)
+		}
+	}
+	return sole, nil
+}
+
+// EnsureOwners promotes the earliest member of every tenant without an owner, so mappings
+// created before roles existed keep someone able to manage them. The shared demo tenant is skipped.
+func EnsureOwners(db *gorm.DB) error {
+	var orphaned []uint64
+	err := db.Model(&models.UserTenantMapping{}).Distinct("tenant_id").
+		Where("tenant_id NOT IN (?)", db.Model(&models.UserTenantMapping{}).Select("tenant_id").Where("role = ?", models.RoleOwner)).
+		Where("tenant_id NOT IN (?)", db.Model(&models.Tenant{}).Select("id").Where("company_guid = ? OR company_name = ?", "default", "default")).
+		Pluck("tenant_id", &orphaned).Error
+	if err != nil {
+		return err
+	}
+
+	for _, tenantID := range orphaned {
+		var first models.UserTenantMapping
+		if err := db.Where("tenant_id = ?", tenantID).Order("id").First(&first).Error; err != nil {
+			return err
+		}
+		if err := db.Model(&first).Update("role", models.RoleOwner).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
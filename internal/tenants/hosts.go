@@ -0,0 +1,367 @@
+package tenants
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoCapacity is returned when no matching host can take another tenant
+	ErrNoCapacity = errors.New("no host has capacity for another tenant")
+	// ErrPortsExhausted is returned when the chosen host has too few free ports left in its range
+	ErrPortsExhausted = errors.New("host has no free ports left")
+	// ErrPortTaken is returned when a requested port is already allocated on the host
+	ErrPortTaken = errors.New("port is already allocated on the host")
+	// ErrPortOutOfRange is returned when a port lies outside the host's range
+	ErrPortOutOfRange = errors.New("port is outside the host's range")
+	// ErrHostInUse is returned when a host that still carries tenants is renamed or deleted
+	ErrHostInUse = errors.New("host still carries tenants")
+)
+
+// allServices are the services a tenant placed without a template gets ports for
+var allServices = []string{models.ServiceBmrm, models.ServiceSgBiz, models.ServiceTallySync}
+
+// HostSpec is a host together with its labels
+type HostSpec struct {
+	models.Host
+	Labels []string `json:"labels"`
+}
+
+// HostUsage is one line of the utilization report
+type HostUsage struct {
+	HostSpec
+	Tenants     int64   `json:"tenants"`
+	PortsTotal  int64   `json:"ports_total"`
+	PortsUsed   int64   `json:"ports_used"`
+	Utilization float64 `json:"utilization"` // Tenants as a percentage of capacity
+}
+
+// HostTenants counts the tenants on the named host, including deleted ones that are not purged yet
+func HostTenants(db *gorm.DB, name string) (int64, error) {
+	var count int64
+	err := db.Model(&models.Tenant{}).Where("host = ? AND purged_at IS NULL", name).Count(&count).Error
+	return count, err
+}
+
+// SaveHost creates or updates the host and replaces its labels. The ports of tenants already on
+// the host are reserved so they are not handed out again. It must run inside a transaction.
+func SaveHost(tx *gorm.DB, spec *HostSpec) error {
+	host := &spec.Host
+	if host.ID != 0 {
+		var existing models.Host
+		if err := tx.First(&existing, host.ID).Error; err != nil {
+			return err
+		}
+		if existing.Name != host.Name {
+			count, err := HostTenants(tx, existing.Name)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrHostInUse
+			}
+		}
+		var outside int64
+		if err := tx.Model(&models.PortAllocation{}).
+			Where("host_id = ? AND (port < ? OR port > ?)", host.ID, host.PortFrom, host.PortTo).
+			Count(&outside).Error; err != nil {
+			return err
+		}
+		if outside > 0 {
+			return fmt.Errorf("%w: %d allocated ports would fall outside it", ErrPortOutOfRange, outside)
+		}
+	}
+	if err := tx.Save(host).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("host_id = ?", host.ID).Delete(&models.HostLabel{}).Error; err != nil {
+		return err
+	}
+	for _, label := range spec.Labels {
+		if err := tx.Create(&models.HostLabel{HostId: host.ID, Label: label}).Error; err != nil {
+			return err
+		}
+	}
+
+	// Tenants placed by hand before the host was registered may share ports; the first one keeps it
+	var existing []models.Tenant
+	if err := tx.Where("host = ? AND purged_at IS NULL", host.Name).Order("id").Find(&existing).Error; err != nil {
+		return err
+	}
+	for i := range existing {
+		for _, target := range ProbeTargets(&existing[i]) {
+			if target.Port < host.PortFrom || target.Port > host.PortTo {
+				continue
+			}
+			var taken int64
+			if err := tx.Model(&models.PortAllocation{}).Where("host_id = ? AND port = ?", host.ID, target.Port).
+				Count(&taken).Error; err != nil {
+				return err
+			}
+			if taken > 0 {
+				continue
+			}
+			if err := tx.Create(&models.PortAllocation{
+				HostId: host.ID, Port: target.Port, TenantId: existing[i].ID, Service: target.Service,
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadHost returns the host with its labels
+func LoadHost(db *gorm.DB, host models.Host) (*HostSpec, error) {
+	spec := &HostSpec{Host: host}
+	err := db.Model(&models.HostLabel{}).Where("host_id = ?", host.ID).Order("label").Pluck("label", &spec.Labels).Error
+	return spec, err
+}
+
+// DeleteHost removes the host with its labels and port allocations. It must run inside a transaction.
+func DeleteHost(tx *gorm.DB, host *models.Host) error {
+	count, err := HostTenants(tx, host.Name)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrHostInUse
+	}
+	for _, model := range []interface{}{&models.HostLabel{}, &models.PortAllocation{}} {
+		if err := tx.Where("host_id = ?", host.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Delete(host).Error
+}
+
+// PickHost returns the least utilized host that is not draining, has capacity left and carries
+// every label. When pool is not empty only hosts named in it are considered.
+func PickHost(db *gorm.DB, pool []string, labels []string) (*models.Host, error) {
+	query := db.Model(&models.Host{}).
+		Select("hosts.*").
+		Joins("LEFT JOIN tenants ON tenants.host = hosts.name AND tenants.purged_at IS NULL").
+		Where("hosts.draining = ?", false).
+		Group("hosts.id").
+		Having("COUNT(tenants.id) < hosts.capacity").
+		Order("CAST(COUNT(tenants.id) AS REAL) / hosts.capacity, hosts.name")
+	if len(pool) > 0 {
+		query = query.Where("hosts.name IN ?", pool)
+	}
+	for _, label := range labels {
+		query = query.Where("hosts.id IN (?)", db.Model(&models.HostLabel{}).Select("host_id").Where("label = ?", label))
+	}
+
+	var hosts []models.Host
+	if err := query.Limit(1).Find(&hosts).Error; err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, ErrNoCapacity
+	}
+	return &hosts[0], nil
+}
+
+// lockHost serialises allocations on the host: touching its row makes concurrent transactions
+// allocating on it wait until this one finishes
+func lockHost(tx *gorm.DB, host *models.Host) error {
+	return tx.Model(&models.Host{}).Where("id = ?", host.ID).Update("updated_at", time.Now()).Error
+}
+
+// AllocatePorts places the tenant on the host, giving each service the lowest free port of the
+// host's range. The ports are reserved by ReservePorts once the tenant is created. It must run
+// inside a transaction.
+func AllocatePorts(tx *gorm.DB, host *models.Host, tenant *models.Tenant, services []string) error {
+	if err := lockHost(tx, host); err != nil {
+		return err
+	}
+	// The host may have filled up since it was picked
+	count, err := HostTenants(tx, host.Name)
+	if err != nil {
+		return err
+	}
+	if count >= int64(host.Capacity) {
+		return ErrNoCapacity
+	}
+
+	var allocated []uint32
+	if err := tx.Model(&models.PortAllocation{}).Where("host_id = ?", host.ID).Pluck("port", &allocated).Error; err != nil {
+		return err
+	}
+	used := make(map[uint32]bool, len(allocated))
+	for _, port := range allocated {
+		used[port] = true
+	}
+
+	tenant.Host = host.Name
+	port := host.PortFrom
+	for _, service := range services {
+		for port <= host.PortTo && used[port] {
+			port++
+		}
+		if port > host.PortTo {
+			return ErrPortsExhausted
+		}
+		setPort(tenant, service, port)
+		port++
+	}
+	return nil
+}
+
+// Place puts a tenant created without a template on the least utilized host carrying the labels,
+// with a port for every service. It must run inside a transaction.
+func Place(tx *gorm.DB, tenant *models.Tenant, labels []string) error {
+	host, err := PickHost(tx, nil, labels)
+	if err != nil {
+		return err
+	}
+	return AllocatePorts(tx, host, tenant, allServices)
+}
+
+// placeFromTemplate puts a tenant on a host of the template's pool with a port for every service
+// the template exposes. Templates whose pool has no host in the inventory keep their fixed ports
+// on the pool's least loaded host; an empty pool means any host in the inventory.
+func placeFromTemplate(tx *gorm.DB, template *models.ProvisioningTemplate, tenant *models.Tenant) error {
+	var pool []string
+	if err := tx.Model(&models.TemplateHost{}).Where("template_id = ?", template.ID).Pluck("host", &pool).Error; err != nil {
+		return err
+	}
+	inventory := tx.Model(&models.Host{})
+	if len(pool) > 0 {
+		inventory = inventory.Where("name IN ?", pool)
+	}
+	var managed int64
+	if err := inventory.Count(&managed).Error; err != nil {
+		return err
+	}
+
+	if managed == 0 {
+		host, err := LeastLoadedHost(tx, template.ID)
+		if err != nil {
+			return err
+		}
+		tenant.Host = host
+		tenant.BmrmPort = template.BmrmPort
+		tenant.SgBizPort = template.SgBizPort
+		tenant.TallySyncPort = template.TallySyncPort
+		return nil
+	}
+
+	host, err := PickHost(tx, pool, nil)
+	if err != nil {
+		return err
+	}
+	var services []string
+	for _, exposed := range []struct {
+		service string
+		port    uint32
+	}{
+		{models.ServiceBmrm, template.BmrmPort},
+		{models.ServiceSgBiz, template.SgBizPort},
+		{models.ServiceTallySync, template.TallySyncPort},
+	} {
+		if exposed.port != 0 {
+			services = append(services, exposed.service)
+		}
+	}
+	return AllocatePorts(tx, host, tenant, services)
+}
+
+// setPort stores the port of one service on the tenant's single-host fields
+func setPort(tenant *models.Tenant, service string, port uint32) {
+	switch service {
+	case models.ServiceBmrm:
+		tenant.BmrmPort = port
+	case models.ServiceSgBiz:
+		tenant.SgBizPort = port
+	case models.ServiceTallySync:
+		tenant.TallySyncPort = port
+	}
+}
+
+// ReservePorts records the created tenant's ports as allocated on its host. Tenants on hosts outside
+// the inventory are left alone. It must run inside a transaction.
+func ReservePorts(tx *gorm.DB, tenant *models.Tenant) error {
+	var host models.Host
+	err := tx.Where("name = ?", tenant.Host).First(&host).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := lockHost(tx, &host); err != nil {
+		return err
+	}
+
+	targets := ProbeTargets(tenant)
+	ports := make([]uint32, 0, len(targets))
+	for _, target := range targets {
+		if target.Port < host.PortFrom || target.Port > host.PortTo {
+			return fmt.Errorf("%w: %d", ErrPortOutOfRange, target.Port)
+		}
+		if slices.Contains(ports, target.Port) {
+			return fmt.Errorf("%w: %d", ErrPortTaken, target.Port)
+		}
+		ports = append(ports, target.Port)
+	}
+	var taken []uint32
+	if err := tx.Model(&models.PortAllocation{}).Where("host_id = ? AND port IN ?", host.ID, ports).
+		Pluck("port", &taken).Error; err != nil {
+		return err
+	}
+	if len(taken) > 0 {
+		return fmt.Errorf("%w: %d", ErrPortTaken, taken[0])
+	}
+
+	for _, target := range targets {
+		if err := tx.Create(&models.PortAllocation{
+			HostId: host.ID, Port: target.Port, TenantId: tenant.ID, Service: target.Service,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Utilization reports the load of every host, or of the hosts carrying the label when one is given
+func Utilization(db *gorm.DB, label string) ([]HostUsage, error) {
+	query := db.Order("name")
+	if label != "" {
+		query = query.Where("id IN (?)", db.Model(&models.HostLabel{}).Select("host_id").Where("label = ?", label))
+	}
+	var hosts []models.Host
+	if err := query.Find(&hosts).Error; err != nil {
+		return nil, err
+	}
+
+	report := make([]HostUsage, 0, len(hosts))
+	for _, host := range hosts {
+		spec, err := LoadHost(db, host)
+		if err != nil {
+			return nil, err
+		}
+		usage := HostUsage{HostSpec: *spec}
+		if usage.Tenants, err = HostTenants(db, host.Name); err != nil {
+			return nil, err
+		}
+		if err := db.Model(&models.PortAllocation{}).Where("host_id = ?", host.ID).Count(&usage.PortsUsed).Error; err != nil {
+			return nil, err
+		}
+		if host.PortTo >= host.PortFrom {
+			usage.PortsTotal = int64(host.PortTo-host.PortFrom) + 1
+		}
+		if host.Capacity > 0 {
+			usage.Utilization = float64(usage.Tenants) * 100 / float64(host.Capacity)
+		}
+		report = append(report, usage)
+	}
+	return report, nil
+}
@@ -0,0 +1,211 @@
+package tenants
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCompanyList is returned when an import is neither a Tally XML nor a JSON company list
+var ErrInvalidCompanyList = errors.New("invalid company list")
+
+// Import actions
+const (
+	ImportCreate    = "create"
+	ImportRename    = "rename"
+	ImportUnchanged = "unchanged"
+	ImportSkip      = "skip"
+	ImportFailed    = "failed"
+	ImportAbsent    = "absent" // The user's company is missing from the list; it is left alone
+)
+
+// ImportedCompany is one company of a Tally company list
+type ImportedCompany struct {
+	Guid string `json:"guid"`
+	Name string `json:"name"`
+}
+
+// ImportChange is what an import does, or did, to one company
+type ImportChange struct {
+	Action       string `json:"action"`
+	CompanyGuid  string `json:"company_guid"`
+	CompanyName  string `json:"company_name"`
+	PreviousName string `json:"previous_name,omitempty"`
+	TenantId     uint64 `json:"tenant_id,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// tallyCompany is a COMPANY element of Tally's XML export; the name may be an attribute or a child
+type tallyCompany struct {
+	Guid     string `xml:"GUID"`
+	Name     string `xml:"NAME"`
+	NameAttr string `xml:"NAME,attr"`
+}
+
+// ParseCompanyList reads a company list as exported by Tally in XML, with COMPANY elements at any
+// depth, or as JSON, either an array of companies or an object with a "companies" array. Companies
+// are returned once per GUID, in list order, with the last name given for a GUID.
+func ParseCompanyList(data []byte) ([]ImportedCompany, error) {
+	trimmed := bytes.TrimSpace(data)
+	var companies []ImportedCompany
+	var err error
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		companies, err = parseTallyXML(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		err = json.Unmarshal(trimmed, &companies)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var wrapped struct {
+			Companies []ImportedCompany `json:"companies"`
+		}
+		err = json.Unmarshal(trimmed, &wrapped)
+		companies = wrapped.Companies
+	default:
+		return nil, fmt.Errorf("%w: expected XML or JSON", ErrInvalidCompanyList)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCompanyList, err)
+	}
+
+	index := make(map[string]int, len(companies))
+	unique := make([]ImportedCompany, 0, len(companies))
+	for i, company := range companies {
+		company.Guid = strings.TrimSpace(company.Guid)
+		company.Name = strings.TrimSpace(company.Name)
+		if company.Guid == "" || company.Name == "" {
+			return nil, fmt.Errorf("%w: company %d lacks a GUID or name", ErrInvalidCompanyList, i+1)
+		}
+		if at, seen := index[company.Guid]; seen {
+			unique[at].Name = company.Name
+			continue
+		}
+		index[company.Guid] = len(unique)
+		unique = append(unique, company)
+	}
+	return unique, nil
+}
+
+func parseTallyXML(data []byte) ([]ImportedCompany, error) {
+	var companies []ImportedCompany
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return companies, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || !strings.EqualFold(start.Name.Local, "COMPANY") {
+			continue
+		}
+		var company tallyCompany
+		if err := decoder.DecodeElement(&company, &start); err != nil {
+			return nil, err
+		}
+		name := company.Name
+		if name == "" {
+			name = company.NameAttr
+		}
+		companies = append(companies, ImportedCompany{Guid: company.Guid, Name: name})
+	}
+}
+
+// PlanImport compares the company list with the tenants it names and with the user's own. Missing
+// companies are created, renamed ones the user may manage are renamed, and companies registered to
+// other accounts are skipped. The user's companies absent from the list are returned separately.
+func PlanImport(db *gorm.DB, userID uint64, companies []ImportedCompany) ([]ImportChange, []ImportChange, error) {
+	guids := make([]string, 0, len(companies))
+	for _, company := range companies {
+		guids = append(guids, company.Guid)
+	}
+	var existing []models.Tenant
+	if len(guids) > 0 {
+		if err := db.Where("company_guid IN ?", guids).Find(&existing).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	byGuid := make(map[string]*models.Tenant, len(existing))
+	for i := range existing {
+		byGuid[existing[i].CompanyGuid] = &existing[i]
+	}
+	var mappings []models.UserTenantMapping
+	if err := db.Where("user_id = ?", userID).Find(&mappings).Error; err != nil {
+		return nil, nil, err
+	}
+	roles := make(map[uint64]string, len(mappings))
+	for _, mapping := range mappings {
+		roles[mapping.TenantId] = mapping.Role
+	}
+
+	changes := make([]ImportChange, 0, len(companies))
+	for _, company := range companies {
+		change := ImportChange{CompanyGuid: company.Guid, CompanyName: company.Name}
+		tenant, found := byGuid[company.Guid]
+		role, member := "", false
+		if found {
+			change.TenantId = tenant.ID
+			role, member = roles[tenant.ID]
+		}
+		switch {
+		case !found:
+			change.Action = ImportCreate
+		case !member:
+			change.Action, change.Reason = ImportSkip, "Registered to another account"
+		case tenant.State == models.TenantDeleted:
+			change.Action, change.Reason = ImportSkip, "Company is deleted"
+		case tenant.CompanyName == company.Name:
+			change.Action = ImportUnchanged
+		case models.RoleRank[role] < models.RoleRank[models.RoleAdmin]:
+			change.Action, change.PreviousName = ImportSkip, tenant.CompanyName
+			change.Reason = "Only owners and admins can rename the company"
+		default:
+			change.Action, change.PreviousName = ImportRename, tenant.CompanyName
+		}
+		changes = append(changes, change)
+	}
+
+	listed := make(map[string]bool, len(guids))
+	for _, guid := range guids {
+		listed[guid] = true
+	}
+	var absent []ImportChange
+	if len(mappings) > 0 {
+		var own []models.Tenant
+		if err := db.Where("id IN (?) AND state <> ?", db.Model(&models.UserTenantMapping{}).Select("tenant_id").
+			Where("user_id = ?", userID), models.TenantDeleted).Order("company_name").Find(&own).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, tenant := range own {
+			if !listed[tenant.CompanyGuid] {
+				absent = append(absent, ImportChange{Action: ImportAbsent, CompanyGuid: tenant.CompanyGuid, CompanyName: tenant.CompanyName, TenantId: tenant.ID})
+			}
+		}
+	}
+	return changes, absent, nil
+}
+
+// ApplyImportChange carries out one planned change. New companies are provisioned from the template
+// with the user as owner. It must run inside a transaction.
+func ApplyImportChange(tx *gorm.DB, template *models.ProvisioningTemplate, userID uint64, change *ImportChange) error {
+	switch change.Action {
+	case ImportCreate:
+		tenant, err := Provision(tx, template, userID, change.CompanyGuid, change.CompanyName)
+		if err != nil {
+			return err
+		}
+		change.TenantId = tenant.ID
+	case ImportRename:
+		return tx.Model(&models.Tenant{}).Where("id = ?", change.TenantId).Update("company_name", change.CompanyName).Error
+	}
+	return nil
+}
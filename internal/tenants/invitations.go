@@ -0,0 +1,52 @@
+package tenants
+
+import (
+	"errors"
+	"time"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvitationNotPending is returned when the invitation was already accepted, revoked or has expired
+var ErrInvitationNotPending = errors.New("invitation is no longer pending")
+
+// ErrAlreadyMember is returned when the invited user already belongs to the tenant
+var ErrAlreadyMember = errors.New("user is already a member of the tenant")
+
+// AcceptInvitation maps the user to the invitation's tenant with its role. The invitation gives up its
+// seat as it is claimed, and the membership takes one, so an invitation sent while a seat was free can
+// still be refused if the limit was lowered since. It must run inside a transaction.
+func AcceptInvitation(tx *gorm.DB, invitation *models.TenantInvitation, userID uint64, now time.Time) error {
+	claimed := tx.Model(&models.TenantInvitation{}).
+		Where("id = ? AND status = ? AND expires_at > ?", invitation.ID, models.InvitationPending, now).
+		Updates(map[string]interface{}{"status": models.InvitationAccepted, "accepted_by": userID, "accepted_at": now})
+	if claimed.Error != nil {
+		return claimed.Error
+	}
+	if claimed.RowsAffected != 1 {
+		return ErrInvitationNotPending
+	}
+	if _, err := Membership(tx, userID, invitation.TenantId); err == nil {
+		return ErrAlreadyMember
+	}
+	if err := ClaimSeats(tx, invitation.TenantId, 1); err != nil {
+		return err
+	}
+	if err := tx.Create(&models.UserTenantMapping{UserId: userID, TenantId: invitation.TenantId, Role: invitation.Role}).Error; err != nil {
+		return err
+	}
+
+	invitation.Status = models.InvitationAccepted
+	invitation.AcceptedBy = &userID
+	invitation.AcceptedAt = &now
+	return nil
+}
+
+// ExpireInvitations marks the tenant's pending invitations past their expiry as expired
+func ExpireInvitations(db *gorm.DB, tenantID uint64, now time.Time) error {
+	return db.Model(&models.TenantInvitation{}).
+		Where("tenant_id = ? AND status = ? AND expires_at <= ?", tenantID, models.InvitationPending, now).
+		Update("status", models.InvitationExpired).Error
+}
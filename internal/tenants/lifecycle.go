@@ -0,0 +1,131 @@
+package tenants
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidTransition is returned when the tenant cannot move from its state to the requested one
+var ErrInvalidTransition = errors.New("invalid tenant state transition")
+
+// ErrPurged is returned when restoring a deleted tenant whose memberships were already removed
+var ErrPurged = errors.New("tenant has already been purged")
+
+// CanTransition reports whether a tenant in state from may move to state to
+func CanTransition(from, to string) bool {
+	return slices.Contains(models.TenantTransitions[from], to)
+}
+
+// Transition moves the tenant to a new state and records the reason. Deleting starts the retention
+// window after which PurgeDeleted removes the memberships. It must run inside a transaction.
+func Transition(tx *gorm.DB, tenant *models.Tenant, to, reason string, actorID *uint64, now time.Time, retention time.Duration) error {
+	from := tenant.State
+	if from == "" {
+		from = models.TenantActive
+	}
+	if !CanTransition(from, to) {
+		return ErrInvalidTransition
+	}
+	if from == models.TenantDeleted && tenant.PurgedAt != nil {
+		return ErrPurged
+	}
+
+	updates := map[string]interface{}{"state": to, "purge_after": nil}
+	if to == models.TenantDeleted {
+		updates["purge_after"] = now.Add(retention)
+	}
+	if err := tx.Model(tenant).Updates(updates).Error; err != nil {
+		return err
+	}
+	tenant.State = to
+	tenant.PurgeAfter = nil
+	if purgeAfter, ok := updates["purge_after"].(time.Time); ok {
+		tenant.PurgeAfter = &purgeAfter
+	}
+	if err := tx.Create(&models.TenantStateChange{
+		TenantId:  tenant.ID,
+		FromState: from,
+		ToState:   to,
+		Reason:    reason,
+		ActorId:   actorID,
+		CreatedAt: now,
+	}).Error; err != nil {
+		return err
+	}
+	return events.Publish(tx, events.TenantStateChanged, &tenant.ID, map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"reason": reason,
+	})
+}
+
+// StateRejection returns the reason code and HTTP status with which requests for a tenant in the
+// given state are refused, or an empty code when the tenant is usable
+func StateRejection(state string) (string, int) {
+	switch state {
+	case models.TenantProvisioning:
+		return models.CodeTenantProvisioning, http.StatusServiceUnavailable
+	case models.TenantSuspended:
+		return models.CodeTenantSuspended, http.StatusForbidden
+	case models.TenantArchived:
+		return models.CodeTenantArchived, http.StatusForbidden
+	case models.TenantDeleted:
+		return models.CodeTenantDeleted, http.StatusGone
+	}
+	return "", http.StatusOK
+}
+
+// PurgeDeleted removes the memberships and everything else scoped to deleted tenants whose
+// retention window has passed. The tenant row is kept as a tombstone.
+func PurgeDeleted(db *gorm.DB, now time.Time) (int, error) {
+	var due []models.Tenant
+	if err := db.Where("state = ? AND purged_at IS NULL AND purge_after <= ?", models.TenantDeleted, now).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, tenant := range due {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, model := range []interface{}{
+				&models.UserTenantMapping{}, &models.UserFeatureMapping{}, &models.UserPreference{},
+				&models.TenantEndpoint{}, &models.TenantServiceStatus{}, &models.TenantHealthCheck{},
+				&models.TenantSetting{}, &models.TenantSettingRevision{}, &models.OrganizationMemberCompany{},
+				&models.PortAllocation{}, &models.TenantSecret{}, &models.TenantSecretVersion{}, &models.ServiceCredential{},
+				&models.TenantInvitation{}, &models.TenantMigration{}, &models.UserWildcardGrant{},
+			} {
+				if err := tx.Where("tenant_id = ?", tenant.ID).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(&tenant).Update("purged_at", now).Error
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunPurgeJob purges deleted tenants every interval; it is meant to run in its own goroutine
+func RunPurgeJob(db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		count, err := PurgeDeleted(db, time.Now())
+		if err != nil {
+			log.Printf("[!] Tenant purge failed: %v\n", err)
+		} else if count > 0 {
+			log.Printf("[+] Purged %d deleted tenants\n", count)
+		}
+	}
+}
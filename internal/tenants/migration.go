@@ -0,0 +1,266 @@
+package tenants
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrMigrationActive is returned when planning a migration for a tenant that is already moving
+	ErrMigrationActive = errors.New("tenant already has an active migration")
+	// ErrSameHost is returned when the target host is the one the tenant is on
+	ErrSameHost = errors.New("tenant is already on the host")
+	// ErrPortsRequired is returned when ports cannot be allocated and some were not given
+	ErrPortsRequired = errors.New("a port is required for every service the tenant exposes")
+	// ErrInvalidMigrationTransition is returned when the migration cannot move to the requested state
+	ErrInvalidMigrationTransition = errors.New("invalid migration state transition")
+)
+
+// MigrationTarget is the host a tenant moves to. Ports left out are allocated when the host is in
+// the inventory; other hosts need a port for every service the tenant exposes.
+type MigrationTarget struct {
+	Host          string `json:"host"`
+	BmrmPort      uint32 `json:"bmrm_port"`
+	SgBizPort     uint32 `json:"sg_biz_port"`
+	TallySyncPort uint32 `json:"tally_sync_port"`
+	Reason        string `json:"reason"`
+}
+
+// ActiveMigration returns the tenant's migration that is neither complete nor rolled back, or
+// gorm.ErrRecordNotFound when it is not moving
+func ActiveMigration(db *gorm.DB, tenantID uint64) (*models.TenantMigration, error) {
+	var migration models.TenantMigration
+	err := db.Where("tenant_id = ? AND state NOT IN ?", tenantID, []string{models.MigrationComplete, models.MigrationRolledBack}).
+		First(&migration).Error
+	if err != nil {
+		return nil, err
+	}
+	return &migration, nil
+}
+
+// InMaintenance reports whether the tenant is being copied or cut over to another host, during
+// which clients should expect its services to be read-only or briefly unavailable
+func InMaintenance(db *gorm.DB, tenantID uint64) (bool, error) {
+	var count int64
+	err := db.Model(&models.TenantMigration{}).
+		Where("tenant_id = ? AND state IN ?", tenantID, []string{models.MigrationCopying, models.MigrationCutover}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// migratedTenant is the tenant as it will look on the migration's target host
+func migratedTenant(tenantID uint64, migration *models.TenantMigration) *models.Tenant {
+	return &models.Tenant{
+		ID:            tenantID,
+		Host:          migration.ToHost,
+		BmrmPort:      migration.ToBmrmPort,
+		SgBizPort:     migration.ToSgBizPort,
+		TallySyncPort: migration.ToTallySyncPort,
+	}
+}
+
+// PlanMigration records a move of the tenant to the target host and reserves its ports there. The
+// tenant keeps serving from its current host until cutover. It must run inside a transaction.
+func PlanMigration(tx *gorm.DB, tenant *models.Tenant, target MigrationTarget, actorID uint64) (*models.TenantMigration, error) {
+	// Touching the tenant makes concurrent plans for it wait, so only one of them becomes active
+	if err := tx.Model(&models.Tenant{}).Where("id = ?", tenant.ID).Update("updated_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	if _, err := ActiveMigration(tx, tenant.ID); err == nil {
+		return nil, ErrMigrationActive
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if target.Host == tenant.Host {
+		return nil, ErrSameHost
+	}
+
+	moved := &models.Tenant{ID: tenant.ID, Host: target.Host}
+	var services, missing []string
+	for _, exposed := range ProbeTargets(tenant) {
+		services = append(services, exposed.Service)
+	}
+	for _, service := range services {
+		port := map[string]uint32{
+			models.ServiceBmrm:      target.BmrmPort,
+			models.ServiceSgBiz:     target.SgBizPort,
+			models.ServiceTallySync: target.TallySyncPort,
+		}[service]
+		if port == 0 {
+			missing = append(missing, service)
+		}
+		setPort(moved, service, port)
+	}
+
+	var host models.Host
+	err := tx.Where("name = ?", target.Host).First(&host).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if len(missing) > 0 {
+			return nil, ErrPortsRequired
+		}
+	case err != nil:
+		return nil, err
+	case host.Draining:
+		return nil, fmt.Errorf("%w: %s is draining", ErrNoCapacity, host.Name)
+	case len(missing) == len(services):
+		if err := AllocatePorts(tx, &host, moved, services); err != nil {
+			return nil, err
+		}
+	case len(missing) > 0:
+		return nil, ErrPortsRequired
+	default:
+		if err := lockHost(tx, &host); err != nil {
+			return nil, err
+		}
+		count, err := HostTenants(tx, host.Name)
+		if err != nil {
+			return nil, err
+		}
+		if count >= int64(host.Capacity) {
+			return nil, ErrNoCapacity
+		}
+	}
+	if err := ReservePorts(tx, moved); err != nil {
+		return nil, err
+	}
+
+	migration := &models.TenantMigration{
+		TenantId:          tenant.ID,
+		State:             models.MigrationPlanned,
+		FromHost:          tenant.Host,
+		FromBmrmPort:      tenant.BmrmPort,
+		FromSgBizPort:     tenant.SgBizPort,
+		FromTallySyncPort: tenant.TallySyncPort,
+		ToHost:            moved.Host,
+		ToBmrmPort:        moved.BmrmPort,
+		ToSgBizPort:       moved.SgBizPort,
+		ToTallySyncPort:   moved.TallySyncPort,
+		Reason:            target.Reason,
+		CreatedBy:         actorID,
+	}
+	if err := tx.Create(migration).Error; err != nil {
+		return nil, err
+	}
+	return migration, publishMigration(tx, migration, "", nil)
+}
+
+// AdvanceMigration moves the migration to its next state or rolls it back. At cutover the tenant's
+// endpoints are replaced in one go by a primary per service on the target host; rolling back after
+// cutover restores the ones it replaced. Completing releases the ports on the source host and
+// rolling back those on the target. It must run inside a transaction.
+func AdvanceMigration(tx *gorm.DB, migration *models.TenantMigration, to string, now time.Time) error {
+	from := migration.State
+	if !slices.Contains(models.MigrationTransitions[from], to) {
+		return ErrInvalidMigrationTransition
+	}
+	// Claim the migration first so concurrent changes cannot both succeed
+	claimed := tx.Model(&models.TenantMigration{}).Where("id = ? AND state = ?", migration.ID, from).Update("state", to)
+	if claimed.Error != nil {
+		return claimed.Error
+	}
+	if claimed.RowsAffected != 1 {
+		return ErrInvalidMigrationTransition
+	}
+
+	updates := map[string]interface{}{}
+	var endpoints []models.TenantEndpoint
+	switch to {
+	case models.MigrationCutover:
+		previous, err := Endpoints(tx, migration.TenantId)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(previous)
+		if err != nil {
+			return err
+		}
+		endpoints = legacyEndpoints(migratedTenant(migration.TenantId, migration))
+		if err := replaceEndpoints(tx, migration.TenantId, endpoints); err != nil {
+			return err
+		}
+		updates["previous_endpoints"], updates["cutover_at"] = string(encoded), now
+	case models.MigrationComplete:
+		if err := releasePorts(tx, migration.TenantId, migration.FromHost); err != nil {
+			return err
+		}
+		updates["completed_at"] = now
+	case models.MigrationRolledBack:
+		if from == models.MigrationCutover {
+			if err := json.Unmarshal([]byte(migration.PreviousEndpoints), &endpoints); err != nil {
+				return err
+			}
+			for i := range endpoints {
+				endpoints[i].ID = 0
+			}
+			if err := replaceEndpoints(tx, migration.TenantId, endpoints); err != nil {
+				return err
+			}
+		}
+		if err := releasePorts(tx, migration.TenantId, migration.ToHost); err != nil {
+			return err
+		}
+		updates["rolled_back_at"] = now
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(&models.TenantMigration{}).Where("id = ?", migration.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	migration.State = to
+	switch to {
+	case models.MigrationCutover:
+		migration.PreviousEndpoints = updates["previous_endpoints"].(string)
+		migration.CutoverAt = &now
+	case models.MigrationComplete:
+		migration.CompletedAt = &now
+	case models.MigrationRolledBack:
+		migration.RolledBackAt = &now
+	}
+	return publishMigration(tx, migration, from, endpoints)
+}
+
+// replaceEndpoints swaps every endpoint of the tenant for the given ones and points its single-host
+// fields at them
+func replaceEndpoints(tx *gorm.DB, tenantID uint64, endpoints []models.TenantEndpoint) error {
+	if err := tx.Where("tenant_id = ?", tenantID).Delete(&models.TenantEndpoint{}).Error; err != nil {
+		return err
+	}
+	if len(endpoints) > 0 {
+		if err := tx.Create(&endpoints).Error; err != nil {
+			return err
+		}
+	}
+	return SyncLegacyFields(tx, tenantID)
+}
+
+// releasePorts frees the tenant's ports on the named host; hosts outside the inventory have none
+func releasePorts(tx *gorm.DB, tenantID uint64, hostName string) error {
+	return tx.Where("tenant_id = ? AND host_id IN (?)", tenantID, tx.Model(&models.Host{}).Select("id").Where("name = ?", hostName)).
+		Delete(&models.PortAllocation{}).Error
+}
+
+// publishMigration tells services about the migration's new state, with the endpoints to use when
+// they changed
+func publishMigration(tx *gorm.DB, migration *models.TenantMigration, from string, endpoints []models.TenantEndpoint) error {
+	payload := map[string]interface{}{
+		"migration_id": migration.ID,
+		"from":         from,
+		"to":           migration.State,
+		"from_host":    migration.FromHost,
+		"to_host":      migration.ToHost,
+	}
+	if endpoints != nil {
+		payload["endpoints"] = endpoints
+	}
+	return events.Publish(tx, events.TenantMigrationChanged, &migration.TenantId, payload)
+}
@@ -0,0 +1,128 @@
+package tenants
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"sg-portal/internal/models"
+	"sg-portal/internal/subscriptions"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoSeats is returned when a tenant has no seat left for another user
+	ErrNoSeats = errors.New("no seats available")
+	// ErrSeatInactive is returned for a member whose seat is deactivated
+	ErrSeatInactive = errors.New("seat is deactivated")
+)
+
+// Where a tenant's seat limit comes from
+const (
+	SeatsOverride  = "override"
+	SeatsPlan      = "plan"
+	SeatsUnlimited = "unlimited"
+)
+
+// SeatUsage is how many of a tenant's seats are taken. Active members and pending invitations each
+// take a seat; deactivated members stay mapped without taking one.
+type SeatUsage struct {
+	TenantId    uint64 `json:"tenant_id"`
+	Limit       int    `json:"limit"` // 0 for unlimited
+	Source      string `json:"source"`
+	Used        int64  `json:"used"`
+	Pending     int64  `json:"pending"`
+	Deactivated int64  `json:"deactivated"`
+	Available   *int64 `json:"available"` // Absent when unlimited
+}
+
+// SeatLimit returns the tenant's seat limit and where it comes from. The tenant's own limit wins;
+// otherwise it is the largest number of seats among the plans its owners hold, and unlimited when
+// none of them sets one.
+func SeatLimit(db *gorm.DB, tenant *models.Tenant) (int, string, error) {
+	if tenant.SeatLimit != nil {
+		if *tenant.SeatLimit == 0 {
+			return 0, SeatsUnlimited, nil
+		}
+		return *tenant.SeatLimit, SeatsOverride, nil
+	}
+
+	var owners []uint64
+	if err := db.Model(&models.UserTenantMapping{}).Where("tenant_id = ? AND role = ?", tenant.ID, models.RoleOwner).
+		Pluck("user_id", &owners).Error; err != nil {
+		return 0, "", err
+	}
+	var held []uint32
+	for _, owner := range owners {
+		ids, err := subscriptions.ForUser(db, owner)
+		if err != nil {
+			return 0, "", err
+		}
+		held = append(held, ids...)
+	}
+	if len(held) == 0 {
+		return 0, SeatsUnlimited, nil
+	}
+	var seats int
+	if err := db.Model(&models.Subscription{}).Where("id IN ? AND seats > 0", held).
+		Select("COALESCE(MAX(seats), 0)").Scan(&seats).Error; err != nil {
+		return 0, "", err
+	}
+	if seats == 0 {
+		return 0, SeatsUnlimited, nil
+	}
+	return seats, SeatsPlan, nil
+}
+
+// Seats returns the tenant's seat usage
+func Seats(db *gorm.DB, tenant *models.Tenant) (*SeatUsage, error) {
+	limit, source, err := SeatLimit(db, tenant)
+	if err != nil {
+		return nil, err
+	}
+	usage := &SeatUsage{TenantId: tenant.ID, Limit: limit, Source: source}
+	if err := db.Model(&models.UserTenantMapping{}).Where("tenant_id = ? AND deactivated_at IS NULL", tenant.ID).
+		Count(&usage.Used).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.UserTenantMapping{}).Where("tenant_id = ? AND deactivated_at IS NOT NULL", tenant.ID).
+		Count(&usage.Deactivated).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.TenantInvitation{}).Where("tenant_id = ? AND status = ? AND expires_at > ?",
+		tenant.ID, models.InvitationPending, time.Now()).Count(&usage.Pending).Error; err != nil {
+		return nil, err
+	}
+	if limit > 0 {
+		available := max(int64(limit)-usage.Used-usage.Pending, 0)
+		usage.Available = &available
+	}
+	return usage, nil
+}
+
+// ClaimSeats checks that the tenant has n seats free for new members or invitations. The tenant row
+// is locked first, so concurrent claims on it are counted one after the other; the seats are taken
+// by the rows the caller then creates in the same transaction. It must run inside a transaction and
+// returns gorm.ErrRecordNotFound when the tenant does not exist.
+func ClaimSeats(tx *gorm.DB, tenantID uint64, n int) error {
+	locked := tx.Exec("UPDATE tenants SET seat_limit = seat_limit WHERE id = ?", tenantID)
+	if locked.Error != nil {
+		return locked.Error
+	}
+	if locked.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	var tenant models.Tenant
+	if err := tx.First(&tenant, tenantID).Error; err != nil {
+		return err
+	}
+	usage, err := Seats(tx, &tenant)
+	if err != nil {
+		return err
+	}
+	if usage.Available != nil && *usage.Available < int64(n) {
+		return fmt.Errorf("%w: %d of %d seats taken", ErrNoSeats, usage.Used+usage.Pending, usage.Limit)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package tenants
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/models"
+	"sg-portal/internal/subscriptions"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrTemplateNotFound is returned when the requested, or any applicable, template does not exist
+	ErrTemplateNotFound = errors.New("provisioning template not found")
+	// ErrNoHosts is returned when a template's host pool is empty
+	ErrNoHosts = errors.New("provisioning template has no hosts")
+	// ErrUnknownCode is returned when a template refers to a feature or subscription that does not exist
+	ErrUnknownCode = errors.New("unknown feature or subscription code")
+	// ErrDefaultTemplateRequired is returned when a change would leave no default template
+	ErrDefaultTemplateRequired = errors.New("another template must be made the default first")
+)
+
+// TemplateSpec is a template together with its host pool, default grants and selection rules,
+// the latter three given as host names, permission codes and subscription codes
+type TemplateSpec struct {
+	models.ProvisioningTemplate
+	Hosts         []string
+	Features      []string
+	Subscriptions []string
+	Rules         []string
+}
+
+// SeedDefaultTemplate creates the default template from the legacy "default" tenant when no template
+// exists yet, so that companies keep landing where they did before templates were configured
+func SeedDefaultTemplate(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&models.ProvisioningTemplate{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	legacy, err := ByCompanyGuid(db, "default")
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Registration grants every feature, so the legacy behaviour is an owner holding all of them
+	var codes []string
+	if err := db.Model(&models.Feature{}).Pluck("permission", &codes).Error; err != nil {
+		return err
+	}
+	spec := &TemplateSpec{
+		ProvisioningTemplate: models.ProvisioningTemplate{
+			Name:          models.DefaultTemplateName,
+			Description:   "Seeded from the default tenant",
+			IsDefault:     true,
+			BmrmPort:      legacy.BmrmPort,
+			SgBizPort:     legacy.SgBizPort,
+			TallySyncPort: legacy.TallySyncPort,
+		},
+		Hosts:    []string{legacy.Host},
+		Features: codes,
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		return SaveTemplate(tx, spec)
+	})
+}
+
+// SaveTemplate creates or updates the template and replaces its hosts, grants and rules.
+// Marking it as the default clears the flag on every other template, while clearing the flag on the
+// default template fails with ErrDefaultTemplateRequired. It must run inside a transaction.
+func SaveTemplate(tx *gorm.DB, spec *TemplateSpec) error {
+	template := &spec.ProvisioningTemplate
+	if template.ID != 0 && !template.IsDefault {
+		if err := requireOtherDefault(tx, template.ID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Save(template).Error; err != nil {
+		return err
+	}
+	if template.IsDefault {
+		if err := tx.Model(&models.ProvisioningTemplate{}).Where("id <> ?", template.ID).
+			Update("is_default", false).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, model := range []interface{}{&models.TemplateHost{}, &models.TemplateFeature{}, &models.TemplateSubscription{}, &models.TemplateRule{}} {
+		if err := tx.Where("template_id = ?", template.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, host := range spec.Hosts {
+		if err := tx.Create(&models.TemplateHost{TemplateId: template.ID, Host: host}).Error; err != nil {
+			return err
+		}
+	}
+	featureIds, err := featureIdsFor(tx, spec.Features)
+	if err != nil {
+		return err
+	}
+	for _, id := range featureIds {
+		if err := tx.Create(&models.TemplateFeature{TemplateId: template.ID, FeatureId: id}).Error; err != nil {
+			return err
+		}
+	}
+	subscriptionIds, err := subscriptionIdsFor(tx, spec.Subscriptions)
+	if err != nil {
+		return err
+	}
+	for _, id := range subscriptionIds {
+		if err := tx.Create(&models.TemplateSubscription{TemplateId: template.ID, SubscriptionId: id}).Error; err != nil {
+			return err
+		}
+	}
+	ruleIds, err := subscriptionIdsFor(tx, spec.Rules)
+	if err != nil {
+		return err
+	}
+	for _, id := range ruleIds {
+		if err := tx.Create(&models.TemplateRule{TemplateId: template.ID, SubscriptionId: id}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTemplate deletes the template with its hosts, grants and rules. The default template cannot
+// be deleted until another template is made the default. It must run inside a transaction.
+func DeleteTemplate(tx *gorm.DB, templateID uint32) error {
+	if err := requireOtherDefault(tx, templateID); err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&models.TemplateHost{}, &models.TemplateFeature{}, &models.TemplateSubscription{}, &models.TemplateRule{}} {
+		if err := tx.Where("template_id = ?", templateID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Delete(&models.ProvisioningTemplate{}, templateID).Error
+}
+
+// requireOtherDefault fails with ErrDefaultTemplateRequired when the template is the default one
+func requireOtherDefault(db *gorm.DB, templateID uint32) error {
+	var count int64
+	if err := db.Model(&models.ProvisioningTemplate{}).
+		Where("id = ? AND is_default = ?", templateID, true).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDefaultTemplateRequired
+	}
+	return nil
+}
+
+// LoadTemplate returns the template with its hosts, grants and rules
+func LoadTemplate(db *gorm.DB, template models.ProvisioningTemplate) (*TemplateSpec, error) {
+	spec := &TemplateSpec{ProvisioningTemplate: template}
+	if err := db.Model(&models.TemplateHost{}).Where("template_id = ?", template.ID).Order("host").
+		Pluck("host", &spec.Hosts).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Feature{}).Where("id IN (?)", db.Model(&models.TemplateFeature{}).
+		Select("feature_id").Where("template_id = ?", template.ID)).Order("permission").
+		Pluck("permission", &spec.Features).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Subscription{}).Where("id IN (?)", db.Model(&models.TemplateSubscription{}).
+		Select("subscription_id").Where("template_id = ?", template.ID)).Order("code").
+		Pluck("code", &spec.Subscriptions).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Subscription{}).Where("id IN (?)", db.Model(&models.TemplateRule{}).
+		Select("subscription_id").Where("template_id = ?", template.ID)).Order("code").
+		Pluck("code", &spec.Rules).Error; err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// SelectTemplate picks the named template when name is given. Otherwise it picks the highest
+// priority template with a rule matching one of the user's subscriptions, then the default template.
+func SelectTemplate(db *gorm.DB, userID uint64, name string) (*models.ProvisioningTemplate, error) {
+	var template models.ProvisioningTemplate
+	var err error
+	if name != "" {
+		err = db.Where("name = ?", name).First(&template).Error
+	} else {
+		var held []uint32
+		if held, err = subscriptions.ForUser(db, userID); err != nil {
+			return nil, err
+		}
+		err = db.Where("id IN (?)", db.Model(&models.TemplateRule{}).Select("template_id").
+			Where("subscription_id IN ?", held)).
+			Order("priority, id").First(&template).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = db.Where("is_default = ?", true).First(&template).Error
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTemplateNotFound
+	}
+	return &template, err
+}
+
+// LeastLoadedHost returns the host of the template's pool that carries the fewest tenants
+func LeastLoadedHost(db *gorm.DB, templateID uint32) (string, error) {
+	var hosts []string
+	err := db.Model(&models.TemplateHost{}).
+		Select("template_hosts.host").
+		Joins("LEFT JOIN tenants ON tenants.host = template_hosts.host").
+		Where("template_hosts.template_id = ?", templateID).
+		Group("template_hosts.host").
+		Order("COUNT(tenants.id), template_hosts.host").
+		Limit(1).Pluck("template_hosts.host", &hosts).Error
+	if err != nil {
+		return "", err
+	}
+	if len(hosts) == 0 {
+		return "", ErrNoHosts
+	}
+	return hosts[0], nil
+}
+
+// Provision creates a company from the template on a host of its pool, with free ports allocated
+// when the pool is in the host inventory, and makes the user its owner with the template's default
+// features and subscriptions. It must run inside a transaction.
+func Provision(tx *gorm.DB, template *models.ProvisioningTemplate, userID uint64, companyGuid, companyName string) (*models.Tenant, error) {
+	tenant := &models.Tenant{
+		CompanyGuid: companyGuid,
+		CompanyName: companyName,
+		State:       models.TenantProvisioning,
+	}
+	if err := placeFromTemplate(tx, template, tenant); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(tenant).Error; err != nil {
+		return nil, err
+	}
+	if err := ReservePorts(tx, tenant); err != nil {
+		return nil, err
+	}
+	if err := CreateLegacyEndpoints(tx, tenant); err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&models.UserTenantMapping{UserId: userID, TenantId: tenant.ID, Role: models.RoleOwner}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Exec(`INSERT INTO user_feature_mappings (user_id, tenant_id, feature_id)
+		SELECT ?, ?, feature_id FROM template_features WHERE template_id = ?`,
+		userID, tenant.ID, template.ID).Error; err != nil {
+		return nil, err
+	}
+
+	var subscriptionIds []uint32
+	if err := tx.Model(&models.TemplateSubscription{}).Where("template_id = ?", template.ID).
+		Pluck("subscription_id", &subscriptionIds).Error; err != nil {
+		return nil, err
+	}
+	for _, id := range subscriptionIds {
+		mapping := &models.UserSubscriptionMapping{UserId: userID, SubscriptionId: id}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(mapping).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := events.Publish(tx, events.TenantProvisioned, &tenant.ID, map[string]interface{}{
+		"company_guid": companyGuid,
+		"template":     template.Name,
+		"host":         tenant.Host,
+		"owner_id":     userID,
+	}); err != nil {
+		return nil, err
+	}
+	err := Transition(tx, tenant, models.TenantActive, "Provisioned from template "+template.Name, &userID, time.Now(), 0)
+	return tenant, err
+}
+
+// featureIdsFor resolves permission codes to feature IDs, failing with ErrUnknownCode on a missing code
+func featureIdsFor(db *gorm.DB, codes []string) ([]uint32, error) {
+	return idsFor(db, &models.Feature{}, "permission", codes)
+}
+
+// subscriptionIdsFor resolves subscription codes to subscription IDs, failing with ErrUnknownCode on a missing code
+func subscriptionIdsFor(db *gorm.DB, codes []string) ([]uint32, error) {
+	return idsFor(db, &models.Subscription{}, "code", codes)
+}
+
+func idsFor(db *gorm.DB, model interface{}, column string, codes []string) ([]uint32, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	var found []struct {
+		ID   uint32
+		Code string
+	}
+	if err := db.Model(model).Select("id, "+column+" AS code").Where(column+" IN ?", codes).Scan(&found).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]uint32, len(found))
+	for _, row := range found {
+		if _, ok := ids[row.Code]; !ok {
+			ids[row.Code] = row.ID
+		}
+	}
+	var result []uint32
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		id, ok := ids[code]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCode, code)
+		}
+		if !seen[code] {
+			seen[code] = true
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
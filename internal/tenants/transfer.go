@@ -0,0 +1,131 @@
+package tenants
+
+import (
+	"errors"
+	"time"
+
+	"sg-portal/internal/events"
+	"sg-portal/internal/features"
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrTransferNotPending is returned when the transfer was already accepted, cancelled or has expired
+var ErrTransferNotPending = errors.New("ownership transfer is no longer pending")
+
+// ErrTransferStale is returned when the user who started the transfer is no longer an owner
+var ErrTransferStale = errors.New("transfer initiator is no longer an owner")
+
+// AcceptTransfer makes the recipient an owner of the tenant and moves the initiator's feature grants
+// on it to them. The recipient is also given the initiator's subscriptions that cover those features;
+// subscriptions belong to users, so the initiator keeps theirs for their other companies. The initiator
+// stays on as a member when the transfer asks for it and is removed otherwise; in the first case the
+// recipient must have a seat or find one free. It must run inside a transaction.
+func AcceptTransfer(tx *gorm.DB, transfer *models.OwnershipTransfer, recipientID uint64, now time.Time) error {
+	// Claim the transfer first so concurrent accepts and cancels cannot both succeed
+	claimed := tx.Model(&models.OwnershipTransfer{}).
+		Where("id = ? AND status = ?", transfer.ID, models.TransferPending).
+		Updates(map[string]interface{}{"status": models.TransferAccepted, "to_user_id": recipientID, "accepted_at": now})
+	if claimed.Error != nil {
+		return claimed.Error
+	}
+	if claimed.RowsAffected != 1 {
+		return ErrTransferNotPending
+	}
+
+	from, err := Membership(tx, transfer.FromUserId, transfer.TenantId)
+	if err != nil || from.Role != models.RoleOwner {
+		return ErrTransferStale
+	}
+
+	// A recipient without an active seat needs one, unless the initiator leaves and hands theirs over
+	existing, err := Membership(tx, recipientID, transfer.TenantId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if (err != nil || existing.DeactivatedAt != nil) && transfer.KeepAsMember {
+		if err := ClaimSeats(tx, transfer.TenantId, 1); err != nil {
+			return err
+		}
+	}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "tenant_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"role": models.RoleOwner, "inherited_from": 0, "deactivated_at": nil}),
+	}).Create(&models.UserTenantMapping{UserId: recipientID, TenantId: transfer.TenantId, Role: models.RoleOwner}).Error; err != nil {
+		return err
+	}
+
+	// The subscriptions to hand over are those covering every feature the initiator holds,
+	// wildcard grants resolved
+	held, err := features.ForUser(tx, transfer.FromUserId, transfer.TenantId)
+	if err != nil {
+		return err
+	}
+	featureIDs := make([]uint32, 0, len(held))
+	for _, feature := range held {
+		featureIDs = append(featureIDs, feature.ID)
+	}
+
+	var grants []models.UserFeatureMapping
+	if err := tx.Where("user_id = ? AND tenant_id = ?", transfer.FromUserId, transfer.TenantId).Find(&grants).Error; err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		moved := &models.UserFeatureMapping{UserId: recipientID, TenantId: transfer.TenantId, FeatureId: grant.FeatureId}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(moved).Error; err != nil {
+			return err
+		}
+	}
+	patterns, err := features.Wildcards(tx, transfer.FromUserId, transfer.TenantId)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		moved := &models.UserWildcardGrant{UserId: recipientID, TenantId: transfer.TenantId, Pattern: pattern}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(moved).Error; err != nil {
+			return err
+		}
+	}
+	if err := features.RevokeInTenant(tx, transfer.FromUserId, transfer.TenantId); err != nil {
+		return err
+	}
+
+	var subscriptionIDs []uint32
+	if len(featureIDs) > 0 {
+		if err := tx.Model(&models.UserSubscriptionMapping{}).Distinct("subscription_id").
+			Where("user_id = ? AND subscription_id IN (?)", transfer.FromUserId,
+				tx.Model(&models.FeatureSubscriptionMapping{}).Select("subscription_id").Where("feature_id IN ?", featureIDs)).
+			Pluck("subscription_id", &subscriptionIDs).Error; err != nil {
+			return err
+		}
+	}
+	for _, subscriptionID := range subscriptionIDs {
+		linked := &models.UserSubscriptionMapping{UserId: recipientID, SubscriptionId: subscriptionID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(linked).Error; err != nil {
+			return err
+		}
+	}
+
+	if transfer.KeepAsMember {
+		err = tx.Model(from).Update("role", models.RoleMember).Error
+	} else {
+		err = tx.Delete(from).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = models.TransferAccepted
+	transfer.ToUserId = &recipientID
+	transfer.AcceptedAt = &now
+	return events.Publish(tx, events.TenantOwnershipTransferred, &transfer.TenantId, map[string]interface{}{
+		"transfer_id":    transfer.ID,
+		"from_user_id":   transfer.FromUserId,
+		"to_user_id":     recipientID,
+		"keep_as_member": transfer.KeepAsMember,
+		"features":       len(featureIDs),
+		"subscriptions":  subscriptionIDs,
+	})
+}
@@ -0,0 +1,172 @@
+package users
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"sg-portal/internal/models"
+	"sg-portal/pkg/util"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultCountryCode is the ISO alpha-2 code assumed for users without a country
+var DefaultCountryCode = util.GetEnv("SGPortal_DefaultCountry", "IN")
+
+// seedCountries is the reference data loaded into the countries table on start-up
+var seedCountries = []models.Country{
+	{ID: 356, IsoCode: "IN", IsoCode3: "IND", Name: "India", DialCode: "91", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 840, IsoCode: "US", IsoCode3: "USA", Name: "United States", DialCode: "1", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 124, IsoCode: "CA", IsoCode3: "CAN", Name: "Canada", DialCode: "1", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 826, IsoCode: "GB", IsoCode3: "GBR", Name: "United Kingdom", DialCode: "44", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 784, IsoCode: "AE", IsoCode3: "ARE", Name: "United Arab Emirates", DialCode: "971", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 682, IsoCode: "SA", IsoCode3: "SAU", Name: "Saudi Arabia", DialCode: "966", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 634, IsoCode: "QA", IsoCode3: "QAT", Name: "Qatar", DialCode: "974", MinMobileLength: 8, MaxMobileLength: 8},
+	{ID: 512, IsoCode: "OM", IsoCode3: "OMN", Name: "Oman", DialCode: "968", MinMobileLength: 8, MaxMobileLength: 8},
+	{ID: 414, IsoCode: "KW", IsoCode3: "KWT", Name: "Kuwait", DialCode: "965", MinMobileLength: 8, MaxMobileLength: 8},
+	{ID: 48, IsoCode: "BH", IsoCode3: "BHR", Name: "Bahrain", DialCode: "973", MinMobileLength: 8, MaxMobileLength: 8},
+	{ID: 702, IsoCode: "SG", IsoCode3: "SGP", Name: "Singapore", DialCode: "65", MinMobileLength: 8, MaxMobileLength: 8},
+	{ID: 458, IsoCode: "MY", IsoCode3: "MYS", Name: "Malaysia", DialCode: "60", MinMobileLength: 9, MaxMobileLength: 10},
+	{ID: 36, IsoCode: "AU", IsoCode3: "AUS", Name: "Australia", DialCode: "61", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 554, IsoCode: "NZ", IsoCode3: "NZL", Name: "New Zealand", DialCode: "64", MinMobileLength: 8, MaxMobileLength: 10},
+	{ID: 524, IsoCode: "NP", IsoCode3: "NPL", Name: "Nepal", DialCode: "977", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 50, IsoCode: "BD", IsoCode3: "BGD", Name: "Bangladesh", DialCode: "880", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 144, IsoCode: "LK", IsoCode3: "LKA", Name: "Sri Lanka", DialCode: "94", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 586, IsoCode: "PK", IsoCode3: "PAK", Name: "Pakistan", DialCode: "92", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 64, IsoCode: "BT", IsoCode3: "BTN", Name: "Bhutan", DialCode: "975", MinMobileLength: 8, MaxMobileLength: 8},
+	{ID: 462, IsoCode: "MV", IsoCode3: "MDV", Name: "Maldives", DialCode: "960", MinMobileLength: 7, MaxMobileLength: 7},
+	{ID: 276, IsoCode: "DE", IsoCode3: "DEU", Name: "Germany", DialCode: "49", MinMobileLength: 10, MaxMobileLength: 11},
+	{ID: 250, IsoCode: "FR", IsoCode3: "FRA", Name: "France", DialCode: "33", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 528, IsoCode: "NL", IsoCode3: "NLD", Name: "Netherlands", DialCode: "31", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 710, IsoCode: "ZA", IsoCode3: "ZAF", Name: "South Africa", DialCode: "27", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 404, IsoCode: "KE", IsoCode3: "KEN", Name: "Kenya", DialCode: "254", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 566, IsoCode: "NG", IsoCode3: "NGA", Name: "Nigeria", DialCode: "234", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 360, IsoCode: "ID", IsoCode3: "IDN", Name: "Indonesia", DialCode: "62", MinMobileLength: 9, MaxMobileLength: 12},
+	{ID: 764, IsoCode: "TH", IsoCode3: "THA", Name: "Thailand", DialCode: "66", MinMobileLength: 9, MaxMobileLength: 9},
+	{ID: 608, IsoCode: "PH", IsoCode3: "PHL", Name: "Philippines", DialCode: "63", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 344, IsoCode: "HK", IsoCode3: "HKG", Name: "Hong Kong", DialCode: "852", MinMobileLength: 8, MaxMobileLength: 8},
+	{ID: 392, IsoCode: "JP", IsoCode3: "JPN", Name: "Japan", DialCode: "81", MinMobileLength: 10, MaxMobileLength: 10},
+	{ID: 156, IsoCode: "CN", IsoCode3: "CHN", Name: "China", DialCode: "86", MinMobileLength: 11, MaxMobileLength: 11},
+}
+
+// SeedCountries inserts the reference countries, leaving existing rows untouched
+func SeedCountries(db *gorm.DB) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&seedCountries).Error
+}
+
+// CountryFor returns the user's country, falling back to DefaultCountryCode when none is set
+func CountryFor(db *gorm.DB, countryID *int) (*models.Country, error) {
+	var country models.Country
+	if countryID != nil && *countryID != 0 {
+		err := db.First(&country, *countryID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("unknown country")
+		}
+		return &country, err
+	}
+	err := db.Where("iso_code = ?", DefaultCountryCode).First(&country).Error
+	return &country, err
+}
+
+// NormalizeMobile validates a mobile number against the country and returns it in E.164 form
+func NormalizeMobile(db *gorm.DB, countryID *int, mobile string) (string, error) {
+	country, err := CountryFor(db, countryID)
+	if err != nil {
+		return "", err
+	}
+	return util.NormalizeMobileNumber(mobile, country.DialCode, country.MinMobileLength, country.MaxMobileLength)
+}
+
+// NormalizeStoredMobiles rewrites mobile numbers saved before normalisation into E.164 form.
+// Numbers that fail validation for the user's country, or that would then match another
+// user's number, are logged and left as typed for support to resolve. It is safe to run on
+// every start.
+func NormalizeStoredMobiles(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var legacy []models.User
+		if err := tx.Where("mobile_number NOT LIKE ? AND mobile_number NOT LIKE ?", "+%", "erased-%").
+			Order("id").Find(&legacy).Error; err != nil {
+			return err
+		}
+
+		normalised := 0
+		for _, user := range legacy {
+			mobile, err := NormalizeMobile(tx, user.CountryID, user.MobileNumber)
+			if err != nil {
+				log.Printf("[!] Leaving mobile number %q of user %d as typed: %v\n", user.MobileNumber, user.ID, err)
+				continue
+			}
+			var holder models.User
+			err = tx.Where("mobile_number = ? AND id <> ?", mobile, user.ID).First(&holder).Error
+			if err == nil {
+				log.Printf("[!] Leaving mobile number %q of user %d as typed: %s already belongs to user %d\n",
+					user.MobileNumber, user.ID, mobile, holder.ID)
+				continue
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("mobile_number", mobile).Error; err != nil {
+				return err
+			}
+			normalised++
+		}
+		if normalised > 0 {
+			log.Printf("[+] Normalised %d stored mobile numbers\n", normalised)
+		}
+		return nil
+	})
+}
+
+// MobileLookupCandidates returns the stored forms a typed mobile number may match, reading it
+// as a number of the given country, or of DefaultCountryCode when countryID is nil.
+// Numbers saved before normalisation were kept as typed, with or without the dialing code.
+func MobileLookupCandidates(db *gorm.DB, countryID *int, mobile string) []string {
+	candidates := []string{mobile}
+	normalized, err := NormalizeMobile(db, countryID, mobile)
+	if err != nil {
+		return candidates
+	}
+	country, err := CountryFor(db, countryID)
+	if err != nil || !strings.HasPrefix(normalized, "+"+country.DialCode) {
+		return append(candidates, normalized)
+	}
+	national := normalized[len(country.DialCode)+1:]
+	return append(candidates, normalized, national, country.DialCode+national)
+}
+
+// LocalMobileMatches returns the users whose stored number is the typed local number read
+// as a number of their own country, for numbers typed without the dialing code
+func LocalMobileMatches(db *gorm.DB, mobile string) ([]models.User, error) {
+	digits := strings.TrimLeft(mobileDigits(mobile), "0")
+	if digits == "" || strings.HasPrefix(strings.TrimSpace(mobile), "+") {
+		return nil, nil
+	}
+
+	var candidates []models.User
+	if err := db.Where("country_id IS NOT NULL AND country_id <> 0 AND mobile_number LIKE ?", "+%"+digits).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	var matches []models.User
+	for _, user := range candidates {
+		normalized, err := NormalizeMobile(db, user.CountryID, mobile)
+		if err == nil && normalized == user.MobileNumber {
+			matches = append(matches, user)
+		}
+	}
+	return matches, nil
+}
+
+// mobileDigits strips everything but the digits from a typed mobile number
+func mobileDigits(mobile string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, mobile)
+}
@@ -0,0 +1,105 @@
+package users
+
+import (
+	"log"
+	"time"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// RevokeTokens deletes every token issued to the user, signing them out everywhere
+func RevokeTokens(db *gorm.DB, userID uint64) error {
+	return db.Where("user_id = ?", userID).Delete(&models.Token{}).Error
+}
+
+// BootstrapSystemUser promotes the registered user with the email to a system user while no system
+// user exists yet, so that a new installation can create its first one without public registration
+func BootstrapSystemUser(db *gorm.DB, email string) error {
+	if email == "" {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&models.User{}).Where("type = ?", models.UserTypeSystem).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	promoted := db.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", email).Update("type", models.UserTypeSystem)
+	if promoted.Error != nil {
+		return promoted.Error
+	}
+	if promoted.RowsAffected > 0 {
+		log.Printf("[+] Promoted %s to the first system user\n", email)
+	}
+	return nil
+}
+
+// Reactivate closes the user's open suspension and marks the user active again.
+// actorID is nil when the reactivation was scheduled rather than done by an admin.
+func Reactivate(db *gorm.DB, suspension *models.UserSuspension, actorID *uint64, now time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserSuspension{}).Where("id = ?", suspension.ID).Updates(map[string]interface{}{
+			"reactivated_at": now,
+			"reactivated_by": actorID,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", suspension.UserId).Update("is_active", true).Error
+	})
+}
+
+// ReactivateDue lifts every open suspension whose scheduled reactivation time has passed
+func ReactivateDue(db *gorm.DB, now time.Time) (int, error) {
+	var due []models.UserSuspension
+	if err := db.Where("reactivated_at IS NULL AND reactivate_at IS NOT NULL AND reactivate_at <= ?", now).
+		Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	reactivated := 0
+	for i := range due {
+		if err := Reactivate(db, &due[i], nil, now); err != nil {
+			return reactivated, err
+		}
+		reactivated++
+	}
+	return reactivated, nil
+}
+
+// RunReactivationJob checks for due reactivations every interval; it is meant to run in its own goroutine
+func RunReactivationJob(db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		count, err := ReactivateDue(db, time.Now())
+		if err != nil {
+			log.Printf("[!] Scheduled reactivation failed: %v\n", err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("[+] Reactivated %d suspended users\n", count)
+		}
+	}
+}
+
+// PurgeLoginAttempts deletes login attempts older than the retention period
+func PurgeLoginAttempts(db *gorm.DB, retention time.Duration, now time.Time) (int64, error) {
+	result := db.Where("created_at < ?", now.Add(-retention)).Delete(&models.LoginAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// RunLoginRetentionJob purges expired login history every interval; it is meant to run in its own goroutine
+func RunLoginRetentionJob(db *gorm.DB, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		count, err := PurgeLoginAttempts(db, retention, time.Now())
+		if err != nil {
+			log.Printf("[!] Login history purge failed: %v\n", err)
+			continue
+		}
+		if count > 0 {
+			log.Printf("[+] Purged %d expired login attempts\n", count)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// MergeCount is how many rows of one table were moved to the survivor or dropped as duplicates
+type MergeCount struct {
+	Moved   int64 `json:"moved"`
+	Dropped int64 `json:"dropped"`
+}
+
+// MergeSummary describes the effect of merging one user into another
+type MergeSummary struct {
+	Tables             map[string]*MergeCount `json:"tables"`
+	TokensRevoked      int64                  `json:"tokens_revoked"`
+	TransfersCancelled int64                  `json:"transfers_cancelled"`
+	InvitationsRevoked int64                  `json:"invitations_revoked"`
+}
+
+// mergeTable is a per-user table whose rows follow the user on a merge. Rows of the absorbed
+// user that would collide with the survivor's on the key columns are dropped instead.
+type mergeTable struct {
+	model interface{}
+	keys  []string
+}
+
+var mergeTables = []mergeTable{
+	{&models.UserTenantMapping{}, []string{"tenant_id"}},
+	{&models.UserFeatureMapping{}, []string{"tenant_id", "feature_id"}},
+	{&models.UserWildcardGrant{}, []string{"tenant_id", "pattern"}},
+	{&models.UserSubscriptionMapping{}, []string{"subscription_id"}},
+	{&models.UserSubscriptionHistory{}, []string{"subscription_id"}},
+	{&models.UserPreference{}, []string{"tenant_id", "namespace", "key"}},
+	{&models.OrganizationMember{}, []string{"organization_id"}},
+	{&models.LoginAttempt{}, nil},
+	{&models.UserSuspension{}, nil},
+}
+
+func tableName(tx *gorm.DB, model interface{}) (string, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+	return stmt.Schema.Table, nil
+}
+
+// keepLatestHistory drops the survivor's subscription history where the absorbed user's entry for
+// the same subscription runs longer, so the longer entry is the one that moves across
+func keepLatestHistory(tx *gorm.DB, survivorID, absorbedID uint64) (int64, error) {
+	result := tx.Exec(`DELETE FROM user_subscription_histories WHERE user_id = ? AND EXISTS (
+		SELECT 1 FROM user_subscription_histories a
+		WHERE a.user_id = ? AND a.subscription_id = user_subscription_histories.subscription_id
+		AND a.expiry_date > user_subscription_histories.expiry_date)`, survivorID, absorbedID)
+	return result.RowsAffected, result.Error
+}
+
+// keepHighestRole raises the survivor's role in tenants both users belong to, so collapsing the
+// duplicate memberships never demotes the merged account (or leaves a tenant without an owner)
+func keepHighestRole(tx *gorm.DB, survivorID, absorbedID uint64) error {
+	var absorbed []models.UserTenantMapping
+	if err := tx.Where("user_id = ?", absorbedID).Find(&absorbed).Error; err != nil {
+		return err
+	}
+	for _, mapping := range absorbed {
+		var survivor models.UserTenantMapping
+		err := tx.Where("user_id = ? AND tenant_id = ?", survivorID, mapping.TenantId).First(&survivor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if models.RoleRank[mapping.Role] > models.RoleRank[survivor.Role] {
+			if err := tx.Model(&survivor).Update("role", mapping.Role).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// settleOffers cancels the ownership transfers and revokes the invitations still waiting on the
+// absorbed user, whose account can no longer accept them, and moves the settled records of
+// transfers and invitations onto the survivor
+func settleOffers(tx *gorm.DB, summary *MergeSummary, survivorID uint64, absorbed *models.User) error {
+	now := time.Now()
+
+	// Offers sent before the recipient registered are only known by the email or mobile number
+	contacts := []string{absorbed.Email, absorbed.MobileNumber}
+	cancelled := tx.Model(&models.OwnershipTransfer{}).
+		Where("(from_user_id = ? OR to_user_id = ? OR recipient IN ?) AND status = ?", absorbed.ID, absorbed.ID, contacts, models.TransferPending).
+		Updates(map[string]interface{}{"status": models.TransferCancelled, "cancelled_at": now})
+	if cancelled.Error != nil {
+		return cancelled.Error
+	}
+	summary.TransfersCancelled = cancelled.RowsAffected
+	transfers := &MergeCount{}
+	summary.Tables["ownership_transfers"] = transfers
+	for _, column := range []string{"from_user_id", "to_user_id"} {
+		moved := tx.Model(&models.OwnershipTransfer{}).Where(column+" = ?", absorbed.ID).Update(column, survivorID)
+		if moved.Error != nil {
+			return moved.Error
+		}
+		transfers.Moved += moved.RowsAffected
+	}
+
+	revoked := tx.Model(&models.TenantInvitation{}).
+		Where("LOWER(email) = LOWER(?) AND status = ?", absorbed.Email, models.InvitationPending).
+		Updates(map[string]interface{}{"status": models.InvitationRevoked, "revoked_at": now})
+	if revoked.Error != nil {
+		return revoked.Error
+	}
+	summary.InvitationsRevoked = revoked.RowsAffected
+	moved := tx.Model(&models.TenantInvitation{}).Where("accepted_by = ?", absorbed.ID).Update("accepted_by", survivorID)
+	if moved.Error != nil {
+		return moved.Error
+	}
+	summary.Tables["tenant_invitations"] = &MergeCount{Moved: moved.RowsAffected}
+	return nil
+}
+
+// Merge moves every mapping of the absorbed user onto the survivor, revokes the absorbed user's
+// tokens and deactivates them. It must run inside a transaction.
+func Merge(tx *gorm.DB, survivorID, absorbedID uint64) (*MergeSummary, error) {
+	summary := &MergeSummary{Tables: map[string]*MergeCount{}}
+
+	var absorbed models.User
+	if err := tx.First(&absorbed, absorbedID).Error; err != nil {
+		return nil, err
+	}
+	if err := settleOffers(tx, summary, survivorID, &absorbed); err != nil {
+		return nil, err
+	}
+
+	replaced, err := keepLatestHistory(tx, survivorID, absorbedID)
+	if err != nil {
+		return nil, err
+	}
+	if err := keepHighestRole(tx, survivorID, absorbedID); err != nil {
+		return nil, err
+	}
+
+	for _, table := range mergeTables {
+		name, err := tableName(tx, table.model)
+		if err != nil {
+			return nil, err
+		}
+		count := &MergeCount{}
+		summary.Tables[name] = count
+
+		if len(table.keys) > 0 {
+			var matches []string
+			for _, key := range table.keys {
+				matches = append(matches, fmt.Sprintf("s.%[1]s = %[2]s.%[1]s", key, name))
+			}
+			result := tx.Exec(fmt.Sprintf(
+				"DELETE FROM %[1]s WHERE user_id = ? AND EXISTS (SELECT 1 FROM %[1]s s WHERE s.user_id = ? AND %[2]s)",
+				name, strings.Join(matches, " AND ")), absorbedID, survivorID)
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			count.Dropped = result.RowsAffected
+		}
+
+		result := tx.Model(table.model).Where("user_id = ?", absorbedID).Update("user_id", survivorID)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		count.Moved = result.RowsAffected
+	}
+	summary.Tables["user_subscription_histories"].Dropped += replaced
+
+	// Company selections of organization memberships dropped as duplicates go with them
+	if err := tx.Where("member_id NOT IN (?)", tx.Model(&models.OrganizationMember{}).Select("id")).
+		Delete(&models.OrganizationMemberCompany{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", absorbedID).Delete(&models.ContactChangeRequest{}).Error; err != nil {
+		return nil, err
+	}
+
+	revoked := tx.Where("user_id = ?", absorbedID).Delete(&models.Token{})
+	if revoked.Error != nil {
+		return nil, revoked.Error
+	}
+	summary.TokensRevoked = revoked.RowsAffected
+
+	if err := tx.Model(&models.User{}).Where("id = ?", absorbedID).Update("is_active", false).Error; err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
@@ -0,0 +1,220 @@
+package users
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"sg-portal/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// tokenMetadata describes a session without exposing the token value
+type tokenMetadata struct {
+	ID        uint64    `json:"id"`
+	Expiry    time.Time `json:"expiry"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// featureGrant is a permission the user holds in one tenant
+type featureGrant struct {
+	TenantId   uint64 `json:"tenant_id"`
+	Permission string `json:"permission"`
+	Name       string `json:"name"`
+}
+
+// preferenceEntry is a saved preference with its value, which the API model leaves out of JSON
+type preferenceEntry struct {
+	TenantId  uint64          `json:"tenant_id"`
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   uint64          `json:"version"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// personalDataSection is one file of the personal data archive
+type personalDataSection struct {
+	name  string
+	query func(db *gorm.DB, userID uint64) (interface{}, error)
+}
+
+func findAll[T any](condition string) func(db *gorm.DB, userID uint64) (interface{}, error) {
+	return func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var entries []T
+		err := db.Where(condition, userID).Find(&entries).Error
+		return entries, err
+	}
+}
+
+// personalDataSections lists everything held about a user, one JSON file each
+var personalDataSections = []personalDataSection{
+	{"user.json", func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var user models.User
+		err := db.First(&user, userID).Error
+		return user, err
+	}},
+	{"tenants.json", func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var tenants []models.Tenant
+		err := db.Where("id IN (?)", db.Model(&models.UserTenantMapping{}).Select("tenant_id").Where("user_id = ?", userID)).
+			Find(&tenants).Error
+		return tenants, err
+	}},
+	{"tenant_mappings.json", findAll[models.UserTenantMapping]("user_id = ?")},
+	{"features.json", func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var grants []featureGrant
+		err := db.Model(&models.UserFeatureMapping{}).
+			Select("user_feature_mappings.tenant_id, features.permission, features.name").
+			Joins("JOIN features ON features.id = user_feature_mappings.feature_id").
+			Where("user_feature_mappings.user_id = ?", userID).Scan(&grants).Error
+		return grants, err
+	}},
+	{"feature_wildcards.json", findAll[models.UserWildcardGrant]("user_id = ?")},
+	{"subscriptions.json", func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var subscriptions []models.Subscription
+		err := db.Where("id IN (?)", db.Model(&models.UserSubscriptionMapping{}).Select("subscription_id").Where("user_id = ?", userID)).
+			Find(&subscriptions).Error
+		return subscriptions, err
+	}},
+	{"subscription_history.json", findAll[models.UserSubscriptionHistory]("user_id = ?")},
+	{"sessions.json", func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var tokens []models.Token
+		if err := db.Where("user_id = ?", userID).Find(&tokens).Error; err != nil {
+			return nil, err
+		}
+		sessions := make([]tokenMetadata, 0, len(tokens))
+		for _, token := range tokens {
+			sessions = append(sessions, tokenMetadata{ID: token.ID, Expiry: token.Expiry, CreatedAt: token.CreatedAt})
+		}
+		return sessions, nil
+	}},
+	{"login_history.json", findAll[models.LoginAttempt]("user_id = ?")},
+	{"suspensions.json", findAll[models.UserSuspension]("user_id = ?")},
+	{"contact_changes.json", findAll[models.ContactChangeRequest]("user_id = ?")},
+	{"preferences.json", func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var preferences []models.UserPreference
+		if err := db.Where("user_id = ?", userID).Find(&preferences).Error; err != nil {
+			return nil, err
+		}
+		entries := make([]preferenceEntry, 0, len(preferences))
+		for _, preference := range preferences {
+			entries = append(entries, preferenceEntry{
+				TenantId:  preference.TenantId,
+				Namespace: preference.Namespace,
+				Key:       preference.Key,
+				Value:     json.RawMessage(preference.Value),
+				Version:   preference.Version,
+				UpdatedAt: preference.UpdatedAt,
+			})
+		}
+		return entries, nil
+	}},
+	{"organizations.json", findAll[models.OrganizationMember]("user_id = ?")},
+	{"ownership_transfers.json", func(db *gorm.DB, userID uint64) (interface{}, error) {
+		var transfers []models.OwnershipTransfer
+		err := db.Where("from_user_id = ? OR to_user_id = ?", userID, userID).Find(&transfers).Error
+		return transfers, err
+	}},
+}
+
+// WritePersonalData writes a zip archive holding every record kept about the user
+func WritePersonalData(db *gorm.DB, userID uint64, w io.Writer) error {
+	archive := zip.NewWriter(w)
+	for _, section := range personalDataSections {
+		data, err := section.query(db, userID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", section.name, err)
+		}
+		file, err := archive.Create(section.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// Erase anonymises the user's personal data and revokes their access. Subscriptions and their
+// history are kept for billing. It must run inside a transaction.
+func Erase(tx *gorm.DB, userID, actorID uint64, reason string, now time.Time) (*models.UserErasure, error) {
+	var user models.User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	// The placeholders stay unique so the email and mobile indexes keep holding
+	erasedEmail := fmt.Sprintf("erased-%d@erased.invalid", userID)
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"email":         erasedEmail,
+		"name":          "Erased User",
+		"mobile_number": fmt.Sprintf("erased-%d", userID),
+		"is_active":     false,
+		"last_login":    nil,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Where("member_id IN (?)", tx.Model(&models.OrganizationMember{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&models.OrganizationMemberCompany{}).Error; err != nil {
+		return nil, err
+	}
+	for _, model := range []interface{}{
+		&models.UserPassword{}, &models.Token{}, &models.ContactChangeRequest{}, &models.OrganizationMember{},
+		&models.UserTenantMapping{}, &models.UserFeatureMapping{}, &models.UserWildcardGrant{}, &models.UserPreference{},
+	} {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// A scheduled reactivation would bring the anonymised account back, so it is dropped
+	if err := tx.Model(&models.UserSuspension{}).Where("user_id = ? AND reactivated_at IS NULL", userID).
+		Update("reactivate_at", nil).Error; err != nil {
+		return nil, err
+	}
+
+	// Offers made or received can no longer be completed; the records stay without the contact details.
+	// Offers sent before the recipient registered are only known by the email or mobile number.
+	contacts := []string{user.Email, user.MobileNumber}
+	if err := tx.Model(&models.OwnershipTransfer{}).
+		Where("(from_user_id = ? OR to_user_id = ? OR recipient IN ?) AND status = ?", userID, userID, contacts, models.TransferPending).
+		Updates(map[string]interface{}{"status": models.TransferCancelled, "cancelled_at": now}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.OwnershipTransfer{}).
+		Where("to_user_id = ? OR recipient IN ?", userID, contacts).
+		Update("recipient", "").Error; err != nil {
+		return nil, err
+	}
+
+	// Invitations to the user's email are revoked and keep the placeholder instead
+	if err := tx.Model(&models.TenantInvitation{}).Where("LOWER(email) = LOWER(?) AND status = ?", user.Email, models.InvitationPending).
+		Updates(map[string]interface{}{"status": models.InvitationRevoked, "revoked_at": now}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.TenantInvitation{}).Where("LOWER(email) = LOWER(?) OR accepted_by = ?", user.Email, userID).
+		Update("email", erasedEmail).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&models.LoginAttempt{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"credential": "",
+		"ip":         "",
+		"user_agent": "",
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	erasure := &models.UserErasure{UserId: userID, ErasedBy: actorID, Reason: reason, ErasedAt: now}
+	if err := tx.Create(erasure).Error; err != nil {
+		return nil, err
+	}
+	return erasure, nil
+}
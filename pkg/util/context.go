@@ -6,7 +6,10 @@ import (
 
 type key int
 
-const userKey key = 0
+const (
+	userKey     key = 0
+	userTypeKey key = 1
+)
 
 // ContextWithUserID stores the user ID in the context.
 func ContextWithUserID(ctx context.Context, userID uint64) context.Context {
@@ -19,3 +22,13 @@ func UserIDFromContext(ctx context.Context) (uint64, bool) {
 	return userID, ok
 }
 
+// ContextWithUserType stores the authenticated user's type in the context.
+func ContextWithUserType(ctx context.Context, userType string) context.Context {
+	return context.WithValue(ctx, userTypeKey, userType)
+}
+
+// UserTypeFromContext retrieves the authenticated user's type from the context.
+func UserTypeFromContext(ctx context.Context) (string, bool) {
+	userType, ok := ctx.Value(userTypeKey).(string)
+	return userType, ok
+}
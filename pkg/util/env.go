@@ -0,0 +1,23 @@
+package util
+
+import (
+	"os"
+	"strconv"
+)
+
+// GetEnv returns the environment variable or the fallback when it is unset
+func GetEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvInt returns the environment variable as an integer or the fallback when it is unset or invalid
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
@@ -3,8 +3,10 @@ package util
 import (
 	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 // ParseJSONBody is a generic function to read the request body and decode it into the provided type `T`.
@@ -58,3 +60,97 @@ func HandleError(w http.ResponseWriter, statusCode int, message string) {
 	http.Error(w, message, statusCode)
 
 }
+
+// ParsePagination reads the optional limit and offset query parameters, capping limit at maxLimit
+func ParsePagination(r *http.Request, defaultLimit, maxLimit int) (int, int, error) {
+	limit, offset := defaultLimit, 0
+	query := r.URL.Query()
+	if query.Has("limit") {
+		value, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || value < 1 {
+			return 0, 0, errors.New("invalid parameter: limit")
+		}
+		limit = min(value, maxLimit)
+	}
+	if query.Has("offset") {
+		value, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || value < 0 {
+			return 0, 0, errors.New("invalid parameter: offset")
+		}
+		offset = value
+	}
+	return limit, offset, nil
+}
+
+// trustedProxies are the networks whose forwarding headers ClientIP believes; none by default
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies configures the proxies allowed to report the caller's address, given as a
+// comma-separated list of addresses and CIDR ranges. It must be called before serving requests.
+func SetTrustedProxies(spec string) error {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return errors.New("invalid trusted proxy: " + entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return errors.New("invalid trusted proxy: " + entry)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxies = networks
+	return nil
+}
+
+// isTrustedProxy reports whether the address belongs to a configured proxy
+func isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the caller's address. Forwarding headers are only believed when the request comes
+// from a trusted proxy, and then the caller is the nearest X-Forwarded-For hop that is not a proxy.
+func ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if !isTrustedProxy(hop) || i == 0 {
+				return hop
+			}
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return remote
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestClientIP checks that forwarding headers are only believed from trusted proxies
+func TestClientIP(t *testing.T) {
+	if err := SetTrustedProxies("10.0.0.0/8, 192.168.1.5"); err != nil {
+		t.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	defer SetTrustedProxies("")
+
+	cases := []struct {
+		remote, forwarded, realIP string
+		want                      string
+	}{
+		{"203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"10.1.2.3:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"10.1.2.3:5000", "198.51.100.9, 198.51.100.1, 10.4.4.4", "", "198.51.100.1"},
+		{"192.168.1.5:5000", "", "198.51.100.2", "198.51.100.2"},
+		{"192.168.1.6:5000", "", "198.51.100.2", "192.168.1.6"},
+	}
+	for _, c := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := ClientIP(r); got != c.want {
+			t.Errorf("ClientIP from %s with %q/%q = %q, want %q", c.remote, c.forwarded, c.realIP, got, c.want)
+		}
+	}
+
+	if err := SetTrustedProxies("not-an-ip"); err == nil {
+		t.Error("Expected an invalid proxy to be rejected")
+	}
+}
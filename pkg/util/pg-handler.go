@@ -1,11 +1,27 @@
 package util
 
 import (
+	"errors"
+	"strings"
+
 	"gorm.io/gorm"
 )
 
 var Db *gorm.DB
 
+// IsDuplicateKeyError reports whether err comes from a unique constraint violation
+func IsDuplicateKeyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	// Connections opened without TranslateError still carry the driver message
+	message := err.Error()
+	return strings.Contains(message, "SQLSTATE 23505") || strings.Contains(message, "UNIQUE constraint failed")
+}
+
 type Repository[T any] struct {
 	db *gorm.DB
 }
@@ -53,3 +69,19 @@ func (r *Repository[T]) Joins(join string, condition string, args ...interface{}
 func (r *Repository[T]) Delete(condition string, args ...interface{}) error {
 	return r.db.Where(condition, args...).Delete(new(T)).Error
 }
+
+// GetAllByScopes returns the records matching the provided query scopes
+func (r *Repository[T]) GetAllByScopes(scopes ...func(*gorm.DB) *gorm.DB) ([]T, error) {
+	var entries []T
+	err := r.db.Scopes(scopes...).Find(&entries).Error
+	return entries, err
+}
+
+// FindInBatches walks the records matching the scopes in primary key order, handing
+// at most batchSize records at a time to fn so callers never hold the full result set.
+func (r *Repository[T]) FindInBatches(batchSize int, fn func(batch []T) error, scopes ...func(*gorm.DB) *gorm.DB) error {
+	var batch []T
+	return r.db.Scopes(scopes...).FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
@@ -1,7 +1,11 @@
 package util
 
 import (
+	"crypto/rand"
+	"errors"
+	"math/big"
 	"regexp"
+	"strings"
 )
 
 // IsValidEmail checks if the given string is a valid email address
@@ -12,11 +16,68 @@ func IsValidEmail(email string) bool {
 	return re.MatchString(email)
 }
 
-// IsValidMobileNumber checks if the given string is a valid mobile number
+// IsValidMobileNumber checks if the given string looks like a mobile number in national or E.164 form.
+// Use NormalizeMobileNumber for the country-aware check.
 func IsValidMobileNumber(mobile string) bool {
-	// Assuming mobile number must be digits and between 10 and 15 characters long
-	const mobileRegex = `^\d{10,15}$`
+	// Digits with an optional leading +, between 7 and 15 digits once separators are removed
+	const mobileRegex = `^\+?\d{7,15}$`
 	re := regexp.MustCompile(mobileRegex)
-	return re.MatchString(mobile)
+	return re.MatchString(mobileSeparators.Replace(strings.TrimSpace(mobile)))
 }
 
+// GenerateNumericCode creates a cryptographically random numeric code of the given length
+func GenerateNumericCode(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + digit.Int64())
+	}
+	return string(code), nil
+}
+
+// mobileSeparators are the characters people commonly type inside phone numbers
+var mobileSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+// NormalizeMobileNumber converts a number typed in national or international form to E.164
+// ("+<dial code><national number>"). Numbers without a "+" or "00" prefix are read as
+// belonging to the country with the given dial code and national number length bounds.
+func NormalizeMobileNumber(mobile, dialCode string, minLength, maxLength int) (string, error) {
+	number := mobileSeparators.Replace(strings.TrimSpace(mobile))
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+
+	international := strings.HasPrefix(number, "+")
+	digits := strings.TrimPrefix(number, "+")
+	if !regexp.MustCompile(`^\d+$`).MatchString(digits) {
+		return "", errors.New("mobile number must contain digits only")
+	}
+
+	validNational := func(national string) bool {
+		return len(national) >= minLength && len(national) <= maxLength
+	}
+
+	if international {
+		if strings.HasPrefix(digits, dialCode) && validNational(digits[len(dialCode):]) {
+			return "+" + digits, nil
+		}
+		// A foreign number can only be checked against the E.164 length limits
+		if !strings.HasPrefix(digits, dialCode) && len(digits) >= 8 && len(digits) <= 15 {
+			return "+" + digits, nil
+		}
+		return "", errors.New("invalid mobile number for the country")
+	}
+
+	// Drop the domestic trunk prefix, e.g. 07700 900123 in the UK
+	national := strings.TrimLeft(digits, "0")
+	if validNational(national) {
+		return "+" + dialCode + national, nil
+	}
+	if strings.HasPrefix(national, dialCode) && validNational(national[len(dialCode):]) {
+		return "+" + national, nil
+	}
+	return "", errors.New("invalid mobile number for the country")
+}
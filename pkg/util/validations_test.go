@@ -0,0 +1,32 @@
+package util
+
+import "testing"
+
+// TestNormalizeMobileNumber checks national and international input for India (+91, 10 digit numbers)
+func TestNormalizeMobileNumber(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"9876543210", "+919876543210", true},
+		{"919876543210", "+919876543210", true},
+		{"+91 98765-43210", "+919876543210", true},
+		{"0091 9876543210", "+919876543210", true},
+		{"09876543210", "+919876543210", true},
+		{"+14155550123", "+14155550123", true},
+		{"98765", "", false},
+		{"+91987654", "", false},
+		{"98765abcde", "", false},
+	}
+
+	for _, c := range cases {
+		got, err := NormalizeMobileNumber(c.input, "91", 10, 10)
+		if c.valid && (err != nil || got != c.want) {
+			t.Errorf("NormalizeMobileNumber(%q) = %q, %v; want %q", c.input, got, err, c.want)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("NormalizeMobileNumber(%q) = %q; want an error", c.input, got)
+		}
+	}
+}